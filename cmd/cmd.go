@@ -5,6 +5,8 @@ import (
 	"os"
 	"runtime"
 
+	"apigateway/pkg/config"
+
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/cobra"
 )
@@ -24,14 +26,27 @@ func CmdRecover() {
 	}
 }
 
+var (
+	profile   string
+	overrides []string
+)
+
 var rootCmd = &cobra.Command{
 	Use:   "root",
-	Short: "choose instance to run: server",
+	Short: "choose instance to run: server, config",
 	Long:  ``,
+	PersistentPreRunE: func(command *cobra.Command, args []string) error {
+		return config.SetCommandLine(profile, overrides)
+	},
+}
+
+func init() {
+	rootCmd.PersistentFlags().StringVar(&profile, "profile", "", "config profile, load app.<profile>.yml (default APIGW_PROFILE)")
+	rootCmd.PersistentFlags().StringArrayVar(&overrides, "set", nil, "override a config key, e.g. --set http.address=:8080")
 }
 
 func main() {
-	rootCmd.AddCommand(ServerCmd)
+	rootCmd.AddCommand(ServerCmd, ConfigCmd)
 	if err := rootCmd.Execute(); err != nil {
 		log.Error().Msg(err.Error())
 		os.Exit(1)
@@ -0,0 +1,188 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+	"strings"
+
+	"apigateway/pkg/config"
+
+	"github.com/spf13/cobra"
+	"go.uber.org/multierr"
+	"gopkg.in/yaml.v2"
+)
+
+var configPath string
+
+// ConfigCmd ...
+var ConfigCmd = &cobra.Command{
+	Use:   "config",
+	Short: "inspect and validate the configuration file",
+}
+
+var configValidateCmd = &cobra.Command{
+	Use:   "validate",
+	Short: "validate the configuration and report every error",
+	Run: func(command *cobra.Command, args []string) {
+		if _, err := loadConfig(); err != nil {
+			for _, e := range multierr.Errors(err) {
+				fmt.Fprintln(os.Stderr, e)
+			}
+			os.Exit(1)
+		}
+		fmt.Println("configuration is valid")
+	},
+}
+
+var configPrintCmd = &cobra.Command{
+	Use:   "print",
+	Short: "print the effective merged configuration as yaml",
+	Run: func(command *cobra.Command, args []string) {
+		redact, _ := command.Flags().GetBool("redact")
+		explain, _ := command.Flags().GetBool("explain")
+		// 設定錯誤時仍然輸出, 方便檢查
+		_, _ = loadConfig()
+
+		if explain {
+			printExplain(config.Settings(redact))
+			return
+		}
+
+		out, err := yaml.Marshal(config.Settings(redact))
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Print(string(out))
+	},
+}
+
+var configSchemaCmd = &cobra.Command{
+	Use:   "schema",
+	Short: "export the configuration JSON Schema",
+	Run: func(command *cobra.Command, args []string) {
+		out, err := json.MarshalIndent(config.Schema(), "", "  ")
+		if err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		fmt.Println(string(out))
+	},
+}
+
+// printExplain 每行輸出 key、值與提供該值的設定層
+func printExplain(settings map[string]interface{}) {
+	sources := config.Sources()
+	keys := make([]string, 0, len(sources))
+	for key := range sources {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		fmt.Printf("%s = %v\t# %s\n", key, lookup(settings, key), sources[key])
+	}
+}
+
+func lookup(settings map[string]interface{}, key string) interface{} {
+	var value interface{} = settings
+	for _, k := range strings.Split(key, ".") {
+		m, ok := value.(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		value = m[k]
+	}
+	return value
+}
+
+var configEncryptCmd = &cobra.Command{
+	Use:   "encrypt [plaintext]",
+	Short: "encrypt a value as ENC[...] for use in the configuration file, read stdin when no argument",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(command *cobra.Command, args []string) {
+		key := mustLoadSecretKey()
+		value, err := argOrStdin(args)
+		exitOnError(err)
+
+		encrypted, err := config.Encrypt(key, value)
+		exitOnError(err)
+		fmt.Println(encrypted)
+	},
+}
+
+var configDecryptCmd = &cobra.Command{
+	Use:   "decrypt [ENC[...]]",
+	Short: "decrypt an ENC[...] value, read stdin when no argument",
+	Args:  cobra.MaximumNArgs(1),
+	Run: func(command *cobra.Command, args []string) {
+		key := mustLoadSecretKey()
+		value, err := argOrStdin(args)
+		exitOnError(err)
+
+		plaintext, err := config.Decrypt(key, value)
+		exitOnError(err)
+		fmt.Println(plaintext)
+	},
+}
+
+var configKeygenCmd = &cobra.Command{
+	Use:   "keygen",
+	Short: "generate a secret key for encrypted values",
+	Run: func(command *cobra.Command, args []string) {
+		key, err := config.GenerateSecretKey()
+		exitOnError(err)
+		fmt.Println(key)
+	},
+}
+
+func mustLoadSecretKey() []byte {
+	if configPath != "" {
+		key, err := config.LoadSecretKey(config.Path(configPath))
+		exitOnError(err)
+		return key
+	}
+	key, err := config.LoadSecretKey(config.Path())
+	exitOnError(err)
+	return key
+}
+
+func argOrStdin(args []string) (string, error) {
+	if len(args) == 1 {
+		return args[0], nil
+	}
+	data, err := ioutil.ReadAll(os.Stdin)
+	return strings.TrimRight(string(data), "\r\n"), err
+}
+
+func exitOnError(err error) {
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+}
+
+func loadConfig() (config.Config, error) {
+	if configPath != "" {
+		return config.CreateConfig(configPath)
+	}
+	return config.CreateConfig()
+}
+
+func init() {
+	ConfigCmd.PersistentFlags().StringVarP(&configPath, "path", "p", "", "directory of the configuration file, default to CONFIGPATH")
+	configPrintCmd.Flags().Bool("redact", false, "mask passwords, secrets and tokens")
+	configPrintCmd.Flags().Bool("explain", false, "show which layer supplied each key")
+
+	ConfigCmd.AddCommand(
+		configValidateCmd,
+		configPrintCmd,
+		configSchemaCmd,
+		configEncryptCmd,
+		configDecryptCmd,
+		configKeygenCmd,
+	)
+}
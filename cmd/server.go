@@ -2,14 +2,19 @@ package main
 
 import (
 	"context"
-	"net/http"
 	"os"
 	"os/signal"
 	"syscall"
-	"time"
 
+	"apigateway/pkg/cache"
 	"apigateway/pkg/config"
+	"apigateway/pkg/event"
+	"apigateway/pkg/health"
+	"apigateway/pkg/lifecycle"
+	"apigateway/pkg/proxy"
+	"apigateway/pkg/ratelimit"
 	"apigateway/pkg/repository"
+	pkgGRPC "apigateway/pkg/router/grpc"
 	pkgHTTP "apigateway/pkg/router/http"
 	"apigateway/pkg/service"
 
@@ -26,37 +31,56 @@ var ServerCmd = &cobra.Command{
 
 func run(command *cobra.Command, args []string) {
 	defer CmdRecover()
-	srv := &http.Server{}
+	cfgManager := &config.Manager{}
+	drainer := &lifecycle.Drainer{}
 	exitCode := 0
 
 	// fx injection
 	app := fx.New(
 		config.Module,
+		health.Module,
+		ratelimit.Module,
+		cache.Module,
+		event.Module,
+		proxy.Module,
 		repository.Module,
 		service.Module,
 		pkgHTTP.Module,
-		fx.Populate(&srv),
+		pkgGRPC.Module,
+		// 必須在 server module 之後, 關閉時最先 drain
+		lifecycle.Module,
+		fx.Populate(&cfgManager, &drainer),
 	)
 
 	if err := app.Start(context.Background()); err != nil {
 		log.Error().Msg(err.Error())
-		os.Exit(exitCode)
+		os.Exit(1)
 		return
 	}
 
-	// Wait for interrupt signal to gracefully shutdown the server with
-	// a timeout of 5 seconds.
-	quit := make(chan os.Signal)
-	signal.Notify(quit, syscall.SIGINT, syscall.SIGKILL, syscall.SIGHUP, syscall.SIGTERM)
-	<-quit
+	// app.Done 在 SIGINT/SIGTERM 或 server 發生錯誤時觸發.
+	// SIGHUP reloads the configuration instead of shutting down.
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	done := app.Done()
+loop:
+	for {
+		select {
+		case <-hup:
+			log.Info().Msg("Reloading configuration...")
+			_ = cfgManager.Reload()
+		case <-done:
+			break loop
+		}
+	}
 	log.Info().Msg("Shutting down server...")
 
-	// The context is used to inform the server it has 30 seconds to finish
-	// the request it is currently handling
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	// OnStop 依相反順序執行: drain, 停止 server 並等待進行中的 request, 最後是背景工作
+	ctx, cancel := context.WithTimeout(context.Background(), drainer.Timeout())
 	defer cancel()
-	if err := srv.Shutdown(ctx); err != nil {
-		log.Error().Msgf("Server forced to shutdown: %s", err.Error())
+	if err := app.Stop(ctx); err != nil {
+		log.Error().Msg(err.Error())
+		exitCode = 1
 	}
 
 	os.Exit(exitCode)
@@ -0,0 +1,4 @@
+-- books 新增 isbn 與 slug, 既有資料為空字串
+-- 適用 mysql, postgres 與 sqlite3, 需在部署新版本之前執行
+ALTER TABLE books ADD COLUMN isbn VARCHAR(17) NOT NULL DEFAULT '';
+ALTER TABLE books ADD COLUMN slug VARCHAR(128) NOT NULL DEFAULT '';
@@ -0,0 +1,104 @@
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+)
+
+// Error 應用程式錯誤. Code 給程式判斷, Message 可直接顯示給使用者,
+// Err 為內部原因, 只會出現在 log 與非 release mode 的 response
+type Error struct {
+	Code    string
+	Status  int
+	Message string
+	Details interface{}
+	Err     error
+}
+
+// New ...
+func New(status int, code, message string) *Error {
+	return &Error{Code: code, Status: status, Message: message}
+}
+
+// 共用的錯誤, 使用時以 WithMessage、WithDetails 或 Wrap 複製一份再修改
+var (
+	ErrBadRequest         = New(http.StatusBadRequest, "bad_request", "The request is invalid.")
+	ErrUnauthorized       = New(http.StatusUnauthorized, "unauthorized", "Authentication is required.")
+	ErrForbidden          = New(http.StatusForbidden, "forbidden", "Access to this resource is denied.")
+	ErrNotFound           = New(http.StatusNotFound, "not_found", "The requested resource was not found.")
+	ErrMethodNotAllowed   = New(http.StatusMethodNotAllowed, "method_not_allowed", "The method is not allowed for this resource.")
+	ErrValidation         = New(http.StatusUnprocessableEntity, "validation_failed", "The request has invalid fields.")
+	ErrTooManyRequests    = New(http.StatusTooManyRequests, "rate_limited", "Too many requests, please retry later.")
+	ErrInternal           = New(http.StatusInternalServerError, "internal", "An internal error occurred.")
+	ErrBadGateway         = New(http.StatusBadGateway, "bad_gateway", "The upstream service failed to respond.")
+	ErrServiceUnavailable = New(http.StatusServiceUnavailable, "service_unavailable", "The service is temporarily unavailable.")
+	ErrGatewayTimeout     = New(http.StatusGatewayTimeout, "gateway_timeout", "The upstream service timed out.")
+)
+
+var byStatus = map[int]*Error{}
+
+func init() {
+	for _, e := range []*Error{
+		ErrBadRequest, ErrUnauthorized, ErrForbidden, ErrNotFound, ErrMethodNotAllowed, ErrValidation, ErrTooManyRequests,
+		ErrInternal, ErrBadGateway, ErrServiceUnavailable, ErrGatewayTimeout,
+	} {
+		byStatus[e.Status] = e
+	}
+}
+
+// FromStatus 取得 status 對應的共用錯誤, 沒有定義的 status 以 http_<status> 為 code
+func FromStatus(status int) *Error {
+	if e, ok := byStatus[status]; ok {
+		return e
+	}
+	return New(status, fmt.Sprintf("http_%d", status), http.StatusText(status)+".")
+}
+
+// From 不是 *Error 的錯誤一律視為 internal
+func From(err error) *Error {
+	var e *Error
+	if errors.As(err, &e) {
+		return e
+	}
+	return ErrInternal.Wrap(err)
+}
+
+func (e *Error) Error() string {
+	if e.Err != nil {
+		return fmt.Sprintf("%s: %s: %v", e.Code, e.Message, e.Err)
+	}
+	return e.Code + ": " + e.Message
+}
+
+// Unwrap ...
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is code 相同即視為同一個錯誤, 讓 errors.Is(err, ErrNotFound) 對複製的錯誤也成立
+func (e *Error) Is(target error) bool {
+	t, ok := target.(*Error)
+	return ok && t.Code == e.Code
+}
+
+// Wrap 複製一份並記錄內部原因
+func (e *Error) Wrap(err error) *Error {
+	c := *e
+	c.Err = err
+	return &c
+}
+
+// WithMessage 複製一份並替換 message
+func (e *Error) WithMessage(message string) *Error {
+	c := *e
+	c.Message = message
+	return &c
+}
+
+// WithDetails 複製一份並附上 details
+func (e *Error) WithDetails(details interface{}) *Error {
+	c := *e
+	c.Details = details
+	return &c
+}
@@ -0,0 +1,78 @@
+package apperror
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"testing"
+)
+
+func TestFromStatus(t *testing.T) {
+	tests := []struct {
+		status      int
+		wantCode    string
+		wantMessage string
+	}{
+		{status: http.StatusNotFound, wantCode: "not_found", wantMessage: ErrNotFound.Message},
+		{status: http.StatusTooManyRequests, wantCode: "rate_limited", wantMessage: ErrTooManyRequests.Message},
+		{status: http.StatusConflict, wantCode: "http_409", wantMessage: "Conflict."},
+		{status: http.StatusTeapot, wantCode: "http_418", wantMessage: "I'm a teapot."},
+	}
+	for _, tt := range tests {
+		e := FromStatus(tt.status)
+		if e.Status != tt.status || e.Code != tt.wantCode || e.Message != tt.wantMessage {
+			t.Errorf("FromStatus(%d) = %d %s %q, want %s %q", tt.status, e.Status, e.Code, e.Message, tt.wantCode, tt.wantMessage)
+		}
+	}
+}
+
+func TestFrom(t *testing.T) {
+	cause := errors.New("connection refused")
+	notFound := ErrNotFound.WithMessage("book 1 not found")
+
+	tests := []struct {
+		name     string
+		err      error
+		wantCode string
+		wantErr  error
+	}{
+		{name: "app error", err: notFound, wantCode: "not_found"},
+		{name: "wrapped app error", err: fmt.Errorf("get book: %w", notFound), wantCode: "not_found"},
+		{name: "other error", err: cause, wantCode: "internal", wantErr: cause},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			e := From(tt.err)
+			if e.Code != tt.wantCode || e.Err != tt.wantErr {
+				t.Errorf("From() = %s %v, want %s %v", e.Code, e.Err, tt.wantCode, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestErrorCopies(t *testing.T) {
+	cause := errors.New("connection refused")
+	wrapped := ErrBadGateway.Wrap(cause)
+	withMessage := ErrNotFound.WithMessage("book 1 not found")
+	withDetails := ErrValidation.WithDetails(map[string]string{"name": "required"})
+
+	// 共用的錯誤不會被修改
+	if ErrBadGateway.Err != nil || ErrNotFound.Message != "The requested resource was not found." || ErrValidation.Details != nil {
+		t.Fatal("shared error modified by a copy")
+	}
+	if withDetails.Details == nil || withDetails.Status != http.StatusUnprocessableEntity {
+		t.Errorf("WithDetails() = %+v", withDetails)
+	}
+	if !errors.Is(wrapped, ErrBadGateway) || !errors.Is(withMessage, ErrNotFound) || errors.Is(withMessage, ErrBadGateway) {
+		t.Error("errors.Is does not match copies by code")
+	}
+	if !errors.Is(wrapped, cause) {
+		t.Error("errors.Is does not reach the wrapped cause")
+	}
+	if got := wrapped.Error(); got != "bad_gateway: The upstream service failed to respond.: connection refused" {
+		t.Errorf("Error() = %q", got)
+	}
+	if got := withMessage.Error(); got != "not_found: book 1 not found" {
+		t.Errorf("Error() = %q", got)
+	}
+}
@@ -0,0 +1,71 @@
+package apperror
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// ContentType RFC 7807
+const ContentType = "application/problem+json"
+
+// headerRequestID 由 RequestID middleware 設定在 response header
+const headerRequestID = "X-Request-Id"
+
+// Problem RFC 7807 problem details 加上 code、request id 與 details
+type Problem struct {
+	Type      string      `json:"type"`
+	Title     string      `json:"title"`
+	Status    int         `json:"status"`
+	Detail    string      `json:"detail"`
+	Instance  string      `json:"instance,omitempty"`
+	Code      string      `json:"code"`
+	RequestID string      `json:"request_id,omitempty"`
+	Details   interface{} `json:"details,omitempty"`
+	// Cause 內部原因, release mode 不會輸出
+	Cause string `json:"cause,omitempty"`
+}
+
+// Problem 轉成 response body
+func (e *Error) Problem(instance, requestID string) Problem {
+	p := Problem{
+		Type:      "about:blank",
+		Title:     http.StatusText(e.Status),
+		Status:    e.Status,
+		Detail:    e.Message,
+		Instance:  instance,
+		Code:      e.Code,
+		RequestID: requestID,
+		Details:   e.Details,
+	}
+	if e.Err != nil && gin.Mode() != gin.ReleaseMode {
+		p.Cause = e.Err.Error()
+	}
+	return p
+}
+
+// Write 以 application/problem+json 回應, req 可以是 nil
+func Write(w http.ResponseWriter, req *http.Request, err error) {
+	e := From(err)
+	instance := ""
+	if req != nil {
+		instance = req.URL.Path
+	}
+
+	body, _ := json.Marshal(e.Problem(instance, w.Header().Get(headerRequestID)))
+	w.Header().Set("Content-Type", ContentType)
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.WriteHeader(e.Status)
+	_, _ = w.Write(body)
+}
+
+// Abort 回應錯誤並中止之後的 handler, 錯誤會記錄在 c.Errors 供 access log 使用
+func Abort(c *gin.Context, err error) {
+	_ = c.Error(err)
+	c.Abort()
+	if c.Writer.Written() {
+		return
+	}
+	Write(c.Writer, c.Request, err)
+}
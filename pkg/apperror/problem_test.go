@@ -0,0 +1,99 @@
+package apperror
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestWrite(t *testing.T) {
+	defer gin.SetMode(gin.Mode())
+
+	tests := []struct {
+		name      string
+		mode      string
+		err       error
+		want      Problem
+		requestID string
+	}{
+		{
+			name: "app error", mode: gin.ReleaseMode,
+			err:       ErrValidation.WithDetails(map[string]interface{}{"name": "required"}),
+			requestID: "req-1",
+			want: Problem{Type: "about:blank", Title: "Unprocessable Entity", Status: 422, Detail: ErrValidation.Message,
+				Instance: "/books", Code: "validation_failed", RequestID: "req-1", Details: map[string]interface{}{"name": "required"}},
+		},
+		{
+			// release mode 不輸出內部原因
+			name: "release", mode: gin.ReleaseMode, err: errors.New("dial tcp 10.0.0.1:3306"),
+			want: Problem{Type: "about:blank", Title: "Internal Server Error", Status: 500, Detail: ErrInternal.Message, Instance: "/books", Code: "internal"},
+		},
+		{
+			name: "debug", mode: gin.DebugMode, err: errors.New("dial tcp 10.0.0.1:3306"),
+			want: Problem{Type: "about:blank", Title: "Internal Server Error", Status: 500, Detail: ErrInternal.Message, Instance: "/books", Code: "internal",
+				Cause: "dial tcp 10.0.0.1:3306"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(tt.mode)
+			rec := httptest.NewRecorder()
+			if tt.requestID != "" {
+				rec.Header().Set(headerRequestID, tt.requestID)
+			}
+			Write(rec, httptest.NewRequest(http.MethodPost, "/books", nil), tt.err)
+
+			if rec.Code != tt.want.Status {
+				t.Errorf("status = %d, want %d", rec.Code, tt.want.Status)
+			}
+			if rec.Header().Get("Content-Type") != ContentType || rec.Header().Get("X-Content-Type-Options") != "nosniff" {
+				t.Errorf("header = %v", rec.Header())
+			}
+			var got Problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			gotJSON, _ := json.Marshal(got)
+			wantJSON, _ := json.Marshal(tt.want)
+			if string(gotJSON) != string(wantJSON) {
+				t.Errorf("problem = %s, want %s", gotJSON, wantJSON)
+			}
+		})
+	}
+}
+
+func TestAbort(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var after bool
+	router := gin.New()
+	router.GET("/written", func(c *gin.Context) {
+		c.String(http.StatusOK, "partial")
+		Abort(c, ErrBadGateway)
+	}, func(c *gin.Context) { after = true })
+	router.GET("/books", func(c *gin.Context) {
+		Abort(c, ErrNotFound)
+		if len(c.Errors) != 1 || !errors.Is(c.Errors.Last().Err, ErrNotFound) {
+			t.Errorf("c.Errors = %v, want the error for the access log", c.Errors)
+		}
+	}, func(c *gin.Context) { after = true })
+
+	rec := httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/books", nil))
+	if rec.Code != http.StatusNotFound || rec.Header().Get("Content-Type") != ContentType {
+		t.Errorf("response = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+	}
+
+	// 已經開始回應時不再寫入 problem
+	rec = httptest.NewRecorder()
+	router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/written", nil))
+	if rec.Code != http.StatusOK || rec.Body.String() != "partial" {
+		t.Errorf("response = %d %q, want the partial response", rec.Code, rec.Body.String())
+	}
+	if after {
+		t.Error("handler after Abort ran")
+	}
+}
@@ -0,0 +1,455 @@
+package cache
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"apigateway/pkg/metrics"
+
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+)
+
+// Module Export response cache module
+var Module = fx.Options(
+	fx.Provide(New),
+)
+
+// headers
+const (
+	// stateHeader 告訴 client 這個 response 的來源
+	stateHeader = "X-Cache"
+	// tagHeader upstream 以此 header 指定 purge 用的 tag, 不會送給 client
+	tagHeader = "Cache-Tag"
+)
+
+// X-Cache values
+const (
+	stateHit         = "HIT"
+	stateMiss        = "MISS"
+	stateStale       = "STALE"
+	stateRevalidated = "REVALIDATED"
+)
+
+// revalidateTimeout 背景 revalidate 的上限, route 的 timeout 仍然有效
+const revalidateTimeout = 30 * time.Second
+
+// Cache RFC 7234 shared cache, memory LRU 加上選用的 disk tier
+type Cache struct {
+	maxEntry int64
+	memory   *memoryStore
+	disk     *diskStore
+
+	mu       sync.Mutex
+	inflight map[string]bool // 背景 revalidate 中的 key
+	wg       sync.WaitGroup
+}
+
+// Stats admin api 的回應
+type Stats struct {
+	Entries  int   `json:"entries"`
+	Bytes    int64 `json:"bytes"`
+	MaxBytes int64 `json:"max_bytes"`
+}
+
+// New 關閉時等待背景 revalidate 結束
+func New(lc fx.Lifecycle, cfg *Config) (*Cache, error) {
+	c := &Cache{
+		maxEntry: defaultMaxEntryBytes,
+		memory:   newMemoryStore(defaultMaxBytes),
+		inflight: map[string]bool{},
+	}
+	lc.Append(fx.Hook{
+		OnStop: c.wait,
+	})
+	if cfg == nil {
+		return c, nil
+	}
+
+	if cfg.MaxBytes > 0 {
+		c.memory = newMemoryStore(cfg.MaxBytes)
+	}
+	if cfg.MaxEntryBytes > 0 {
+		c.maxEntry = cfg.MaxEntryBytes
+	}
+	if cfg.Disk != nil {
+		var err error
+		if c.disk, err = newDiskStore(cfg.Disk); err != nil {
+			return nil, err
+		}
+	}
+	return c, nil
+}
+
+// Handler 以 cache 包裝 route 的 handler
+func (c *Cache) Handler(route string, rc *RouteConfig, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		c.serve(route, rc, next, w, req)
+	})
+}
+
+// PurgeKey 刪除 key 下的所有 variants
+func (c *Cache) PurgeKey(key string) int {
+	n := 0
+	if c.memory.delete(key) {
+		n = 1
+	}
+	if c.disk != nil && c.disk.delete(key) {
+		n = 1
+	}
+	return n
+}
+
+// PurgeTag 刪除帶有 tag 的所有 key, 回傳刪除的 key 數
+func (c *Cache) PurgeTag(tag string) int {
+	keys := map[string]bool{}
+	for _, k := range c.memory.purgeTag(tag) {
+		keys[k] = true
+	}
+	if c.disk != nil {
+		for _, k := range c.disk.purgeTag(tag) {
+			keys[k] = true
+		}
+	}
+	return len(keys)
+}
+
+// Stats memory 與 disk 的使用量
+func (c *Cache) Stats() map[string]Stats {
+	stats := map[string]Stats{}
+	entries, size := c.memory.stats()
+	stats["memory"] = Stats{Entries: entries, Bytes: size, MaxBytes: c.memory.maxBytes}
+	if c.disk != nil {
+		entries, size = c.disk.stats()
+		stats["disk"] = Stats{Entries: entries, Bytes: size, MaxBytes: c.disk.maxBytes}
+	}
+	return stats
+}
+
+func (c *Cache) serve(route string, rc *RouteConfig, next http.Handler, w http.ResponseWriter, req *http.Request) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		// unsafe method 成功後清除相同 url 的內容 (RFC 7234 4.4)
+		sw := &statusWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, req)
+		if sw.status < http.StatusBadRequest {
+			get := *req
+			get.Method = http.MethodGet
+			c.PurgeKey(rc.key(route, &get))
+		}
+		return
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	if reqCC.has("no-store") {
+		c.count(route, "bypass")
+		next.ServeHTTP(w, req)
+		return
+	}
+
+	key := rc.key(route, req)
+	var entry *Entry
+	if obj, ok := c.get(key); ok {
+		entry = obj.match(req)
+	}
+
+	now := time.Now()
+	switch {
+	case entry == nil && reqCC.has("only-if-cached"):
+		w.WriteHeader(http.StatusGatewayTimeout)
+	case entry == nil:
+		c.count(route, "miss")
+		c.fetch(route, rc, key, next, w, req)
+	case usable(entry, reqCC, now):
+		c.count(route, "hit")
+		serveEntry(w, req, entry, stateHit, now)
+	case !reqCC.has("no-cache") && !entry.NoCache && !entry.MustRevalidate &&
+		entry.Age(now) < entry.TTL+entry.StaleWhileRevalidate:
+		c.count(route, "stale")
+		serveEntry(w, req, entry, stateStale, now)
+		c.revalidateAsync(route, rc, key, next, req, entry)
+	case reqCC.has("only-if-cached"):
+		w.WriteHeader(http.StatusGatewayTimeout)
+	default:
+		c.revalidate(route, rc, key, next, w, req, entry)
+	}
+}
+
+// fetch cache miss, 直接串流給 client 並保存可 cache 的 response
+func (c *Cache) fetch(route string, rc *RouteConfig, key string, next http.Handler, w http.ResponseWriter, req *http.Request) {
+	requestTime := time.Now()
+	tw := &teeWriter{ResponseWriter: w, max: c.maxEntry, base: w.Header().Clone()}
+	next.ServeHTTP(tw, req)
+
+	if req.Method != http.MethodGet || tw.skip || tw.status == 0 {
+		return
+	}
+	if cl := tw.header.Get("Content-Length"); cl != "" && cl != strconv.Itoa(tw.body.Len()) {
+		return
+	}
+
+	if e := newEntry(req, tw.status, tw.header, tw.body.Bytes(), requestTime, time.Now(), rc.DefaultTTL); e != nil {
+		e.Tags = append(append([]string{}, rc.Tags...), tw.tags...)
+		c.store(key, e)
+	}
+}
+
+// revalidate 以 conditional request 確認過期的內容, upstream 錯誤時依 stale-if-error 回傳舊內容
+func (c *Cache) revalidate(route string, rc *RouteConfig, key string, next http.Handler, w http.ResponseWriter, req *http.Request, entry *Entry) {
+	requestTime := time.Now()
+	reqCC := parseCacheControl(req.Header)
+	rec := newRecorder(c.maxEntry)
+	rec.overflow = func() http.ResponseWriter {
+		// 304 與可以改回 stale 內容的錯誤不需要 body, 其他超過 maxEntry 的 response 直接串流給 client
+		if rec.status == http.StatusNotModified ||
+			rec.status >= http.StatusInternalServerError && staleIfError(entry, reqCC, time.Now()) {
+			return nil
+		}
+		return w
+	}
+	next.ServeHTTP(rec, conditional(req.Context(), req, entry))
+	now := time.Now()
+
+	switch {
+	case rec.stream != nil:
+		c.count(route, "miss")
+	case rec.status == http.StatusNotModified:
+		c.count(route, "revalidated")
+		serveEntry(w, req, c.refresh(key, entry, rec.header, requestTime, now, rc), stateRevalidated, now)
+	case rec.status >= http.StatusInternalServerError && (rec.exceeded || staleIfError(entry, reqCC, now)):
+		c.count(route, "stale")
+		log.Warn().Str("route", route).Int("status", rec.status).Msg("cache: serving stale content, upstream failed")
+		serveEntry(w, req, entry, stateStale, now)
+	default:
+		c.count(route, "miss")
+		c.replace(key, req, rec, requestTime, now, rc)
+		rec.writeTo(w, stateMiss)
+	}
+}
+
+// revalidateAsync stale-while-revalidate, 同一個 key 同時只有一個背景 revalidate
+func (c *Cache) revalidateAsync(route string, rc *RouteConfig, key string, next http.Handler, req *http.Request, entry *Entry) {
+	c.mu.Lock()
+	if c.inflight[key] {
+		c.mu.Unlock()
+		return
+	}
+	c.inflight[key] = true
+	c.mu.Unlock()
+
+	ctx, cancel := context.WithTimeout(context.Background(), revalidateTimeout)
+	creq := conditional(ctx, req, entry)
+
+	c.wg.Add(1)
+	go func() {
+		defer func() {
+			c.wg.Done()
+			cancel()
+			c.mu.Lock()
+			delete(c.inflight, key)
+			c.mu.Unlock()
+		}()
+
+		requestTime := time.Now()
+		// 沒有 overflow, 超過 maxEntry 的 body 直接丟棄
+		rec := newRecorder(c.maxEntry)
+		next.ServeHTTP(rec, creq)
+		now := time.Now()
+
+		switch {
+		case rec.status == http.StatusNotModified:
+			c.refresh(key, entry, rec.header, requestTime, now, rc)
+		case rec.status >= http.StatusInternalServerError:
+			log.Warn().Str("route", route).Int("status", rec.status).Msg("cache: background revalidation failed")
+		default:
+			c.replace(key, creq, rec, requestTime, now, rc)
+		}
+	}()
+}
+
+// wait 等待背景 revalidate 結束, ctx 逾時則放棄
+func (c *Cache) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		c.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return fmt.Errorf("cache: background revalidation still running: %v", ctx.Err())
+	}
+}
+
+// refresh 以 304 的 header 更新 entry 並重新計算有效時間
+func (c *Cache) refresh(key string, entry *Entry, header http.Header, requestTime, responseTime time.Time, rc *RouteConfig) *Entry {
+	e := *entry
+	e.Header = entry.Header.Clone()
+	for k, v := range header {
+		switch k {
+		case "Content-Length", tagHeader:
+			continue
+		}
+		e.Header[k] = v
+	}
+	e.update(e.Header, requestTime, responseTime, rc.DefaultTTL)
+	c.store(key, &e)
+	return &e
+}
+
+// replace 以完整的 response 取代舊內容, 不可 cache 時刪除同一個 variant
+func (c *Cache) replace(key string, req *http.Request, rec *recorder, requestTime, responseTime time.Time, rc *RouteConfig) {
+	if req.Method != http.MethodGet || rec.exceeded {
+		return
+	}
+
+	header := rec.header.Clone()
+	tags := splitTags(header.Values(tagHeader))
+	header.Del(tagHeader)
+
+	e := newEntry(req, rec.status, header, rec.body.Bytes(), requestTime, responseTime, rc.DefaultTTL)
+	if e == nil {
+		c.PurgeKey(key)
+		return
+	}
+	e.Tags = append(append([]string{}, rc.Tags...), tags...)
+	c.store(key, e)
+}
+
+// store 取代 Vary 相同的 variant, 最新的放在最前面
+func (c *Cache) store(key string, e *Entry) {
+	obj := &object{Key: key, Entries: []*Entry{e}}
+	if old, ok := c.get(key); ok {
+		for _, x := range old.Entries {
+			if len(obj.Entries) >= defaultMaxVariants {
+				break
+			}
+			if !reflect.DeepEqual(x.Vary, e.Vary) {
+				obj.Entries = append(obj.Entries, x)
+			}
+		}
+	}
+
+	c.memory.set(obj)
+	if c.disk != nil {
+		c.disk.set(obj)
+	}
+}
+
+func (c *Cache) get(key string) (*object, bool) {
+	if obj, ok := c.memory.get(key); ok {
+		return obj, true
+	}
+	if c.disk == nil {
+		return nil, false
+	}
+	obj, ok := c.disk.get(key)
+	if ok {
+		c.memory.set(obj)
+	}
+	return obj, ok
+}
+
+func (c *Cache) count(route, state string) {
+	metrics.Cache.Add(route+"."+state, 1)
+}
+
+// usable 新鮮且符合 request 的 Cache-Control
+func usable(e *Entry, reqCC cacheControl, now time.Time) bool {
+	age := e.Age(now)
+	if reqCC.has("no-cache") || e.NoCache {
+		return false
+	}
+	if reqCC.has("max-age") && age > reqCC.duration("max-age") {
+		return false
+	}
+	if reqCC.has("min-fresh") && e.TTL-age < reqCC.duration("min-fresh") {
+		return false
+	}
+	if age < e.TTL {
+		return true
+	}
+	// max-stale 沒有值時表示接受任何 stale 內容
+	if reqCC.has("max-stale") && !e.MustRevalidate {
+		return reqCC["max-stale"] == "" || age-e.TTL <= reqCC.duration("max-stale")
+	}
+	return false
+}
+
+func staleIfError(e *Entry, reqCC cacheControl, now time.Time) bool {
+	if e.MustRevalidate {
+		return false
+	}
+	window := e.StaleIfError
+	if d := reqCC.duration("stale-if-error"); d > window {
+		window = d
+	}
+	return e.Age(now) < e.TTL+window
+}
+
+// conditional 以 entry 的 validator 取代 client 的 conditional header
+func conditional(ctx context.Context, req *http.Request, e *Entry) *http.Request {
+	creq := req.Clone(ctx)
+	for _, h := range []string{"If-None-Match", "If-Modified-Since", "If-Match", "If-Unmodified-Since", "If-Range"} {
+		creq.Header.Del(h)
+	}
+	if etag := e.ETag(); etag != "" {
+		creq.Header.Set("If-None-Match", etag)
+	}
+	if lm := e.Header.Get("Last-Modified"); lm != "" {
+		creq.Header.Set("If-Modified-Since", lm)
+	}
+	return creq
+}
+
+func serveEntry(w http.ResponseWriter, req *http.Request, e *Entry, state string, now time.Time) {
+	h := w.Header()
+	for k, v := range e.Header {
+		h[k] = append([]string(nil), v...)
+	}
+	h.Set("Age", strconv.FormatInt(int64(e.Age(now)/time.Second), 10))
+	h.Set(stateHeader, state)
+
+	if e.Status == http.StatusOK && notModified(req, e) {
+		h.Del("Content-Length")
+		h.Del("Content-Type")
+		w.WriteHeader(http.StatusNotModified)
+		return
+	}
+
+	w.WriteHeader(e.Status)
+	if req.Method != http.MethodHead {
+		_, _ = w.Write(e.Body)
+	}
+}
+
+// notModified client 的 conditional request, If-None-Match 優先 (RFC 7232 6)
+func notModified(req *http.Request, e *Entry) bool {
+	if inm := req.Header.Get("If-None-Match"); inm != "" {
+		etag := strings.TrimPrefix(e.ETag(), "W/")
+		if etag == "" {
+			return false
+		}
+		for _, t := range strings.Split(inm, ",") {
+			t = strings.TrimSpace(t)
+			if t == "*" || strings.TrimPrefix(t, "W/") == etag {
+				return true
+			}
+		}
+		return false
+	}
+
+	ims, err := http.ParseTime(req.Header.Get("If-Modified-Since"))
+	if err != nil {
+		return false
+	}
+	lm, err := http.ParseTime(e.Header.Get("Last-Modified"))
+	return err == nil && !lm.After(ims)
+}
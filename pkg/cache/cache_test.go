@@ -0,0 +1,314 @@
+package cache
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+
+	"go.uber.org/fx"
+)
+
+type testLifecycle struct{}
+
+func (testLifecycle) Append(fx.Hook) {}
+
+func newTestCache(t *testing.T, cfg *Config) *Cache {
+	t.Helper()
+	c, err := New(testLifecycle{}, cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return c
+}
+
+// upstream 依序回傳 responses, 記錄呼叫次數與最後一個 request
+type upstream struct {
+	calls     int32
+	last      *http.Request
+	responses []func(w http.ResponseWriter, req *http.Request)
+}
+
+func (u *upstream) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	n := atomic.AddInt32(&u.calls, 1)
+	u.last = req
+	i := int(n) - 1
+	if i >= len(u.responses) {
+		i = len(u.responses) - 1
+	}
+	u.responses[i](w, req)
+}
+
+func respond(status int, header map[string]string, body string) func(http.ResponseWriter, *http.Request) {
+	return func(w http.ResponseWriter, _ *http.Request) {
+		for k, v := range header {
+			w.Header().Set(k, v)
+		}
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}
+}
+
+func do(h http.Handler, method string, header map[string]string) *httptest.ResponseRecorder {
+	req := httptest.NewRequest(method, "http://example.com/books?b=2&a=1", nil)
+	for k, v := range header {
+		req.Header.Set(k, v)
+	}
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+	return rec
+}
+
+// cacheRequest state 為預期的 X-Cache, 空字串表示沒有經過 cache
+type cacheRequest struct {
+	method string
+	header map[string]string
+	state  string
+}
+
+func TestCacheFetch(t *testing.T) {
+	fresh := map[string]string{"Cache-Control": "max-age=60", "Cache-Tag": "books"}
+
+	tests := []struct {
+		name      string
+		responses []func(http.ResponseWriter, *http.Request)
+		requests  []cacheRequest
+		wantCalls int32
+	}{
+		{
+			name:      "hit after miss",
+			responses: []func(http.ResponseWriter, *http.Request){respond(http.StatusOK, fresh, "v1")},
+			requests: []cacheRequest{
+				{method: http.MethodGet, state: stateMiss},
+				{method: http.MethodGet, state: stateHit},
+				{method: http.MethodHead, state: stateHit},
+			},
+			wantCalls: 1,
+		},
+		{
+			name:      "request no-store bypasses cache",
+			responses: []func(http.ResponseWriter, *http.Request){respond(http.StatusOK, fresh, "v1")},
+			requests: []cacheRequest{
+				{method: http.MethodGet, header: map[string]string{"Cache-Control": "no-store"}},
+				{method: http.MethodGet, state: stateMiss},
+			},
+			wantCalls: 2,
+		},
+		{
+			name:      "private response is not stored",
+			responses: []func(http.ResponseWriter, *http.Request){respond(http.StatusOK, map[string]string{"Cache-Control": "private, max-age=60"}, "v1")},
+			requests: []cacheRequest{
+				{method: http.MethodGet, state: stateMiss},
+				{method: http.MethodGet, state: stateMiss},
+			},
+			wantCalls: 2,
+		},
+		{
+			name: "successful unsafe method purges",
+			responses: []func(http.ResponseWriter, *http.Request){
+				respond(http.StatusOK, fresh, "v1"),
+				respond(http.StatusNoContent, nil, ""),
+				respond(http.StatusOK, fresh, "v2"),
+			},
+			requests: []cacheRequest{
+				{method: http.MethodGet, state: stateMiss},
+				{method: http.MethodPost},
+				{method: http.MethodGet, state: stateMiss},
+			},
+			wantCalls: 3,
+		},
+		{
+			name:      "only-if-cached without entry",
+			responses: []func(http.ResponseWriter, *http.Request){respond(http.StatusOK, fresh, "v1")},
+			requests: []cacheRequest{
+				{method: http.MethodGet, header: map[string]string{"Cache-Control": "only-if-cached"}},
+			},
+			wantCalls: 0,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCache(t, nil)
+			up := &upstream{responses: tt.responses}
+			h := c.Handler("books", &RouteConfig{}, up)
+
+			for i, r := range tt.requests {
+				rec := do(h, r.method, r.header)
+				if got := rec.Header().Get(stateHeader); got != r.state {
+					t.Errorf("request %d: X-Cache = %q, want %q", i, got, r.state)
+				}
+				if r.state != "" && rec.Header().Get(tagHeader) != "" {
+					t.Errorf("request %d: Cache-Tag sent to client", i)
+				}
+			}
+			if up.calls != tt.wantCalls {
+				t.Errorf("upstream calls = %d, want %d", up.calls, tt.wantCalls)
+			}
+		})
+	}
+}
+
+func TestCacheRevalidate(t *testing.T) {
+	// max-age=0 加上 ETag, 每次使用前都要 revalidate
+	stale := map[string]string{"Cache-Control": "max-age=0, stale-if-error=60", "ETag": `"v1"`}
+	large := strings.Repeat("x", 64)
+
+	tests := []struct {
+		name      string
+		response  func(http.ResponseWriter, *http.Request)
+		wantState string
+		wantBody  string
+		// wantStored revalidate 之後 cache 中的 body
+		wantStored string
+	}{
+		{
+			name:       "not modified",
+			response:   respond(http.StatusNotModified, map[string]string{"Cache-Control": "max-age=0"}, ""),
+			wantState:  stateRevalidated,
+			wantBody:   "v1",
+			wantStored: "v1",
+		},
+		{
+			name:       "upstream error within stale-if-error",
+			response:   respond(http.StatusBadGateway, nil, "bad gateway"),
+			wantState:  stateStale,
+			wantBody:   "v1",
+			wantStored: "v1",
+		},
+		{
+			name:       "replaced",
+			response:   respond(http.StatusOK, map[string]string{"Cache-Control": "max-age=60"}, "v2"),
+			wantState:  stateMiss,
+			wantBody:   "v2",
+			wantStored: "v2",
+		},
+		{
+			// 超過 max_entry_bytes 的 response 串流給 client, 不取代舊內容
+			name:       "larger than max entry",
+			response:   respond(http.StatusOK, map[string]string{"Cache-Control": "max-age=60"}, large),
+			wantState:  stateMiss,
+			wantBody:   large,
+			wantStored: "v1",
+		},
+		{
+			name:       "large upstream error within stale-if-error",
+			response:   respond(http.StatusInternalServerError, nil, large),
+			wantState:  stateStale,
+			wantBody:   "v1",
+			wantStored: "v1",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := newTestCache(t, &Config{MaxEntryBytes: 16})
+			up := &upstream{responses: []func(http.ResponseWriter, *http.Request){respond(http.StatusOK, stale, "v1"), tt.response}}
+			rc := &RouteConfig{}
+			h := c.Handler("books", rc, up)
+
+			do(h, http.MethodGet, nil)
+			rec := do(h, http.MethodGet, nil)
+
+			if got := up.last.Header.Get("If-None-Match"); got != `"v1"` {
+				t.Errorf("If-None-Match = %q, want the stored ETag", got)
+			}
+			if got := rec.Header().Get(stateHeader); got != tt.wantState {
+				t.Errorf("X-Cache = %q, want %q", got, tt.wantState)
+			}
+			if got := rec.Body.String(); got != tt.wantBody {
+				t.Errorf("body = %q, want %q", got, tt.wantBody)
+			}
+
+			obj, ok := c.get(rc.key("books", httptest.NewRequest(http.MethodGet, "http://example.com/books?a=1&b=2", nil)))
+			if !ok || string(obj.Entries[0].Body) != tt.wantStored {
+				t.Errorf("stored entry = %v, want body %q", obj, tt.wantStored)
+			}
+		})
+	}
+}
+
+func TestCacheRevalidateAsyncDiscardsLargeBody(t *testing.T) {
+	c := newTestCache(t, &Config{MaxEntryBytes: 16})
+	up := &upstream{responses: []func(http.ResponseWriter, *http.Request){
+		respond(http.StatusOK, map[string]string{"Cache-Control": "max-age=0, stale-while-revalidate=60", "ETag": `"v1"`}, "v1"),
+		respond(http.StatusOK, map[string]string{"Cache-Control": "max-age=60"}, strings.Repeat("x", 64)),
+	}}
+	rc := &RouteConfig{}
+	h := c.Handler("books", rc, up)
+
+	do(h, http.MethodGet, nil)
+	rec := do(h, http.MethodGet, nil)
+	if got := rec.Header().Get(stateHeader); got != stateStale {
+		t.Fatalf("X-Cache = %q, want %q", got, stateStale)
+	}
+	c.wg.Wait()
+
+	if up.calls != 2 {
+		t.Errorf("upstream calls = %d, want 2", up.calls)
+	}
+	obj, ok := c.get(rc.key("books", httptest.NewRequest(http.MethodGet, "http://example.com/books?a=1&b=2", nil)))
+	if !ok || string(obj.Entries[0].Body) != "v1" {
+		t.Errorf("background revalidation replaced the entry with a body over max_entry_bytes")
+	}
+}
+
+func TestCachePurge(t *testing.T) {
+	c := newTestCache(t, nil)
+	up := &upstream{responses: []func(http.ResponseWriter, *http.Request){
+		respond(http.StatusOK, map[string]string{"Cache-Control": "max-age=60", "Cache-Tag": "books, hot"}, "v1"),
+	}}
+	h := c.Handler("books", &RouteConfig{Tags: []string{"route:books"}}, up)
+
+	for _, tag := range []string{"hot", "route:books"} {
+		do(h, http.MethodGet, nil)
+		if n := c.PurgeTag(tag); n != 1 {
+			t.Errorf("PurgeTag(%s) = %d, want 1", tag, n)
+		}
+		if rec := do(h, http.MethodGet, nil); rec.Header().Get(stateHeader) != stateMiss {
+			t.Errorf("X-Cache after PurgeTag(%s) = %q, want MISS", tag, rec.Header().Get(stateHeader))
+		}
+		c.PurgeTag(tag)
+	}
+	if n := c.PurgeTag("unknown"); n != 0 {
+		t.Errorf("PurgeTag(unknown) = %d, want 0", n)
+	}
+}
+
+func TestRecorderOverflow(t *testing.T) {
+	chunk := []byte(strings.Repeat("x", 10))
+
+	for _, stream := range []bool{false, true} {
+		client := httptest.NewRecorder()
+		rec := newRecorder(16)
+		rec.overflow = func() http.ResponseWriter {
+			if stream {
+				return client
+			}
+			return nil
+		}
+		rec.Header().Set("Cache-Tag", "books")
+
+		for i := 0; i < 5; i++ {
+			if n, err := rec.Write(chunk); n != len(chunk) || err != nil {
+				t.Fatalf("Write() = %d, %v", n, err)
+			}
+			if int64(rec.body.Len()) > rec.max {
+				t.Fatalf("recorder buffered %d bytes, max %d", rec.body.Len(), rec.max)
+			}
+		}
+		if !rec.exceeded {
+			t.Error("exceeded not set")
+		}
+
+		want := 0
+		if stream {
+			want = 5 * len(chunk)
+		}
+		if client.Body.Len() != want {
+			t.Errorf("stream %v: client received %d bytes, want %d", stream, client.Body.Len(), want)
+		}
+		if stream && (client.Header().Get(stateHeader) != stateMiss || client.Header().Get(tagHeader) != "") {
+			t.Errorf("streamed header = %v", client.Header())
+		}
+	}
+}
@@ -0,0 +1,112 @@
+package cache
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+)
+
+// cache defaults
+const (
+	defaultMaxBytes      = 64 << 20
+	defaultMaxEntryBytes = 1 << 20
+	defaultMaxVariants   = 8
+)
+
+// Key components
+const (
+	KeyMethod = "method"
+	KeyHost   = "host"
+	KeyPath   = "path"
+	KeyQuery  = "query"
+	// KeyHeaderPrefix header:<name>
+	KeyHeaderPrefix = "header:"
+	// KeyCookiePrefix cookie:<name>
+	KeyCookiePrefix = "cookie:"
+)
+
+// Config the structure for the `cache:` section, 所有 route 共用同一個 store
+type Config struct {
+	// MaxBytes memory LRU 的大小, 預設 64MB
+	MaxBytes int64 `yaml:"max_bytes" mapstructure:"max_bytes" validate:"min=0"`
+	// MaxEntryBytes 超過的 response 不會被 cache, 預設 1MB
+	MaxEntryBytes int64       `yaml:"max_entry_bytes" mapstructure:"max_entry_bytes" validate:"min=0"`
+	Disk          *DiskConfig `validate:"omitempty"`
+}
+
+// DiskConfig 第二層 cache, memory 被淘汰的內容仍可從 disk 取回, 重啟後保留
+type DiskConfig struct {
+	Path     string `validate:"required"`
+	MaxBytes int64  `yaml:"max_bytes" mapstructure:"max_bytes" validate:"required,min=1"`
+}
+
+// RouteConfig 只有 GET 與 HEAD 會使用 cache, 其他 method 成功時清除相同 key 的內容
+type RouteConfig struct {
+	// Key 組成 cache key 的欄位, 預設為 host, path, query
+	Key []string `validate:"dive,required"`
+	// DefaultTTL upstream 沒有提供 Cache-Control 或 Expires 時的有效時間, 0 表示不 cache
+	DefaultTTL time.Duration `yaml:"default_ttl" mapstructure:"default_ttl" validate:"min=0"`
+	// Tags 附加在此 route 所有內容上, 另外也會讀取 upstream 的 Cache-Tag header
+	Tags []string
+}
+
+// key route 名稱加上設定的欄位, 例如 accounts|localhost:8080|/api/v1/accounts/1|a=1
+func (rc *RouteConfig) key(route string, req *http.Request) string {
+	fields := rc.Key
+	if len(fields) == 0 {
+		fields = []string{KeyHost, KeyPath, KeyQuery}
+	}
+
+	parts := make([]string, 0, len(fields)+1)
+	parts = append(parts, route)
+	for _, f := range fields {
+		switch {
+		case f == KeyMethod:
+			method := req.Method
+			if method == http.MethodHead {
+				method = http.MethodGet
+			}
+			parts = append(parts, method)
+		case f == KeyHost:
+			parts = append(parts, strings.ToLower(req.Host))
+		case f == KeyPath:
+			parts = append(parts, req.URL.Path)
+		case f == KeyQuery:
+			parts = append(parts, sortedQuery(req.URL.Query()))
+		case strings.HasPrefix(f, KeyHeaderPrefix):
+			parts = append(parts, req.Header.Get(strings.TrimPrefix(f, KeyHeaderPrefix)))
+		case strings.HasPrefix(f, KeyCookiePrefix):
+			value := ""
+			if c, err := req.Cookie(strings.TrimPrefix(f, KeyCookiePrefix)); err == nil {
+				value = c.Value
+			}
+			parts = append(parts, value)
+		}
+	}
+	return strings.Join(parts, "|")
+}
+
+// sortedQuery 參數順序不同的 url 使用同一個 key
+func sortedQuery(q url.Values) string {
+	for _, v := range q {
+		sort.Strings(v)
+	}
+	return q.Encode()
+}
+
+// Check key 欄位必須是已知的名稱
+func (rc *RouteConfig) Check() error {
+	for _, f := range rc.Key {
+		switch {
+		case f == KeyMethod, f == KeyHost, f == KeyPath, f == KeyQuery:
+		case strings.HasPrefix(f, KeyHeaderPrefix) && len(f) > len(KeyHeaderPrefix):
+		case strings.HasPrefix(f, KeyCookiePrefix) && len(f) > len(KeyCookiePrefix):
+		default:
+			return fmt.Errorf("unknown cache key field %q", f)
+		}
+	}
+	return nil
+}
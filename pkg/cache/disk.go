@@ -0,0 +1,218 @@
+package cache
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/rs/zerolog/log"
+)
+
+// diskExt cache 檔案的副檔名, 目錄中的其他檔案不會被讀取或刪除
+const diskExt = ".cache"
+
+// diskStore 每個 object 一個 gob 檔, 啟動時讀取目錄重建 index
+type diskStore struct {
+	mu       sync.Mutex
+	dir      string
+	maxBytes int64
+	size     int64
+	lru      *list.List
+	items    map[string]*list.Element
+	tags     map[string]map[string]struct{}
+}
+
+type diskItem struct {
+	key  string
+	file string
+	size int64
+	tags []string
+}
+
+func newDiskStore(cfg *DiskConfig) (*diskStore, error) {
+	if err := os.MkdirAll(cfg.Path, 0o755); err != nil {
+		return nil, fmt.Errorf("cache: %v", err)
+	}
+
+	s := &diskStore{
+		dir:      cfg.Path,
+		maxBytes: cfg.MaxBytes,
+		lru:      list.New(),
+		items:    map[string]*list.Element{},
+		tags:     map[string]map[string]struct{}{},
+	}
+
+	files, err := ioutil.ReadDir(cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("cache: %v", err)
+	}
+	// 依修改時間由舊到新加入, 最新的在 LRU 前端
+	sort.Slice(files, func(i, j int) bool { return files[i].ModTime().Before(files[j].ModTime()) })
+	for _, fi := range files {
+		if fi.IsDir() || !strings.HasSuffix(fi.Name(), diskExt) {
+			continue
+		}
+		file := filepath.Join(cfg.Path, fi.Name())
+		obj, err := readObject(file)
+		if err != nil {
+			log.Warn().Str("file", file).Msgf("cache: removing unreadable file: %v", err)
+			_ = os.Remove(file)
+			continue
+		}
+		s.add(&diskItem{key: obj.Key, file: file, size: fi.Size(), tags: obj.tags()})
+	}
+	s.evict()
+
+	log.Info().Str("path", cfg.Path).Int("entries", len(s.items)).Int64("bytes", s.size).Msg("disk cache loaded")
+	return s, nil
+}
+
+func (s *diskStore) get(key string) (*object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	item := el.Value.(*diskItem)
+	obj, err := readObject(item.file)
+	if err != nil {
+		log.Warn().Str("file", item.file).Msgf("cache: %v", err)
+		s.remove(key)
+		return nil, false
+	}
+	s.lru.MoveToFront(el)
+	return obj, true
+}
+
+func (s *diskStore) set(obj *object) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(obj.Key)
+
+	file := filepath.Join(s.dir, fileName(obj.Key))
+	size, err := writeObject(file, obj)
+	if err != nil {
+		log.Error().Str("file", file).Msgf("cache: %v", err)
+		return
+	}
+	s.add(&diskItem{key: obj.Key, file: file, size: size, tags: obj.tags()})
+	s.evict()
+}
+
+func (s *diskStore) delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.remove(key)
+}
+
+func (s *diskStore) purgeTag(tag string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for key := range s.tags[tag] {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		s.remove(key)
+	}
+	return keys
+}
+
+func (s *diskStore) stats() (entries int, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items), s.size
+}
+
+func (s *diskStore) add(item *diskItem) {
+	s.items[item.key] = s.lru.PushFront(item)
+	s.size += item.size
+	for _, t := range item.tags {
+		if s.tags[t] == nil {
+			s.tags[t] = map[string]struct{}{}
+		}
+		s.tags[t][item.key] = struct{}{}
+	}
+}
+
+func (s *diskStore) evict() {
+	for s.size > s.maxBytes && s.lru.Len() > 0 {
+		s.remove(s.lru.Back().Value.(*diskItem).key)
+	}
+}
+
+// remove 呼叫前必須持有 mu
+func (s *diskStore) remove(key string) bool {
+	el, ok := s.items[key]
+	if !ok {
+		return false
+	}
+	item := el.Value.(*diskItem)
+	s.lru.Remove(el)
+	delete(s.items, key)
+	s.size -= item.size
+	for _, t := range item.tags {
+		delete(s.tags[t], key)
+		if len(s.tags[t]) == 0 {
+			delete(s.tags, t)
+		}
+	}
+	if err := os.Remove(item.file); err != nil && !os.IsNotExist(err) {
+		log.Warn().Str("file", item.file).Msgf("cache: %v", err)
+	}
+	return true
+}
+
+func fileName(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:]) + diskExt
+}
+
+func readObject(file string) (*object, error) {
+	f, err := os.Open(file)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var obj object
+	if err := gob.NewDecoder(f).Decode(&obj); err != nil {
+		return nil, err
+	}
+	return &obj, nil
+}
+
+// writeObject 先寫入暫存檔再 rename, 避免留下寫到一半的檔案
+func writeObject(file string, obj *object) (int64, error) {
+	tmp, err := ioutil.TempFile(filepath.Dir(file), ".tmp-*")
+	if err != nil {
+		return 0, err
+	}
+	defer os.Remove(tmp.Name())
+
+	if err := gob.NewEncoder(tmp).Encode(obj); err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	fi, err := tmp.Stat()
+	if err != nil {
+		tmp.Close()
+		return 0, err
+	}
+	if err := tmp.Close(); err != nil {
+		return 0, err
+	}
+	return fi.Size(), os.Rename(tmp.Name(), file)
+}
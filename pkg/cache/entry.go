@@ -0,0 +1,280 @@
+package cache
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// heuristic freshness (RFC 7234 4.2.2), Last-Modified 到現在的 10%, 最多一天
+const (
+	heuristicFraction = 10
+	maxHeuristicTTL   = 24 * time.Hour
+)
+
+// Entry 一個 response, 同一個 key 依 Vary 可以有多個 Entry
+type Entry struct {
+	Status int
+	Header http.Header
+	Body   []byte
+	// Vary 儲存時 request 中被 Vary 指定的 header 值
+	Vary map[string]string
+	Tags []string
+
+	// ResponseTime 收到 response 的時間, 用來計算 age
+	ResponseTime time.Time
+	// InitialAge 收到時已經存在的 age (Age header 與 Date 的差)
+	InitialAge time.Duration
+	TTL        time.Duration
+
+	StaleWhileRevalidate time.Duration
+	StaleIfError         time.Duration
+	// NoCache 每次使用前都要 revalidate
+	NoCache bool
+	// MustRevalidate 過期後不得使用 stale 內容
+	MustRevalidate bool
+}
+
+// object 一個 key 下的所有 variants, 為 store 的單位
+type object struct {
+	Key     string
+	Entries []*Entry
+}
+
+func (o *object) size() int64 {
+	n := int64(len(o.Key))
+	for _, e := range o.Entries {
+		n += e.size()
+	}
+	return n
+}
+
+func (o *object) tags() []string {
+	seen := map[string]bool{}
+	var tags []string
+	for _, e := range o.Entries {
+		for _, t := range e.Tags {
+			if !seen[t] {
+				seen[t] = true
+				tags = append(tags, t)
+			}
+		}
+	}
+	return tags
+}
+
+// match 回傳符合 request Vary header 的 variant
+func (o *object) match(req *http.Request) *Entry {
+	for _, e := range o.Entries {
+		if e.matchVary(req) {
+			return e
+		}
+	}
+	return nil
+}
+
+func (e *Entry) size() int64 {
+	n := int64(len(e.Body))
+	for k, vs := range e.Header {
+		n += int64(len(k))
+		for _, v := range vs {
+			n += int64(len(v))
+		}
+	}
+	return n
+}
+
+func (e *Entry) matchVary(req *http.Request) bool {
+	for name, value := range e.Vary {
+		if normalizeVary(req.Header.Values(name)) != value {
+			return false
+		}
+	}
+	return true
+}
+
+// Age current_age (RFC 7234 4.2.3)
+func (e *Entry) Age(now time.Time) time.Duration {
+	return e.InitialAge + now.Sub(e.ResponseTime)
+}
+
+// Fresh ...
+func (e *Entry) Fresh(now time.Time) bool {
+	return !e.NoCache && e.Age(now) < e.TTL
+}
+
+// ETag ...
+func (e *Entry) ETag() string {
+	return e.Header.Get("ETag")
+}
+
+// newEntry 依 response 的 Cache-Control、Expires 與 Date 計算有效時間, 不可 cache 時回傳 nil
+func newEntry(req *http.Request, status int, header http.Header, body []byte, requestTime, responseTime time.Time, defaultTTL time.Duration) *Entry {
+	if !cacheableStatus(status) {
+		return nil
+	}
+
+	reqCC := parseCacheControl(req.Header)
+	cc := parseCacheControl(header)
+	if reqCC.has("no-store") || cc.has("no-store") || cc.has("private") {
+		return nil
+	}
+	// shared cache 不保存帶有 Authorization 的 response, 除非 upstream 明確允許
+	if req.Header.Get("Authorization") != "" && !cc.has("public") && !cc.has("s-maxage") && !cc.has("must-revalidate") {
+		return nil
+	}
+	if header.Get("Set-Cookie") != "" {
+		return nil
+	}
+
+	vary := map[string]string{}
+	for _, v := range header.Values("Vary") {
+		for _, name := range strings.Split(v, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil
+			}
+			if name != "" {
+				vary[name] = normalizeVary(req.Header.Values(name))
+			}
+		}
+	}
+
+	e := &Entry{
+		Status:       status,
+		Header:       header.Clone(),
+		Body:         body,
+		Vary:         vary,
+		ResponseTime: responseTime,
+	}
+	e.update(header, requestTime, responseTime, defaultTTL)
+
+	if e.TTL <= 0 && !e.revalidatable() {
+		return nil
+	}
+	return e
+}
+
+// update 重新計算 freshness, 收到 304 時也會呼叫
+func (e *Entry) update(header http.Header, requestTime, responseTime time.Time, defaultTTL time.Duration) {
+	cc := parseCacheControl(header)
+	e.ResponseTime = responseTime
+
+	// apparent_age 與 corrected_age_value 取較大者
+	e.InitialAge = 0
+	if date, err := http.ParseTime(header.Get("Date")); err == nil && responseTime.After(date) {
+		e.InitialAge = responseTime.Sub(date)
+	}
+	if age, err := strconv.Atoi(header.Get("Age")); err == nil {
+		if corrected := time.Duration(age)*time.Second + responseTime.Sub(requestTime); corrected > e.InitialAge {
+			e.InitialAge = corrected
+		}
+	}
+
+	e.TTL = lifetime(header, cc, responseTime, defaultTTL)
+	e.StaleWhileRevalidate = cc.duration("stale-while-revalidate")
+	e.StaleIfError = cc.duration("stale-if-error")
+	e.NoCache = cc.has("no-cache")
+	e.MustRevalidate = cc.has("must-revalidate") || cc.has("proxy-revalidate")
+}
+
+// lifetime freshness_lifetime (RFC 7234 4.2.1), shared cache 優先使用 s-maxage
+func lifetime(header http.Header, cc cacheControl, now time.Time, defaultTTL time.Duration) time.Duration {
+	if cc.has("s-maxage") {
+		return cc.duration("s-maxage")
+	}
+	if cc.has("max-age") {
+		return cc.duration("max-age")
+	}
+	if v := header.Get("Expires"); v != "" {
+		expires, err := http.ParseTime(v)
+		if err != nil {
+			// 無效的 Expires 代表已經過期
+			return 0
+		}
+		date, err := http.ParseTime(header.Get("Date"))
+		if err != nil {
+			date = now
+		}
+		return expires.Sub(date)
+	}
+	if defaultTTL > 0 {
+		return defaultTTL
+	}
+	if lm, err := http.ParseTime(header.Get("Last-Modified")); err == nil && now.After(lm) {
+		ttl := now.Sub(lm) / heuristicFraction
+		if ttl > maxHeuristicTTL {
+			ttl = maxHeuristicTTL
+		}
+		return ttl
+	}
+	return 0
+}
+
+// revalidatable 過期後仍可以 conditional request 重新驗證
+func (e *Entry) revalidatable() bool {
+	return e.Header.Get("ETag") != "" || e.Header.Get("Last-Modified") != ""
+}
+
+// cacheableStatus heuristically cacheable status codes (RFC 7231 6.1)
+func cacheableStatus(status int) bool {
+	switch status {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusNotFound,
+		http.StatusMethodNotAllowed, http.StatusGone, http.StatusRequestURITooLong,
+		http.StatusNotImplemented, http.StatusPermanentRedirect:
+		return true
+	}
+	return false
+}
+
+func normalizeVary(values []string) string {
+	parts := make([]string, 0, len(values))
+	for _, v := range values {
+		for _, p := range strings.Split(v, ",") {
+			if p = strings.TrimSpace(p); p != "" {
+				parts = append(parts, p)
+			}
+		}
+	}
+	return strings.Join(parts, ",")
+}
+
+// cacheControl directive -> argument
+type cacheControl map[string]string
+
+func parseCacheControl(h http.Header) cacheControl {
+	cc := cacheControl{}
+	for _, v := range h.Values("Cache-Control") {
+		for _, part := range strings.Split(v, ",") {
+			part = strings.TrimSpace(part)
+			if part == "" {
+				continue
+			}
+			name, arg := part, ""
+			if i := strings.Index(part, "="); i >= 0 {
+				name, arg = part[:i], strings.Trim(strings.TrimSpace(part[i+1:]), `"`)
+			}
+			cc[strings.ToLower(strings.TrimSpace(name))] = arg
+		}
+	}
+	if len(cc) == 0 && strings.EqualFold(h.Get("Pragma"), "no-cache") {
+		cc["no-cache"] = ""
+	}
+	return cc
+}
+
+func (cc cacheControl) has(name string) bool {
+	_, ok := cc[name]
+	return ok
+}
+
+// duration delta-seconds, 無效的值視為 0
+func (cc cacheControl) duration(name string) time.Duration {
+	n, err := strconv.ParseInt(cc[name], 10, 64)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
@@ -0,0 +1,115 @@
+package cache
+
+import (
+	"container/list"
+	"sync"
+)
+
+// memoryStore 以 byte 數限制大小的 LRU
+type memoryStore struct {
+	mu       sync.Mutex
+	maxBytes int64
+	size     int64
+	lru      *list.List // front 為最近使用
+	items    map[string]*list.Element
+	tags     map[string]map[string]struct{}
+}
+
+type memoryItem struct {
+	obj  *object
+	size int64
+	tags []string
+}
+
+func newMemoryStore(maxBytes int64) *memoryStore {
+	return &memoryStore{
+		maxBytes: maxBytes,
+		lru:      list.New(),
+		items:    map[string]*list.Element{},
+		tags:     map[string]map[string]struct{}{},
+	}
+}
+
+func (s *memoryStore) get(key string) (*object, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	el, ok := s.items[key]
+	if !ok {
+		return nil, false
+	}
+	s.lru.MoveToFront(el)
+	return el.Value.(*memoryItem).obj, true
+}
+
+func (s *memoryStore) set(obj *object) {
+	size := obj.size()
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.remove(obj.Key)
+	if size > s.maxBytes {
+		return
+	}
+
+	item := &memoryItem{obj: obj, size: size, tags: obj.tags()}
+	s.items[obj.Key] = s.lru.PushFront(item)
+	s.size += size
+	for _, t := range item.tags {
+		if s.tags[t] == nil {
+			s.tags[t] = map[string]struct{}{}
+		}
+		s.tags[t][obj.Key] = struct{}{}
+	}
+
+	for s.size > s.maxBytes {
+		oldest := s.lru.Back()
+		s.remove(oldest.Value.(*memoryItem).obj.Key)
+	}
+}
+
+func (s *memoryStore) delete(key string) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.remove(key)
+}
+
+func (s *memoryStore) purgeTag(tag string) []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var keys []string
+	for key := range s.tags[tag] {
+		keys = append(keys, key)
+	}
+	for _, key := range keys {
+		s.remove(key)
+	}
+	return keys
+}
+
+func (s *memoryStore) stats() (entries int, size int64) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.items), s.size
+}
+
+// remove 呼叫前必須持有 mu
+func (s *memoryStore) remove(key string) bool {
+	el, ok := s.items[key]
+	if !ok {
+		return false
+	}
+	item := el.Value.(*memoryItem)
+	s.lru.Remove(el)
+	delete(s.items, key)
+	s.size -= item.size
+	for _, t := range item.tags {
+		delete(s.tags[t], key)
+		if len(s.tags[t]) == 0 {
+			delete(s.tags, t)
+		}
+	}
+	return true
+}
@@ -0,0 +1,188 @@
+package cache
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strings"
+)
+
+// teeWriter 寫給 client 的同時保留一份 response, 超過 max 時放棄保存
+type teeWriter struct {
+	http.ResponseWriter
+	max int64
+	// base 呼叫 upstream 之前已經存在的 header, 例如 X-Request-Id, RateLimit-*, 不保存到 cache
+	base http.Header
+
+	status int
+	header http.Header
+	tags   []string
+	body   bytes.Buffer
+	skip   bool
+}
+
+func (w *teeWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+
+	h := w.ResponseWriter.Header()
+	w.tags = splitTags(h.Values(tagHeader))
+	h.Del(tagHeader)
+	w.header = http.Header{}
+	for k, v := range h {
+		if !reflect.DeepEqual(w.base[k], v) {
+			w.header[k] = append([]string(nil), v...)
+		}
+	}
+	h.Set(stateHeader, stateMiss)
+
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *teeWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.skip {
+		if int64(w.body.Len()+len(b)) > w.max {
+			w.skip = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(b)
+		}
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	if err != nil {
+		w.skip = true
+	}
+	return n, err
+}
+
+// Flush 串流的 response 仍可即時送出
+func (w *teeWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap for http.ResponseController
+func (w *teeWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// recorder 保存 response, revalidate 時先判斷結果才決定回給 client 的內容.
+// body 超過 max 時不再保存, 由 overflow 決定改為串流給 client 或丟棄
+type recorder struct {
+	status int
+	header http.Header
+	body   bytes.Buffer
+	max    int64
+
+	// overflow 回傳超過 max 之後的去向, nil 表示丟棄
+	overflow func() http.ResponseWriter
+	exceeded bool
+	stream   http.ResponseWriter
+}
+
+func newRecorder(max int64) *recorder {
+	return &recorder{header: http.Header{}, max: max}
+}
+
+func (r *recorder) Header() http.Header {
+	return r.header
+}
+
+func (r *recorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+}
+
+func (r *recorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	if !r.exceeded && int64(r.body.Len()+len(b)) > r.max {
+		r.exceeded = true
+		if r.overflow != nil {
+			r.stream = r.overflow()
+		}
+		if r.stream != nil {
+			r.writeTo(r.stream, stateMiss)
+		}
+		r.body = bytes.Buffer{}
+	}
+
+	switch {
+	case !r.exceeded:
+		return r.body.Write(b)
+	case r.stream != nil:
+		return r.stream.Write(b)
+	default:
+		return len(b), nil
+	}
+}
+
+// Flush 改為串流後才有作用
+func (r *recorder) Flush() {
+	if f, ok := r.stream.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// writeTo 將 recorder 的內容送給 client
+func (r *recorder) writeTo(w http.ResponseWriter, state string) {
+	h := w.Header()
+	for k, v := range r.header {
+		h[k] = v
+	}
+	h.Del(tagHeader)
+	h.Set(stateHeader, state)
+	w.WriteHeader(r.status)
+	_, _ = w.Write(r.body.Bytes())
+}
+
+// statusWriter 記錄非 GET request 的 status, 成功時清除 cache
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+func (w *statusWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush ...
+func (w *statusWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap for http.ResponseController
+func (w *statusWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func splitTags(values []string) []string {
+	var tags []string
+	for _, v := range values {
+		for _, t := range strings.FieldsFunc(v, func(r rune) bool { return r == ',' || r == ' ' }) {
+			tags = append(tags, t)
+		}
+	}
+	return tags
+}
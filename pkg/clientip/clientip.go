@@ -0,0 +1,90 @@
+// Package clientip 取得 request 的 client 位址, 只有直接連線的來源是可信任的 proxy 時才參考 X-Forwarded-For
+package clientip
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync/atomic"
+)
+
+// trusted []*net.IPNet
+var trusted atomic.Value
+
+func init() {
+	trusted.Store([]*net.IPNet(nil))
+}
+
+// SetTrustedProxies 設定可信任的 proxy, 接受 CIDR 或單一 IP, 沒有設定時一律使用 RemoteAddr
+func SetTrustedProxies(proxies []string) error {
+	nets := make([]*net.IPNet, 0, len(proxies))
+	for _, p := range proxies {
+		p = strings.TrimSpace(p)
+		if !strings.Contains(p, "/") {
+			ip := net.ParseIP(p)
+			if ip == nil {
+				return fmt.Errorf("invalid trusted proxy %q", p)
+			}
+			bits := 8 * net.IPv6len
+			if ip.To4() != nil {
+				ip, bits = ip.To4(), 8*net.IPv4len
+			}
+			nets = append(nets, &net.IPNet{IP: ip, Mask: net.CIDRMask(bits, bits)})
+			continue
+		}
+		_, n, err := net.ParseCIDR(p)
+		if err != nil {
+			return fmt.Errorf("invalid trusted proxy %q", p)
+		}
+		nets = append(nets, n)
+	}
+	trusted.Store(nets)
+	return nil
+}
+
+// FromRequest RemoteAddr 為可信任的 proxy 時, 由右至左取 X-Forwarded-For 中第一個不可信任的位址
+func FromRequest(req *http.Request) string {
+	remote := RemoteIP(req)
+	if !isTrusted(remote) {
+		return remote
+	}
+
+	if xff := req.Header.Values("X-Forwarded-For"); len(xff) > 0 {
+		hops := strings.Split(strings.Join(xff, ","), ",")
+		for i := len(hops) - 1; i >= 0; i-- {
+			ip := strings.TrimSpace(hops[i])
+			if ip == "" {
+				continue
+			}
+			if !isTrusted(ip) || i == 0 {
+				return ip
+			}
+		}
+	}
+	if ip := strings.TrimSpace(req.Header.Get("X-Real-Ip")); ip != "" {
+		return ip
+	}
+	return remote
+}
+
+// RemoteIP 直接連線的 client 位址, 不參考 X-Forwarded-For
+func RemoteIP(req *http.Request) string {
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		return ip
+	}
+	return req.RemoteAddr
+}
+
+func isTrusted(addr string) bool {
+	ip := net.ParseIP(addr)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted.Load().([]*net.IPNet) {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
@@ -1,13 +1,20 @@
 package config
 
 import (
+	"apigateway/pkg/cache"
 	"apigateway/pkg/database"
+	"apigateway/pkg/event"
+	"apigateway/pkg/health"
+	"apigateway/pkg/lifecycle"
+	"apigateway/pkg/proxy"
+	"apigateway/pkg/router/grpc"
 	"apigateway/pkg/router/http"
 
 	"fmt"
 	"path/filepath"
 	"runtime"
 
+	"github.com/mitchellh/mapstructure"
 	"github.com/rs/zerolog/log"
 	"github.com/spf13/viper"
 	"go.uber.org/fx"
@@ -17,21 +24,49 @@ import (
 type Config struct {
 	fx.Out
 
-	HTTP  *http.Config
-	MySQL *database.RdbmsConfig
+	Log       *LogConfig
+	HTTP      *http.Config     `validate:"required"`
+	GRPC      *grpc.Config     `yaml:"grpc" mapstructure:"grpc" validate:"omitempty"`
+	Databases database.Configs `validate:"dive,required"`
+	Routes    proxy.Routes     `validate:"dive,required"`
+	Upstreams proxy.Upstreams  `validate:"dive,required"`
+	// Aggregations 平行呼叫多個 upstream 並合併結果的 routes
+	Aggregations proxy.Aggregations `validate:"dive,required"`
+	// Transcodings 將 HTTP/JSON 轉成 upstream gRPC 呼叫的 routes
+	Transcodings proxy.Transcodings `validate:"dive,required"`
+	// RetryBudget 全部 route 共用的重試上限
+	RetryBudget *proxy.RetryBudgetConfig `yaml:"retry_budget" mapstructure:"retry_budget" validate:"omitempty"`
+	// Cache response cache 的 store, 各 route 另外設定是否使用
+	Cache *cache.Config `validate:"omitempty"`
+	// Events book change feed 的 replay buffer 與 heartbeat
+	Events *event.Config `validate:"omitempty"`
+	// Shutdown drain delay 與 grace period
+	Shutdown *lifecycle.Config `validate:"omitempty"`
+	// Health readiness check 的 cache 與 timeout
+	Health *health.Config `validate:"omitempty"`
 }
 
-// ProvideConfig ...
-func ProvideConfig() Config {
+// LogConfig the structure for global logger
+type LogConfig struct {
+	Level string `validate:"omitempty,oneof=trace debug info warn error fatal panic disabled"`
+}
+
+// ProvideManager 讀取設定檔並交給 Manager 管理後續的 reload
+func ProvideManager() *Manager {
 	cfg, err := CreateConfig()
 	if err != nil {
 		log.Fatal().Msg("Error create configuration failed")
 	}
 
-	return cfg
+	return NewManager(cfg)
 }
 
-// CreateConfig 讀取App 啟動程式設定檔
+// ProvideConfig 提供啟動時的設定, 執行中的變更需透過 Manager.Subscribe 取得
+func ProvideConfig(m *Manager) Config {
+	return m.Current()
+}
+
+// CreateConfig 讀取App 啟動程式設定檔, 依序合併 profile、local、環境變數與 CLI flags, 見 readLayers
 func CreateConfig(path ...string) (Config, error) {
 	viper.AutomaticEnv()
 
@@ -42,26 +77,53 @@ func CreateConfig(path ...string) (Config, error) {
 		configName = "app"
 	}
 
+	// 監看 base 設定檔用
 	viper.SetConfigName(configName)
 	viper.AddConfigPath(configPath)
 	viper.SetConfigType("yaml")
 
-	var cfg Config
+	layerMu.Lock()
+	layerDir, layerName = configPath, configName
+	layerMu.Unlock()
 
-	if err := viper.ReadInConfig(); err != nil {
+	if err := readLayers(); err != nil {
 		log.Error().Msgf("error reading config file, %s", err)
-		return cfg, err
+		return Config{}, err
 	}
 
-	err := viper.Unmarshal(&cfg)
+	return decode()
+}
+
+// decode 將 viper 目前的內容轉成 Config 並檢查
+func decode() (Config, error) {
+	var cfg Config
+
+	// 未知的 key 視為錯誤, 避免拼錯的設定被默默忽略
+	err := viper.Unmarshal(&cfg, func(dc *mapstructure.DecoderConfig) {
+		dc.ErrorUnused = true
+	})
 	if err != nil {
+		err = decodeErrors(err)
 		log.Error().Msgf("unable to decode into struct, %v", err)
 		return cfg, err
 	}
 
+	cfg.Databases.SetDefaults()
+
+	if err := Validate(cfg); err != nil {
+		log.Error().Msgf("invalid configuration, %v", err)
+		return cfg, err
+	}
+
 	return cfg, nil
 }
 
+// Path directory of the configuration files, same rule as CreateConfig
+func Path(path ...string) string {
+	viper.AutomaticEnv()
+	return resolvePath(path)
+}
+
 func resolvePath(paths []string) string {
 	switch len(paths) {
 	case 0:
@@ -82,7 +144,17 @@ func resolvePath(paths []string) string {
 // Module provide related configuration
 var Module = fx.Options(
 	fx.Provide(
+		ProvideManager,
 		ProvideConfig,
-		database.InitRdbms,
+		database.NewRegistry,
+	),
+	fx.Invoke(
+		SubscribeLogLevel,
+		SubscribeHTTP,
+		SubscribeDatabases,
+		SubscribeRoutes,
+		WatchConfig,
+		database.RegisterHealthChecks,
+		RegisterHealthCheck,
 	),
 )
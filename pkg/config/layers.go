@@ -0,0 +1,239 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+// EnvPrefix 環境變數覆寫設定的前綴, e.g. APIGW_HTTP_ADDRESS => http.address
+const EnvPrefix = "APIGW_"
+
+var (
+	// interpolateRegexp ${VAR} 或 ${VAR:-default}
+	interpolateRegexp = regexp.MustCompile(`\$\{([A-Za-z_][A-Za-z0-9_]*)(:-([^}]*))?\}`)
+
+	layerMu     sync.Mutex
+	layerDir    string
+	layerName   string
+	profile     string
+	commandLine = map[string]string{}
+	sources     = map[string]string{}
+)
+
+// SetCommandLine 設定 --profile 與 --set key=value, 需在 CreateConfig 之前呼叫
+func SetCommandLine(p string, overrides []string) error {
+	layerMu.Lock()
+	defer layerMu.Unlock()
+
+	profile = p
+	commandLine = map[string]string{}
+	for _, o := range overrides {
+		kv := strings.SplitN(o, "=", 2)
+		if len(kv) != 2 || kv[0] == "" {
+			return fmt.Errorf("invalid override %q, expect key=value", o)
+		}
+		commandLine[strings.ToLower(kv[0])] = kv[1]
+	}
+	return nil
+}
+
+// Sources 每個 key 最後由哪一層提供, 用於 explain
+func Sources() map[string]string {
+	layerMu.Lock()
+	defer layerMu.Unlock()
+
+	result := make(map[string]string, len(sources))
+	for k, v := range sources {
+		result[k] = v
+	}
+	return result
+}
+
+// readLayers 依序合併 app.yml, app.<profile>.yml, app.local.yml, APIGW_ 環境變數與 CLI flags
+func readLayers() error {
+	layerMu.Lock()
+	defer layerMu.Unlock()
+
+	next := map[string]string{}
+	secrets := &secretKey{dir: layerDir}
+
+	p := profile
+	if p == "" {
+		p = os.Getenv(EnvPrefix + "PROFILE")
+	}
+	names := []string{layerName}
+	if p != "" {
+		names = append(names, layerName+"."+p)
+	}
+	names = append(names, layerName+".local")
+
+	for i, name := range names {
+		file, data, err := readLayerFile(name)
+		if err != nil {
+			// 只有 base 檔案是必要的
+			if i == 0 || !os.IsNotExist(err) {
+				return err
+			}
+			continue
+		}
+
+		layer := viper.New()
+		layer.SetConfigType("yaml")
+		if err := layer.ReadConfig(bytes.NewReader(interpolate(data))); err != nil {
+			return fmt.Errorf("%s: %v", file, err)
+		}
+
+		settings := layer.AllSettings()
+		if err := decryptSettings("", settings, secrets); err != nil {
+			return err
+		}
+
+		if i == 0 {
+			// 清掉上一次 reload 的內容
+			if err := viper.ReadConfig(bytes.NewReader(nil)); err != nil {
+				return err
+			}
+		}
+		if err := viper.MergeConfigMap(settings); err != nil {
+			return err
+		}
+
+		for _, k := range flatten("", settings) {
+			next[k] = "file " + filepath.Base(file)
+		}
+	}
+
+	env := map[string]interface{}{}
+	for _, kv := range os.Environ() {
+		pair := strings.SplitN(kv, "=", 2)
+		if !strings.HasPrefix(pair[0], EnvPrefix) {
+			continue
+		}
+		parts := strings.Split(strings.ToLower(strings.TrimPrefix(pair[0], EnvPrefix)), "_")
+		key, ok := resolveKey(parts, reflect.TypeOf(Config{}))
+		if !ok {
+			continue
+		}
+		setNested(env, key, pair[1])
+		next[strings.Join(key, ".")] = "env " + pair[0]
+	}
+	if err := decryptSettings("", env, secrets); err != nil {
+		return err
+	}
+	if err := viper.MergeConfigMap(env); err != nil {
+		return err
+	}
+
+	flags := map[string]interface{}{}
+	for key, value := range commandLine {
+		setNested(flags, strings.Split(key, "."), value)
+		next[key] = "flag --set " + key
+	}
+	if err := decryptSettings("", flags, secrets); err != nil {
+		return err
+	}
+	if err := viper.MergeConfigMap(flags); err != nil {
+		return err
+	}
+
+	sources = next
+	return nil
+}
+
+func readLayerFile(name string) (string, []byte, error) {
+	var err error
+	for _, ext := range []string{"yml", "yaml"} {
+		file := filepath.Join(layerDir, name+"."+ext)
+		var data []byte
+		if data, err = ioutil.ReadFile(file); err == nil {
+			return file, data, nil
+		}
+		if !os.IsNotExist(err) {
+			return file, nil, err
+		}
+	}
+	return "", nil, err
+}
+
+// interpolate 以環境變數取代 ${VAR:-default}
+func interpolate(data []byte) []byte {
+	return interpolateRegexp.ReplaceAllFunc(data, func(m []byte) []byte {
+		sub := interpolateRegexp.FindSubmatch(m)
+		if value, ok := os.LookupEnv(string(sub[1])); ok && value != "" {
+			return []byte(value)
+		}
+		return sub[3]
+	})
+}
+
+// resolveKey 依 Config 結構找出底線分隔的環境變數對應的 key, key 本身也可能包含底線
+func resolveKey(parts []string, t reflect.Type) ([]string, bool) {
+	for t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	if len(parts) == 0 {
+		leaf := t == durationType || (t.Kind() != reflect.Struct && t.Kind() != reflect.Map)
+		return nil, leaf
+	}
+
+	switch {
+	case t.Kind() == reflect.Struct && t != durationType:
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous || f.PkgPath != "" {
+				continue
+			}
+			for n := 1; n <= len(parts); n++ {
+				if strings.Join(parts[:n], "_") != keyName(f) {
+					continue
+				}
+				if rest, ok := resolveKey(parts[n:], f.Type); ok {
+					return append([]string{keyName(f)}, rest...), true
+				}
+			}
+		}
+	case t.Kind() == reflect.Map:
+		for n := 1; n <= len(parts); n++ {
+			if rest, ok := resolveKey(parts[n:], t.Elem()); ok {
+				return append([]string{strings.Join(parts[:n], "_")}, rest...), true
+			}
+		}
+	}
+	return nil, false
+}
+
+func setNested(m map[string]interface{}, key []string, value interface{}) {
+	for _, k := range key[:len(key)-1] {
+		child, ok := m[k].(map[string]interface{})
+		if !ok {
+			child = map[string]interface{}{}
+			m[k] = child
+		}
+		m = child
+	}
+	m[key[len(key)-1]] = value
+}
+
+func flatten(prefix string, m map[string]interface{}) []string {
+	var keys []string
+	for k, v := range m {
+		if child, ok := v.(map[string]interface{}); ok && len(child) > 0 {
+			keys = append(keys, flatten(join(prefix, k), child)...)
+			continue
+		}
+		keys = append(keys, join(prefix, k))
+	}
+	sort.Strings(keys)
+	return keys
+}
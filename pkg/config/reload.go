@@ -0,0 +1,242 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+	"github.com/spf13/viper"
+)
+
+// hotReloadable 可以在執行中套用的欄位, 其他欄位變更時必須重啟. `*` 代表任一層, `**` 代表以下所有欄位
+var hotReloadable = []string{
+	"log",
+	"log.level",
+	"http.read_timeout",
+	"http.write_timeout",
+	"routes",
+	"upstreams",
+	"upstreams.**",
+	"aggregations",
+	"transcodings",
+	"retry_budget",
+	"retry_budget.**",
+	"databases.*.read.maxidleconns",
+	"databases.*.read.maxopenconns",
+	"databases.*.read.maxlifetimesec",
+	"databases.*.write.maxidleconns",
+	"databases.*.write.maxopenconns",
+	"databases.*.write.maxlifetimesec",
+}
+
+// Subscriber 收到新舊設定, 只會在有欄位變更時被呼叫
+type Subscriber func(old, new Config) error
+
+type subscription struct {
+	name string
+	fn   Subscriber
+}
+
+// Manager 保存目前生效的設定並通知訂閱者
+type Manager struct {
+	// reloadMu 讓 file watch 與 SIGHUP 不會同時 reload
+	reloadMu sync.Mutex
+	// stopped 關閉後不再 reload, 避免在元件停止後重建 route table
+	stopped bool
+
+	mu      sync.RWMutex
+	current Config
+	subs    []subscription
+}
+
+// NewManager ...
+func NewManager(cfg Config) *Manager {
+	return &Manager{current: cfg}
+}
+
+// Current return the config in effect
+func (m *Manager) Current() Config {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.current
+}
+
+// Subscribe register fn to be called after a reload is accepted
+func (m *Manager) Subscribe(name string, fn Subscriber) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.subs = append(m.subs, subscription{name: name, fn: fn})
+}
+
+// Reload 重新讀取所有設定層, 用於 SIGHUP. 讀取與 decode 都在 reloadMu 內, 避免 viper 被同時修改
+func (m *Manager) Reload() error {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+
+	if m.stopped {
+		log.Warn().Msg("config reload ignored, shutting down")
+		return nil
+	}
+
+	if err := readLayers(); err != nil {
+		log.Error().Msgf("config reload: error reading config file, %s", err)
+		return err
+	}
+	return m.apply()
+}
+
+// Watch 監看 base 設定檔, 變更時自動 reload
+func (m *Manager) Watch() {
+	viper.OnConfigChange(func(e fsnotify.Event) {
+		log.Info().Str("file", e.Name).Msg("config file changed")
+		_ = m.Reload()
+	})
+	viper.WatchConfig()
+}
+
+// Stop 等待進行中的 reload 結束, 之後的變更都忽略
+func (m *Manager) Stop() {
+	m.reloadMu.Lock()
+	defer m.reloadMu.Unlock()
+	m.stopped = true
+}
+
+// apply 檢查新設定, 有需要重啟的欄位變更時整份拒絕, 呼叫前需持有 reloadMu
+func (m *Manager) apply() error {
+	next, err := decode()
+	if err != nil {
+		log.Error().Msgf("config reload rejected: %v", err)
+		return err
+	}
+
+	old := m.Current()
+	changed := Diff(old, next)
+	if len(changed) == 0 {
+		log.Info().Msg("config reload: nothing changed")
+		return nil
+	}
+
+	var rejected []string
+	for _, path := range changed {
+		if RequiresRestart(path) {
+			log.Error().Str("key", path).Msg("config reload rejected: key requires restart")
+			rejected = append(rejected, path)
+		}
+	}
+	if len(rejected) > 0 {
+		return fmt.Errorf("config reload rejected, restart required for: %s", strings.Join(rejected, ", "))
+	}
+
+	m.mu.Lock()
+	m.current = next
+	subs := m.subs
+	m.mu.Unlock()
+
+	log.Info().Strs("keys", changed).Msg("config reloaded")
+
+	for _, sub := range subs {
+		if err := sub.fn(old, next); err != nil {
+			log.Error().Str("subscriber", sub.name).Msgf("config reload: apply failed, %v", err)
+		}
+	}
+
+	return nil
+}
+
+// RequiresRestart 不在 hotReloadable 中的欄位都需要重啟
+func RequiresRestart(path string) bool {
+	for _, pattern := range hotReloadable {
+		if matchPath(pattern, path) {
+			return false
+		}
+	}
+	return true
+}
+
+func matchPath(pattern, path string) bool {
+	ps := strings.Split(pattern, ".")
+	ks := strings.Split(path, ".")
+
+	for i, p := range ps {
+		if p == "**" {
+			return true
+		}
+		if i >= len(ks) {
+			return false
+		}
+		if p != "*" && p != ks[i] {
+			return false
+		}
+	}
+	return len(ps) == len(ks)
+}
+
+// Diff 列出兩份設定中不同的 key, key 與設定檔的寫法一致
+func Diff(old, new Config) []string {
+	var changed []string
+	diffValue("", reflect.ValueOf(old), reflect.ValueOf(new), &changed)
+	sort.Strings(changed)
+	return changed
+}
+
+func diffValue(path string, a, b reflect.Value, out *[]string) {
+	switch a.Kind() {
+	case reflect.Ptr:
+		if a.IsNil() || b.IsNil() {
+			if a.IsNil() != b.IsNil() {
+				*out = append(*out, path)
+			}
+			return
+		}
+		diffValue(path, a.Elem(), b.Elem(), out)
+	case reflect.Struct:
+		t := a.Type()
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous || f.PkgPath != "" {
+				continue
+			}
+			diffValue(join(path, keyName(f)), a.Field(i), b.Field(i), out)
+		}
+	case reflect.Map:
+		keys := map[string]bool{}
+		for _, k := range a.MapKeys() {
+			keys[k.String()] = true
+		}
+		for _, k := range b.MapKeys() {
+			keys[k.String()] = true
+		}
+		for k := range keys {
+			key := reflect.ValueOf(k).Convert(a.Type().Key())
+			av, bv := a.MapIndex(key), b.MapIndex(key)
+			if !av.IsValid() || !bv.IsValid() {
+				*out = append(*out, join(path, k))
+				continue
+			}
+			diffValue(join(path, k), av, bv, out)
+		}
+	default:
+		if !reflect.DeepEqual(a.Interface(), b.Interface()) {
+			*out = append(*out, path)
+		}
+	}
+}
+
+// keyName 與 viper 的 key 規則相同: mapstructure tag 優先, 否則為小寫欄位名稱
+func keyName(f reflect.StructField) string {
+	if tag := f.Tag.Get("mapstructure"); tag != "" {
+		return strings.Split(tag, ",")[0]
+	}
+	return strings.ToLower(f.Name)
+}
+
+func join(prefix, key string) string {
+	if prefix == "" {
+		return key
+	}
+	return prefix + "." + key
+}
@@ -0,0 +1,135 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"apigateway/pkg/database"
+	"apigateway/pkg/proxy"
+	"apigateway/pkg/router/http"
+)
+
+func TestMatchPath(t *testing.T) {
+	tests := []struct {
+		pattern, path string
+		want          bool
+	}{
+		{pattern: "log.level", path: "log.level", want: true},
+		{pattern: "log.level", path: "log", want: false},
+		{pattern: "log", path: "log.level", want: false},
+		{pattern: "http.**", path: "http.tls.certificates", want: true},
+		{pattern: "http.**", path: "http", want: true},
+		{pattern: "http.**", path: "grpc.address", want: false},
+		{pattern: "databases.*.read.maxopenconns", path: "databases.main.read.maxopenconns", want: true},
+		{pattern: "databases.*.read.maxopenconns", path: "databases.main.write.maxopenconns", want: false},
+		{pattern: "databases.*.read.maxopenconns", path: "databases.main.read", want: false},
+		{pattern: "databases.*", path: "databases.main.read", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pattern+"|"+tt.path, func(t *testing.T) {
+			if got := matchPath(tt.pattern, tt.path); got != tt.want {
+				t.Errorf("matchPath(%q, %q) = %v, want %v", tt.pattern, tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRequiresRestart(t *testing.T) {
+	tests := []struct {
+		path string
+		want bool
+	}{
+		{path: "log.level", want: false},
+		{path: "routes", want: false},
+		{path: "upstreams.accounts.targets", want: false},
+		{path: "retry_budget.percent", want: false},
+		{path: "databases.main.read.maxidleconns", want: false},
+		{path: "databases.main.read.host", want: true},
+		{path: "databases.main", want: true},
+		{path: "http.read_timeout", want: false},
+		{path: "http.write_timeout", want: false},
+		{path: "http.max_header_bytes", want: true},
+		{path: "grpc.reflection", want: true},
+		{path: "cache.max_bytes", want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			if got := RequiresRestart(tt.path); got != tt.want {
+				t.Errorf("RequiresRestart(%q) = %v, want %v", tt.path, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestDiff(t *testing.T) {
+	base := func() Config {
+		return Config{
+			Log:  &LogConfig{Level: "info"},
+			HTTP: &http.Config{Mode: "release", Address: ":8080", ReadTimeout: time.Second},
+			Databases: database.Configs{
+				"main": {Read: &database.Rdbms{DBName: "a", MaxOpenConns: 10}, Write: &database.Rdbms{DBName: "a"}},
+			},
+			Routes:    proxy.Routes{{Name: "books", PathPrefix: "/books", Upstream: "books"}},
+			Upstreams: proxy.Upstreams{"books": {Targets: []*proxy.TargetConfig{{URL: "http://127.0.0.1:1"}}}},
+		}
+	}
+
+	tests := []struct {
+		name   string
+		modify func(*Config)
+		want   []string
+	}{
+		{name: "nothing changed", modify: func(*Config) {}},
+		{
+			name:   "nested field",
+			modify: func(c *Config) { c.Log.Level = "debug" },
+			want:   []string{"log.level"},
+		},
+		{
+			name:   "mapstructure tag",
+			modify: func(c *Config) { c.HTTP.ReadTimeout = 2 * time.Second },
+			want:   []string{"http.read_timeout"},
+		},
+		{
+			name:   "nil pointer",
+			modify: func(c *Config) { c.RetryBudget = &proxy.RetryBudgetConfig{Percent: 20} },
+			want:   []string{"retry_budget"},
+		},
+		{
+			name:   "map entry",
+			modify: func(c *Config) { c.Databases["main"].Read.MaxOpenConns = 20 },
+			want:   []string{"databases.main.read.maxopenconns"},
+		},
+		{
+			name: "map key added",
+			modify: func(c *Config) {
+				c.Upstreams["reviews"] = &proxy.UpstreamConfig{Targets: []*proxy.TargetConfig{{URL: "http://127.0.0.1:2"}}}
+			},
+			want: []string{"upstreams.reviews"},
+		},
+		{
+			name:   "slice compared as a whole",
+			modify: func(c *Config) { c.Routes[0].PathPrefix = "/v2/books" },
+			want:   []string{"routes"},
+		},
+		{
+			name: "sorted",
+			modify: func(c *Config) {
+				c.Routes = nil
+				c.Log.Level = "warn"
+				c.HTTP.Address = ":9090"
+			},
+			want: []string{"http.address", "log.level", "routes"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			next := base()
+			tt.modify(&next)
+			if got := Diff(base(), next); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("Diff() = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
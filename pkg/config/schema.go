@@ -0,0 +1,159 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+var (
+	durationType = reflect.TypeOf(time.Duration(0))
+
+	// sensitiveKeyRegexp 輸出設定時需要遮蔽的 key
+	sensitiveKeyRegexp = regexp.MustCompile(`(?i)(password|secret|token|private_key|api_key)`)
+)
+
+// Schema JSON Schema (draft-07) of the configuration file, derived from the validate tags
+func Schema() map[string]interface{} {
+	schema := schemaOf(reflect.TypeOf(Config{}))
+	schema["$schema"] = "http://json-schema.org/draft-07/schema#"
+	schema["title"] = "apigateway configuration"
+	return schema
+}
+
+func schemaOf(t reflect.Type) map[string]interface{} {
+	if t.Kind() == reflect.Ptr {
+		t = t.Elem()
+	}
+
+	switch {
+	case t == durationType:
+		return map[string]interface{}{
+			"type":    "string",
+			"pattern": `^([0-9]+(\.[0-9]+)?(ns|us|µs|ms|s|m|h))+$`,
+		}
+	case t.Kind() == reflect.Struct:
+		properties := map[string]interface{}{}
+		var required []string
+		for i := 0; i < t.NumField(); i++ {
+			f := t.Field(i)
+			if f.Anonymous || f.PkgPath != "" {
+				continue
+			}
+			name := keyName(f)
+			prop := schemaOf(f.Type)
+			if applyRules(prop, f.Tag.Get("validate")) {
+				required = append(required, name)
+			}
+			properties[name] = prop
+		}
+		schema := map[string]interface{}{
+			"type":       "object",
+			"properties": properties,
+		}
+		if len(required) > 0 {
+			schema["required"] = required
+		}
+		return schema
+	case t.Kind() == reflect.Map:
+		return map[string]interface{}{
+			"type":                 "object",
+			"additionalProperties": schemaOf(t.Elem()),
+		}
+	case t.Kind() == reflect.Slice:
+		return map[string]interface{}{
+			"type":  "array",
+			"items": schemaOf(t.Elem()),
+		}
+	case t.Kind() == reflect.Bool:
+		return map[string]interface{}{"type": "boolean"}
+	case t.Kind() >= reflect.Int && t.Kind() <= reflect.Uint64:
+		return map[string]interface{}{"type": "integer"}
+	case t.Kind() == reflect.Float32 || t.Kind() == reflect.Float64:
+		return map[string]interface{}{"type": "number"}
+	default:
+		return map[string]interface{}{"type": "string"}
+	}
+}
+
+// applyRules 將 validate tag 轉成 schema 限制, 回傳是否為必填
+func applyRules(prop map[string]interface{}, tag string) bool {
+	required := false
+	// dive 之後的規則屬於 map/slice 的元素
+	if i := strings.Index(tag, "dive"); i >= 0 {
+		tag = tag[:i]
+	}
+
+	for _, rule := range strings.Split(tag, ",") {
+		name, param := rule, ""
+		if i := strings.Index(rule, "="); i >= 0 {
+			name, param = rule[:i], rule[i+1:]
+		}
+
+		switch name {
+		case "required":
+			required = true
+		case "oneof":
+			enum := []interface{}{}
+			for _, v := range strings.Fields(param) {
+				enum = append(enum, v)
+			}
+			prop["enum"] = enum
+		case "min", "max":
+			n, err := strconv.ParseInt(param, 10, 64)
+			if err != nil || prop["type"] != "integer" {
+				continue
+			}
+			if name == "min" {
+				prop["minimum"] = n
+			} else {
+				prop["maximum"] = n
+			}
+		case "duration":
+			prop["pattern"] = schemaOf(durationType)["pattern"]
+		}
+	}
+	return required
+}
+
+// Settings 目前生效的設定 (檔案與環境變數合併後), redact 時遮蔽敏感欄位
+func Settings(redact bool) map[string]interface{} {
+	settings := viper.AllSettings()
+	if redact {
+		redactMap(settings)
+	}
+	return settings
+}
+
+func redactMap(m map[string]interface{}) {
+	for k, v := range m {
+		m[k] = redactValue(k, v)
+	}
+}
+
+// redactValue 敏感欄位整個遮蔽 (包含 list), 其他的遞迴檢查 map 與 list
+func redactValue(key string, v interface{}) interface{} {
+	switch val := v.(type) {
+	case map[string]interface{}:
+		redactMap(val)
+	case map[interface{}]interface{}:
+		for k, item := range val {
+			val[k] = redactValue(fmt.Sprint(k), item)
+		}
+	case []interface{}:
+		for i, item := range val {
+			val[i] = redactValue(key, item)
+		}
+	case nil:
+	default:
+		if sensitiveKeyRegexp.MatchString(key) && val != "" {
+			return "******"
+		}
+	}
+	return v
+}
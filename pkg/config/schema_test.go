@@ -0,0 +1,36 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestRedactMap(t *testing.T) {
+	settings := map[string]interface{}{
+		"log": map[string]interface{}{"level": "debug"},
+		"databases": map[string]interface{}{
+			"main": map[string]interface{}{"read": map[string]interface{}{"password": "pw", "host": "db"}},
+		},
+		"grpc": map[string]interface{}{"auth": map[string]interface{}{
+			"tokens": []interface{}{map[interface{}]interface{}{"principal": "mobile", "token": "supersecret"}},
+		}},
+		"http":   map[string]interface{}{"admin": map[string]interface{}{"tokens": []interface{}{"t1", "t2"}}},
+		"secret": "",
+	}
+	want := map[string]interface{}{
+		"log": map[string]interface{}{"level": "debug"},
+		"databases": map[string]interface{}{
+			"main": map[string]interface{}{"read": map[string]interface{}{"password": "******", "host": "db"}},
+		},
+		"grpc": map[string]interface{}{"auth": map[string]interface{}{
+			"tokens": []interface{}{map[interface{}]interface{}{"principal": "mobile", "token": "******"}},
+		}},
+		"http":   map[string]interface{}{"admin": map[string]interface{}{"tokens": []interface{}{"******", "******"}}},
+		"secret": "",
+	}
+
+	redactMap(settings)
+	if !reflect.DeepEqual(settings, want) {
+		t.Errorf("redactMap() = %v, want %v", settings, want)
+	}
+}
@@ -0,0 +1,181 @@
+package config
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"go.uber.org/multierr"
+)
+
+const (
+	// SecretKeyEnv base64 編碼的 32 bytes key
+	SecretKeyEnv = EnvPrefix + "CONFIG_KEY"
+	// SecretKeyFileEnv key 檔案路徑, 未設定時使用設定檔目錄下的 app.key
+	SecretKeyFileEnv = EnvPrefix + "CONFIG_KEY_FILE"
+
+	defaultKeyFile = "app.key"
+	secretKeySize  = 32
+)
+
+// encRegexp 加密後的值, e.g. password: ENC[...]
+var encRegexp = regexp.MustCompile(`^ENC\[([A-Za-z0-9+/=]+)\]$`)
+
+// IsEncrypted ...
+func IsEncrypted(value string) bool {
+	return encRegexp.MatchString(value)
+}
+
+// GenerateSecretKey return a new base64 encoded AES-256 key
+func GenerateSecretKey() (string, error) {
+	key := make([]byte, secretKeySize)
+	if _, err := io.ReadFull(rand.Reader, key); err != nil {
+		return "", err
+	}
+	return base64.StdEncoding.EncodeToString(key), nil
+}
+
+// LoadSecretKey 依序從 APIGW_CONFIG_KEY, APIGW_CONFIG_KEY_FILE, <dir>/app.key 取得 key
+func LoadSecretKey(dir string) ([]byte, error) {
+	encoded := os.Getenv(SecretKeyEnv)
+	if encoded == "" {
+		file := os.Getenv(SecretKeyFileEnv)
+		if file == "" {
+			file = filepath.Join(dir, defaultKeyFile)
+		}
+		data, err := ioutil.ReadFile(file)
+		if err != nil {
+			return nil, fmt.Errorf("secret key not found, set %s or %s: %v", SecretKeyEnv, SecretKeyFileEnv, err)
+		}
+		encoded = string(data)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(encoded))
+	if err != nil || len(key) != secretKeySize {
+		return nil, errors.New("secret key must be 32 bytes encoded in base64")
+	}
+	return key, nil
+}
+
+// Encrypt 以 AES-256-GCM 加密, 回傳 ENC[...]
+func Encrypt(key []byte, plaintext string) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := io.ReadFull(rand.Reader, nonce); err != nil {
+		return "", err
+	}
+
+	sealed := gcm.Seal(nonce, nonce, []byte(plaintext), nil)
+	return "ENC[" + base64.StdEncoding.EncodeToString(sealed) + "]", nil
+}
+
+// Decrypt ENC[...] 解密, 錯誤訊息不包含密文
+func Decrypt(key []byte, value string) (string, error) {
+	m := encRegexp.FindStringSubmatch(strings.TrimSpace(value))
+	if m == nil {
+		return "", errors.New("value is not in ENC[...] format")
+	}
+
+	sealed, err := base64.StdEncoding.DecodeString(m[1])
+	if err != nil {
+		return "", errors.New("malformed ciphertext")
+	}
+
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return "", errors.New("malformed ciphertext")
+	}
+
+	plaintext, err := gcm.Open(nil, sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():], nil)
+	if err != nil {
+		return "", errors.New("wrong key or corrupted ciphertext")
+	}
+	return string(plaintext), nil
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// secretKey 在 readLayers 期間只讀取一次 key, 沒有加密值時不需要 key
+type secretKey struct {
+	dir string
+	key []byte
+}
+
+func (s *secretKey) get() ([]byte, error) {
+	if s.key == nil {
+		key, err := LoadSecretKey(s.dir)
+		if err != nil {
+			return nil, err
+		}
+		s.key = key
+	}
+	return s.key, nil
+}
+
+// decryptSettings 將 m 中所有 ENC[...] 就地解密
+func decryptSettings(prefix string, m map[string]interface{}, key *secretKey) error {
+	var errs error
+	for name, v := range m {
+		val, err := decryptValue(join(prefix, name), v, key)
+		errs = multierr.Append(errs, err)
+		m[name] = val
+	}
+	return errs
+}
+
+// decryptValue 遞迴處理 map 與 list, list 中的 map 由 yaml 解出時為 map[interface{}]interface{}
+func decryptValue(path string, v interface{}, key *secretKey) (interface{}, error) {
+	var errs error
+	switch val := v.(type) {
+	case map[string]interface{}:
+		return val, decryptSettings(path, val, key)
+	case map[interface{}]interface{}:
+		for name, item := range val {
+			dec, err := decryptValue(join(path, fmt.Sprint(name)), item, key)
+			errs = multierr.Append(errs, err)
+			val[name] = dec
+		}
+	case []interface{}:
+		for i, item := range val {
+			dec, err := decryptValue(fmt.Sprintf("%s[%d]", path, i), item, key)
+			errs = multierr.Append(errs, err)
+			val[i] = dec
+		}
+	case string:
+		if !IsEncrypted(val) {
+			return val, nil
+		}
+		k, err := key.get()
+		if err != nil {
+			return val, fmt.Errorf("%s: %v", path, err)
+		}
+		plaintext, err := Decrypt(k, val)
+		if err != nil {
+			return val, fmt.Errorf("%s: unable to decrypt value, %v", path, err)
+		}
+		return plaintext, nil
+	}
+	return v, errs
+}
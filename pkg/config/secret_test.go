@@ -0,0 +1,72 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestDecryptSettings(t *testing.T) {
+	key := make([]byte, secretKeySize)
+	enc := func(s string) string {
+		v, err := Encrypt(key, s)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return v
+	}
+
+	tests := []struct {
+		name     string
+		settings map[string]interface{}
+		want     map[string]interface{}
+		wantErr  string
+	}{
+		{
+			name:     "plain values untouched",
+			settings: map[string]interface{}{"a": "b", "n": 1},
+			want:     map[string]interface{}{"a": "b", "n": 1},
+		},
+		{
+			name:     "nested map",
+			settings: map[string]interface{}{"db": map[string]interface{}{"password": enc("pw")}},
+			want:     map[string]interface{}{"db": map[string]interface{}{"password": "pw"}},
+		},
+		{
+			name: "list of maps from yaml",
+			settings: map[string]interface{}{"grpc": map[string]interface{}{"auth": map[string]interface{}{
+				"tokens": []interface{}{map[interface{}]interface{}{"principal": "mobile", "token": enc("t1")}},
+			}}},
+			want: map[string]interface{}{"grpc": map[string]interface{}{"auth": map[string]interface{}{
+				"tokens": []interface{}{map[interface{}]interface{}{"principal": "mobile", "token": "t1"}},
+			}}},
+		},
+		{
+			name:     "list of strings",
+			settings: map[string]interface{}{"tokens": []interface{}{enc("t1"), "t2"}},
+			want:     map[string]interface{}{"tokens": []interface{}{"t1", "t2"}},
+		},
+		{
+			name:     "error names the list element",
+			settings: map[string]interface{}{"tokens": []interface{}{"t1", "ENC[bad]"}},
+			wantErr:  "tokens[1]",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := decryptSettings("", tt.settings, &secretKey{key: key})
+			if tt.wantErr != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.wantErr) {
+					t.Fatalf("decryptSettings() error = %v, want containing %q", err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(tt.settings, tt.want) {
+				t.Errorf("decryptSettings() = %v, want %v", tt.settings, tt.want)
+			}
+		})
+	}
+}
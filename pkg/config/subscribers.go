@@ -0,0 +1,90 @@
+package config
+
+import (
+	"context"
+	"errors"
+
+	"apigateway/pkg/database"
+	"apigateway/pkg/health"
+	"apigateway/pkg/middleware"
+	"apigateway/pkg/proxy"
+
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+)
+
+// SubscribeLogLevel 套用 log.level, reload 時一併更新
+func SubscribeLogLevel(m *Manager) error {
+	if err := applyLogLevel(m.Current().Log); err != nil {
+		return err
+	}
+
+	m.Subscribe("log", func(old, new Config) error {
+		return applyLogLevel(new.Log)
+	})
+	return nil
+}
+
+func applyLogLevel(cfg *LogConfig) error {
+	if cfg == nil || cfg.Level == "" {
+		return nil
+	}
+
+	level, err := zerolog.ParseLevel(cfg.Level)
+	if err != nil {
+		return err
+	}
+	zerolog.SetGlobalLevel(level)
+	log.Info().Str("log_level", level.String()).Msg("log level applied")
+	return nil
+}
+
+// SubscribeHTTP 更新 read_timeout 與 write_timeout, 從下一個 request 開始生效
+func SubscribeHTTP(m *Manager, t *middleware.Timeouts) {
+	m.Subscribe("http", func(old, new Config) error {
+		t.Set(new.HTTP.ReadTimeout, new.HTTP.WriteTimeout)
+		return nil
+	})
+}
+
+// SubscribeDatabases 更新每個連線的 pool 大小
+func SubscribeDatabases(m *Manager, r *database.Registry) {
+	m.Subscribe("databases", func(old, new Config) error {
+		return r.UpdatePools(new.Databases)
+	})
+}
+
+// SubscribeRoutes 替換 proxy route table 與 upstream pools, 編譯失敗時保留原本的 table
+func SubscribeRoutes(m *Manager, t *proxy.Table) {
+	m.Subscribe("routes", func(old, new Config) error {
+		t.SetRetryBudget(new.RetryBudget)
+		return t.Update(new.Routes, new.Upstreams, new.Aggregations, new.Transcodings)
+	})
+}
+
+// WatchConfig 啟動設定檔監看, 在訂閱的元件停止前停止 reload
+func WatchConfig(lc fx.Lifecycle, m *Manager) {
+	m.Watch()
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			m.Stop()
+			return nil
+		},
+	})
+}
+
+// RegisterHealthCheck 設定檔尚未成功載入時 readiness 失敗
+func RegisterHealthCheck(c *health.Checker, m *Manager) {
+	c.Register(health.Check{
+		Name:     "config",
+		Critical: true,
+		Local:    true,
+		Run: func(context.Context) error {
+			if m.Current().HTTP == nil {
+				return errors.New("configuration is not loaded")
+			}
+			return nil
+		},
+	})
+}
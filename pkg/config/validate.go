@@ -0,0 +1,152 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"regexp"
+	"strings"
+	"time"
+
+	"apigateway/pkg/database"
+
+	"github.com/go-playground/validator/v10"
+	"github.com/mitchellh/mapstructure"
+	"go.uber.org/multierr"
+)
+
+var (
+	validate = newValidator()
+
+	// mapKeyRegexp 將 validator 的 databases[catalog] 轉成 databases.catalog
+	mapKeyRegexp = regexp.MustCompile(`\[([^\]]+)\]`)
+	// decodeKeyRegexp mapstructure 錯誤訊息開頭的 'Databases[catalog].Read'
+	decodeKeyRegexp = regexp.MustCompile(`^'([^']*)'`)
+	// camelRegexp 將 struct 欄位名稱 KeyFile 轉成 key_file
+	camelRegexp = regexp.MustCompile(`([a-z0-9])([A-Z])`)
+)
+
+func newValidator() *validator.Validate {
+	v := validator.New()
+
+	// 錯誤訊息使用設定檔中的 key
+	v.RegisterTagNameFunc(func(f reflect.StructField) string {
+		return keyName(f)
+	})
+
+	_ = v.RegisterValidation("duration", func(fl validator.FieldLevel) bool {
+		_, err := time.ParseDuration(fl.Field().String())
+		return err == nil
+	})
+
+	_ = v.RegisterValidation("regexp", func(fl validator.FieldLevel) bool {
+		_, err := regexp.Compile(fl.Field().String())
+		return err == nil
+	})
+
+	v.RegisterStructValidation(func(sl validator.StructLevel) {
+		r := sl.Current().Interface().(database.Rdbms)
+		if r.Type == database.SQLite {
+			return
+		}
+		if r.Host == "" {
+			sl.ReportError(r.Host, "host", "Host", "required", "")
+		}
+		if r.Port == 0 {
+			sl.ReportError(r.Port, "port", "Port", "required", "")
+		}
+	}, database.Rdbms{})
+
+	return v
+}
+
+// Validate 檢查必要欄位、範圍與列舉值, 一次回報所有錯誤
+func Validate(cfg Config) error {
+	err := validate.Struct(cfg)
+	if err == nil {
+		return crossCheck(cfg)
+	}
+
+	var fieldErrs validator.ValidationErrors
+	if !errors.As(err, &fieldErrs) {
+		return err
+	}
+
+	var errs error
+	for _, fe := range fieldErrs {
+		errs = multierr.Append(errs, fmt.Errorf("%s: %s", yamlPath(fe.Namespace()), describe(fe)))
+	}
+	return multierr.Append(errs, crossCheck(cfg))
+}
+
+// crossCheck 跨 section 的規則
+func crossCheck(cfg Config) error {
+	var errs error
+	for i, rc := range cfg.Routes {
+		if rc == nil || rc.ClientCert == nil {
+			continue
+		}
+		// 沒有 TLS 時 request 不會帶有驗證過的憑證, route 只會回 403
+		switch {
+		case cfg.HTTP == nil || cfg.HTTP.TLS == nil:
+			errs = multierr.Append(errs, fmt.Errorf("routes.%d.client_cert: requires http.tls, the server is not using TLS", i))
+		case cfg.HTTP.TLS.ClientCA == "":
+			errs = multierr.Append(errs, fmt.Errorf("routes.%d.client_cert: requires http.tls.client_ca", i))
+		}
+	}
+	return errs
+}
+
+// decodeErrors 拆開 mapstructure 的錯誤, key 改成設定檔的寫法
+func decodeErrors(err error) error {
+	var decodeErr *mapstructure.Error
+	if !errors.As(err, &decodeErr) {
+		return err
+	}
+
+	var errs error
+	for _, msg := range decodeErr.Errors {
+		msg = decodeKeyRegexp.ReplaceAllStringFunc(msg, func(key string) string {
+			key = strings.Trim(key, "'")
+			return strings.ToLower(mapKeyRegexp.ReplaceAllString(key, ".$1")) + ":"
+		})
+		errs = multierr.Append(errs, errors.New(msg))
+	}
+	return errs
+}
+
+// yamlPath Config.databases[catalog].read.type => databases.catalog.read.type
+func yamlPath(namespace string) string {
+	path := mapKeyRegexp.ReplaceAllString(namespace, ".$1")
+	if i := strings.Index(path, "."); i >= 0 {
+		path = path[i+1:]
+	}
+	return path
+}
+
+func describe(fe validator.FieldError) string {
+	switch fe.Tag() {
+	case "required":
+		return "is required"
+	case "oneof":
+		return fmt.Sprintf("must be one of [%s], got %q", fe.Param(), fmt.Sprint(fe.Value()))
+	case "min":
+		return fmt.Sprintf("must be at least %s, got %v", fe.Param(), fe.Value())
+	case "max":
+		return fmt.Sprintf("must be at most %s, got %v", fe.Param(), fe.Value())
+	case "url":
+		return fmt.Sprintf("must be an absolute url, got %q", fmt.Sprint(fe.Value()))
+	case "startswith":
+		return fmt.Sprintf("must start with %q", fe.Param())
+	case "required_with":
+		return fmt.Sprintf("is required when %s is set", strings.ToLower(camelRegexp.ReplaceAllString(fe.Param(), "${1}_${2}")))
+	case "regexp":
+		return "must be a valid regular expression"
+	case "cidr|ip":
+		return fmt.Sprintf("must be an ip or cidr such as 10.0.0.0/8, got %q", fmt.Sprint(fe.Value()))
+	case "duration":
+		return fmt.Sprintf("must be a duration such as 10s, got %q", fmt.Sprint(fe.Value()))
+	default:
+		return fmt.Sprintf("failed on %s", fe.Tag())
+	}
+}
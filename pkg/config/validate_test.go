@@ -0,0 +1,67 @@
+package config
+
+import (
+	"strings"
+	"testing"
+
+	"apigateway/pkg/proxy"
+	"apigateway/pkg/router/grpc"
+	"apigateway/pkg/router/http"
+)
+
+func TestValidate(t *testing.T) {
+	tlsConfig := func(clientCA string) *http.TLSConfig {
+		return &http.TLSConfig{
+			Certificates: []*http.CertificateConfig{{CertFile: "server.crt", KeyFile: "server.key"}},
+			ClientCA:     clientCA,
+		}
+	}
+	mtlsRoute := proxy.Routes{{Name: "partners", PathPrefix: "/partners", Upstream: "http://127.0.0.1:8081", ClientCert: &proxy.ClientCertConfig{}}}
+
+	tests := []struct {
+		name string
+		cfg  Config
+		// wantErr 錯誤訊息需包含的內容, 空字串表示通過
+		wantErr string
+	}{
+		{
+			name: "minimal",
+			cfg:  Config{HTTP: &http.Config{Mode: "release", Address: ":8080"}},
+		},
+		{
+			name:    "missing http",
+			cfg:     Config{},
+			wantErr: "http: is required",
+		},
+		{
+			name:    "empty grpc token",
+			cfg:     Config{HTTP: &http.Config{Mode: "release", Address: ":8080"}, GRPC: &grpc.Config{Address: ":9090", Auth: &grpc.AuthConfig{Tokens: []*grpc.TokenConfig{{Principal: "mobile"}}}}},
+			wantErr: "grpc.auth.tokens.0.token: is required",
+		},
+		{
+			name:    "client cert without tls",
+			cfg:     Config{HTTP: &http.Config{Mode: "release", Address: ":8080"}, Routes: mtlsRoute},
+			wantErr: "routes.0.client_cert: requires http.tls",
+		},
+		{
+			name:    "client cert without client ca",
+			cfg:     Config{HTTP: &http.Config{Mode: "release", Address: ":8443", TLS: tlsConfig("")}, Routes: mtlsRoute},
+			wantErr: "routes.0.client_cert: requires http.tls.client_ca",
+		},
+		{
+			name: "client cert with client ca",
+			cfg:  Config{HTTP: &http.Config{Mode: "release", Address: ":8443", TLS: tlsConfig("ca.crt")}, Routes: mtlsRoute},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := Validate(tt.cfg)
+			switch {
+			case tt.wantErr == "" && err != nil:
+				t.Errorf("Validate() = %v, want nil", err)
+			case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+				t.Errorf("Validate() = %v, want %q", err, tt.wantErr)
+			}
+		})
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"errors"
 	"fmt"
 	"strconv"
+	"strings"
 	"time"
 
 	"github.com/jinzhu/gorm"
@@ -20,45 +21,54 @@ const (
 	MySQL DBType = "mysql"
 	// Postgres ...
 	Postgres DBType = "postgres"
+	// SQLite DBName 為檔案路徑
+	SQLite DBType = "sqlite3"
 )
 
 // RdbmsConn ...
 type RdbmsConn struct {
 	ReadDB  *gorm.DB
 	WriteDB *gorm.DB
+
+	readLogger  *GormLogger
+	writeLogger *GormLogger
 }
 
 // RdbmsConfig for db config
 type RdbmsConfig struct {
-	Read       *Rdbms
-	Write      *Rdbms
+	Read       *Rdbms `validate:"required"`
+	Write      *Rdbms `validate:"required"`
 	Secrets    string `yaml:"secrets"`
 	WithColor  bool   `yaml:"withColor"`
 	WithCaller bool   `yaml:"withCaller"`
 }
 
-// Rdbms ...
+// Rdbms host 與 port 除了 sqlite3 以外都必須設定
 type Rdbms struct {
-	Type           DBType
+	Type           DBType `validate:"required,oneof=mysql postgres sqlite3"`
 	Debug          bool
 	Host           string
-	Port           int
+	Port           int `validate:"min=0,max=65535"`
 	Username       string
 	Password       string
-	DBName         string
-	MaxIdleConns   int
-	MaxOpenConns   int
-	MaxLifetimeSec int
-	ReadTimeout    string `yaml:"read_timeout"`
-	WriteTimeout   string `yaml:"write_timeout"`
+	DBName         string `validate:"required"`
+	MaxIdleConns   int    `validate:"min=0"`
+	MaxOpenConns   int    `validate:"min=0"`
+	MaxLifetimeSec int    `validate:"min=0"`
+	ReadTimeout    string `yaml:"read_timeout" mapstructure:"read_timeout" validate:"omitempty,duration"`
+	WriteTimeout   string `yaml:"write_timeout" mapstructure:"write_timeout" validate:"omitempty,duration"`
 	SearchPath     string `yaml:"search_path" mapstructure:"search_path"`
 }
 
 // InitRdbms init and return write and read DB objects
-func InitRdbms(cfg *RdbmsConfig) (*RdbmsConn, error) {
+func InitRdbms(name string, cfg *RdbmsConfig) (*RdbmsConn, error) {
 	var err error
 	var conn RdbmsConn
 
+	if cfg == nil || cfg.Read == nil || cfg.Write == nil {
+		return nil, fmt.Errorf("database %s: read and write config are required", name)
+	}
+
 	gorm.NowFunc = func() time.Time {
 		return time.Now().UTC()
 	}
@@ -70,36 +80,48 @@ func InitRdbms(cfg *RdbmsConfig) (*RdbmsConn, error) {
 
 	conn.WriteDB, err = cfg.Write.Open()
 	if err != nil {
+		conn.Close()
 		return nil, err
 	}
 
-	if conn.ReadDB == nil {
-		return nil, fmt.Errorf("read db initialization was failed, name: %s", cfg.Read.DBName)
-	} else if err = conn.ReadDB.DB().Ping(); err != nil {
-		return nil, fmt.Errorf("read db ping was failed")
-	}
-
-	if conn.WriteDB == nil {
-		return nil, fmt.Errorf("write db initialization was failed, name: %s", cfg.Write.DBName)
-	} else if err = conn.WriteDB.DB().Ping(); err != nil {
-		return nil, fmt.Errorf("write db ping was failed")
+	if err = conn.verify(name, cfg); err != nil {
+		conn.Close()
+		return nil, err
 	}
 
 	if cfg.Read.Debug {
 		conn.ReadDB = conn.ReadDB.LogMode(true)
-		readLogger := log.With().Str("db_log", "read").Logger()
-		conn.ReadDB.SetLogger(&GormLogger{logger: readLogger, WithColor: cfg.WithColor, WithCaller: cfg.WithCaller})
+		readLogger := log.With().Str("db_log", "read").Str("db_name", name).Logger()
+		conn.readLogger = &GormLogger{logger: readLogger, WithColor: cfg.WithColor, WithCaller: cfg.WithCaller}
+		conn.ReadDB.SetLogger(conn.readLogger)
 	}
 
 	if cfg.Write.Debug {
 		conn.WriteDB = conn.WriteDB.LogMode(true)
-		writeLogger := log.With().Str("db_log", "write").Logger()
-		conn.WriteDB.SetLogger(&GormLogger{logger: writeLogger, WithColor: cfg.WithColor, WithCaller: cfg.WithCaller})
+		writeLogger := log.With().Str("db_log", "write").Str("db_name", name).Logger()
+		conn.writeLogger = &GormLogger{logger: writeLogger, WithColor: cfg.WithColor, WithCaller: cfg.WithCaller}
+		conn.WriteDB.SetLogger(conn.writeLogger)
 	}
 
 	return &conn, nil
 }
 
+// verify 確認 read 與 write 兩個連線都可以使用
+func (conn *RdbmsConn) verify(name string, cfg *RdbmsConfig) error {
+	if conn.ReadDB == nil {
+		return fmt.Errorf("read db initialization was failed, name: %s", cfg.Read.DBName)
+	} else if err := conn.ReadDB.DB().Ping(); err != nil {
+		return fmt.Errorf("read db ping was failed, database: %s", name)
+	}
+
+	if conn.WriteDB == nil {
+		return fmt.Errorf("write db initialization was failed, name: %s", cfg.Write.DBName)
+	} else if err := conn.WriteDB.DB().Ping(); err != nil {
+		return fmt.Errorf("write db ping was failed, database: %s", name)
+	}
+	return nil
+}
+
 // Open create database connection with dbType
 func (r *Rdbms) Open() (*gorm.DB, error) {
 	bo := backoff.NewExponentialBackOff()
@@ -108,6 +130,8 @@ func (r *Rdbms) Open() (*gorm.DB, error) {
 	var db *gorm.DB
 	var err error
 
+	r.SetDefaults()
+
 	switch r.Type {
 	case MySQL:
 		db, err = OpenMySQL(r)
@@ -119,19 +143,34 @@ func (r *Rdbms) Open() (*gorm.DB, error) {
 		if err != nil {
 			return db, err
 		}
+	case SQLite:
+		db, err = OpenSQLite(r)
+		if err != nil {
+			return db, err
+		}
 	default:
 		return nil, errors.New("Not support sql driver")
 	}
 
 	log.Info().Msgf("database ping success")
 
+	r.ConfigurePool(db)
+
+	return db, nil
+}
+
+// SetDefaults fill in timeouts used by the connection string
+func (r *Rdbms) SetDefaults() {
 	if r.WriteTimeout == "" {
 		r.WriteTimeout = "10s"
 	}
 	if r.ReadTimeout == "" {
 		r.ReadTimeout = "10s"
 	}
+}
 
+// ConfigurePool apply pool size settings, safe to call on a live connection
+func (r *Rdbms) ConfigurePool(db *gorm.DB) {
 	if r.MaxIdleConns != 0 {
 		db.DB().SetMaxIdleConns(r.MaxIdleConns)
 	} else {
@@ -149,14 +188,10 @@ func (r *Rdbms) Open() (*gorm.DB, error) {
 	} else {
 		db.DB().SetConnMaxLifetime(14400 * time.Second)
 	}
-
-	return db, nil
 }
 
 // OpenMySQL ...
 func OpenMySQL(database *Rdbms) (*gorm.DB, error) {
-	var sqlDriver = "mysql"
-
 	connectionStr := fmt.Sprintf(
 		`%s:%s@tcp(%s)/%s?charset=utf8&parseTime=true&multiStatements=true&readTimeout=%s&writeTimeout=%s`,
 		database.Username,
@@ -169,6 +204,33 @@ func OpenMySQL(database *Rdbms) (*gorm.DB, error) {
 
 	log.Debug().Msgf("main: database connection string: %s", connectionStr)
 
+	return connect(string(MySQL), connectionStr)
+}
+
+// OpenPostgres ...
+func OpenPostgres(database *Rdbms) (*gorm.DB, error) {
+	connectionStr := fmt.Sprintf(
+		`user=%s password=%s host=%s port=%d dbname=%s sslmode=disable`,
+		database.Username,
+		database.Password,
+		database.Host,
+		database.Port,
+		database.DBName,
+	)
+	if strings.TrimSpace(database.SearchPath) != "" {
+		connectionStr = fmt.Sprintf("%s search_path=%s", connectionStr, database.SearchPath)
+	}
+
+	return connect(string(Postgres), connectionStr)
+}
+
+// OpenSQLite ...
+func OpenSQLite(database *Rdbms) (*gorm.DB, error) {
+	return connect(string(SQLite), database.DBName)
+}
+
+// connect open and ping with exponential backoff
+func connect(sqlDriver, connectionStr string) (*gorm.DB, error) {
 	var db *gorm.DB
 	var err error
 
@@ -190,21 +252,9 @@ func OpenMySQL(database *Rdbms) (*gorm.DB, error) {
 	}, bo)
 
 	if err != nil {
-		log.Error().Msgf("main: mysql connect err: %s", err.Error())
+		log.Error().Msgf("main: %s connect err: %s", sqlDriver, err.Error())
 		return nil, err
 	}
 
 	return db, nil
 }
-
-// OpenPostgres ...
-func OpenPostgres(rdbms *Rdbms) (*gorm.DB, error) {
-	// sqlDriver = "postgres"
-
-	// connectionString = fmt.Sprintf(`user=%s password=%s host=%s port=%d dbname=%s sslmode=disable `, database.Username, database.Password, database.Host, database.Port, database.DBName)
-	// if strings.TrimSpace(database.SearchPath) != "" {
-	// 	connectionString = fmt.Sprintf("%s search_path=%s", connectionString, database.SearchPath)
-	// }
-
-	return nil, nil
-}
@@ -0,0 +1,8 @@
+package database
+
+import (
+	// register sql drivers for every supported DBType
+	_ "github.com/jinzhu/gorm/dialects/mysql"
+	_ "github.com/jinzhu/gorm/dialects/postgres"
+	_ "github.com/jinzhu/gorm/dialects/sqlite"
+)
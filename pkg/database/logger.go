@@ -18,6 +18,8 @@ type GormLogger struct {
 	logger     zerolog.Logger
 	WithColor  bool
 	WithCaller bool
+	// profile is set on per-request clones, see RdbmsConn.Read
+	profile *QueryProfile
 }
 
 // Print handles log events from Gorm for the custom logger.
@@ -34,6 +36,9 @@ func (gl *GormLogger) Print(v ...interface{}) {
 		callers += fmt.Sprintf("\n%s:%d", file, line)
 	}
 	if gormType == "sql" {
+		if gl.profile != nil {
+			gl.profile.Record(v[3].(string), v[2].(time.Duration))
+		}
 		src := messages[1]
 		latency := messages[3].(time.Duration)
 		sql := messages[4]
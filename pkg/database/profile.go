@@ -0,0 +1,141 @@
+package database
+
+import (
+	"context"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ProfileConfig 單一 request 的查詢統計設定, 只在 debug mode 啟用
+type ProfileConfig struct {
+	Enabled bool
+	// QueryBudget 單一 request 允許的查詢數量, 0 表示不限制
+	QueryBudget int `yaml:"query_budget" mapstructure:"query_budget" validate:"min=0"`
+	// RepeatThreshold 相同 query shape 重複幾次視為 N+1
+	RepeatThreshold int `yaml:"repeat_threshold" mapstructure:"repeat_threshold" validate:"min=0"`
+}
+
+type profileCtxKey struct{}
+
+var (
+	shapeSpaceRegexp = regexp.MustCompile(`\s+`)
+	shapeListRegexp  = regexp.MustCompile(`\(\s*(\?|\$\d+)(\s*,\s*(\?|\$\d+))*\s*\)`)
+)
+
+// QueryProfile collects statements issued while serving one request
+type QueryProfile struct {
+	RequestID string
+
+	cfg ProfileConfig
+
+	mu     sync.Mutex
+	count  int
+	total  time.Duration
+	shapes map[string]int
+}
+
+// NewQueryProfile ...
+func NewQueryProfile(requestID string, cfg *ProfileConfig) *QueryProfile {
+	p := &QueryProfile{
+		RequestID: requestID,
+		shapes:    map[string]int{},
+	}
+	if cfg != nil {
+		p.cfg = *cfg
+	}
+	if p.cfg.RepeatThreshold <= 0 {
+		p.cfg.RepeatThreshold = 5
+	}
+
+	return p
+}
+
+// ContextWithQueryProfile ...
+func ContextWithQueryProfile(ctx context.Context, p *QueryProfile) context.Context {
+	return context.WithValue(ctx, profileCtxKey{}, p)
+}
+
+// QueryProfileFromContext return nil if the request is not profiled
+func QueryProfileFromContext(ctx context.Context) *QueryProfile {
+	if ctx == nil {
+		return nil
+	}
+	p, _ := ctx.Value(profileCtxKey{}).(*QueryProfile)
+	return p
+}
+
+// Record 記錄一筆 statement, N+1 與 budget 的警告由 request 結束時的 summary 統一輸出
+func (p *QueryProfile) Record(sql string, latency time.Duration) {
+	shape := QueryShape(sql)
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.count++
+	p.total += latency
+	p.shapes[shape]++
+}
+
+// Count number of statements recorded
+func (p *QueryProfile) Count() int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.count
+}
+
+// Duration total time spent in the database
+func (p *QueryProfile) Duration() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.total
+}
+
+// Repeated shapes that reached the N+1 threshold
+func (p *QueryProfile) Repeated() map[string]int {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	repeated := map[string]int{}
+	for shape, n := range p.shapes {
+		if n >= p.cfg.RepeatThreshold {
+			repeated[shape] = n
+		}
+	}
+	return repeated
+}
+
+// QueryShape 將 sql 正規化, 忽略空白與 IN list 長度
+func QueryShape(sql string) string {
+	shape := shapeSpaceRegexp.ReplaceAllString(strings.TrimSpace(sql), " ")
+	return shapeListRegexp.ReplaceAllString(shape, "(?)")
+}
+
+// Read return read DB bound to the profile carried by ctx
+func (conn *RdbmsConn) Read(ctx context.Context) *gorm.DB {
+	return profiled(ctx, conn.ReadDB, conn.readLogger)
+}
+
+// Write return write DB bound to the profile carried by ctx
+func (conn *RdbmsConn) Write(ctx context.Context) *gorm.DB {
+	return profiled(ctx, conn.WriteDB, conn.writeLogger)
+}
+
+// profiled 只有開啟 Debug 的 DB 才會有 statement 可以統計
+func profiled(ctx context.Context, db *gorm.DB, gl *GormLogger) *gorm.DB {
+	p := QueryProfileFromContext(ctx)
+	if p == nil || gl == nil {
+		return db
+	}
+
+	clone := db.New()
+	clone.SetLogger(&GormLogger{
+		logger:     gl.logger.With().Str("request_id", p.RequestID).Logger(),
+		WithColor:  gl.WithColor,
+		WithCaller: gl.WithCaller,
+		profile:    p,
+	})
+	return clone
+}
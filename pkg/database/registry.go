@@ -0,0 +1,174 @@
+package database
+
+import (
+	"context"
+	"fmt"
+	"sort"
+
+	"apigateway/pkg/health"
+
+	"github.com/jinzhu/gorm"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+	"go.uber.org/multierr"
+)
+
+// Configs named database configs, e.g. databases.catalog
+type Configs map[string]*RdbmsConfig
+
+// SetDefaults apply Rdbms defaults to every configured database
+func (cfgs Configs) SetDefaults() {
+	for _, cfg := range cfgs {
+		if cfg == nil {
+			continue
+		}
+		if cfg.Read != nil {
+			cfg.Read.SetDefaults()
+		}
+		if cfg.Write != nil {
+			cfg.Write.SetDefaults()
+		}
+	}
+}
+
+// Registry 管理所有具名連線, 每個連線有各自的 pool 與 logger
+type Registry struct {
+	conns map[string]*RdbmsConn
+}
+
+// NewRegistry open every configured database and close them on stop
+func NewRegistry(lc fx.Lifecycle, cfgs Configs) (*Registry, error) {
+	r := &Registry{conns: map[string]*RdbmsConn{}}
+
+	for _, name := range sortedNames(cfgs) {
+		conn, err := InitRdbms(name, cfgs[name])
+		if err != nil {
+			_ = r.Close()
+			return nil, err
+		}
+		r.conns[name] = conn
+		log.Info().Str("db_name", name).Msg("database registered")
+	}
+
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			return r.Close()
+		},
+	})
+
+	return r, nil
+}
+
+// Get return the connection registered under name
+func (r *Registry) Get(name string) (*RdbmsConn, error) {
+	conn, ok := r.conns[name]
+	if !ok {
+		return nil, fmt.Errorf("database %s is not configured", name)
+	}
+	return conn, nil
+}
+
+// Names of all registered connections
+func (r *Registry) Names() []string {
+	names := make([]string, 0, len(r.conns))
+	for name := range r.conns {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// HealthCheck ping every connection, nil value means healthy
+func (r *Registry) HealthCheck(ctx context.Context) map[string]error {
+	result := map[string]error{}
+	for name, conn := range r.conns {
+		result[name] = conn.Ping(ctx)
+	}
+	return result
+}
+
+// UpdatePools apply pool sizes of cfgs to the matching connections
+func (r *Registry) UpdatePools(cfgs Configs) error {
+	for name, conn := range r.conns {
+		cfg, ok := cfgs[name]
+		if !ok || cfg == nil || cfg.Read == nil || cfg.Write == nil {
+			return fmt.Errorf("database %s is missing from the new config", name)
+		}
+		cfg.Read.ConfigurePool(conn.ReadDB)
+		cfg.Write.ConfigurePool(conn.WriteDB)
+		log.Info().Str("db_name", name).Msg("database pool updated")
+	}
+	return nil
+}
+
+// Close every connection
+func (r *Registry) Close() error {
+	var err error
+	for _, conn := range r.conns {
+		err = multierr.Append(err, conn.Close())
+	}
+	return err
+}
+
+// RegisterHealthChecks 每個連線的 read 與 write 各一個 check, 只有 read 是 critical
+func RegisterHealthChecks(c *health.Checker, r *Registry) {
+	for _, name := range r.Names() {
+		conn := r.conns[name]
+		c.Register(health.Check{Name: "database." + name + ".read", Critical: true, Run: conn.PingRead})
+		c.Register(health.Check{Name: "database." + name + ".write", Run: conn.PingWrite})
+	}
+}
+
+// Ping read and write DB
+func (conn *RdbmsConn) Ping(ctx context.Context) error {
+	if err := conn.PingRead(ctx); err != nil {
+		return err
+	}
+	return conn.PingWrite(ctx)
+}
+
+// PingRead ...
+func (conn *RdbmsConn) PingRead(ctx context.Context) error {
+	if err := conn.ReadDB.DB().PingContext(ctx); err != nil {
+		return fmt.Errorf("read db ping was failed: %w", err)
+	}
+	return nil
+}
+
+// PingWrite ...
+func (conn *RdbmsConn) PingWrite(ctx context.Context) error {
+	if err := conn.WriteDB.DB().PingContext(ctx); err != nil {
+		return fmt.Errorf("write db ping was failed: %w", err)
+	}
+	return nil
+}
+
+// Close read and write DB, 尚未開啟的連線會略過
+func (conn *RdbmsConn) Close() error {
+	var err error
+	for _, db := range []*gorm.DB{conn.ReadDB, conn.WriteDB} {
+		if db != nil {
+			err = multierr.Append(err, db.Close())
+		}
+	}
+	return err
+}
+
+// Named provide the connection as an fx named value, consumers use `name:"<name>"`
+func Named(name string) fx.Option {
+	return fx.Provide(fx.Annotated{
+		Name: name,
+		Target: func(r *Registry) (*RdbmsConn, error) {
+			return r.Get(name)
+		},
+	})
+}
+
+func sortedNames(cfgs Configs) []string {
+	names := make([]string, 0, len(cfgs))
+	for name := range cfgs {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
@@ -0,0 +1,178 @@
+package event
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"sync"
+	"time"
+
+	"apigateway/pkg/metrics"
+
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+)
+
+// Module Export event module
+var Module = fx.Options(
+	fx.Provide(NewBroker),
+)
+
+// Book event types
+const (
+	Created = "created"
+	Updated = "updated"
+	Deleted = "deleted"
+)
+
+var (
+	// ErrSlowConsumer client 讀取速度跟不上, 尚未送出的 event 超過 buffer
+	ErrSlowConsumer = errors.New("event: slow consumer dropped")
+	// ErrClosed broker 已關閉
+	ErrClosed = errors.New("event: broker closed")
+)
+
+// Event 依發布順序編號, ID 只在同一個 process 內遞增
+type Event struct {
+	ID   uint64      `json:"id"`
+	Type string      `json:"type"`
+	Data interface{} `json:"data"`
+	Time time.Time   `json:"time"`
+}
+
+// Broker in-process publisher, 保留最近的 event 供斷線的 client 續傳
+type Broker struct {
+	name       string
+	replaySize int
+	bufferSize int
+
+	mu     sync.Mutex
+	seq    uint64
+	replay []Event
+	subs   map[*Subscription]struct{}
+	closed bool
+}
+
+// NewBroker 目前只有 book 的 change feed
+func NewBroker(lc fx.Lifecycle, cfg *Config) *Broker {
+	b := &Broker{
+		name:       "books",
+		replaySize: cfg.replaySize(),
+		bufferSize: cfg.bufferSize(),
+		subs:       map[*Subscription]struct{}{},
+	}
+
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			b.Close()
+			return nil
+		},
+	})
+	return b
+}
+
+// Publish 不會阻塞, buffer 已滿的 subscriber 直接中斷
+func (b *Broker) Publish(typ string, data interface{}) Event {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.seq++
+	e := Event{ID: b.seq, Type: typ, Data: data, Time: time.Now().UTC()}
+	if b.closed {
+		return e
+	}
+
+	b.replay = append(b.replay, e)
+	if len(b.replay) > b.replaySize {
+		b.replay = append(b.replay[:0:0], b.replay[len(b.replay)-b.replaySize:]...)
+	}
+
+	for s := range b.subs {
+		select {
+		case s.events <- e:
+		default:
+			b.drop(s, ErrSlowConsumer)
+			metrics.EventsDropped.Add(b.name, 1)
+			log.Warn().Str("stream", b.name).Uint64("event_id", e.ID).Msg("event: dropping slow consumer")
+		}
+	}
+	return e
+}
+
+// Subscribe 回傳 lastEventID 之後仍在 replay buffer 中的 event 與新的 subscription.
+// lastEventID 無法辨識 (例如重啟前的 id) 或已不在 buffer 時, 回傳整個 buffer.
+func (b *Broker) Subscribe(lastEventID string) ([]Event, *Subscription) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	s := &Subscription{broker: b, events: make(chan Event, b.bufferSize)}
+	if b.closed {
+		s.err = ErrClosed
+		close(s.events)
+		return nil, s
+	}
+	b.subs[s] = struct{}{}
+	metrics.EventSubscribers.Add(b.name, 1)
+
+	if lastEventID == "" {
+		return nil, s
+	}
+	return b.since(lastEventID), s
+}
+
+func (b *Broker) since(lastEventID string) []Event {
+	id, err := strconv.ParseUint(lastEventID, 10, 64)
+	if err != nil || id > b.seq || len(b.replay) == 0 || id+1 < b.replay[0].ID {
+		return append([]Event(nil), b.replay...)
+	}
+	// replay 中的 id 連續, 直接換算位置
+	return append([]Event(nil), b.replay[id+1-b.replay[0].ID:]...)
+}
+
+// Close 中斷所有 subscriber, 之後 Publish 的 event 不再保留
+func (b *Broker) Close() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	b.closed = true
+	for s := range b.subs {
+		b.drop(s, ErrClosed)
+	}
+}
+
+// drop caller 必須持有 b.mu
+func (b *Broker) drop(s *Subscription, err error) {
+	if _, ok := b.subs[s]; !ok {
+		return
+	}
+	delete(b.subs, s)
+	metrics.EventSubscribers.Add(b.name, -1)
+	s.err = err
+	close(s.events)
+}
+
+// Subscription Events 被關閉後可由 Err 取得原因
+type Subscription struct {
+	broker *Broker
+	events chan Event
+	err    error
+}
+
+// Events ...
+func (s *Subscription) Events() <-chan Event {
+	return s.events
+}
+
+// Err 只在 Events 關閉後有意義
+func (s *Subscription) Err() error {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	return s.err
+}
+
+// Close 取消訂閱, 可重複呼叫
+func (s *Subscription) Close() {
+	s.broker.mu.Lock()
+	defer s.broker.mu.Unlock()
+	s.broker.drop(s, nil)
+}
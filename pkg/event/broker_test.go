@@ -0,0 +1,114 @@
+package event
+
+import (
+	"testing"
+
+	"go.uber.org/fx"
+)
+
+type testLifecycle struct{}
+
+func (testLifecycle) Append(fx.Hook) {}
+
+func ids(events []Event) []uint64 {
+	var out []uint64
+	for _, e := range events {
+		out = append(out, e.ID)
+	}
+	return out
+}
+
+func equal(a, b []uint64) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+func TestBrokerReplay(t *testing.T) {
+	b := NewBroker(testLifecycle{}, &Config{ReplaySize: 3})
+	for i := 0; i < 5; i++ {
+		b.Publish(Created, i)
+	}
+
+	tests := []struct {
+		name        string
+		lastEventID string
+		want        []uint64
+	}{
+		{name: "new client", lastEventID: "", want: nil},
+		{name: "in buffer", lastEventID: "3", want: []uint64{4, 5}},
+		{name: "oldest in buffer", lastEventID: "2", want: []uint64{3, 4, 5}},
+		{name: "up to date", lastEventID: "5", want: nil},
+		{name: "evicted from buffer", lastEventID: "1", want: []uint64{3, 4, 5}},
+		{name: "from a previous process", lastEventID: "99", want: []uint64{3, 4, 5}},
+		{name: "not a number", lastEventID: "abc", want: []uint64{3, 4, 5}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			replay, sub := b.Subscribe(tt.lastEventID)
+			defer sub.Close()
+			if got := ids(replay); !equal(got, tt.want) {
+				t.Errorf("Subscribe(%q) replay = %v, want %v", tt.lastEventID, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBrokerDelivery(t *testing.T) {
+	b := NewBroker(testLifecycle{}, &Config{BufferSize: 2})
+	_, fast := b.Subscribe("")
+	_, slow := b.Subscribe("")
+
+	b.Publish(Created, "a")
+	<-fast.Events()
+	b.Publish(Updated, "a")
+	<-fast.Events()
+	// slow 的 buffer 已滿, 下一個 event 中斷 slow, fast 不受影響
+	b.Publish(Deleted, "a")
+
+	if e := <-fast.Events(); e.ID != 3 || e.Type != Deleted {
+		t.Errorf("fast got %+v, want event 3", e)
+	}
+
+	var got []uint64
+	for e := range slow.Events() {
+		got = append(got, e.ID)
+	}
+	if !equal(got, []uint64{1, 2}) {
+		t.Errorf("slow got %v before being dropped, want [1 2]", got)
+	}
+	if err := slow.Err(); err != ErrSlowConsumer {
+		t.Errorf("slow Err() = %v, want ErrSlowConsumer", err)
+	}
+
+	fast.Close()
+	fast.Close()
+	if _, ok := <-fast.Events(); ok || fast.Err() != nil {
+		t.Errorf("after Close: events open %v, Err() = %v", ok, fast.Err())
+	}
+}
+
+func TestBrokerClose(t *testing.T) {
+	b := NewBroker(testLifecycle{}, nil)
+	_, sub := b.Subscribe("")
+	b.Close()
+
+	if _, ok := <-sub.Events(); ok || sub.Err() != ErrClosed {
+		t.Errorf("subscriber after Close: events open %v, Err() = %v", ok, sub.Err())
+	}
+
+	b.Publish(Created, "a")
+	replay, late := b.Subscribe("0")
+	if len(replay) != 0 || late.Err() != ErrClosed {
+		t.Errorf("Subscribe after Close = %v, %v", replay, late.Err())
+	}
+	if _, ok := <-late.Events(); ok {
+		t.Error("events of a subscription after Close are open")
+	}
+}
@@ -0,0 +1,42 @@
+package event
+
+import "time"
+
+// broker defaults
+const (
+	defaultReplaySize = 1000
+	defaultBufferSize = 64
+	defaultHeartbeat  = 15 * time.Second
+)
+
+// Config the structure for the `events:` section
+type Config struct {
+	// ReplaySize 保留最近的 event 數量, 供 Last-Event-ID 續傳, 預設 1000
+	ReplaySize int `yaml:"replay_size" mapstructure:"replay_size" validate:"min=0"`
+	// BufferSize 每個 client 尚未送出的 event 上限, 超過時中斷該 client, 預設 64
+	BufferSize int `yaml:"buffer_size" mapstructure:"buffer_size" validate:"min=0"`
+	// Heartbeat 沒有 event 時送出 comment 的間隔, 預設 15s
+	Heartbeat time.Duration `validate:"min=0"`
+}
+
+func (cfg *Config) replaySize() int {
+	if cfg == nil || cfg.ReplaySize == 0 {
+		return defaultReplaySize
+	}
+	return cfg.ReplaySize
+}
+
+func (cfg *Config) bufferSize() int {
+	if cfg == nil || cfg.BufferSize == 0 {
+		return defaultBufferSize
+	}
+	return cfg.BufferSize
+}
+
+// HeartbeatInterval ...
+func (cfg *Config) HeartbeatInterval() time.Duration {
+	if cfg == nil || cfg.Heartbeat == 0 {
+		return defaultHeartbeat
+	}
+	return cfg.Heartbeat
+}
@@ -0,0 +1,146 @@
+package health
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"go.uber.org/fx"
+)
+
+// Module Export health module, 各 package 以 Checker.Register 加入自己的 check
+var Module = fx.Options(
+	fx.Provide(NewChecker),
+)
+
+// report status
+const (
+	StatusOK = "ok"
+	// StatusDegraded 只有非 critical 的 check 失敗, 仍然 ready
+	StatusDegraded = "degraded"
+	StatusFail     = "fail"
+)
+
+// Check 一個 readiness check
+type Check struct {
+	Name string
+	// Critical 失敗時 readiness 回報失敗, 否則只標示為 degraded
+	Critical bool
+	// Local 只檢查程序內的狀態, 不經過 cache, 例如是否正在 drain
+	Local bool
+	Run   func(ctx context.Context) error
+}
+
+// Result 一個 check 的結果
+type Result struct {
+	Name      string    `json:"name"`
+	Status    string    `json:"status"`
+	Critical  bool      `json:"critical"`
+	LatencyMS float64   `json:"latency_ms"`
+	Error     string    `json:"error,omitempty"`
+	CheckedAt time.Time `json:"checked_at"`
+	Cached    bool      `json:"cached"`
+}
+
+// Report readiness 的回應
+type Report struct {
+	Status string   `json:"status"`
+	Checks []Result `json:"checks"`
+}
+
+// Checker 平行執行所有 check, 結果保留 cache ttl, 同一個 check 同時只會執行一次
+type Checker struct {
+	ttl     time.Duration
+	timeout time.Duration
+
+	mu     sync.Mutex
+	checks []*entry
+}
+
+type entry struct {
+	check Check
+
+	// mu 讓同時到達的 probe 等待同一次執行
+	mu   sync.Mutex
+	last *Result
+}
+
+// NewChecker ...
+func NewChecker(cfg *Config) *Checker {
+	return &Checker{ttl: cfg.cacheTTL(), timeout: cfg.timeout()}
+}
+
+// Register ...
+func (c *Checker) Register(check Check) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.checks = append(c.checks, &entry{check: check})
+}
+
+// Ready 執行所有 check, critical 的 check 失敗時 Status 為 fail
+func (c *Checker) Ready() Report {
+	c.mu.Lock()
+	entries := append([]*entry(nil), c.checks...)
+	c.mu.Unlock()
+
+	results := make([]Result, len(entries))
+	var wg sync.WaitGroup
+	for i, e := range entries {
+		wg.Add(1)
+		go func(i int, e *entry) {
+			defer wg.Done()
+			results[i] = c.run(e)
+		}(i, e)
+	}
+	wg.Wait()
+
+	sort.Slice(results, func(i, j int) bool { return results[i].Name < results[j].Name })
+	report := Report{Status: StatusOK, Checks: results}
+	for _, r := range results {
+		if r.Status == StatusOK {
+			continue
+		}
+		if r.Critical {
+			report.Status = StatusFail
+			break
+		}
+		report.Status = StatusDegraded
+	}
+	return report
+}
+
+// run 結果會給其他 probe 共用, 不使用 request 的 ctx
+func (c *Checker) run(e *entry) Result {
+	if !e.check.Local {
+		e.mu.Lock()
+		defer e.mu.Unlock()
+		if e.last != nil && time.Since(e.last.CheckedAt) < c.ttl {
+			r := *e.last
+			r.Cached = true
+			return r
+		}
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), c.timeout)
+	defer cancel()
+
+	start := time.Now()
+	err := e.check.Run(ctx)
+	r := Result{
+		Name:      e.check.Name,
+		Status:    StatusOK,
+		Critical:  e.check.Critical,
+		LatencyMS: float64(time.Since(start).Microseconds()) / 1000,
+		CheckedAt: start,
+	}
+	if err != nil {
+		r.Status = StatusFail
+		r.Error = err.Error()
+	}
+
+	if !e.check.Local {
+		e.last = &r
+	}
+	return r
+}
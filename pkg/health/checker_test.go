@@ -0,0 +1,118 @@
+package health
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func check(name string, critical bool, err error) Check {
+	return Check{Name: name, Critical: critical, Local: true, Run: func(context.Context) error { return err }}
+}
+
+func TestCheckerReady(t *testing.T) {
+	fail := errors.New("down")
+	tests := []struct {
+		name   string
+		checks []Check
+		want   string
+	}{
+		{name: "no checks", want: StatusOK},
+		{name: "all ok", checks: []Check{check("a", true, nil), check("b", false, nil)}, want: StatusOK},
+		{name: "non critical failed", checks: []Check{check("a", true, nil), check("b", false, fail)}, want: StatusDegraded},
+		{name: "critical failed", checks: []Check{check("a", false, fail), check("b", true, fail)}, want: StatusFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewChecker(nil)
+			for _, ch := range tt.checks {
+				c.Register(ch)
+			}
+			report := c.Ready()
+			if report.Status != tt.want {
+				t.Errorf("Status = %s, want %s", report.Status, tt.want)
+			}
+			if len(report.Checks) != len(tt.checks) {
+				t.Fatalf("%d results, want %d", len(report.Checks), len(tt.checks))
+			}
+			for _, r := range report.Checks {
+				if (r.Status == StatusFail) != (r.Error != "") {
+					t.Errorf("result %+v: error does not match status", r)
+				}
+			}
+		})
+	}
+}
+
+func TestCheckerCache(t *testing.T) {
+	var remote, local int32
+	c := NewChecker(&Config{CacheTTL: 100 * time.Millisecond})
+	c.Register(Check{Name: "remote", Run: func(context.Context) error {
+		atomic.AddInt32(&remote, 1)
+		return nil
+	}})
+	c.Register(Check{Name: "local", Local: true, Run: func(context.Context) error {
+		atomic.AddInt32(&local, 1)
+		return nil
+	}})
+
+	c.Ready()
+	report := c.Ready()
+	if remote != 1 || local != 2 {
+		t.Errorf("runs remote %d local %d, want 1 and 2", remote, local)
+	}
+	// 結果依名稱排序
+	if r := report.Checks[1]; r.Name != "remote" || !r.Cached {
+		t.Errorf("second result = %+v, want cached remote", r)
+	}
+
+	time.Sleep(150 * time.Millisecond)
+	c.Ready()
+	if remote != 2 {
+		t.Errorf("remote runs after ttl = %d, want 2", remote)
+	}
+}
+
+// TestCheckerConcurrentProbes 同時到達的 probe 共用同一次執行
+func TestCheckerConcurrentProbes(t *testing.T) {
+	var runs int32
+	c := NewChecker(nil)
+	c.Register(Check{Name: "db", Critical: true, Run: func(context.Context) error {
+		atomic.AddInt32(&runs, 1)
+		time.Sleep(50 * time.Millisecond)
+		return nil
+	}})
+
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			c.Ready()
+		}()
+	}
+	wg.Wait()
+	if runs != 1 {
+		t.Errorf("runs = %d, want 1", runs)
+	}
+}
+
+func TestCheckerTimeout(t *testing.T) {
+	c := NewChecker(&Config{Timeout: 50 * time.Millisecond})
+	c.Register(Check{Name: "slow", Critical: true, Run: func(ctx context.Context) error {
+		<-ctx.Done()
+		return ctx.Err()
+	}})
+
+	start := time.Now()
+	report := c.Ready()
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Ready() took %v", elapsed)
+	}
+	if report.Status != StatusFail || report.Checks[0].Error != context.DeadlineExceeded.Error() {
+		t.Errorf("report = %+v, want the check to time out", report)
+	}
+}
@@ -0,0 +1,31 @@
+package health
+
+import "time"
+
+// checker defaults
+const (
+	defaultCacheTTL = 2 * time.Second
+	defaultTimeout  = time.Second
+)
+
+// Config the structure for the `health:` section
+type Config struct {
+	// CacheTTL 結果保留的時間, 期間內的 probe 直接回傳上次的結果, 預設 2s
+	CacheTTL time.Duration `yaml:"cache_ttl" mapstructure:"cache_ttl" validate:"min=0"`
+	// Timeout 每個 check 的上限, 預設 1s
+	Timeout time.Duration `validate:"min=0"`
+}
+
+func (cfg *Config) cacheTTL() time.Duration {
+	if cfg == nil || cfg.CacheTTL == 0 {
+		return defaultCacheTTL
+	}
+	return cfg.CacheTTL
+}
+
+func (cfg *Config) timeout() time.Duration {
+	if cfg == nil || cfg.Timeout == 0 {
+		return defaultTimeout
+	}
+	return cfg.Timeout
+}
@@ -0,0 +1,123 @@
+package lifecycle
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"apigateway/pkg/health"
+
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+)
+
+// Module Export lifecycle module.
+// 必須放在所有 server module 之後, fx 依相反順序執行 OnStop, drain 才會最先開始
+var Module = fx.Options(
+	fx.Provide(NewDrainer),
+	fx.Invoke(RegisterDrain, RegisterHealthCheck),
+)
+
+var errDraining = errors.New("shutting down")
+
+// defaultGracePeriod 沒有設定時等待進行中 request 與背景工作的上限
+const defaultGracePeriod = 30 * time.Second
+
+// Config 收到 SIGINT/SIGTERM 後的關閉流程
+type Config struct {
+	// DrainDelay readiness 回報失敗後, 等待 load balancer 移除這個 instance 的時間, 期間仍正常服務
+	DrainDelay time.Duration `yaml:"drain_delay" mapstructure:"drain_delay" validate:"min=0"`
+	// GracePeriod drain 之後等待進行中的 request 與背景工作結束的上限, 預設 30s
+	GracePeriod time.Duration `yaml:"grace_period" mapstructure:"grace_period" validate:"min=0"`
+}
+
+// Drainer 記錄是否已開始關閉, readiness check 以此回報失敗
+type Drainer struct {
+	drainDelay  time.Duration
+	gracePeriod time.Duration
+	draining    int32
+
+	mu    sync.Mutex
+	hooks []func()
+}
+
+// NewDrainer ...
+func NewDrainer(cfg *Config) *Drainer {
+	d := &Drainer{gracePeriod: defaultGracePeriod}
+	if cfg != nil {
+		d.drainDelay = cfg.DrainDelay
+		if cfg.GracePeriod > 0 {
+			d.gracePeriod = cfg.GracePeriod
+		}
+	}
+	return d
+}
+
+// RegisterDrain OnStop 最先執行 Drain
+func RegisterDrain(lc fx.Lifecycle, d *Drainer) {
+	lc.Append(fx.Hook{
+		OnStop: d.Drain,
+	})
+}
+
+// RegisterHealthCheck 開始 drain 後 readiness 立即失敗
+func RegisterHealthCheck(c *health.Checker, d *Drainer) {
+	c.Register(health.Check{
+		Name:     "draining",
+		Critical: true,
+		Local:    true,
+		Run: func(context.Context) error {
+			if d.Draining() {
+				return errDraining
+			}
+			return nil
+		},
+	})
+}
+
+// Timeout 整個關閉流程的上限, 給 app.Stop 使用
+func (d *Drainer) Timeout() time.Duration {
+	return d.drainDelay + d.gracePeriod
+}
+
+// Draining ...
+func (d *Drainer) Draining() bool {
+	return atomic.LoadInt32(&d.draining) == 1
+}
+
+// OnDrain fn 在開始 drain 時執行, 例如讓 gRPC health 回報 NOT_SERVING
+func (d *Drainer) OnDrain(fn func()) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	d.hooks = append(d.hooks, fn)
+}
+
+// Drain readiness 改為失敗並等待 drain delay, 之後才由各 server 停止 accept
+func (d *Drainer) Drain(ctx context.Context) error {
+	if !atomic.CompareAndSwapInt32(&d.draining, 0, 1) {
+		return nil
+	}
+
+	d.mu.Lock()
+	hooks := d.hooks
+	d.mu.Unlock()
+	for _, fn := range hooks {
+		fn()
+	}
+
+	if d.drainDelay <= 0 {
+		return nil
+	}
+	log.Info().Dur("drain_delay", d.drainDelay).Msg("draining, readiness is now failing")
+
+	timer := time.NewTimer(d.drainDelay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,80 @@
+package lifecycle
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"apigateway/pkg/health"
+)
+
+func TestNewDrainer(t *testing.T) {
+	tests := []struct {
+		name        string
+		cfg         *Config
+		wantTimeout time.Duration
+	}{
+		{name: "default", cfg: nil, wantTimeout: defaultGracePeriod},
+		{name: "drain delay", cfg: &Config{DrainDelay: 5 * time.Second}, wantTimeout: 5*time.Second + defaultGracePeriod},
+		{name: "grace period", cfg: &Config{DrainDelay: 5 * time.Second, GracePeriod: 10 * time.Second}, wantTimeout: 15 * time.Second},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := NewDrainer(tt.cfg).Timeout(); got != tt.wantTimeout {
+				t.Errorf("Timeout() = %v, want %v", got, tt.wantTimeout)
+			}
+		})
+	}
+}
+
+func TestDrain(t *testing.T) {
+	d := NewDrainer(&Config{DrainDelay: 100 * time.Millisecond})
+	checker := health.NewChecker(nil)
+	RegisterHealthCheck(checker, d)
+
+	var calls int
+	d.OnDrain(func() {
+		calls++
+		// hook 執行時 readiness 已經失敗
+		if !d.Draining() {
+			t.Error("OnDrain hook ran before Draining() is true")
+		}
+	})
+	if r := checker.Ready(); r.Status != health.StatusOK {
+		t.Fatalf("readiness before drain = %s, want ok", r.Status)
+	}
+
+	// drain delay 期間仍正常服務, 結束後才回傳讓 server 停止 accept
+	start := time.Now()
+	if err := d.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed < 100*time.Millisecond {
+		t.Errorf("Drain() returned after %v, want the drain delay", elapsed)
+	}
+	if r := checker.Ready(); r.Status != health.StatusFail {
+		t.Errorf("readiness while draining = %s, want fail", r.Status)
+	}
+
+	// 第二次 Drain 不再執行 hook 也不等待
+	start = time.Now()
+	if err := d.Drain(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed >= 100*time.Millisecond || calls != 1 {
+		t.Errorf("second Drain() took %v and ran hooks %d times", elapsed, calls)
+	}
+}
+
+func TestDrainCanceled(t *testing.T) {
+	d := NewDrainer(&Config{DrainDelay: time.Minute})
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	if err := d.Drain(ctx); err != context.DeadlineExceeded {
+		t.Errorf("Drain() = %v, want DeadlineExceeded", err)
+	}
+	if !d.Draining() {
+		t.Error("Draining() = false after a canceled Drain")
+	}
+}
@@ -0,0 +1,35 @@
+package metrics
+
+import (
+	"expvar"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	// CircuitState 目前狀態, key 為 breaker 名稱, 0 closed, 1 open, 2 half-open
+	CircuitState = expvar.NewMap("circuit_breaker_state")
+	// CircuitTransitions key 為 "<name>:<from>-><to>"
+	CircuitTransitions = expvar.NewMap("circuit_breaker_transitions")
+	// ProxyRetries key 為 route 名稱
+	ProxyRetries = expvar.NewMap("proxy_retries")
+	// RetryBudgetExhausted 因超過 retry budget 而放棄重試的次數, key 為 route 名稱
+	RetryBudgetExhausted = expvar.NewMap("proxy_retry_budget_exhausted")
+	// RateLimited 回 429 的次數, key 為 route 名稱
+	RateLimited = expvar.NewMap("rate_limited")
+	// UpstreamTLSErrors 與 upstream 的 TLS handshake 或憑證驗證失敗的次數, key 為 upstream 名稱
+	UpstreamTLSErrors = expvar.NewMap("upstream_tls_errors")
+	// Cache key 為 "<route>.<hit|miss|stale|revalidated|bypass>"
+	Cache = expvar.NewMap("cache")
+	// Coalesced 共用其他 request 的 upstream response 的次數, key 為 route 名稱
+	Coalesced = expvar.NewMap("proxy_coalesced")
+	// EventSubscribers 目前連線中的 SSE client, key 為 stream 名稱
+	EventSubscribers = expvar.NewMap("event_subscribers")
+	// EventsDropped 因讀取過慢被中斷的 client 數, key 為 stream 名稱
+	EventsDropped = expvar.NewMap("event_dropped_consumers")
+)
+
+// Handler 以 expvar 的 JSON 格式輸出所有 metrics
+func Handler() gin.HandlerFunc {
+	return gin.WrapH(expvar.Handler())
+}
@@ -1,18 +1,59 @@
 package middleware
 
 import (
-	"github.com/labstack/echo/v4"
-	"github.com/labstack/echo/v4/middleware"
+	"net/http"
+	"strings"
+	"time"
+
+	"apigateway/pkg/clientip"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
 )
 
-// AccessLogger ...
-func AccessLogger(appID string) echo.MiddlewareFunc {
-	return middleware.LoggerWithConfig(
-		middleware.LoggerConfig{
-			Format: `{"level":"info","time":"${time_rfc3339_nano}","request_id":"${id}","remote_ip":"${remote_ip}","host":"${host}",` +
-				`"method":"${method}","uri":"${uri}","path":"${path}","status":${status},"error":"${error}","latency":${latency},` +
-				`"latency_human":"${latency_human}","bytes_in":${bytes_in},` +
-				`"bytes_out":${bytes_out},"message":"access log","app_id":"` + appID + `"}` + "\n",
-		},
-	)
+// AccessLogger request 結束後輸出一筆 JSON access log, 5xx 為 error level.
+// 放在 RequestID 之前或之後都能取得 request id
+func AccessLogger(appID string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		path := c.Request.URL.Path
+
+		c.Next()
+
+		requestID := RequestIDFromContext(c.Request.Context())
+		if requestID == "" {
+			requestID = c.Writer.Header().Get(HeaderXRequestID)
+		}
+
+		status := c.Writer.Status()
+		var event *zerolog.Event
+		switch {
+		case status >= http.StatusInternalServerError:
+			event = log.Error()
+		case status >= http.StatusBadRequest:
+			event = log.Warn()
+		default:
+			event = log.Info()
+		}
+		if len(c.Errors) > 0 {
+			event = event.Str("error", strings.Join(c.Errors.Errors(), "; "))
+		}
+
+		event.
+			Str("app_id", appID).
+			Str("request_id", requestID).
+			Str("remote_ip", clientip.FromRequest(c.Request)).
+			Str("host", c.Request.Host).
+			Str("method", c.Request.Method).
+			Str("uri", c.Request.RequestURI).
+			Str("path", path).
+			Str("route", c.FullPath()).
+			Int("status", status).
+			Dur("latency", time.Since(start)).
+			Int64("bytes_in", c.Request.ContentLength).
+			Int("bytes_out", c.Writer.Size()).
+			Str("user_agent", c.Request.UserAgent()).
+			Msg("access log")
+	}
 }
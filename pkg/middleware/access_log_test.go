@@ -0,0 +1,80 @@
+package middleware
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"apigateway/pkg/apperror"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAccessLogger(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	buf := captureLog(t)
+
+	tests := []struct {
+		name string
+		// requestIDFirst RequestID 放在 AccessLogger 之前
+		requestIDFirst bool
+		status         int
+		wantLevel      string
+		wantError      string
+	}{
+		{name: "ok", status: http.StatusOK, wantLevel: "info"},
+		{name: "client error", status: http.StatusNotFound, wantLevel: "warn", wantError: "not_found: The requested resource was not found."},
+		{name: "server error", status: http.StatusBadGateway, wantLevel: "error", wantError: "bad_gateway: The upstream service failed to respond."},
+		{name: "request id first", requestIDFirst: true, status: http.StatusOK, wantLevel: "info"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			if tt.requestIDFirst {
+				router.Use(RequestID(), AccessLogger("gateway"))
+			} else {
+				router.Use(AccessLogger("gateway"), RequestID())
+			}
+			status := tt.status
+			router.GET("/books/:id", func(c *gin.Context) {
+				if status >= http.StatusBadRequest {
+					apperror.Abort(c, apperror.FromStatus(status))
+					return
+				}
+				c.String(status, "book")
+			})
+
+			buf.Reset()
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/books/1?x=1", nil))
+
+			var entry struct {
+				Level     string `json:"level"`
+				AppID     string `json:"app_id"`
+				RequestID string `json:"request_id"`
+				URI       string `json:"uri"`
+				Path      string `json:"path"`
+				Route     string `json:"route"`
+				Status    int    `json:"status"`
+				BytesOut  int    `json:"bytes_out"`
+				Error     string `json:"error"`
+			}
+			if err := json.Unmarshal(buf.Bytes(), &entry); err != nil {
+				t.Fatalf("access log %q: %v", buf.String(), err)
+			}
+			if entry.Level != tt.wantLevel || entry.Status != tt.status || entry.Error != tt.wantError {
+				t.Errorf("entry = %+v, want level %s status %d error %q", entry, tt.wantLevel, tt.status, tt.wantError)
+			}
+			if entry.AppID != "gateway" || entry.URI != "/books/1?x=1" || entry.Path != "/books/1" || entry.Route != "/books/:id" {
+				t.Errorf("entry = %+v", entry)
+			}
+			if entry.RequestID == "" || entry.RequestID != rec.Header().Get(HeaderXRequestID) {
+				t.Errorf("request_id = %q, want %q", entry.RequestID, rec.Header().Get(HeaderXRequestID))
+			}
+			if entry.BytesOut != rec.Body.Len() {
+				t.Errorf("bytes_out = %d, want %d", entry.BytesOut, rec.Body.Len())
+			}
+		})
+	}
+}
@@ -0,0 +1,55 @@
+package middleware
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+)
+
+// TokenConfig bearer token 對應的 principal, token 可使用 ENC[...] 加密
+type TokenConfig struct {
+	Principal string `validate:"required"`
+	Token     string `validate:"required"`
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext 驗證通過的 principal, 匿名的 request 為空字串
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+// ContextWithPrincipal 讓 PrincipalFromContext 取得 principal, 給其他驗證方式使用
+func ContextWithPrincipal(ctx context.Context, principal string) context.Context {
+	return context.WithValue(ctx, principalKey{}, principal)
+}
+
+// Principal 以 `Authorization: Bearer <token>` 找出 principal 放入 request 的 ctx.
+// 只負責識別, 沒有或不符合的 token 視為匿名, 是否拒絕由各 route 決定
+func Principal(tokens []*TokenConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if principal, ok := authenticate(tokens, c.GetHeader("Authorization")); ok {
+			c.Request = c.Request.WithContext(ContextWithPrincipal(c.Request.Context(), principal))
+		}
+		c.Next()
+	}
+}
+
+// authenticate token 以 constant time 比對
+func authenticate(tokens []*TokenConfig, authorization string) (string, bool) {
+	const prefix = "bearer "
+	if len(authorization) <= len(prefix) || !strings.EqualFold(authorization[:len(prefix)], prefix) {
+		return "", false
+	}
+	token := []byte(authorization[len(prefix):])
+
+	for _, t := range tokens {
+		if subtle.ConstantTimeCompare(token, []byte(t.Token)) == 1 {
+			return t.Principal, true
+		}
+	}
+	return "", false
+}
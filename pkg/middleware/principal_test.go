@@ -0,0 +1,47 @@
+package middleware
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestPrincipal(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	var got string
+	router := gin.New()
+	router.Use(Principal([]*TokenConfig{
+		{Principal: "mobile", Token: "token-m"},
+		{Principal: "partner", Token: "token-p"},
+	}))
+	router.GET("/", func(c *gin.Context) {
+		got = PrincipalFromContext(c.Request.Context())
+	})
+
+	tests := []struct {
+		authorization string
+		want          string
+	}{
+		{authorization: "Bearer token-m", want: "mobile"},
+		{authorization: "bearer token-p", want: "partner"},
+		{authorization: "Bearer token-x"},
+		{authorization: "Bearer token-m2"},
+		{authorization: "Bearer "},
+		{authorization: "Basic token-m"},
+		{authorization: "token-m"},
+		{},
+	}
+	for _, tt := range tests {
+		got = "unset"
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		if tt.authorization != "" {
+			req.Header.Set("Authorization", tt.authorization)
+		}
+		router.ServeHTTP(httptest.NewRecorder(), req)
+		if got != tt.want {
+			t.Errorf("Authorization %q: principal = %q, want %q", tt.authorization, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,84 @@
+package middleware
+
+import (
+	"strconv"
+
+	"apigateway/pkg/database"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+const (
+	// HeaderDebugQueryCount number of statements issued by the request
+	HeaderDebugQueryCount = "X-Debug-Query-Count"
+	// HeaderDebugQueryTime total DB time of the request
+	HeaderDebugQueryTime = "X-Debug-Query-Time"
+)
+
+// QueryProfiler 將同一個 request 的 statement 歸在一起, 並在 response header 回報查詢數量與時間
+func QueryProfiler(cfg *database.ProfileConfig) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := RequestIDFromContext(c.Request.Context())
+		if requestID == "" {
+			requestID = c.GetHeader(HeaderXRequestID)
+		}
+
+		profile := database.NewQueryProfile(requestID, cfg)
+		c.Request = c.Request.WithContext(database.ContextWithQueryProfile(c.Request.Context(), profile))
+
+		w := &profileWriter{ResponseWriter: c.Writer, profile: profile}
+		c.Writer = w
+
+		c.Next()
+
+		// handler 沒有寫 body 時 header 還沒送出
+		w.setHeaders()
+
+		// 每個 request 只輸出一筆 summary, 同時涵蓋 N+1 與超過 budget
+		repeated := profile.Repeated()
+		overBudget := cfg.QueryBudget > 0 && profile.Count() > cfg.QueryBudget
+		if len(repeated) > 0 || overBudget {
+			log.Warn().
+				Str("request_id", requestID).
+				Str("path", c.FullPath()).
+				Int("query_count", profile.Count()).
+				Int("query_budget", cfg.QueryBudget).
+				Bool("over_budget", overBudget).
+				Dur("query_time", profile.Duration()).
+				Interface("repeated", repeated).
+				Msg("query profile")
+		}
+	}
+}
+
+// profileWriter 在 header 送出前補上統計欄位
+type profileWriter struct {
+	gin.ResponseWriter
+	profile *database.QueryProfile
+	done    bool
+}
+
+func (w *profileWriter) setHeaders() {
+	if w.done || w.ResponseWriter.Written() {
+		return
+	}
+	w.done = true
+	w.Header().Set(HeaderDebugQueryCount, strconv.Itoa(w.profile.Count()))
+	w.Header().Set(HeaderDebugQueryTime, w.profile.Duration().String())
+}
+
+func (w *profileWriter) WriteHeaderNow() {
+	w.setHeaders()
+	w.ResponseWriter.WriteHeaderNow()
+}
+
+func (w *profileWriter) Write(data []byte) (int, error) {
+	w.setHeaders()
+	return w.ResponseWriter.Write(data)
+}
+
+func (w *profileWriter) WriteString(s string) (int, error) {
+	w.setHeaders()
+	return w.ResponseWriter.WriteString(s)
+}
@@ -2,41 +2,40 @@ package middleware
 
 import (
 	"fmt"
-	"runtime"
+	"net/http"
+	"runtime/debug"
+
+	"apigateway/pkg/apperror"
 
 	"github.com/gin-gonic/gin"
-	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog/log"
 )
 
-// Recovery handling panic error
-func Recovery() echo.MiddlewareFunc {
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			defer func() {
-				if r := recover(); r != nil {
-					trace := make([]byte, 4096)
-					runtime.Stack(trace, true)
-					customFields := map[string]interface{}{
-						"uri":         c.Request().RequestURI,
-						"stack_error": string(trace),
-					}
-					err, ok := r.(error)
-					if !ok {
-						if err == nil {
-							err = fmt.Errorf("%v", r)
-						} else {
-							err = fmt.Errorf("%v", err)
-						}
-					}
-					logger := log.With().Fields(customFields).Logger()
-					logger.Error().Msgf("http: unknown error: %v", err)
+// Recovery handling panic error, 回 500 problem, release mode 不把 panic 內容回給 client.
+// http.ErrAbortHandler 是 proxy 中斷 response 的方式, 繼續往上拋
+func Recovery() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		defer func() {
+			r := recover()
+			if r == nil {
+				return
+			}
+			if r == http.ErrAbortHandler {
+				panic(r)
+			}
+
+			err, ok := r.(error)
+			if !ok {
+				err = fmt.Errorf("%v", r)
+			}
+			log.Error().
+				Str("request_id", RequestIDFromContext(c.Request.Context())).
+				Str("uri", c.Request.RequestURI).
+				Str("stack_error", string(debug.Stack())).
+				Msgf("http: unknown error: %v", err)
 
-					// _ = c.JSON(500, errors.NewWithMessage(errors.ErrInternalError, err.Error()))
-					c.JSON(500, gin.H{})
-				}
-			}()
-			return next(c)
-		}
+			apperror.Abort(c, apperror.ErrInternal.Wrap(err))
+		}()
+		c.Next()
 	}
 }
@@ -0,0 +1,70 @@
+package middleware
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"apigateway/pkg/apperror"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRecovery(t *testing.T) {
+	captureLog(t)
+	defer gin.SetMode(gin.Mode())
+
+	tests := []struct {
+		name      string
+		mode      string
+		panic     interface{}
+		wantCause string
+	}{
+		{name: "error", mode: gin.DebugMode, panic: errors.New("nil map"), wantCause: "nil map"},
+		{name: "value", mode: gin.DebugMode, panic: 42, wantCause: "42"},
+		// release mode 不回傳 panic 內容
+		{name: "release", mode: gin.ReleaseMode, panic: "secret"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			gin.SetMode(tt.mode)
+			router := gin.New()
+			router.Use(RequestID(), Recovery())
+			router.GET("/", func(c *gin.Context) { panic(tt.panic) })
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if rec.Code != http.StatusInternalServerError || rec.Header().Get("Content-Type") != apperror.ContentType {
+				t.Fatalf("response = %d %s", rec.Code, rec.Header().Get("Content-Type"))
+			}
+			var p apperror.Problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Code != "internal" || p.Cause != tt.wantCause {
+				t.Errorf("problem = %+v, want cause %q", p, tt.wantCause)
+			}
+			if p.RequestID == "" || p.RequestID != rec.Header().Get(HeaderXRequestID) {
+				t.Errorf("problem request id = %q, want %q", p.RequestID, rec.Header().Get(HeaderXRequestID))
+			}
+		})
+	}
+}
+
+// TestRecoveryAbortHandler http.ErrAbortHandler 交給 http server 中斷 connection
+func TestRecoveryAbortHandler(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(Recovery())
+	router.GET("/", func(c *gin.Context) { panic(http.ErrAbortHandler) })
+
+	defer func() {
+		if r := recover(); r != http.ErrAbortHandler {
+			t.Errorf("recovered %v, want http.ErrAbortHandler", r)
+		}
+	}()
+	router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+}
@@ -3,37 +3,59 @@ package middleware
 import (
 	"context"
 
+	"github.com/gin-gonic/gin"
 	"github.com/google/uuid"
-	"github.com/labstack/echo/v4"
 	"github.com/rs/zerolog/log"
 )
 
-// RequestIDFromContext ...
-func RequestIDFromContext(ctx context.Context) string {
-	value, ok := ctx.Value(echo.HeaderXRequestID).(string)
-	if !ok {
-		return uuid.New().String()
-	}
+// HeaderXRequestID request 與 response 都帶上同一個 request id, 也會轉送給 upstream
+const HeaderXRequestID = "X-Request-Id"
+
+// maxRequestIDLength client 帶來的 request id 超過此長度時改為產生新的
+const maxRequestIDLength = 128
 
+type requestIDKey struct{}
+
+// RequestIDFromContext 沒有 request id 時回傳空字串
+func RequestIDFromContext(ctx context.Context) string {
+	value, _ := ctx.Value(requestIDKey{}).(string)
 	return value
 }
 
-// RequestID 從 ctx 中取得 request id, 如果沒有即時產生一個
-func RequestID() echo.MiddlewareFunc {
-	return func(next echo.HandlerFunc) echo.HandlerFunc {
-		return func(c echo.Context) error {
-			requestID := RequestIDFromContext(c.Request().Context())
+// ContextWithRequestID 讓 RequestIDFromContext 取得相同的 request id
+func ContextWithRequestID(ctx context.Context, requestID string) context.Context {
+	return context.WithValue(ctx, requestIDKey{}, requestID)
+}
+
+// RequestID 沿用 client 帶來的 request id, 沒有或不合法時產生一個.
+// request 的 ctx 帶有 request id 與含 request_id 欄位的 logger, 可用 log.Ctx 取得
+func RequestID() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		requestID := c.GetHeader(HeaderXRequestID)
+		if !validRequestID(requestID) {
+			requestID = uuid.New().String()
+		}
+
+		c.Request.Header.Set(HeaderXRequestID, requestID)
+		c.Header(HeaderXRequestID, requestID)
 
-			c.Request().Header.Set(echo.HeaderXRequestID, requestID)
-			c.Response().Writer.Header().Set(echo.HeaderXRequestID, requestID)
+		zlog := log.With().Str("request_id", requestID).Logger()
+		ctx := ContextWithRequestID(zlog.WithContext(c.Request.Context()), requestID)
+		c.Request = c.Request.WithContext(ctx)
 
-			zlog := log.With().Str("request_id", requestID).Logger()
-			ctx := zlog.WithContext(c.Request().Context())
-			// ctx = errors.ContextWithXRequestID(ctx, requestID)
-			ctx = context.WithValue(ctx, echo.HeaderXRequestID, requestID)
-			zlog.WithContext(ctx)
+		c.Next()
+	}
+}
 
-			return next(c)
+// validRequestID 只接受可見的 ASCII 字元, 避免 log 與 header injection
+func validRequestID(id string) bool {
+	if id == "" || len(id) > maxRequestIDLength {
+		return false
+	}
+	for i := 0; i < len(id); i++ {
+		if id[i] <= ' ' || id[i] > '~' {
+			return false
 		}
 	}
+	return true
 }
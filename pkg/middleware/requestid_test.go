@@ -0,0 +1,71 @@
+package middleware
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog"
+	"github.com/rs/zerolog/log"
+)
+
+// captureLog 把全域 logger 改為寫入 buffer, 測試結束後還原
+func captureLog(t *testing.T) *bytes.Buffer {
+	t.Helper()
+	var buf bytes.Buffer
+	previous := log.Logger
+	log.Logger = zerolog.New(&buf)
+	t.Cleanup(func() { log.Logger = previous })
+	return &buf
+}
+
+func TestRequestID(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	buf := captureLog(t)
+	var fromContext, upstream string
+	router := gin.New()
+	router.Use(RequestID())
+	router.GET("/", func(c *gin.Context) {
+		fromContext = RequestIDFromContext(c.Request.Context())
+		upstream = c.Request.Header.Get(HeaderXRequestID)
+		zerolog.Ctx(c.Request.Context()).Info().Msg("handler")
+	})
+
+	tests := []struct {
+		name     string
+		clientID string
+		wantKeep bool
+	}{
+		{name: "client id", clientID: "req-1", wantKeep: true},
+		{name: "missing", clientID: ""},
+		{name: "too long", clientID: strings.Repeat("a", maxRequestIDLength+1)},
+		{name: "max length", clientID: strings.Repeat("a", maxRequestIDLength), wantKeep: true},
+		{name: "space", clientID: "req 1"},
+		{name: "non ascii", clientID: "請求"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			buf.Reset()
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.clientID != "" {
+				req.Header.Set(HeaderXRequestID, tt.clientID)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			got := rec.Header().Get(HeaderXRequestID)
+			if kept := got == tt.clientID; kept != tt.wantKeep {
+				t.Errorf("response request id = %q, client sent %q", got, tt.clientID)
+			}
+			if got == "" || fromContext != got || upstream != got {
+				t.Errorf("request id: response %q, context %q, forwarded %q", got, fromContext, upstream)
+			}
+			if !strings.Contains(buf.String(), `"request_id":"`+got+`"`) {
+				t.Errorf("context logger output %s, want request_id %s", buf.String(), got)
+			}
+		})
+	}
+}
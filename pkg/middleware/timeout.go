@@ -0,0 +1,116 @@
+package middleware
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// Timeouts 以 request 為單位套用 http.read_timeout 與 write_timeout, reload 時呼叫 Set, 從下一個 request 開始生效.
+// http.Server 的 ReadTimeout 與 WriteTimeout 必須維持 0, 否則 server 會覆蓋這裡設定的 deadline
+type Timeouts struct {
+	read  int64
+	write int64
+}
+
+type connKey struct{}
+
+type deadlineKey struct{}
+
+// deadline 單一 request 的 write deadline, 讓長時間的 stream 可以延長
+type deadline struct {
+	write time.Duration
+	// conn HTTP/1.x 直接設定連線的 deadline
+	conn net.Conn
+	// timer HTTP/2 到期時取消 request 的 ctx
+	timer *time.Timer
+}
+
+// NewTimeouts ...
+func NewTimeouts(read, write time.Duration) *Timeouts {
+	t := &Timeouts{}
+	t.Set(read, write)
+	return t
+}
+
+// Set 更新 timeout, 0 表示不限制
+func (t *Timeouts) Set(read, write time.Duration) {
+	atomic.StoreInt64(&t.read, int64(read))
+	atomic.StoreInt64(&t.write, int64(write))
+}
+
+// Get return read and write timeout in effect
+func (t *Timeouts) Get() (read, write time.Duration) {
+	return time.Duration(atomic.LoadInt64(&t.read)), time.Duration(atomic.LoadInt64(&t.write))
+}
+
+// ConnContext 設定為 http.Server.ConnContext, 讓 Handler 取得 request 所在的連線
+func (t *Timeouts) ConnContext(ctx context.Context, c net.Conn) context.Context {
+	return context.WithValue(ctx, connKey{}, c)
+}
+
+// Handler 需要放在最外層. HTTP/1.x 在 request 開始時設定連線的 deadline, 與 http.Server 的行為相同;
+// HTTP/2 的連線由多個 stream 共用, 改為 read timeout 到期時關閉 request body, write timeout 到期時取消 request 的 ctx
+func (t *Timeouts) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		read, write := t.Get()
+		d := &deadline{write: write}
+
+		if conn, ok := c.Request.Context().Value(connKey{}).(net.Conn); ok && c.Request.ProtoMajor == 1 {
+			d.conn = conn
+			now := time.Now()
+			_ = conn.SetReadDeadline(deadlineAfter(now, read))
+			_ = conn.SetWriteDeadline(deadlineAfter(now, write))
+		} else {
+			ctx, cancel := context.WithCancel(c.Request.Context())
+			defer cancel()
+			c.Request = c.Request.WithContext(ctx)
+
+			if read > 0 && c.Request.Body != nil {
+				body := c.Request.Body
+				timer := time.AfterFunc(read, func() {
+					_ = body.Close()
+				})
+				defer timer.Stop()
+			}
+			if write > 0 {
+				d.timer = time.AfterFunc(write, cancel)
+				defer d.timer.Stop()
+			}
+		}
+
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), deadlineKey{}, d))
+		c.Next()
+	}
+}
+
+// ExtendWriteDeadline 長時間的 stream (例如 SSE) 在每次寫入前呼叫, write_timeout 改為限制單次寫入而不是整個 request.
+// 寫入並 flush 後呼叫回傳的 func, 等待下一次寫入的期間不計時
+func ExtendWriteDeadline(req *http.Request) func() {
+	d, ok := req.Context().Value(deadlineKey{}).(*deadline)
+	if !ok || d.write <= 0 {
+		return func() {}
+	}
+
+	if d.conn != nil {
+		// 連線的 deadline 只在寫入時檢查, 不需要停止
+		_ = d.conn.SetWriteDeadline(time.Now().Add(d.write))
+		return func() {}
+	}
+	d.timer.Reset(d.write)
+	return func() {
+		d.timer.Stop()
+	}
+}
+
+// deadlineAfter timeout 為 0 時清除 deadline
+func deadlineAfter(now time.Time, timeout time.Duration) time.Time {
+	if timeout <= 0 {
+		return time.Time{}
+	}
+	return now.Add(timeout)
+}
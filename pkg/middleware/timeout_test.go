@@ -0,0 +1,92 @@
+package middleware
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestTimeoutsAppliedPerRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	timeouts := NewTimeouts(0, 50*time.Millisecond)
+
+	router := gin.New()
+	router.Use(timeouts.Handler())
+	router.GET("/slow", func(c *gin.Context) {
+		time.Sleep(150 * time.Millisecond)
+		c.String(http.StatusOK, "ok")
+	})
+
+	srv := httptest.NewUnstartedServer(router)
+	srv.Config.ConnContext = timeouts.ConnContext
+	srv.Start()
+	defer srv.Close()
+
+	get := func() error {
+		resp, err := http.Get(srv.URL + "/slow")
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+		_, err = ioutil.ReadAll(resp.Body)
+		return err
+	}
+
+	if err := get(); err == nil {
+		t.Fatal("request finished after write_timeout, want connection error")
+	}
+
+	// reload 後的 request 使用新的 timeout
+	timeouts.Set(0, time.Second)
+	if err := get(); err != nil {
+		t.Fatalf("request after Set: %v", err)
+	}
+}
+
+func TestTimeoutsWithoutConnCancelContext(t *testing.T) {
+	timeouts := NewTimeouts(0, 50*time.Millisecond)
+
+	tests := []struct {
+		name   string
+		extend bool
+		// idle 每次寫入完成後停止計時
+		idle bool
+		// wantDone 100ms 後 ctx 是否已取消
+		wantDone bool
+	}{
+		{name: "write timeout cancels", wantDone: true},
+		{name: "extended before timeout", extend: true, wantDone: false},
+		{name: "idle after write", extend: true, idle: true, wantDone: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var done bool
+			router := gin.New()
+			router.Use(timeouts.Handler())
+			router.GET("/", func(c *gin.Context) {
+				for i := 0; i < 4; i++ {
+					time.Sleep(25 * time.Millisecond)
+					if !tt.extend {
+						continue
+					}
+					written := ExtendWriteDeadline(c.Request)
+					if tt.idle {
+						written()
+						time.Sleep(50 * time.Millisecond)
+					}
+				}
+				done = c.Request.Context().Err() != nil
+			})
+
+			// httptest.NewRecorder 沒有連線, 與 HTTP/2 相同使用 timer
+			router.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+			if done != tt.wantDone {
+				t.Errorf("ctx done = %v, want %v", done, tt.wantDone)
+			}
+		})
+	}
+}
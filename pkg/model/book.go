@@ -1,7 +1,9 @@
 package model
 
-// Book ...
+// Book isbn 與 slug 欄位由 migrations/001_books_add_isbn_slug.sql 新增
 type Book struct {
 	ID   int    `json:"id"`
 	Name string `json:"name"`
+	ISBN string `json:"isbn"`
+	Slug string `json:"slug"`
 }
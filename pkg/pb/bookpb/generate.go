@@ -0,0 +1,6 @@
+// Package bookpb book.proto 產生的 message 與 gRPC stub.
+// book.protoset 供 proxy 的 transcodings 使用.
+package bookpb
+
+//go:generate protoc --go_out=plugins=grpc,paths=source_relative:. book.proto
+//go:generate protoc --include_imports --descriptor_set_out=book.protoset book.proto
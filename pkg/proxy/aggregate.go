@@ -0,0 +1,249 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// errorsKey partial response 中記錄失敗 branch 的欄位
+const errorsKey = "_errors"
+
+var paramRegexp = regexp.MustCompile(`\{([^{}/]+)\}`)
+
+type aggregate struct {
+	cfg      *AggregateConfig
+	segments []string
+	branches []*branch
+}
+
+type branch struct {
+	cfg      *BranchConfig
+	route    *route
+	mappings []mapping
+}
+
+type mapping struct {
+	from, to []string
+}
+
+func newAggregate(cfg *AggregateConfig, newBranchRoute func(*BranchConfig) (*route, error)) (*aggregate, error) {
+	a := &aggregate{
+		cfg:      cfg,
+		segments: strings.Split(strings.Trim(cfg.Path, "/"), "/"),
+	}
+
+	names := map[string]bool{}
+	for _, bc := range cfg.Branches {
+		if names[bc.Name] {
+			return nil, fmt.Errorf("aggregation %s: branch %s is defined more than once", cfg.Name, bc.Name)
+		}
+		names[bc.Name] = true
+
+		r, err := newBranchRoute(bc)
+		if err != nil {
+			return nil, fmt.Errorf("aggregation %s: %v", cfg.Name, err)
+		}
+		b := &branch{cfg: bc, route: r}
+
+		mappings := bc.Mappings
+		if len(mappings) == 0 {
+			mappings = []*MappingConfig{{To: "/" + bc.Name}}
+		}
+		for _, mc := range mappings {
+			from, err := parsePointer(mc.From)
+			if err != nil {
+				return nil, fmt.Errorf("aggregation %s: branch %s: %v", cfg.Name, bc.Name, err)
+			}
+			to, err := parsePointer(mc.To)
+			if err != nil {
+				return nil, fmt.Errorf("aggregation %s: branch %s: %v", cfg.Name, bc.Name, err)
+			}
+			b.mappings = append(b.mappings, mapping{from: from, to: to})
+		}
+		a.branches = append(a.branches, b)
+	}
+	return a, nil
+}
+
+// match GET 且 path 符合時回傳 path 參數
+func (a *aggregate) match(req *http.Request) (map[string]string, bool) {
+	if req.Method != http.MethodGet {
+		return nil, false
+	}
+	if a.cfg.Host != "" && !matchHost(a.cfg.Host, req.Host) {
+		return nil, false
+	}
+	return matchTemplate(a.segments, req.URL.Path)
+}
+
+// matchTemplate segments 中的 {name} 符合任一個 path segment
+func matchTemplate(segments []string, path string) (map[string]string, bool) {
+	parts := strings.Split(strings.Trim(path, "/"), "/")
+	if len(parts) != len(segments) {
+		return nil, false
+	}
+	params := map[string]string{}
+	for i, s := range segments {
+		if strings.HasPrefix(s, "{") && strings.HasSuffix(s, "}") {
+			params[s[1:len(s)-1]] = parts[i]
+			continue
+		}
+		if s != parts[i] {
+			return nil, false
+		}
+	}
+	return params, true
+}
+
+type branchResult struct {
+	doc interface{}
+	err error
+}
+
+func (a *aggregate) serve(w http.ResponseWriter, req *http.Request, params map[string]string) {
+	ctx := req.Context()
+	if a.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, a.cfg.Timeout)
+		defer cancel()
+	}
+
+	results := make([]branchResult, len(a.branches))
+	var wg sync.WaitGroup
+	for i, b := range a.branches {
+		wg.Add(1)
+		go func(i int, b *branch) {
+			defer wg.Done()
+			results[i].doc, results[i].err = b.call(ctx, req, params)
+		}(i, b)
+	}
+	wg.Wait()
+
+	var doc interface{} = map[string]interface{}{}
+	failed := map[string]interface{}{}
+	for i, b := range a.branches {
+		res := results[i]
+		if res.err == nil {
+			for _, m := range b.mappings {
+				value, ok := pointerGet(res.doc, m.from)
+				if !ok {
+					continue
+				}
+				var err error
+				if doc, err = pointerSet(doc, m.to, value); err != nil {
+					res.err = fmt.Errorf("mapping: %v", err)
+					break
+				}
+			}
+		}
+		if res.err == nil {
+			continue
+		}
+
+		log.Error().Str("aggregation", a.cfg.Name).Str("branch", b.cfg.Name).Msgf("aggregate: %v", res.err)
+		if a.cfg.OnError != OnErrorPartial {
+			status := http.StatusBadGateway
+			if errors.Is(res.err, context.DeadlineExceeded) {
+				status = http.StatusGatewayTimeout
+			}
+			writeError(w, status)
+			return
+		}
+		failed[b.cfg.Name] = res.err.Error()
+	}
+
+	if len(failed) > 0 {
+		if m, ok := doc.(map[string]interface{}); ok {
+			m[errorsKey] = failed
+		}
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		log.Error().Str("aggregation", a.cfg.Name).Msgf("aggregate: %v", err)
+		writeError(w, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// call 以原本 request 的 header 呼叫 branch, 只接受 2xx 的 JSON
+func (b *branch) call(ctx context.Context, req *http.Request, params map[string]string) (interface{}, error) {
+	path := paramRegexp.ReplaceAllStringFunc(b.cfg.Path, func(s string) string {
+		name := s[1 : len(s)-1]
+		if v, ok := params[name]; ok {
+			return url.PathEscape(v)
+		}
+		return url.QueryEscape(req.URL.Query().Get(name))
+	})
+
+	breq, err := http.NewRequestWithContext(ctx, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	breq.Header = req.Header.Clone()
+	// 需要解析 body, 不接受壓縮過的內容
+	breq.Header.Del("Accept-Encoding")
+	breq.Header.Set("Accept", "application/json")
+	breq.Host = req.Host
+	breq.RemoteAddr = req.RemoteAddr
+
+	start := time.Now()
+	bw := &bufferWriter{header: http.Header{}}
+	b.route.ServeHTTP(bw, breq)
+
+	if ctx.Err() != nil {
+		return nil, ctx.Err()
+	}
+	if bw.status < 200 || bw.status > 299 {
+		if bw.status == http.StatusGatewayTimeout {
+			return nil, fmt.Errorf("upstream timed out after %v: %w", time.Since(start).Round(time.Millisecond), context.DeadlineExceeded)
+		}
+		return nil, fmt.Errorf("upstream status %d", bw.status)
+	}
+
+	var doc interface{}
+	dec := json.NewDecoder(&bw.body)
+	dec.UseNumber()
+	if err := dec.Decode(&doc); err != nil {
+		return nil, fmt.Errorf("invalid json: %v", err)
+	}
+	return doc, nil
+}
+
+// bufferWriter 保存 branch 的 response
+type bufferWriter struct {
+	status int
+	header http.Header
+	body   bytes.Buffer
+}
+
+func (w *bufferWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *bufferWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
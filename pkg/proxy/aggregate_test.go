@@ -0,0 +1,201 @@
+package proxy
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"apigateway/pkg/ratelimit"
+
+	"go.uber.org/fx"
+)
+
+// testLifecycle 只記錄 hook, 測試結束時由 Table.Close 清理
+type testLifecycle struct {
+	hooks []fx.Hook
+}
+
+func (l *testLifecycle) Append(h fx.Hook) {
+	l.hooks = append(l.hooks, h)
+}
+
+// jsonUpstream 回傳固定的 JSON, delay 大於 0 時先等待
+func jsonUpstream(t *testing.T, status int, body string, delay time.Duration) string {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if delay > 0 {
+			select {
+			case <-time.After(delay):
+			case <-req.Context().Done():
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(status)
+		_, _ = w.Write([]byte(body))
+	}))
+	t.Cleanup(srv.Close)
+	return srv.URL
+}
+
+func TestAggregateServe(t *testing.T) {
+	book := `{"id": 1, "name": "go"}`
+	review := `{"stars": 5}`
+
+	tests := []struct {
+		name       string
+		onError    string
+		timeout    time.Duration
+		branches   func(t *testing.T) []*BranchConfig
+		wantStatus int
+		want       string
+		// wantErrors partial response 中應該失敗的 branch
+		wantErrors []string
+	}{
+		{
+			name: "default mappings",
+			branches: func(t *testing.T) []*BranchConfig {
+				return []*BranchConfig{
+					{Name: "book", Upstream: jsonUpstream(t, 200, book, 0), Path: "/books/{id}"},
+					{Name: "review", Upstream: jsonUpstream(t, 200, review, 0), Path: "/reviews/{id}"},
+				}
+			},
+			wantStatus: http.StatusOK,
+			want:       `{"book": {"id": 1, "name": "go"}, "review": {"stars": 5}}`,
+		},
+		{
+			name: "append to array",
+			branches: func(t *testing.T) []*BranchConfig {
+				return []*BranchConfig{
+					{Name: "a", Upstream: jsonUpstream(t, 200, book, 0), Path: "/a", Mappings: []*MappingConfig{{From: "/name", To: "/names/-"}}},
+					{Name: "b", Upstream: jsonUpstream(t, 200, book, 0), Path: "/b", Mappings: []*MappingConfig{{From: "/id", To: "/names/-"}}},
+				}
+			},
+			wantStatus: http.StatusOK,
+			// mappings 依 branch 的順序套用, 與完成的順序無關
+			want: `{"names": ["go", 1]}`,
+		},
+		{
+			name:    "fail on branch error",
+			onError: OnErrorFail,
+			branches: func(t *testing.T) []*BranchConfig {
+				return []*BranchConfig{
+					{Name: "book", Upstream: jsonUpstream(t, 200, book, 0), Path: "/books/{id}"},
+					{Name: "review", Upstream: jsonUpstream(t, 500, `{}`, 0), Path: "/reviews/{id}"},
+				}
+			},
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:    "partial on branch error",
+			onError: OnErrorPartial,
+			branches: func(t *testing.T) []*BranchConfig {
+				return []*BranchConfig{
+					{Name: "book", Upstream: jsonUpstream(t, 200, book, 0), Path: "/books/{id}"},
+					{Name: "review", Upstream: jsonUpstream(t, 500, `{}`, 0), Path: "/reviews/{id}"},
+				}
+			},
+			wantStatus: http.StatusOK,
+			want:       `{"book": {"id": 1, "name": "go"}}`,
+			wantErrors: []string{"review"},
+		},
+		{
+			name:    "invalid json fails",
+			onError: OnErrorFail,
+			branches: func(t *testing.T) []*BranchConfig {
+				return []*BranchConfig{
+					{Name: "book", Upstream: jsonUpstream(t, 200, `not json`, 0), Path: "/books/{id}"},
+				}
+			},
+			wantStatus: http.StatusBadGateway,
+		},
+		{
+			name:    "branch timeout",
+			onError: OnErrorFail,
+			branches: func(t *testing.T) []*BranchConfig {
+				return []*BranchConfig{
+					{Name: "book", Upstream: jsonUpstream(t, 200, book, 0), Path: "/books/{id}"},
+					{Name: "review", Upstream: jsonUpstream(t, 200, review, time.Second), Path: "/reviews/{id}", Timeout: 50 * time.Millisecond},
+				}
+			},
+			wantStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:    "aggregate timeout",
+			onError: OnErrorFail,
+			timeout: 50 * time.Millisecond,
+			branches: func(t *testing.T) []*BranchConfig {
+				return []*BranchConfig{
+					{Name: "review", Upstream: jsonUpstream(t, 200, review, time.Second), Path: "/reviews/{id}"},
+				}
+			},
+			wantStatus: http.StatusGatewayTimeout,
+		},
+		{
+			name:    "partial on timeout",
+			onError: OnErrorPartial,
+			branches: func(t *testing.T) []*BranchConfig {
+				return []*BranchConfig{
+					{Name: "book", Upstream: jsonUpstream(t, 200, book, 0), Path: "/books/{id}"},
+					{Name: "review", Upstream: jsonUpstream(t, 200, review, time.Second), Path: "/reviews/{id}", Timeout: 50 * time.Millisecond},
+				}
+			},
+			wantStatus: http.StatusOK,
+			want:       `{"book": {"id": 1, "name": "go"}}`,
+			wantErrors: []string{"review"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &AggregateConfig{
+				Name:     "composite",
+				Path:     "/composite/books/{id}",
+				OnError:  tt.onError,
+				Timeout:  tt.timeout,
+				Branches: tt.branches(t),
+			}
+			table, err := NewTable(&testLifecycle{}, nil, nil, Aggregations{cfg}, nil, nil, ratelimit.NewLimiter(ratelimit.NewMemoryStore()), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			t.Cleanup(table.Close)
+
+			req := httptest.NewRequest(http.MethodGet, "/composite/books/1", nil)
+			a, params := table.matchAggregate(req)
+			if a == nil {
+				t.Fatal("aggregation did not match")
+			}
+			rec := httptest.NewRecorder()
+			a.serve(rec, req, params)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d, body %s", rec.Code, tt.wantStatus, rec.Body)
+			}
+			if rec.Code != http.StatusOK {
+				return
+			}
+
+			var got map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatalf("invalid json %s: %v", rec.Body, err)
+			}
+			failed, _ := got[errorsKey].(map[string]interface{})
+			delete(got, errorsKey)
+
+			var names []string
+			for name := range failed {
+				names = append(names, name)
+			}
+			if !reflect.DeepEqual(names, tt.wantErrors) {
+				t.Errorf("%s = %v, want %v", errorsKey, failed, tt.wantErrors)
+			}
+
+			if !reflect.DeepEqual(got, decodeJSON(t, tt.want)) {
+				t.Errorf("body = %v, want %s", got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,171 @@
+package proxy
+
+import (
+	"fmt"
+	"hash/crc32"
+	"math/rand"
+	"net/http"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+
+	"apigateway/pkg/clientip"
+)
+
+// balancer 從 healthy targets 中挑出一個, targets 不會是空的
+type balancer interface {
+	next(req *http.Request, targets []*Target) *Target
+}
+
+func newBalancer(cfg *BalancerConfig, targets []*Target) (balancer, error) {
+	if cfg == nil {
+		return &roundRobin{}, nil
+	}
+
+	switch cfg.Policy {
+	case "", RoundRobin:
+		return &roundRobin{}, nil
+	case WeightedRoundRobin:
+		return &weightedRoundRobin{current: map[*Target]int{}}, nil
+	case LeastConnections:
+		return leastConnections{}, nil
+	case RandomTwoChoices:
+		return randomTwoChoices{}, nil
+	case ConsistentHash:
+		return newConsistentHash(cfg, targets)
+	default:
+		return nil, fmt.Errorf("unknown balancer policy %q", cfg.Policy)
+	}
+}
+
+type roundRobin struct {
+	counter uint64
+}
+
+func (b *roundRobin) next(_ *http.Request, targets []*Target) *Target {
+	n := atomic.AddUint64(&b.counter, 1)
+	return targets[(n-1)%uint64(len(targets))]
+}
+
+// weightedRoundRobin smooth weighted round-robin (nginx)
+type weightedRoundRobin struct {
+	mu      sync.Mutex
+	current map[*Target]int
+}
+
+func (b *weightedRoundRobin) next(_ *http.Request, targets []*Target) *Target {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	total := 0
+	var best *Target
+	for _, t := range targets {
+		b.current[t] += t.Weight
+		total += t.Weight
+		if best == nil || b.current[t] > b.current[best] {
+			best = t
+		}
+	}
+	b.current[best] -= total
+	return best
+}
+
+type leastConnections struct{}
+
+func (leastConnections) next(_ *http.Request, targets []*Target) *Target {
+	// 從隨機位置開始, 避免連線數相同時總是選到第一個
+	offset := rand.Intn(len(targets))
+	best := targets[offset]
+	for i := 1; i < len(targets); i++ {
+		t := targets[(offset+i)%len(targets)]
+		if t.Active() < best.Active() {
+			best = t
+		}
+	}
+	return best
+}
+
+type randomTwoChoices struct{}
+
+func (randomTwoChoices) next(_ *http.Request, targets []*Target) *Target {
+	if len(targets) == 1 {
+		return targets[0]
+	}
+	i := rand.Intn(len(targets))
+	j := rand.Intn(len(targets) - 1)
+	if j >= i {
+		j++
+	}
+	if targets[j].Active() < targets[i].Active() {
+		return targets[j]
+	}
+	return targets[i]
+}
+
+// virtualNodes 每單位 weight 在 hash ring 上的節點數
+const virtualNodes = 100
+
+type ringNode struct {
+	hash   uint32
+	target *Target
+}
+
+// consistentHash ring 包含所有 target, 落在不在 targets 中的節點時順時針找下一個
+type consistentHash struct {
+	hashOn  string
+	hashKey string
+	ring    []ringNode
+}
+
+func newConsistentHash(cfg *BalancerConfig, targets []*Target) (*consistentHash, error) {
+	b := &consistentHash{hashOn: cfg.HashOn, hashKey: cfg.HashKey}
+	if b.hashOn == "" {
+		b.hashOn = HashOnClientIP
+	}
+	if b.hashOn != HashOnClientIP && b.hashKey == "" {
+		return nil, fmt.Errorf("hash_key is required when hash_on is %s", b.hashOn)
+	}
+
+	for _, t := range targets {
+		for i := 0; i < virtualNodes*t.Weight; i++ {
+			h := crc32.ChecksumIEEE([]byte(t.URL.String() + "#" + strconv.Itoa(i)))
+			b.ring = append(b.ring, ringNode{hash: h, target: t})
+		}
+	}
+	sort.Slice(b.ring, func(i, j int) bool { return b.ring[i].hash < b.ring[j].hash })
+
+	return b, nil
+}
+
+func (b *consistentHash) next(req *http.Request, targets []*Target) *Target {
+	key := b.key(req)
+	if key == "" {
+		// 沒有 key 時退回隨機
+		return targets[rand.Intn(len(targets))]
+	}
+
+	h := crc32.ChecksumIEEE([]byte(key))
+	start := sort.Search(len(b.ring), func(i int) bool { return b.ring[i].hash >= h })
+	for i := 0; i < len(b.ring); i++ {
+		node := b.ring[(start+i)%len(b.ring)]
+		if containsTarget(targets, node.target) {
+			return node.target
+		}
+	}
+	return targets[0]
+}
+
+func (b *consistentHash) key(req *http.Request) string {
+	switch b.hashOn {
+	case HashOnHeader:
+		return req.Header.Get(b.hashKey)
+	case HashOnCookie:
+		if c, err := req.Cookie(b.hashKey); err == nil {
+			return c.Value
+		}
+		return ""
+	default:
+		return clientip.FromRequest(req)
+	}
+}
@@ -0,0 +1,169 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"testing"
+)
+
+func testTargets(weights ...int) []*Target {
+	targets := make([]*Target, 0, len(weights))
+	for i, w := range weights {
+		u, _ := url.Parse("http://10.0.0." + strconv.Itoa(i+1) + ":80")
+		targets = append(targets, &Target{URL: u, Weight: w, healthy: 1})
+	}
+	return targets
+}
+
+func TestBalancerDistribution(t *testing.T) {
+	tests := []struct {
+		name    string
+		cfg     *BalancerConfig
+		weights []int
+		picks   int
+		want    []int
+	}{
+		{name: "default round robin", weights: []int{1, 1, 1}, picks: 6, want: []int{2, 2, 2}},
+		{name: "round robin ignores weight", cfg: &BalancerConfig{Policy: RoundRobin}, weights: []int{5, 1}, picks: 4, want: []int{2, 2}},
+		{name: "weighted round robin", cfg: &BalancerConfig{Policy: WeightedRoundRobin}, weights: []int{3, 1}, picks: 8, want: []int{6, 2}},
+		{name: "weighted round robin three targets", cfg: &BalancerConfig{Policy: WeightedRoundRobin}, weights: []int{2, 1, 1}, picks: 4, want: []int{2, 1, 1}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets := testTargets(tt.weights...)
+			b, err := newBalancer(tt.cfg, targets)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			got := make([]int, len(targets))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for i := 0; i < tt.picks; i++ {
+				picked := b.next(req, targets)
+				for j, target := range targets {
+					if target == picked {
+						got[j]++
+					}
+				}
+			}
+			for i := range got {
+				if got[i] != tt.want[i] {
+					t.Fatalf("picks = %v, want %v", got, tt.want)
+				}
+			}
+		})
+	}
+}
+
+func TestBalancerPrefersIdleTargets(t *testing.T) {
+	for _, policy := range []string{LeastConnections, RandomTwoChoices} {
+		t.Run(policy, func(t *testing.T) {
+			targets := testTargets(1, 1)
+			targets[0].acquire()
+			targets[0].acquire()
+
+			b, err := newBalancer(&BalancerConfig{Policy: policy}, targets)
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			for i := 0; i < 10; i++ {
+				if got := b.next(req, targets); got != targets[1] {
+					t.Fatalf("picked %s, want the target without active requests", got.URL)
+				}
+			}
+		})
+	}
+}
+
+func TestConsistentHash(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  *BalancerConfig
+		set  func(req *http.Request, key string)
+	}{
+		{
+			name: "header",
+			cfg:  &BalancerConfig{Policy: ConsistentHash, HashOn: HashOnHeader, HashKey: "X-User"},
+			set:  func(req *http.Request, key string) { req.Header.Set("X-User", key) },
+		},
+		{
+			name: "cookie",
+			cfg:  &BalancerConfig{Policy: ConsistentHash, HashOn: HashOnCookie, HashKey: "session"},
+			set:  func(req *http.Request, key string) { req.AddCookie(&http.Cookie{Name: "session", Value: key}) },
+		},
+		{
+			name: "client ip",
+			cfg:  &BalancerConfig{Policy: ConsistentHash},
+			set:  func(req *http.Request, key string) { req.RemoteAddr = "192.0.2." + key + ":1234" },
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			targets := testTargets(1, 1, 1)
+			b, err := newBalancer(tt.cfg, targets)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for i := 0; i < 20; i++ {
+				key := strconv.Itoa(i)
+				req := httptest.NewRequest(http.MethodGet, "/", nil)
+				tt.set(req, key)
+
+				first := b.next(req, targets)
+				if again := b.next(req, targets); again != first {
+					t.Fatalf("key %s moved from %s to %s", key, first.URL, again.URL)
+				}
+
+				// 移除其他 target 時 key 不會移動
+				other := targets[(indexOf(targets, first)+1)%len(targets)]
+				if b.next(req, []*Target{first, other}) != first {
+					t.Fatalf("key %s moved although its target is still healthy", key)
+				}
+			}
+		})
+	}
+}
+
+func TestConsistentHashRequiresKey(t *testing.T) {
+	_, err := newBalancer(&BalancerConfig{Policy: ConsistentHash, HashOn: HashOnHeader}, testTargets(1))
+	if err == nil {
+		t.Fatal("hash_on header without hash_key should fail")
+	}
+}
+
+func TestPoolPick(t *testing.T) {
+	p, err := newPool("test", &UpstreamConfig{Targets: []*TargetConfig{{URL: "http://10.0.0.1"}, {URL: "http://10.0.0.2"}}}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := p.targets[0], p.targets[1]
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+
+	if got := p.Pick(req, a); got != b {
+		t.Errorf("Pick(exclude a) = %s, want b", got.URL)
+	}
+
+	// 只剩被排除的 target 時仍然選它
+	b.healthy = 0
+	if got := p.Pick(req, a); got != a {
+		t.Errorf("Pick(exclude a) with b unhealthy = %v, want a", got)
+	}
+
+	a.healthy = 0
+	if got := p.Pick(req); got != nil {
+		t.Errorf("Pick() with no healthy target = %s, want nil", got.URL)
+	}
+}
+
+func indexOf(targets []*Target, t *Target) int {
+	for i, x := range targets {
+		if x == t {
+			return i
+		}
+	}
+	return -1
+}
@@ -0,0 +1,272 @@
+package proxy
+
+import (
+	"expvar"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"apigateway/pkg/metrics"
+
+	"github.com/rs/zerolog/log"
+)
+
+// circuit breaker defaults
+const (
+	defaultBreakerWindow      = 10 * time.Second
+	defaultBreakerMinRequests = 20
+	defaultBreakerOpenTimeout = 30 * time.Second
+	breakerBuckets            = 10
+)
+
+// BreakerConfig 在 rolling window 內錯誤率或連續失敗次數超過門檻時 trip
+type BreakerConfig struct {
+	Window time.Duration `validate:"min=0"`
+	// MinRequests window 內至少幾個 request 才計算錯誤率
+	MinRequests int `yaml:"min_requests" mapstructure:"min_requests" validate:"min=0"`
+	// ErrorRate 0 表示不以錯誤率 trip
+	ErrorRate float64 `yaml:"error_rate" mapstructure:"error_rate" validate:"min=0,max=1"`
+	// ConsecutiveFailures 0 表示不以連續失敗 trip
+	ConsecutiveFailures int `yaml:"consecutive_failures" mapstructure:"consecutive_failures" validate:"min=0"`
+	// OpenTimeout open 多久之後進入 half-open
+	OpenTimeout time.Duration `yaml:"open_timeout" mapstructure:"open_timeout" validate:"min=0"`
+	// HalfOpenRequests half-open 時允許的試探 request 數, 全部成功才會 close
+	HalfOpenRequests int `yaml:"half_open_requests" mapstructure:"half_open_requests" validate:"min=0"`
+}
+
+type breakerState int
+
+const (
+	stateClosed breakerState = iota
+	stateOpen
+	stateHalfOpen
+)
+
+func (s breakerState) String() string {
+	switch s {
+	case stateOpen:
+		return "open"
+	case stateHalfOpen:
+		return "half-open"
+	default:
+		return "closed"
+	}
+}
+
+type bucket struct {
+	start    time.Time
+	success  int
+	failures int
+}
+
+// Breaker closed -> open -> half-open -> closed
+type Breaker struct {
+	name string
+	cfg  BreakerConfig
+
+	mu          sync.Mutex
+	state       breakerState
+	generation  uint64
+	openedAt    time.Time
+	buckets     [breakerBuckets]bucket
+	consecutive int
+	trials      int
+	trialOK     int
+}
+
+// circuitOpenError 回 503 與 Retry-After
+type circuitOpenError struct {
+	name       string
+	retryAfter time.Duration
+}
+
+func (e *circuitOpenError) Error() string {
+	return fmt.Sprintf("circuit breaker %s is open", e.name)
+}
+
+func newBreaker(name string, cfg *BreakerConfig) *Breaker {
+	if cfg == nil {
+		return nil
+	}
+
+	b := &Breaker{name: name, cfg: *cfg}
+	if b.cfg.Window <= 0 {
+		b.cfg.Window = defaultBreakerWindow
+	}
+	if b.cfg.MinRequests <= 0 {
+		b.cfg.MinRequests = defaultBreakerMinRequests
+	}
+	if b.cfg.OpenTimeout <= 0 {
+		b.cfg.OpenTimeout = defaultBreakerOpenTimeout
+	}
+	if b.cfg.HalfOpenRequests <= 0 {
+		b.cfg.HalfOpenRequests = 1
+	}
+	metrics.CircuitState.Set(name, metricState(stateClosed))
+	return b
+}
+
+// allow 回傳 ticket 給 record 使用, 狀態改變後舊的 ticket 會被忽略
+// nil breaker 一律允許
+func (b *Breaker) allow() (uint64, error) {
+	if b == nil {
+		return 0, nil
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	switch b.state {
+	case stateOpen:
+		elapsed := now.Sub(b.openedAt)
+		if elapsed < b.cfg.OpenTimeout {
+			return 0, &circuitOpenError{name: b.name, retryAfter: b.cfg.OpenTimeout - elapsed}
+		}
+		b.transition(stateHalfOpen, now)
+		fallthrough
+	case stateHalfOpen:
+		if b.trials >= b.cfg.HalfOpenRequests {
+			return 0, &circuitOpenError{name: b.name, retryAfter: time.Second}
+		}
+		b.trials++
+	}
+	return b.generation, nil
+}
+
+// record success 為 false 時代表連線錯誤或 5xx
+func (b *Breaker) record(ticket uint64, success bool) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ticket != b.generation {
+		return
+	}
+
+	now := time.Now()
+	switch b.state {
+	case stateHalfOpen:
+		if !success {
+			b.transition(stateOpen, now)
+			return
+		}
+		b.trialOK++
+		if b.trialOK >= b.cfg.HalfOpenRequests {
+			b.transition(stateClosed, now)
+		}
+	case stateClosed:
+		bk := b.bucket(now)
+		if success {
+			bk.success++
+			b.consecutive = 0
+			return
+		}
+		bk.failures++
+		b.consecutive++
+
+		if b.cfg.ConsecutiveFailures > 0 && b.consecutive >= b.cfg.ConsecutiveFailures {
+			b.transition(stateOpen, now)
+			return
+		}
+		if b.cfg.ErrorRate > 0 {
+			total, failures := b.counts(now)
+			if total >= b.cfg.MinRequests && float64(failures)/float64(total) >= b.cfg.ErrorRate {
+				b.transition(stateOpen, now)
+			}
+		}
+	}
+}
+
+// cancel 歸還沒有實際送出的 request 佔用的 half-open 名額
+func (b *Breaker) cancel(ticket uint64) {
+	if b == nil {
+		return
+	}
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if ticket == b.generation && b.state == stateHalfOpen && b.trials > 0 {
+		b.trials--
+	}
+}
+
+// State ...
+func (b *Breaker) State() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.state.String()
+}
+
+func (b *Breaker) transition(to breakerState, now time.Time) {
+	from := b.state
+	b.state = to
+	b.generation++
+	b.trials, b.trialOK, b.consecutive = 0, 0, 0
+
+	switch to {
+	case stateOpen:
+		b.openedAt = now
+	case stateClosed:
+		b.buckets = [breakerBuckets]bucket{}
+	}
+
+	metrics.CircuitState.Set(b.name, metricState(to))
+	metrics.CircuitTransitions.Add(fmt.Sprintf("%s:%s->%s", b.name, from, to), 1)
+
+	event := log.Info()
+	if to == stateOpen {
+		event = log.Warn()
+	}
+	event.Str("breaker", b.name).Str("from", from.String()).Str("to", to.String()).Msg("circuit breaker state changed")
+}
+
+// bucket 取得 now 所在的 bucket, 過期的 bucket 重設
+func (b *Breaker) bucket(now time.Time) *bucket {
+	width := b.cfg.Window / breakerBuckets
+	start := now.Truncate(width)
+	bk := &b.buckets[(start.UnixNano()/int64(width))%breakerBuckets]
+	if !bk.start.Equal(start) {
+		*bk = bucket{start: start}
+	}
+	return bk
+}
+
+func (b *Breaker) counts(now time.Time) (total, failures int) {
+	for _, bk := range b.buckets {
+		if now.Sub(bk.start) < b.cfg.Window {
+			total += bk.success + bk.failures
+			failures += bk.failures
+		}
+	}
+	return total, failures
+}
+
+// unregisterBreakers 移除已不存在的 breaker 的 metrics, 名稱沿用的 breaker 保留
+func unregisterBreakers(old, current map[string]bool) {
+	for name := range old {
+		if current[name] {
+			continue
+		}
+		metrics.CircuitState.Delete(name)
+
+		var keys []string
+		metrics.CircuitTransitions.Do(func(kv expvar.KeyValue) {
+			// key 為 "<name>:<from>-><to>", 狀態名稱不含 ':'
+			if rest := strings.TrimPrefix(kv.Key, name+":"); rest != kv.Key && !strings.Contains(rest, ":") {
+				keys = append(keys, kv.Key)
+			}
+		})
+		for _, key := range keys {
+			metrics.CircuitTransitions.Delete(key)
+		}
+	}
+}
+
+func metricState(s breakerState) *expvar.Int {
+	v := new(expvar.Int)
+	v.Set(int64(s))
+	return v
+}
@@ -0,0 +1,219 @@
+package proxy
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"apigateway/pkg/metrics"
+	"apigateway/pkg/ratelimit"
+)
+
+// step 一次 allow 與 record, ok 為 nil 時只呼叫 allow
+type step struct {
+	ok        *bool
+	wantAllow bool
+	wantState string
+}
+
+var (
+	recordSuccess = func() *bool { b := true; return &b }()
+	recordFailure = func() *bool { b := false; return &b }()
+)
+
+func TestBreakerTransitions(t *testing.T) {
+	tests := []struct {
+		name string
+		cfg  BreakerConfig
+		// expire open 狀態在此 step 之前超過 OpenTimeout
+		expire int
+		steps  []step
+	}{
+		{
+			name: "consecutive failures trip",
+			cfg:  BreakerConfig{ConsecutiveFailures: 2},
+			steps: []step{
+				{ok: recordFailure, wantAllow: true, wantState: "closed"},
+				{ok: recordFailure, wantAllow: true, wantState: "open"},
+				{wantAllow: false, wantState: "open"},
+			},
+		},
+		{
+			name: "success resets consecutive failures",
+			cfg:  BreakerConfig{ConsecutiveFailures: 2},
+			steps: []step{
+				{ok: recordFailure, wantAllow: true, wantState: "closed"},
+				{ok: recordSuccess, wantAllow: true, wantState: "closed"},
+				{ok: recordFailure, wantAllow: true, wantState: "closed"},
+			},
+		},
+		{
+			name: "error rate needs min requests",
+			cfg:  BreakerConfig{ErrorRate: 0.5, MinRequests: 4},
+			steps: []step{
+				{ok: recordFailure, wantAllow: true, wantState: "closed"},
+				{ok: recordFailure, wantAllow: true, wantState: "closed"},
+				{ok: recordSuccess, wantAllow: true, wantState: "closed"},
+				{ok: recordFailure, wantAllow: true, wantState: "open"},
+			},
+		},
+		{
+			name:   "half-open success closes",
+			cfg:    BreakerConfig{ConsecutiveFailures: 1},
+			expire: 1,
+			steps: []step{
+				{ok: recordFailure, wantAllow: true, wantState: "open"},
+				{ok: recordSuccess, wantAllow: true, wantState: "closed"},
+				{ok: recordFailure, wantAllow: true, wantState: "open"},
+			},
+		},
+		{
+			name:   "half-open failure reopens",
+			cfg:    BreakerConfig{ConsecutiveFailures: 1},
+			expire: 1,
+			steps: []step{
+				{ok: recordFailure, wantAllow: true, wantState: "open"},
+				{ok: recordFailure, wantAllow: true, wantState: "open"},
+				{wantAllow: false, wantState: "open"},
+			},
+		},
+		{
+			name:   "half-open limits trial requests",
+			cfg:    BreakerConfig{ConsecutiveFailures: 1, HalfOpenRequests: 2},
+			expire: 1,
+			steps: []step{
+				{ok: recordFailure, wantAllow: true, wantState: "open"},
+				{wantAllow: true, wantState: "half-open"},
+				{wantAllow: true, wantState: "half-open"},
+				{wantAllow: false, wantState: "half-open"},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newBreaker("test_"+tt.name, &tt.cfg)
+			for i, s := range tt.steps {
+				if tt.expire > 0 && i == tt.expire {
+					b.mu.Lock()
+					b.openedAt = time.Now().Add(-b.cfg.OpenTimeout)
+					b.mu.Unlock()
+				}
+
+				ticket, err := b.allow()
+				if allowed := err == nil; allowed != s.wantAllow {
+					t.Fatalf("step %d: allow = %v, want %v", i, allowed, s.wantAllow)
+				}
+				if err == nil && s.ok != nil {
+					b.record(ticket, *s.ok)
+				}
+				if got := b.State(); got != s.wantState {
+					t.Fatalf("step %d: state = %s, want %s", i, got, s.wantState)
+				}
+			}
+		})
+	}
+}
+
+func TestBreakerIgnoresStaleTicket(t *testing.T) {
+	b := newBreaker("test_stale_ticket", &BreakerConfig{ConsecutiveFailures: 1})
+
+	stale, _ := b.allow()
+	ticket, _ := b.allow()
+	b.record(ticket, false)
+	if got := b.State(); got != "open" {
+		t.Fatalf("state = %s, want open", got)
+	}
+
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-b.cfg.OpenTimeout)
+	b.mu.Unlock()
+	trial, err := b.allow()
+	if err != nil {
+		t.Fatalf("half-open allow: %v", err)
+	}
+
+	// 在 open 之前取得的 ticket 不影響 half-open 的結果
+	b.record(stale, false)
+	if got := b.State(); got != "half-open" {
+		t.Fatalf("state after stale record = %s, want half-open", got)
+	}
+	b.record(trial, true)
+	if got := b.State(); got != "closed" {
+		t.Fatalf("state = %s, want closed", got)
+	}
+}
+
+func TestBreakerCancelReturnsTrial(t *testing.T) {
+	b := newBreaker("test_cancel", &BreakerConfig{ConsecutiveFailures: 1})
+	ticket, _ := b.allow()
+	b.record(ticket, false)
+
+	b.mu.Lock()
+	b.openedAt = time.Now().Add(-b.cfg.OpenTimeout)
+	b.mu.Unlock()
+
+	ticket, err := b.allow()
+	if err != nil {
+		t.Fatalf("half-open allow: %v", err)
+	}
+	if _, err := b.allow(); err == nil {
+		t.Fatal("second trial allowed, want circuit open")
+	}
+	b.cancel(ticket)
+	if _, err := b.allow(); err != nil {
+		t.Fatalf("allow after cancel: %v", err)
+	}
+}
+
+func TestTableUpdateKeepsBreaker(t *testing.T) {
+	routes := func(openTimeout time.Duration, extra ...*RouteConfig) Routes {
+		return append(Routes{{
+			Name:           "books",
+			PathPrefix:     "/books",
+			Upstream:       "http://127.0.0.1:1",
+			CircuitBreaker: &BreakerConfig{ConsecutiveFailures: 1, OpenTimeout: openTimeout},
+		}}, extra...)
+	}
+	table, err := NewTable(&testLifecycle{}, routes(time.Minute), nil, nil, nil, nil, ratelimit.NewLimiter(ratelimit.NewMemoryStore()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+
+	breaker := func() *Breaker {
+		return table.match(httptest.NewRequest(http.MethodGet, "/books", nil)).breaker
+	}
+	ticket, _ := breaker().allow()
+	breaker().record(ticket, false)
+
+	// 與 breaker 無關的變更不會重設狀態
+	other := &RouteConfig{Name: "reviews", PathPrefix: "/reviews", Upstream: "http://127.0.0.1:2"}
+	if err := table.Update(routes(time.Minute, other), nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := breaker().State(); got != "open" {
+		t.Fatalf("state after unrelated reload = %s, want open", got)
+	}
+
+	if err := table.Update(routes(time.Second, other), nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := breaker().State(); got != "closed" {
+		t.Fatalf("state after breaker config changed = %s, want closed", got)
+	}
+
+	if err := table.Update(Routes{other}, nil, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if v := metrics.CircuitState.Get("route:books"); v != nil {
+		t.Errorf("metrics of the removed route = %v, want removed", v)
+	}
+	metrics.CircuitTransitions.Do(func(kv expvar.KeyValue) {
+		if strings.HasPrefix(kv.Key, "route:books:") {
+			t.Errorf("transition metric %s of the removed route was kept", kv.Key)
+		}
+	})
+}
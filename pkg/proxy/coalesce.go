@@ -0,0 +1,242 @@
+package proxy
+
+import (
+	"bytes"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+
+	"apigateway/pkg/metrics"
+)
+
+// defaultCoalesceMaxBytes 超過此大小的 response 不分享給其他 request
+const defaultCoalesceMaxBytes = 1 << 20
+
+// CoalesceConfig 相同的 GET 與 HEAD 同時只送一個到 upstream, 其他 request 等待並共用 response
+type CoalesceConfig struct {
+	// Vary 加入比對的 request header, method 與 url 一定會比對
+	Vary []string
+	// MaxBytes response 超過時等待中的 request 各自送往 upstream, 預設 1MB
+	MaxBytes int64 `yaml:"max_bytes" mapstructure:"max_bytes" validate:"min=0"`
+	// AllowCredentials 預設帶有 Authorization 或 Cookie 的 request 不合併,
+	// 開啟後只有 credentials 相同的 request 才會合併
+	AllowCredentials bool `yaml:"allow_credentials" mapstructure:"allow_credentials"`
+}
+
+// credentialHeaders 帶有這些 header 的 request 可能得到個人化的 response
+var credentialHeaders = []string{"Authorization", "Proxy-Authorization", "Cookie"}
+
+type coalescer struct {
+	route    string
+	cfg      *CoalesceConfig
+	maxBytes int64
+	next     http.Handler
+
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+// call 進行中的 upstream request, done 關閉後其他欄位不再改變
+type call struct {
+	done   chan struct{}
+	ok     bool
+	status int
+	header http.Header
+	body   []byte
+}
+
+func newCoalescer(route string, cfg *CoalesceConfig, next http.Handler) *coalescer {
+	c := &coalescer{
+		route:    route,
+		cfg:      cfg,
+		maxBytes: cfg.MaxBytes,
+		next:     next,
+		calls:    map[string]*call{},
+	}
+	if c.maxBytes <= 0 {
+		c.maxBytes = defaultCoalesceMaxBytes
+	}
+	return c
+}
+
+func (c *coalescer) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	key, ok := c.key(req)
+	if !ok {
+		c.next.ServeHTTP(w, req)
+		return
+	}
+
+	c.mu.Lock()
+	if cl, ok := c.calls[key]; ok {
+		c.mu.Unlock()
+		c.wait(cl, w, req)
+		return
+	}
+	cl := &call{done: make(chan struct{})}
+	c.calls[key] = cl
+	c.mu.Unlock()
+
+	c.lead(key, cl, w, req)
+}
+
+// lead 直接串流給自己的 client, 同時保存一份給等待中的 request
+func (c *coalescer) lead(key string, cl *call, w http.ResponseWriter, req *http.Request) {
+	cw := &coalesceWriter{ResponseWriter: w, max: c.maxBytes, base: w.Header().Clone()}
+	completed := false
+	defer func() {
+		// ReverseProxy 在複製 body 失敗時會 panic, 等待中的 request 仍需被喚醒並各自送出
+		c.mu.Lock()
+		delete(c.calls, key)
+		c.mu.Unlock()
+
+		// client 已離開時 upstream 的 response 可能不完整
+		if completed && cw.status != 0 && !cw.skip && req.Context().Err() == nil {
+			cl.ok, cl.status, cl.header, cl.body = true, cw.status, cw.header, cw.body.Bytes()
+		}
+		close(cl.done)
+	}()
+
+	c.next.ServeHTTP(cw, req)
+	completed = true
+}
+
+// wait 共用 leader 的 response, leader 失敗或 response 太大時自行送出
+func (c *coalescer) wait(cl *call, w http.ResponseWriter, req *http.Request) {
+	select {
+	case <-cl.done:
+	case <-req.Context().Done():
+		return
+	}
+
+	if !cl.ok {
+		c.next.ServeHTTP(w, req)
+		return
+	}
+
+	metrics.Coalesced.Add(c.route, 1)
+	h := w.Header()
+	for k, v := range cl.header {
+		h[k] = append([]string(nil), v...)
+	}
+	w.WriteHeader(cl.status)
+	if req.Method != http.MethodHead {
+		_, _ = w.Write(cl.body)
+	}
+}
+
+// key method, url 與 Vary header, 不可合併時回傳 false
+func (c *coalescer) key(req *http.Request) (string, bool) {
+	if req.Method != http.MethodGet && req.Method != http.MethodHead {
+		return "", false
+	}
+
+	var b strings.Builder
+	b.WriteString(req.Method)
+	b.WriteString(" ")
+	b.WriteString(strings.ToLower(req.Host))
+	b.WriteString(req.URL.RequestURI())
+
+	for _, name := range credentialHeaders {
+		if _, ok := req.Header[name]; !ok {
+			continue
+		}
+		if !c.cfg.AllowCredentials {
+			return "", false
+		}
+		b.WriteString("\n" + name + ": " + strings.Join(req.Header.Values(name), ","))
+	}
+	// client 憑證同樣視為 credentials
+	if req.TLS != nil && len(req.TLS.PeerCertificates) > 0 {
+		if !c.cfg.AllowCredentials {
+			return "", false
+		}
+		b.WriteString("\nclient-cert: " + req.TLS.PeerCertificates[0].Subject.String())
+	}
+	for _, name := range c.cfg.Vary {
+		b.WriteString("\n" + http.CanonicalHeaderKey(name) + ": " + strings.Join(req.Header.Values(name), ","))
+	}
+	return b.String(), true
+}
+
+// coalesceWriter 與 cache 的 teeWriter 相同, 超過 max 時放棄保存
+type coalesceWriter struct {
+	http.ResponseWriter
+	max int64
+	// base 呼叫 upstream 之前已經存在的 header, 例如 RateLimit-*, 不分享給其他 request
+	base http.Header
+
+	status int
+	header http.Header
+	body   bytes.Buffer
+	skip   bool
+}
+
+func (w *coalesceWriter) WriteHeader(status int) {
+	if w.status != 0 {
+		return
+	}
+	w.status = status
+	w.header = http.Header{}
+	for k, v := range w.ResponseWriter.Header() {
+		if !reflect.DeepEqual(w.base[k], v) {
+			w.header[k] = append([]string(nil), v...)
+		}
+	}
+	// 屬於這個 client 的 response 不分享, 等待中的 request 各自呼叫 upstream
+	if !shareable(w.header) {
+		w.skip = true
+	}
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// shareable 帶有 Set-Cookie 或 Cache-Control: private 的 response 不可給其他 client
+func shareable(h http.Header) bool {
+	if len(h.Values("Set-Cookie")) > 0 {
+		return false
+	}
+	for _, v := range h.Values("Cache-Control") {
+		for _, d := range strings.Split(v, ",") {
+			d = strings.TrimSpace(d)
+			if i := strings.IndexByte(d, '='); i >= 0 {
+				d = d[:i]
+			}
+			if strings.EqualFold(d, "private") {
+				return false
+			}
+		}
+	}
+	return true
+}
+
+func (w *coalesceWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.WriteHeader(http.StatusOK)
+	}
+	if !w.skip {
+		if int64(w.body.Len()+len(b)) > w.max {
+			w.skip = true
+			w.body = bytes.Buffer{}
+		} else {
+			w.body.Write(b)
+		}
+	}
+
+	n, err := w.ResponseWriter.Write(b)
+	if err != nil {
+		w.skip = true
+	}
+	return n, err
+}
+
+// Flush ...
+func (w *coalesceWriter) Flush() {
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap for http.ResponseController
+func (w *coalesceWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,189 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+// brokenWriter client 已經斷線, 所有寫入都失敗
+type brokenWriter struct {
+	*httptest.ResponseRecorder
+}
+
+func (w brokenWriter) Write([]byte) (int, error) {
+	return 0, errors.New("broken pipe")
+}
+
+func TestCoalesceKey(t *testing.T) {
+	tests := []struct {
+		name   string
+		cfg    *CoalesceConfig
+		method string
+		header map[string]string
+		want   bool
+	}{
+		{name: "get", cfg: &CoalesceConfig{}, method: http.MethodGet, want: true},
+		{name: "head", cfg: &CoalesceConfig{}, method: http.MethodHead, want: true},
+		{name: "post", cfg: &CoalesceConfig{}, method: http.MethodPost, want: false},
+		{name: "authorization", cfg: &CoalesceConfig{}, method: http.MethodGet, header: map[string]string{"Authorization": "Bearer a"}, want: false},
+		{name: "cookie", cfg: &CoalesceConfig{}, method: http.MethodGet, header: map[string]string{"Cookie": "s=1"}, want: false},
+		{name: "allow credentials", cfg: &CoalesceConfig{AllowCredentials: true}, method: http.MethodGet, header: map[string]string{"Cookie": "s=1"}, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/books", nil)
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			if _, ok := newCoalescer("books", tt.cfg, nil).key(req); ok != tt.want {
+				t.Errorf("key() ok = %v, want %v", ok, tt.want)
+			}
+		})
+	}
+
+	c := newCoalescer("books", &CoalesceConfig{Vary: []string{"Accept-Language"}, AllowCredentials: true}, nil)
+	key := func(header map[string]string) string {
+		req := httptest.NewRequest(http.MethodGet, "/books", nil)
+		for k, v := range header {
+			req.Header.Set(k, v)
+		}
+		k, _ := c.key(req)
+		return k
+	}
+	if key(map[string]string{"Accept-Language": "en"}) == key(map[string]string{"Accept-Language": "fr"}) {
+		t.Error("requests with different Vary header share a key")
+	}
+	if key(map[string]string{"Authorization": "Bearer a"}) == key(map[string]string{"Authorization": "Bearer b"}) {
+		t.Error("requests with different credentials share a key")
+	}
+}
+
+// TestCoalesceLeader 等待中的 request 只在 leader 正常完成時共用 response, 否則各自送往 upstream
+func TestCoalesceLeader(t *testing.T) {
+	tests := []struct {
+		name string
+		// leader 第一個 request 的 upstream response
+		leader func(w http.ResponseWriter)
+		// writer leader 的 client, 預設為 httptest.ResponseRecorder
+		writer func() http.ResponseWriter
+		cancel bool
+		shared bool
+		panics bool
+	}{
+		{
+			name:   "shared",
+			leader: func(w http.ResponseWriter) { _, _ = w.Write([]byte("shared")) },
+			shared: true,
+		},
+		{
+			name: "set-cookie",
+			leader: func(w http.ResponseWriter) {
+				w.Header().Set("Set-Cookie", "s=1")
+				_, _ = w.Write([]byte("shared"))
+			},
+		},
+		{
+			name:   "larger than max bytes",
+			leader: func(w http.ResponseWriter) { _, _ = w.Write([]byte(strings.Repeat("x", 64))) },
+		},
+		{
+			name:   "upstream panic",
+			leader: func(w http.ResponseWriter) { _, _ = w.Write([]byte("sha")); panic(http.ErrAbortHandler) },
+			panics: true,
+		},
+		{
+			name:   "client write error",
+			leader: func(w http.ResponseWriter) { _, _ = w.Write([]byte("shared")) },
+			writer: func() http.ResponseWriter { return brokenWriter{httptest.NewRecorder()} },
+		},
+		{
+			name:   "client canceled",
+			leader: func(w http.ResponseWriter) { _, _ = w.Write([]byte("shared")) },
+			cancel: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			started, release := make(chan struct{}), make(chan struct{})
+			var calls int32
+			next := http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				if atomic.AddInt32(&calls, 1) == 1 {
+					close(started)
+					<-release
+					tt.leader(w)
+					return
+				}
+				_, _ = w.Write([]byte("own"))
+			})
+			c := newCoalescer("books", &CoalesceConfig{MaxBytes: 32}, next)
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			var w http.ResponseWriter = httptest.NewRecorder()
+			if tt.writer != nil {
+				w = tt.writer()
+			}
+			leaderDone := make(chan interface{})
+			go func() {
+				defer func() { leaderDone <- recover() }()
+				c.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/books", nil).WithContext(ctx))
+			}()
+			<-started
+
+			key, _ := c.key(httptest.NewRequest(http.MethodGet, "/books", nil))
+			c.mu.Lock()
+			cl := c.calls[key]
+			c.mu.Unlock()
+			if cl == nil {
+				t.Fatal("leader did not register the call")
+			}
+
+			const waiters = 3
+			var wg sync.WaitGroup
+			bodies := make([]string, waiters)
+			for i := 0; i < waiters; i++ {
+				wg.Add(1)
+				go func(i int) {
+					defer wg.Done()
+					rec := httptest.NewRecorder()
+					c.wait(cl, rec, httptest.NewRequest(http.MethodGet, "/books", nil))
+					bodies[i] = rec.Body.String()
+				}(i)
+			}
+
+			if tt.cancel {
+				cancel()
+			}
+			close(release)
+			if r := <-leaderDone; (r != nil) != tt.panics {
+				t.Errorf("leader panic = %v, want panic %v", r, tt.panics)
+			}
+			wg.Wait()
+
+			want, wantCalls := "own", int32(1+waiters)
+			if tt.shared {
+				want, wantCalls = "shared", 1
+			}
+			for i, body := range bodies {
+				if body != want {
+					t.Errorf("waiter %d body = %q, want %q", i, body, want)
+				}
+			}
+			if calls != wantCalls {
+				t.Errorf("upstream calls = %d, want %d", calls, wantCalls)
+			}
+
+			c.mu.Lock()
+			defer c.mu.Unlock()
+			if len(c.calls) != 0 {
+				t.Error("call not removed after the leader finished")
+			}
+		})
+	}
+}
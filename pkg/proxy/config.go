@@ -0,0 +1,209 @@
+package proxy
+
+import (
+	"time"
+
+	"apigateway/pkg/cache"
+	"apigateway/pkg/ratelimit"
+)
+
+// Balancer policies
+const (
+	RoundRobin         = "round_robin"
+	WeightedRoundRobin = "weighted_round_robin"
+	LeastConnections   = "least_connections"
+	RandomTwoChoices   = "random_two_choices"
+	ConsistentHash     = "consistent_hash"
+)
+
+// Hash sources of consistent_hash
+const (
+	HashOnHeader   = "header"
+	HashOnCookie   = "cookie"
+	HashOnClientIP = "client_ip"
+)
+
+// Routes the structure for the `routes:` section
+type Routes []*RouteConfig
+
+// RouteConfig 將符合 host、path prefix 與 method 的 request 轉送到 upstream
+type RouteConfig struct {
+	Name string `validate:"required"`
+	// Host 空白表示任何 host, 支援 *.example.com
+	Host       string
+	PathPrefix string `yaml:"path_prefix" mapstructure:"path_prefix" validate:"required,startswith=/"`
+	// Methods 空白表示任何 method
+	Methods []string `validate:"dive,oneof=GET HEAD POST PUT PATCH DELETE OPTIONS"`
+	// Upstream upstreams 中的名稱, 或單一 target 的 url
+	Upstream string `validate:"required"`
+	// StripPrefix 轉送前移除 PathPrefix
+	StripPrefix bool           `yaml:"strip_prefix" mapstructure:"strip_prefix"`
+	Rewrite     *RewriteConfig `validate:"omitempty"`
+	Headers     *HeadersConfig `validate:"omitempty"`
+	// Timeout 0 表示不限制
+	Timeout time.Duration `validate:"min=0"`
+	// CircuitBreaker 只計算此 route 的 request, 與 upstream 的 breaker 各自獨立
+	CircuitBreaker *BreakerConfig `yaml:"circuit_breaker" mapstructure:"circuit_breaker" validate:"omitempty"`
+	Retry          *RetryConfig   `validate:"omitempty"`
+	// RateLimits 全部規則都通過才放行
+	RateLimits []*ratelimit.Config `yaml:"rate_limits" mapstructure:"rate_limits" validate:"dive,required"`
+	// Cache 設定後 GET 與 HEAD 會使用 response cache
+	Cache    *cache.RouteConfig `validate:"omitempty"`
+	Coalesce *CoalesceConfig    `validate:"omitempty"`
+	// WebSocket 設定後才允許 Upgrade request, 未設定的 route 回 400
+	WebSocket *WebSocketConfig `yaml:"websocket" mapstructure:"websocket" validate:"omitempty"`
+	// ClientCert 設定後只接受通過 http.tls.client_ca 驗證的 client 憑證
+	ClientCert *ClientCertConfig `yaml:"client_cert" mapstructure:"client_cert" validate:"omitempty"`
+}
+
+// DefaultClientCertHeader 轉送 client 憑證 subject 的預設 header
+const DefaultClientCertHeader = "X-Client-Cert-Subject"
+
+// ClientCertConfig 驗證過的 subject 以 header 轉送給 upstream, client 自行帶來的同名 header 一律移除.
+// 使用 cache 時需將該 header 加入 cache key.
+type ClientCertConfig struct {
+	// SubjectHeader 預設 X-Client-Cert-Subject
+	SubjectHeader string `yaml:"subject_header" mapstructure:"subject_header"`
+}
+
+func (cc *ClientCertConfig) header() string {
+	if cc == nil || cc.SubjectHeader == "" {
+		return DefaultClientCertHeader
+	}
+	return cc.SubjectHeader
+}
+
+// WebSocketConfig upgrade 後的 connection 不受 route 的 timeout 限制
+type WebSocketConfig struct {
+	// IdleTimeout 兩個方向都沒有資料時關閉, 0 表示不限制
+	IdleTimeout time.Duration `yaml:"idle_timeout" mapstructure:"idle_timeout" validate:"min=0"`
+	// MaxConnectionsPerClient 每個 client ip 在此 route 的 connection 上限, 0 表示不限制
+	MaxConnectionsPerClient int `yaml:"max_connections_per_client" mapstructure:"max_connections_per_client" validate:"min=0"`
+}
+
+// RewriteConfig 在 strip prefix 之後以 regexp 改寫 path
+type RewriteConfig struct {
+	Pattern     string `validate:"required,regexp"`
+	Replacement string
+}
+
+// HeadersConfig ...
+type HeadersConfig struct {
+	Request  *HeaderRules `validate:"omitempty"`
+	Response *HeaderRules `validate:"omitempty"`
+}
+
+// HeaderRules remove 先於 add 執行
+type HeaderRules struct {
+	Add    map[string]string
+	Remove []string
+}
+
+// Upstreams the structure for the `upstreams:` section, key 為 pool 名稱
+type Upstreams map[string]*UpstreamConfig
+
+// UpstreamConfig a pool of targets
+type UpstreamConfig struct {
+	Targets        []*TargetConfig    `validate:"required,min=1,dive,required"`
+	Balancer       *BalancerConfig    `validate:"omitempty"`
+	HealthCheck    *HealthCheckConfig `yaml:"health_check" mapstructure:"health_check" validate:"omitempty"`
+	CircuitBreaker *BreakerConfig     `yaml:"circuit_breaker" mapstructure:"circuit_breaker" validate:"omitempty"`
+	// TLS 設定後 https target 使用 pool 自己的 transport, 不經過 HTTP_PROXY/HTTPS_PROXY
+	TLS *UpstreamTLSConfig `validate:"omitempty"`
+}
+
+// TargetConfig ...
+type TargetConfig struct {
+	URL string `validate:"required,url"`
+	// Weight 只有 weighted_round_robin 與 consistent_hash 使用, 預設為 1
+	Weight int `validate:"min=0"`
+}
+
+// BalancerConfig 預設為 round_robin
+type BalancerConfig struct {
+	Policy string `validate:"omitempty,oneof=round_robin weighted_round_robin least_connections random_two_choices consistent_hash"`
+	// HashOn consistent_hash 使用的 key 來源, 預設為 client_ip
+	HashOn string `yaml:"hash_on" mapstructure:"hash_on" validate:"omitempty,oneof=header cookie client_ip"`
+	// HashKey header 或 cookie 名稱
+	HashKey string `yaml:"hash_key" mapstructure:"hash_key"`
+}
+
+// HealthCheckConfig active http health check
+type HealthCheckConfig struct {
+	Path     string        `validate:"required,startswith=/"`
+	Interval time.Duration `validate:"min=0"`
+	Timeout  time.Duration `validate:"min=0"`
+	// HealthyThreshold 連續成功幾次後重新加入
+	HealthyThreshold int `yaml:"healthy_threshold" mapstructure:"healthy_threshold" validate:"min=0"`
+	// UnhealthyThreshold 連續失敗幾次後移除
+	UnhealthyThreshold int `yaml:"unhealthy_threshold" mapstructure:"unhealthy_threshold" validate:"min=0"`
+	// ExpectedStatus 空白表示任何 2xx
+	ExpectedStatus []int `yaml:"expected_status" mapstructure:"expected_status" validate:"dive,min=100,max=599"`
+}
+
+// Aggregate error policies
+const (
+	OnErrorFail    = "fail"
+	OnErrorPartial = "partial"
+)
+
+// Aggregations the structure for the `aggregations:` section
+type Aggregations []*AggregateConfig
+
+// AggregateConfig GET Path 時平行呼叫所有 branch, 依 mappings 合併成一份 JSON
+type AggregateConfig struct {
+	Name string `validate:"required"`
+	Host string
+	// Path 以 {name} 表示 path 參數, 例如 /api/v1/composite/books/{id}
+	Path string `validate:"required,startswith=/"`
+	// OnError fail 時任一 branch 失敗即回傳錯誤, partial 時回傳其他 branch 的結果與 _errors, 預設為 fail
+	OnError string `yaml:"on_error" mapstructure:"on_error" validate:"omitempty,oneof=fail partial"`
+	// Timeout 整個 request 的上限, 0 表示只受各 branch 的 timeout 限制
+	Timeout  time.Duration   `validate:"min=0"`
+	Branches []*BranchConfig `validate:"required,min=1,dive,required"`
+}
+
+// BranchConfig 一個 upstream 呼叫
+type BranchConfig struct {
+	Name string `validate:"required"`
+	// Upstream upstreams 中的名稱, 或單一 target 的 url
+	Upstream string `validate:"required"`
+	// Path 可使用 {name} 引用 path 或 query 參數
+	Path    string        `validate:"required,startswith=/"`
+	Timeout time.Duration `validate:"min=0"`
+	// Mappings 空白時整份結果放在 /<name>
+	Mappings []*MappingConfig `validate:"dive,required"`
+}
+
+// MappingConfig 將 branch 結果中 From 指向的值寫入合併結果的 To, 兩者皆為 JSON pointer
+type MappingConfig struct {
+	From string
+	To   string `validate:"required,startswith=/"`
+}
+
+// Transcodings the structure for the `transcodings:` section
+type Transcodings []*TranscodeConfig
+
+// TranscodeConfig 將 HTTP/JSON request 轉成 upstream 的 unary gRPC 呼叫
+type TranscodeConfig struct {
+	Name string `validate:"required"`
+	Host string
+	// Upstream gRPC server, upstreams 中的名稱或單一 target 的 url, http 的 target 以 h2c 連線
+	Upstream string `validate:"required"`
+	// DescriptorSet protoc --include_imports --descriptor_set_out 產生的檔案
+	DescriptorSet string           `yaml:"descriptor_set" mapstructure:"descriptor_set" validate:"required"`
+	Timeout       time.Duration    `validate:"min=0"`
+	Bindings      []*BindingConfig `validate:"required,min=1,dive,required"`
+}
+
+// BindingConfig 一組 HTTP method 與 path 對應到一個 gRPC method.
+// request message 依序寫入 body、query 參數與 path 參數, 後者優先.
+type BindingConfig struct {
+	Method string `validate:"required,oneof=GET POST PUT PATCH DELETE"`
+	// Path 以 {field} 將 path 參數寫入 request message 的欄位, 巢狀欄位使用 {book.id}
+	Path string `validate:"required,startswith=/"`
+	// RPC 完整的 method 名稱, 例如 apigateway.book.v1.BookService/GetBook
+	RPC string `validate:"required"`
+	// Body * 表示整個 request message, 欄位名稱表示寫入該欄位, 空白表示不讀取 body
+	Body string
+}
@@ -0,0 +1,129 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/rs/zerolog/log"
+)
+
+// health check defaults
+const (
+	defaultCheckInterval      = 10 * time.Second
+	defaultCheckTimeout       = 2 * time.Second
+	defaultHealthyThreshold   = 2
+	defaultUnhealthyThreshold = 3
+)
+
+// startHealthCheck 每個 target 一個 goroutine, 直到 pool 被關閉
+func (p *Pool) startHealthCheck(transport http.RoundTripper) {
+	hc := p.cfg.HealthCheck
+	if hc == nil {
+		return
+	}
+
+	interval, timeout := hc.Interval, hc.Timeout
+	if interval <= 0 {
+		interval = defaultCheckInterval
+	}
+	if timeout <= 0 {
+		timeout = defaultCheckTimeout
+	}
+	client := &http.Client{Transport: transport, Timeout: timeout}
+
+	for _, t := range p.targets {
+		p.wg.Add(1)
+		go func(t *Target) {
+			defer p.wg.Done()
+
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				p.check(client, t)
+				select {
+				case <-p.stop:
+					return
+				case <-ticker.C:
+				}
+			}
+		}(t)
+	}
+}
+
+func (p *Pool) check(client *http.Client, t *Target) {
+	hc := p.cfg.HealthCheck
+	err := probe(client, t.URL.String()+hc.Path, hc.ExpectedStatus, p.stop)
+
+	healthyThreshold, unhealthyThreshold := hc.HealthyThreshold, hc.UnhealthyThreshold
+	if healthyThreshold <= 0 {
+		healthyThreshold = defaultHealthyThreshold
+	}
+	if unhealthyThreshold <= 0 {
+		unhealthyThreshold = defaultUnhealthyThreshold
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err != nil {
+		t.successes = 0
+		t.failures++
+		t.lastError = err.Error()
+		if t.Healthy() && t.failures >= unhealthyThreshold {
+			atomic.StoreInt32(&t.healthy, 0)
+			log.Warn().Str("upstream", p.Name).Str("target", t.URL.String()).Msgf("target removed from pool: %v", err)
+		}
+		return
+	}
+
+	t.failures = 0
+	t.successes++
+	t.lastError = ""
+	if !t.Healthy() && t.successes >= healthyThreshold {
+		atomic.StoreInt32(&t.healthy, 1)
+		log.Info().Str("upstream", p.Name).Str("target", t.URL.String()).Msg("target added back to pool")
+	}
+}
+
+func probe(client *http.Client, url string, expected []int, stop <-chan struct{}) error {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go func() {
+		select {
+		case <-stop:
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("User-Agent", "apigateway-health-check")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	resp.Body.Close()
+
+	if len(expected) == 0 {
+		if resp.StatusCode >= 200 && resp.StatusCode < 300 {
+			return nil
+		}
+		return fmt.Errorf("unexpected status %d", resp.StatusCode)
+	}
+	for _, code := range expected {
+		if resp.StatusCode == code {
+			return nil
+		}
+	}
+	return fmt.Errorf("unexpected status %d", resp.StatusCode)
+}
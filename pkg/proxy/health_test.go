@@ -0,0 +1,78 @@
+package proxy
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+func TestHealthCheckThresholds(t *testing.T) {
+	var status int32 = http.StatusOK
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if req.URL.Path != "/health" {
+			w.WriteHeader(http.StatusNotFound)
+			return
+		}
+		w.WriteHeader(int(atomic.LoadInt32(&status)))
+	}))
+	defer srv.Close()
+
+	p, err := newPool("test", &UpstreamConfig{
+		Targets:     []*TargetConfig{{URL: srv.URL}},
+		HealthCheck: &HealthCheckConfig{Path: "/health", HealthyThreshold: 2, UnhealthyThreshold: 2},
+	}, nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	target := p.targets[0]
+
+	steps := []struct {
+		status  int32
+		healthy bool
+	}{
+		{status: http.StatusOK, healthy: true},
+		{status: http.StatusServiceUnavailable, healthy: true},
+		{status: http.StatusServiceUnavailable, healthy: false},
+		{status: http.StatusOK, healthy: false},
+		{status: http.StatusServiceUnavailable, healthy: false},
+		{status: http.StatusOK, healthy: false},
+		{status: http.StatusOK, healthy: true},
+	}
+	for i, s := range steps {
+		atomic.StoreInt32(&status, s.status)
+		p.check(srv.Client(), target)
+		if target.Healthy() != s.healthy {
+			t.Fatalf("step %d (status %d): healthy = %v, want %v", i, s.status, target.Healthy(), s.healthy)
+		}
+	}
+
+	if got := p.Status().Targets[0].LastCheckError; got != "" {
+		t.Errorf("LastCheckError = %q after a success, want empty", got)
+	}
+}
+
+func TestProbeExpectedStatus(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer srv.Close()
+
+	tests := []struct {
+		name     string
+		expected []int
+		wantErr  bool
+	}{
+		{name: "any 2xx", wantErr: false},
+		{name: "listed", expected: []int{200, 204}, wantErr: false},
+		{name: "not listed", expected: []int{200}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := probe(srv.Client(), srv.URL, tt.expected, make(chan struct{}))
+			if (err != nil) != tt.wantErr {
+				t.Errorf("probe() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
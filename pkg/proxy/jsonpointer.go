@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// parsePointer RFC 6901, "" 代表整份文件
+func parsePointer(p string) ([]string, error) {
+	if p == "" {
+		return nil, nil
+	}
+	if !strings.HasPrefix(p, "/") {
+		return nil, fmt.Errorf("json pointer %q must start with /", p)
+	}
+	tokens := strings.Split(p[1:], "/")
+	for i, t := range tokens {
+		tokens[i] = strings.NewReplacer("~1", "/", "~0", "~").Replace(t)
+	}
+	return tokens, nil
+}
+
+// pointerGet 取出 doc 中 tokens 指向的值
+func pointerGet(doc interface{}, tokens []string) (interface{}, bool) {
+	for _, t := range tokens {
+		switch v := doc.(type) {
+		case map[string]interface{}:
+			var ok bool
+			if doc, ok = v[t]; !ok {
+				return nil, false
+			}
+		case []interface{}:
+			i, err := strconv.Atoi(t)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			doc = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return doc, true
+}
+
+// pointerSet 寫入 value 並回傳新的 doc, 缺少的中間節點以 object 建立, "-" 表示加在 array 最後 (不存在時建立 array)
+func pointerSet(doc interface{}, tokens []string, value interface{}) (interface{}, error) {
+	if len(tokens) == 0 {
+		return value, nil
+	}
+
+	t := tokens[0]
+	switch v := doc.(type) {
+	case nil:
+		child, err := pointerSet(nil, tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		if t == "-" {
+			return []interface{}{child}, nil
+		}
+		return map[string]interface{}{t: child}, nil
+	case map[string]interface{}:
+		child, err := pointerSet(v[t], tokens[1:], value)
+		if err != nil {
+			return nil, err
+		}
+		v[t] = child
+		return v, nil
+	case []interface{}:
+		if t == "-" {
+			child, err := pointerSet(nil, tokens[1:], value)
+			if err != nil {
+				return nil, err
+			}
+			return append(v, child), nil
+		}
+		i, err := strconv.Atoi(t)
+		if err != nil || i < 0 || i >= len(v) {
+			return nil, fmt.Errorf("invalid array index %q", t)
+		}
+		if v[i], err = pointerSet(v[i], tokens[1:], value); err != nil {
+			return nil, err
+		}
+		return v, nil
+	default:
+		return nil, fmt.Errorf("cannot set %q on a %T", t, doc)
+	}
+}
@@ -0,0 +1,118 @@
+package proxy
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decodeJSON(t *testing.T, s string) interface{} {
+	t.Helper()
+	if s == "" {
+		return nil
+	}
+	var v interface{}
+	if err := json.Unmarshal([]byte(s), &v); err != nil {
+		t.Fatalf("invalid json %s: %v", s, err)
+	}
+	return v
+}
+
+func TestParsePointer(t *testing.T) {
+	tests := []struct {
+		pointer string
+		want    []string
+		wantErr bool
+	}{
+		{pointer: "", want: nil},
+		{pointer: "/", want: []string{""}},
+		{pointer: "/a/b", want: []string{"a", "b"}},
+		{pointer: "/a~1b/c~0d", want: []string{"a/b", "c~d"}},
+		{pointer: "/~01", want: []string{"~1"}},
+		{pointer: "a/b", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.pointer, func(t *testing.T) {
+			got, err := parsePointer(tt.pointer)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("parsePointer(%q) error = %v, wantErr %v", tt.pointer, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parsePointer(%q) = %q, want %q", tt.pointer, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPointerGet(t *testing.T) {
+	doc := `{"book": {"name": "go", "tags": ["a", "b"]}, "a/b": 1}`
+	tests := []struct {
+		name    string
+		pointer string
+		want    string
+		ok      bool
+	}{
+		{name: "whole document", pointer: "", want: doc, ok: true},
+		{name: "object member", pointer: "/book/name", want: `"go"`, ok: true},
+		{name: "array index", pointer: "/book/tags/1", want: `"b"`, ok: true},
+		{name: "escaped key", pointer: "/a~1b", want: `1`, ok: true},
+		{name: "missing member", pointer: "/book/isbn"},
+		{name: "index out of range", pointer: "/book/tags/2"},
+		{name: "negative index", pointer: "/book/tags/-1"},
+		{name: "append token", pointer: "/book/tags/-"},
+		{name: "through scalar", pointer: "/book/name/x"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := parsePointer(tt.pointer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, ok := pointerGet(decodeJSON(t, doc), tokens)
+			if ok != tt.ok {
+				t.Fatalf("pointerGet(%q) ok = %v, want %v", tt.pointer, ok, tt.ok)
+			}
+			if ok && !reflect.DeepEqual(got, decodeJSON(t, tt.want)) {
+				t.Errorf("pointerGet(%q) = %v, want %s", tt.pointer, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestPointerSet(t *testing.T) {
+	tests := []struct {
+		name    string
+		doc     string
+		pointer string
+		value   interface{}
+		want    string
+		wantErr bool
+	}{
+		{name: "replace document", doc: `{"a": 1}`, pointer: "", value: "x", want: `"x"`},
+		{name: "new member", doc: `{}`, pointer: "/a", value: "x", want: `{"a": "x"}`},
+		{name: "create intermediate objects", doc: ``, pointer: "/a/b", value: "x", want: `{"a": {"b": "x"}}`},
+		{name: "overwrite member", doc: `{"a": {"b": 1, "c": 2}}`, pointer: "/a/b", value: "x", want: `{"a": {"b": "x", "c": 2}}`},
+		{name: "array index", doc: `{"a": [1, 2]}`, pointer: "/a/0", value: "x", want: `{"a": ["x", 2]}`},
+		{name: "append", doc: `{"a": [1]}`, pointer: "/a/-", value: "x", want: `{"a": [1, "x"]}`},
+		{name: "append creates array", doc: `{}`, pointer: "/a/-", value: "x", want: `{"a": ["x"]}`},
+		{name: "append object", doc: `{"a": []}`, pointer: "/a/-/b", value: "x", want: `{"a": [{"b": "x"}]}`},
+		{name: "index out of range", doc: `{"a": [1]}`, pointer: "/a/1", value: "x", wantErr: true},
+		{name: "invalid index", doc: `{"a": [1]}`, pointer: "/a/b", value: "x", wantErr: true},
+		{name: "through scalar", doc: `{"a": 1}`, pointer: "/a/b", value: "x", wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			tokens, err := parsePointer(tt.pointer)
+			if err != nil {
+				t.Fatal(err)
+			}
+			got, err := pointerSet(decodeJSON(t, tt.doc), tokens, tt.value)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("pointerSet(%q) error = %v, wantErr %v", tt.pointer, err, tt.wantErr)
+			}
+			if !tt.wantErr && !reflect.DeepEqual(got, decodeJSON(t, tt.want)) {
+				t.Errorf("pointerSet(%q) = %v, want %s", tt.pointer, got, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,37 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"apigateway/pkg/health"
+)
+
+// RegisterHealthCheck 所有 target 都不健康的 upstream 只讓 readiness 標示為 degraded,
+// 避免單一 upstream 故障讓整個 gateway 被移出 load balancer
+func RegisterHealthCheck(c *health.Checker, t *Table) {
+	c.Register(health.Check{
+		Name:  "upstreams",
+		Local: true,
+		Run: func(context.Context) error {
+			var down []string
+			for _, p := range t.Pools() {
+				healthy := false
+				for _, target := range p.Targets {
+					if target.Healthy {
+						healthy = true
+						break
+					}
+				}
+				if !healthy {
+					down = append(down, p.Name)
+				}
+			}
+			if len(down) > 0 {
+				return fmt.Errorf("no healthy target: %s", strings.Join(down, ", "))
+			}
+			return nil
+		},
+	})
+}
@@ -0,0 +1,199 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/cenk/backoff"
+)
+
+// retry defaults
+const (
+	defaultRetryInitialInterval = 50 * time.Millisecond
+	defaultRetryMaxInterval     = time.Second
+	defaultBudgetPercent        = 20
+	defaultBudgetMinPerSecond   = 10
+	defaultBudgetWindow         = 10 * time.Second
+
+	// maxRetryBody 超過此大小的 request body 不重試, 避免整個 body 留在記憶體
+	maxRetryBody = 1 << 20
+)
+
+// RetryConfig 連線錯誤、502/503/504 與逾時才重試
+type RetryConfig struct {
+	// Attempts 不含第一次的最多重試次數
+	Attempts        int           `validate:"min=0"`
+	InitialInterval time.Duration `yaml:"initial_interval" mapstructure:"initial_interval" validate:"min=0"`
+	MaxInterval     time.Duration `yaml:"max_interval" mapstructure:"max_interval" validate:"min=0"`
+	// PerTryTimeout 每次嘗試的逾時, 0 表示只受 route 的 timeout 限制
+	PerTryTimeout time.Duration `yaml:"per_try_timeout" mapstructure:"per_try_timeout" validate:"min=0"`
+}
+
+// RetryBudgetConfig 全部 route 共用, window 內重試數不超過 request 數的 Percent%
+type RetryBudgetConfig struct {
+	Percent float64 `validate:"min=0,max=100"`
+	// MinPerSecond 流量很低時仍允許的每秒重試數
+	MinPerSecond int           `yaml:"min_per_second" mapstructure:"min_per_second" validate:"min=0"`
+	Window       time.Duration `validate:"min=0"`
+}
+
+// retryBudget 以每秒一個 bucket 統計 request 與重試數
+type retryBudget struct {
+	mu      sync.Mutex
+	cfg     RetryBudgetConfig
+	buckets []budgetBucket
+}
+
+type budgetBucket struct {
+	second   int64
+	requests int
+	retries  int
+}
+
+func newRetryBudget(cfg *RetryBudgetConfig) *retryBudget {
+	b := &retryBudget{}
+	b.update(cfg)
+	return b
+}
+
+// update 套用新的設定, window 改變時重新開始統計
+func (b *retryBudget) update(cfg *RetryBudgetConfig) {
+	c := RetryBudgetConfig{
+		Percent:      defaultBudgetPercent,
+		MinPerSecond: defaultBudgetMinPerSecond,
+		Window:       defaultBudgetWindow,
+	}
+	if cfg != nil {
+		c = *cfg
+		if c.Window <= 0 {
+			c.Window = defaultBudgetWindow
+		}
+	}
+	seconds := int(c.Window / time.Second)
+	if seconds < 1 {
+		seconds = 1
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.cfg = c
+	if len(b.buckets) != seconds {
+		b.buckets = make([]budgetBucket, seconds)
+	}
+}
+
+func (b *retryBudget) bucket(now time.Time) *budgetBucket {
+	sec := now.Unix()
+	bk := &b.buckets[sec%int64(len(b.buckets))]
+	if bk.second != sec {
+		*bk = budgetBucket{second: sec}
+	}
+	return bk
+}
+
+// request 記錄一個新進的 request
+func (b *retryBudget) request() {
+	b.mu.Lock()
+	b.bucket(time.Now()).requests++
+	b.mu.Unlock()
+}
+
+// withdraw 預算足夠時記錄一次重試並回傳 true
+func (b *retryBudget) withdraw() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	now := time.Now()
+	oldest := now.Unix() - int64(len(b.buckets))
+	requests, retries := 0, 0
+	for _, bk := range b.buckets {
+		if bk.second > oldest {
+			requests += bk.requests
+			retries += bk.retries
+		}
+	}
+
+	limit := float64(requests) * b.cfg.Percent / 100
+	if min := float64(b.cfg.MinPerSecond * len(b.buckets)); limit < min {
+		limit = min
+	}
+	if float64(retries) >= limit {
+		return false
+	}
+	b.bucket(now).retries++
+	return true
+}
+
+// bufferBody 讀出 request body 以便重試時重送, 超過 maxRetryBody 時回傳 false 並還原 body
+func bufferBody(req *http.Request) (func() io.ReadCloser, bool) {
+	if req.Body == nil || req.Body == http.NoBody {
+		return func() io.ReadCloser { return req.Body }, true
+	}
+
+	buf, err := ioutil.ReadAll(io.LimitReader(req.Body, maxRetryBody+1))
+	if err != nil || len(buf) > maxRetryBody {
+		req.Body = ioutil.NopCloser(io.MultiReader(bytes.NewReader(buf), req.Body))
+		return nil, false
+	}
+	return func() io.ReadCloser { return ioutil.NopCloser(bytes.NewReader(buf)) }, true
+}
+
+// newBackOff 不限制總時間, 次數由 RetryConfig.Attempts 控制
+func newBackOff(cfg *RetryConfig) backoff.BackOff {
+	bo := backoff.NewExponentialBackOff()
+	bo.InitialInterval = defaultRetryInitialInterval
+	bo.MaxInterval = defaultRetryMaxInterval
+	if cfg.InitialInterval > 0 {
+		bo.InitialInterval = cfg.InitialInterval
+	}
+	if cfg.MaxInterval > 0 {
+		bo.MaxInterval = cfg.MaxInterval
+	}
+	bo.MaxElapsedTime = 0
+	bo.Reset()
+	return bo
+}
+
+// retryable GET 等 idempotent method, 或帶有 Idempotency-Key 的 POST
+func retryable(req *http.Request) bool {
+	switch req.Method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace, http.MethodPut, http.MethodDelete:
+		return true
+	case http.MethodPost:
+		return req.Header.Get("Idempotency-Key") != ""
+	}
+	return false
+}
+
+// shouldRetry 連線失敗、逾時或 502/503/504, route 本身的 ctx 結束時不再重試
+func shouldRetry(ctx context.Context, resp *http.Response, err error) bool {
+	if ctx.Err() != nil {
+		return false
+	}
+	if err != nil {
+		var netErr net.Error
+		var opErr *net.OpError
+		switch {
+		case errors.Is(err, context.DeadlineExceeded):
+			return true
+		case errors.As(err, &opErr) && opErr.Op == "dial":
+			return true
+		case errors.As(err, &netErr) && netErr.Timeout():
+			return true
+		}
+		return false
+	}
+
+	switch resp.StatusCode {
+	case http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		return true
+	}
+	return false
+}
@@ -0,0 +1,156 @@
+package proxy
+
+import (
+	"bytes"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"apigateway/pkg/ratelimit"
+)
+
+func TestRetryable(t *testing.T) {
+	tests := []struct {
+		method string
+		key    string
+		want   bool
+	}{
+		{method: http.MethodGet, want: true},
+		{method: http.MethodHead, want: true},
+		{method: http.MethodPut, want: true},
+		{method: http.MethodDelete, want: true},
+		{method: http.MethodPost, want: false},
+		{method: http.MethodPost, key: "abc", want: true},
+		{method: http.MethodPatch, want: false},
+		{method: http.MethodPatch, key: "abc", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.method+"|"+tt.key, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, "/", nil)
+			if tt.key != "" {
+				req.Header.Set("Idempotency-Key", tt.key)
+			}
+			if got := retryable(req); got != tt.want {
+				t.Errorf("retryable(%s, key %q) = %v, want %v", tt.method, tt.key, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	tests := []struct {
+		name     string
+		cfg      *RetryBudgetConfig
+		requests int
+		want     int
+	}{
+		{name: "percent of requests", cfg: &RetryBudgetConfig{Percent: 20, Window: time.Second}, requests: 10, want: 2},
+		{name: "min per second floor", cfg: &RetryBudgetConfig{Percent: 10, MinPerSecond: 3, Window: time.Second}, requests: 10, want: 3},
+		{name: "zero budget", cfg: &RetryBudgetConfig{Window: time.Second}, requests: 10, want: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			b := newRetryBudget(tt.cfg)
+			for i := 0; i < tt.requests; i++ {
+				b.request()
+			}
+			got := 0
+			for b.withdraw() {
+				got++
+				if got > tt.requests {
+					break
+				}
+			}
+			if got != tt.want {
+				t.Errorf("retries allowed = %d, want %d", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestBufferBody(t *testing.T) {
+	small := httptest.NewRequest(http.MethodPost, "/", strings.NewReader("hello"))
+	body, ok := bufferBody(small)
+	if !ok {
+		t.Fatal("small body should be buffered")
+	}
+	for i := 0; i < 2; i++ {
+		if b, _ := ioutil.ReadAll(body()); string(b) != "hello" {
+			t.Fatalf("replay %d = %q, want hello", i, b)
+		}
+	}
+
+	large := bytes.Repeat([]byte("x"), maxRetryBody+10)
+	req := httptest.NewRequest(http.MethodPost, "/", bytes.NewReader(large))
+	if _, ok := bufferBody(req); ok {
+		t.Fatal("body larger than maxRetryBody should not be buffered")
+	}
+	if b, _ := ioutil.ReadAll(req.Body); !bytes.Equal(b, large) {
+		t.Fatalf("restored body has %d bytes, want %d", len(b), len(large))
+	}
+}
+
+func TestRouteRetry(t *testing.T) {
+	tests := []struct {
+		name   string
+		method string
+		key    string
+		// fail 前幾次回 503
+		fail       int32
+		wantStatus int
+		wantHits   int32
+	}{
+		{name: "get retried", method: http.MethodGet, fail: 1, wantStatus: http.StatusOK, wantHits: 2},
+		{name: "attempts exhausted", method: http.MethodGet, fail: 5, wantStatus: http.StatusServiceUnavailable, wantHits: 3},
+		{name: "post without key", method: http.MethodPost, fail: 1, wantStatus: http.StatusServiceUnavailable, wantHits: 1},
+		{name: "post with idempotency key", method: http.MethodPost, key: "k1", fail: 1, wantStatus: http.StatusOK, wantHits: 2},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var hits int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+				n := atomic.AddInt32(&hits, 1)
+				// 每次重試都要收到完整的 body
+				if b, _ := ioutil.ReadAll(req.Body); req.Method == http.MethodPost && string(b) != "payload" {
+					w.WriteHeader(http.StatusBadRequest)
+					return
+				}
+				if n <= tt.fail {
+					w.WriteHeader(http.StatusServiceUnavailable)
+					return
+				}
+				w.WriteHeader(http.StatusOK)
+			}))
+			defer srv.Close()
+
+			table, err := NewTable(&testLifecycle{}, Routes{{
+				Name:       "books",
+				PathPrefix: "/books",
+				Upstream:   srv.URL,
+				Retry:      &RetryConfig{Attempts: 2, InitialInterval: time.Millisecond},
+			}}, nil, nil, nil, &RetryBudgetConfig{Percent: 100, MinPerSecond: 10}, ratelimit.NewLimiter(ratelimit.NewMemoryStore()), nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer table.Close()
+
+			req := httptest.NewRequest(tt.method, "/books/1", strings.NewReader("payload"))
+			if tt.key != "" {
+				req.Header.Set("Idempotency-Key", tt.key)
+			}
+			rec := httptest.NewRecorder()
+			table.match(req).ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Errorf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if got := atomic.LoadInt32(&hits); got != tt.wantHits {
+				t.Errorf("upstream hits = %d, want %d", got, tt.wantHits)
+			}
+		})
+	}
+}
@@ -0,0 +1,409 @@
+package proxy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"math"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"reflect"
+	"regexp"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"apigateway/pkg/apperror"
+	"apigateway/pkg/clientip"
+	"apigateway/pkg/metrics"
+
+	"github.com/cenk/backoff"
+	"github.com/rs/zerolog/log"
+)
+
+type route struct {
+	cfg       *RouteConfig
+	pool      *Pool
+	rewrite   *regexp.Regexp
+	methods   map[string]bool
+	breaker   *Breaker
+	budget    *retryBudget
+	transport http.RoundTripper
+	upgrades  *upgrades
+	proxy     *httputil.ReverseProxy
+	// handler proxy 外層再包上 cache 等功能
+	handler http.Handler
+}
+
+var errNoHealthyTarget = errors.New("no healthy upstream")
+
+// newRoute 使用 pool 的 transport, 共用 table 的 retry budget、cache 與 upgraded connections.
+// prev 為 reload 前同名的 route, pool 與 circuit_breaker 都沒有變更時沿用它的 breaker, 保留 open 與 half-open 狀態
+func newRoute(cfg *RouteConfig, pool *Pool, prev *route, t *Table) (*route, error) {
+	r := &route{
+		cfg:       cfg,
+		pool:      pool,
+		methods:   map[string]bool{},
+		budget:    t.budget,
+		transport: pool.transport,
+		upgrades:  t.upgrades,
+	}
+	if prev != nil && prev.pool == pool && reflect.DeepEqual(prev.cfg.CircuitBreaker, cfg.CircuitBreaker) {
+		r.breaker = prev.breaker
+	} else {
+		r.breaker = newBreaker("route:"+cfg.Name, cfg.CircuitBreaker)
+	}
+
+	var err error
+	if cfg.Rewrite != nil {
+		if r.rewrite, err = regexp.Compile(cfg.Rewrite.Pattern); err != nil {
+			return nil, fmt.Errorf("route %s: invalid rewrite pattern: %v", cfg.Name, err)
+		}
+	}
+
+	for _, m := range cfg.Methods {
+		r.methods[strings.ToUpper(m)] = true
+	}
+
+	r.proxy = &httputil.ReverseProxy{
+		Director:       r.director,
+		Transport:      r,
+		ModifyResponse: r.modifyResponse,
+		ErrorHandler:   r.errorHandler,
+	}
+
+	r.handler = http.HandlerFunc(r.forward)
+	if cfg.Coalesce != nil {
+		r.handler = newCoalescer(cfg.Name, cfg.Coalesce, r.handler)
+	}
+	if cfg.Cache != nil {
+		if err := cfg.Cache.Check(); err != nil {
+			return nil, fmt.Errorf("route %s: %v", cfg.Name, err)
+		}
+		r.handler = t.cache.Handler(cfg.Name, cfg.Cache, r.handler)
+	}
+
+	return r, nil
+}
+
+// match host, path prefix (以 path segment 為單位) 與 method
+func (r *route) match(req *http.Request) bool {
+	if len(r.methods) > 0 && !r.methods[req.Method] {
+		return false
+	}
+	if r.cfg.Host != "" && !matchHost(r.cfg.Host, req.Host) {
+		return false
+	}
+	return matchPrefix(r.cfg.PathPrefix, req.URL.Path)
+}
+
+func (r *route) ServeHTTP(w http.ResponseWriter, req *http.Request) {
+	// 在 cache 與 coalesce 之前設定, 讓 cache key 可以使用. client 帶來的 subject header 已由 Table.Handler 移除
+	if r.cfg.ClientCert != nil {
+		subject, ok := verifiedSubject(req)
+		if !ok {
+			log.Warn().Str("route", r.cfg.Name).Str("client_ip", clientip.RemoteIP(req)).Msg("proxy: client certificate required")
+			writeError(w, http.StatusForbidden)
+			return
+		}
+		req.Header.Set(r.cfg.ClientCert.header(), subject)
+	}
+
+	if isUpgrade(req) {
+		r.serveUpgrade(w, req)
+		return
+	}
+	r.handler.ServeHTTP(w, req)
+}
+
+// verifiedSubject 只採用通過 CA 驗證的憑證
+func verifiedSubject(req *http.Request) (string, bool) {
+	if req.TLS == nil || len(req.TLS.VerifiedChains) == 0 || len(req.TLS.VerifiedChains[0]) == 0 {
+		return "", false
+	}
+	return req.TLS.VerifiedChains[0][0].Subject.String(), true
+}
+
+// forward 套用 route 的 timeout 後送往 upstream
+func (r *route) forward(w http.ResponseWriter, req *http.Request) {
+	if r.cfg.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(req.Context(), r.cfg.Timeout)
+		defer cancel()
+		req = req.WithContext(ctx)
+	}
+
+	r.proxy.ServeHTTP(w, req)
+}
+
+// RoundTrip 依 route 的 retry 設定送出, 重試時優先換一個 target
+func (r *route) RoundTrip(req *http.Request) (*http.Response, error) {
+	r.budget.request()
+
+	attempts := 1
+	getBody := func() io.ReadCloser { return req.Body }
+	if retry := r.cfg.Retry; retry != nil && retry.Attempts > 0 && retryable(req) {
+		if body, ok := bufferBody(req); ok {
+			attempts += retry.Attempts
+			getBody = body
+		}
+	}
+
+	var bo backoff.BackOff
+	var tried []*Target
+	for i := 1; ; i++ {
+		resp, target, err := r.attempt(req, getBody(), tried)
+		if i >= attempts || !shouldRetry(req.Context(), resp, err) {
+			return resp, err
+		}
+		if !r.budget.withdraw() {
+			metrics.RetryBudgetExhausted.Add(r.cfg.Name, 1)
+			log.Warn().Str("route", r.cfg.Name).Msg("proxy: retry budget exhausted")
+			return resp, err
+		}
+
+		event := log.Warn().Str("route", r.cfg.Name).Str("target", target.URL.String()).Int("attempt", i)
+		if err != nil {
+			event.Msgf("proxy: retrying: %v", err)
+		} else {
+			event.Msgf("proxy: retrying: upstream status %d", resp.StatusCode)
+			_, _ = io.Copy(ioutil.Discard, resp.Body)
+			resp.Body.Close()
+		}
+		metrics.ProxyRetries.Add(r.cfg.Name, 1)
+		tried = append(tried, target)
+
+		if bo == nil {
+			bo = newBackOff(r.cfg.Retry)
+		}
+		timer := time.NewTimer(bo.NextBackOff())
+		select {
+		case <-req.Context().Done():
+			timer.Stop()
+			return nil, req.Context().Err()
+		case <-timer.C:
+		}
+	}
+}
+
+// attempt 通過 circuit breaker 後挑選 target 送出一次, 連線錯誤與 5xx 記為失敗
+func (r *route) attempt(req *http.Request, body io.ReadCloser, exclude []*Target) (*http.Response, *Target, error) {
+	poolTicket, err := r.pool.breaker.allow()
+	if err != nil {
+		return nil, nil, err
+	}
+	routeTicket, err := r.breaker.allow()
+	if err != nil {
+		r.pool.breaker.cancel(poolTicket)
+		return nil, nil, err
+	}
+
+	target := r.pool.Pick(req, exclude...)
+	if target == nil {
+		r.pool.breaker.cancel(poolTicket)
+		r.breaker.cancel(routeTicket)
+		return nil, nil, errNoHealthyTarget
+	}
+
+	ctx, cancel := req.Context(), context.CancelFunc(func() {})
+	// upgrade 後的 connection 不受 per-try timeout 限制
+	if r.cfg.Retry != nil && r.cfg.Retry.PerTryTimeout > 0 && !isUpgrade(req) {
+		ctx, cancel = context.WithTimeout(ctx, r.cfg.Retry.PerTryTimeout)
+	}
+	out := toTarget(req, target.URL).WithContext(ctx)
+	out.Body = body
+
+	target.acquire()
+	resp, err := r.transport.RoundTrip(out)
+
+	switch {
+	case errors.Is(err, context.Canceled):
+		// client 中斷不算 upstream 的失敗
+		r.pool.breaker.cancel(poolTicket)
+		r.breaker.cancel(routeTicket)
+	default:
+		success := err == nil && resp.StatusCode < http.StatusInternalServerError
+		r.pool.breaker.record(poolTicket, success)
+		r.breaker.record(routeTicket, success)
+	}
+
+	if err != nil {
+		target.release()
+		cancel()
+		return nil, target, err
+	}
+	release := func() {
+		target.release()
+		cancel()
+	}
+	if rwc, ok := resp.Body.(io.ReadWriteCloser); ok && resp.StatusCode == http.StatusSwitchingProtocols {
+		resp.Body = &releaseConn{ReadWriteCloser: rwc, release: release}
+	} else {
+		resp.Body = &releaseBody{ReadCloser: resp.Body, release: release}
+	}
+	return resp, target, nil
+}
+
+// toTarget 複製 request 並指向 target, path 接在 target 的 path 之後
+func toTarget(req *http.Request, target *url.URL) *http.Request {
+	out := *req
+	u := *req.URL
+	out.URL = &u
+
+	u.Scheme = target.Scheme
+	u.Host = target.Host
+	u.Path = joinPath(target.Path, req.URL.Path)
+	u.RawPath = ""
+	if target.RawQuery == "" || req.URL.RawQuery == "" {
+		u.RawQuery = target.RawQuery + req.URL.RawQuery
+	} else {
+		u.RawQuery = target.RawQuery + "&" + req.URL.RawQuery
+	}
+	out.Host = target.Host
+	return &out
+}
+
+// releaseBody response body 關閉時才結束 target 的 active request
+type releaseBody struct {
+	io.ReadCloser
+	once    sync.Once
+	release func()
+}
+
+func (b *releaseBody) Close() error {
+	err := b.ReadCloser.Close()
+	b.once.Do(b.release)
+	return err
+}
+
+// releaseConn upgrade 後的 connection, 關閉時才結束 target 的 active request
+type releaseConn struct {
+	io.ReadWriteCloser
+	once    sync.Once
+	release func()
+}
+
+func (c *releaseConn) Close() error {
+	err := c.ReadWriteCloser.Close()
+	c.once.Do(c.release)
+	return err
+}
+
+// director 改寫 path 與 header 後送往 upstream
+func (r *route) director(req *http.Request) {
+	path := req.URL.Path
+	if r.cfg.StripPrefix {
+		path = "/" + strings.TrimLeft(strings.TrimPrefix(path, strings.TrimRight(r.cfg.PathPrefix, "/")), "/")
+	}
+	if r.rewrite != nil {
+		path = r.rewrite.ReplaceAllString(path, r.cfg.Rewrite.Replacement)
+	}
+
+	req.Header.Set("X-Forwarded-Host", req.Host)
+	if req.TLS != nil {
+		req.Header.Set("X-Forwarded-Proto", "https")
+	} else {
+		req.Header.Set("X-Forwarded-Proto", "http")
+	}
+
+	// scheme 與 host 在 RoundTrip 挑選 target 後才決定
+	req.URL.Path = path
+	req.URL.RawPath = ""
+
+	if _, ok := req.Header["User-Agent"]; !ok {
+		// explicitly disable User-Agent so it's not set to default value
+		req.Header.Set("User-Agent", "")
+	}
+
+	if r.cfg.Headers != nil {
+		applyHeaders(req.Header, r.cfg.Headers.Request)
+	}
+}
+
+func (r *route) modifyResponse(resp *http.Response) error {
+	if r.cfg.Headers != nil {
+		applyHeaders(resp.Header, r.cfg.Headers.Response)
+	}
+	return nil
+}
+
+// errorHandler circuit open 或沒有 healthy target 回 503, upstream 逾時回 504, 其他錯誤回 502.
+// log 的 error_class 區分錯誤原因, TLS handshake 失敗為 tls_handshake
+func (r *route) errorHandler(w http.ResponseWriter, req *http.Request, err error) {
+	status, class := http.StatusBadGateway, "upstream"
+	var openErr *circuitOpenError
+	var tlsErr *tlsHandshakeError
+	switch {
+	case errors.As(err, &openErr):
+		status, class = http.StatusServiceUnavailable, "circuit_open"
+		w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(openErr.retryAfter.Seconds()))))
+	case errors.Is(err, errNoHealthyTarget):
+		status, class = http.StatusServiceUnavailable, "no_healthy_upstream"
+	case errors.Is(err, context.DeadlineExceeded):
+		status, class = http.StatusGatewayTimeout, "timeout"
+	case errors.As(err, &tlsErr):
+		class = "tls_handshake"
+	}
+
+	log.Error().
+		Str("route", r.cfg.Name).
+		Str("upstream", r.pool.Name).
+		Str("error_class", class).
+		Int("status", status).
+		Msgf("proxy: %v", err)
+
+	writeError(w, status)
+}
+
+// writeError 以 problem+json 回應, 不包含 upstream 的錯誤內容
+func writeError(w http.ResponseWriter, status int) {
+	apperror.Write(w, nil, apperror.FromStatus(status))
+}
+
+func applyHeaders(h http.Header, rules *HeaderRules) {
+	if rules == nil {
+		return
+	}
+	for _, name := range rules.Remove {
+		h.Del(name)
+	}
+	for name, value := range rules.Add {
+		h.Set(name, value)
+	}
+}
+
+// matchHost 忽略 port, *.example.com 符合任一層 subdomain
+func matchHost(pattern, host string) bool {
+	if i := strings.LastIndex(host, ":"); i >= 0 && !strings.Contains(host[i:], "]") {
+		host = host[:i]
+	}
+	pattern, host = strings.ToLower(pattern), strings.ToLower(host)
+
+	if strings.HasPrefix(pattern, "*.") {
+		return strings.HasSuffix(host, pattern[1:])
+	}
+	return pattern == host
+}
+
+// matchPrefix /api 符合 /api 與 /api/x, 但不符合 /apix
+func matchPrefix(prefix, path string) bool {
+	if !strings.HasPrefix(path, prefix) {
+		return false
+	}
+	return len(path) == len(prefix) || strings.HasSuffix(prefix, "/") || path[len(prefix)] == '/'
+}
+
+func joinPath(a, b string) string {
+	aslash := strings.HasSuffix(a, "/")
+	bslash := strings.HasPrefix(b, "/")
+	switch {
+	case aslash && bslash:
+		return a + b[1:]
+	case !aslash && !bslash:
+		return a + "/" + b
+	}
+	return a + b
+}
@@ -0,0 +1,80 @@
+package proxy
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"apigateway/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRouteClientCert(t *testing.T) {
+	// upstream 回傳收到的 subject header
+	up := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		_, _ = w.Write([]byte(req.Header.Get("X-Partner") + "|" + req.Header.Get(DefaultClientCertHeader)))
+	}))
+	defer up.Close()
+
+	table, err := NewTable(&testLifecycle{}, Routes{
+		{Name: "partner", PathPrefix: "/partner", Upstream: up.URL, ClientCert: &ClientCertConfig{SubjectHeader: "X-Partner"}},
+		{Name: "public", PathPrefix: "/public", Upstream: up.URL},
+	}, nil, nil, nil, nil, ratelimit.NewLimiter(ratelimit.NewMemoryStore()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+	router := gin.New()
+	router.Use(table.Handler())
+
+	// 以 state 模擬 http server 完成的 TLS handshake
+	var state *tls.ConnectionState
+	gw := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		req.TLS = state
+		router.ServeHTTP(w, req)
+	}))
+	defer gw.Close()
+
+	verified := &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{{Subject: pkix.Name{CommonName: "partner"}}}}}
+	unverified := &tls.ConnectionState{PeerCertificates: []*x509.Certificate{{Subject: pkix.Name{CommonName: "intruder"}}}}
+
+	tests := []struct {
+		name       string
+		path       string
+		tls        *tls.ConnectionState
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "plain http", path: "/partner", wantStatus: http.StatusForbidden},
+		{name: "no verified chain", path: "/partner", tls: unverified, wantStatus: http.StatusForbidden},
+		{name: "verified", path: "/partner", tls: verified, wantStatus: http.StatusOK, wantBody: "CN=partner|"},
+		// client 自行帶來的 subject header 在所有 route 都會移除
+		{name: "route without client_cert", path: "/public", tls: verified, wantStatus: http.StatusOK, wantBody: "|"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			state = tt.tls
+			req, _ := http.NewRequest(http.MethodGet, gw.URL+tt.path, nil)
+			req.Header.Set("X-Partner", "CN=spoofed")
+			req.Header.Set(DefaultClientCertHeader, "CN=spoofed")
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if tt.wantBody != "" && string(body) != tt.wantBody {
+				t.Errorf("upstream subject = %q, want %q", body, tt.wantBody)
+			}
+		})
+	}
+}
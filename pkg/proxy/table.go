@@ -0,0 +1,381 @@
+package proxy
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"apigateway/pkg/cache"
+	"apigateway/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+)
+
+// Module Export proxy module
+var Module = fx.Options(
+	fx.Provide(NewTable),
+	fx.Invoke(RegisterHealthCheck),
+)
+
+// Table 目前生效的 route table, reload 時整份替換
+type Table struct {
+	transport     *http.Transport
+	grpcTransport *grpcTransport
+	budget        *retryBudget
+	limiter       *ratelimit.Limiter
+	cache         *cache.Cache
+	upgrades      *upgrades
+	routes        atomic.Value // []*route
+	aggregates    atomic.Value // []*aggregate
+	transcoders   atomic.Value // []*transcoder
+	// subjectHeaders 所有 route 的 client_cert.subject_header, 轉送前一律移除 client 帶來的值
+	subjectHeaders atomic.Value // []string
+
+	// mu 保護 pools, 只有 Update 與 Close 會修改
+	mu    sync.Mutex
+	pools map[string]*Pool
+}
+
+// NewTable ...
+func NewTable(lc fx.Lifecycle, routes Routes, upstreams Upstreams, aggregations Aggregations, transcodings Transcodings, budget *RetryBudgetConfig, limiter *ratelimit.Limiter, c *cache.Cache) (*Table, error) {
+	t := &Table{
+		grpcTransport: newGRPCTransport(),
+		budget:        newRetryBudget(budget),
+		limiter:       limiter,
+		cache:         c,
+		upgrades:      newUpgrades(),
+		transport: &http.Transport{
+			Proxy: http.ProxyFromEnvironment,
+			DialContext: (&net.Dialer{
+				Timeout:   30 * time.Second,
+				KeepAlive: 30 * time.Second,
+			}).DialContext,
+			MaxIdleConns:          100,
+			MaxIdleConnsPerHost:   20,
+			IdleConnTimeout:       90 * time.Second,
+			TLSHandshakeTimeout:   10 * time.Second,
+			ExpectContinueTimeout: 1 * time.Second,
+		},
+		pools: map[string]*Pool{},
+	}
+
+	if err := t.Update(routes, upstreams, aggregations, transcodings); err != nil {
+		return nil, err
+	}
+
+	lc.Append(fx.Hook{
+		OnStop: func(context.Context) error {
+			t.Close()
+			return nil
+		},
+	})
+	return t, nil
+}
+
+// Update 編譯新的 routes、aggregations、transcodings 與 upstream pools, 失敗時保留原本的 table.
+// 設定沒有變更的 pool 會沿用, 保留 health check 狀態.
+func (t *Table) Update(cfg Routes, upstreams Upstreams, aggregations Aggregations, transcodings Transcodings) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	pools := map[string]*Pool{}
+	var created []*Pool
+	fail := func(err error) error {
+		for _, p := range created {
+			p.close()
+		}
+		return err
+	}
+	pool := func(name string, uc *UpstreamConfig) (*Pool, error) {
+		if p, ok := pools[name]; ok {
+			return p, nil
+		}
+		if old, ok := t.pools[name]; ok && reflect.DeepEqual(old.cfg, uc) {
+			pools[name] = old
+			return old, nil
+		}
+
+		p, err := newPool(name, uc, t.transport, t.grpcTransport)
+		if err != nil {
+			return nil, err
+		}
+		p.startHealthCheck(p.transport)
+		created = append(created, p)
+		pools[name] = p
+		return p, nil
+	}
+
+	// resolve upstreams 中的名稱或單一 target 的 url, viper 的 map key 都是小寫
+	resolve := func(name, upstream string) (*Pool, error) {
+		if uc, ok := upstreams[strings.ToLower(upstream)]; ok {
+			return pool(strings.ToLower(upstream), uc)
+		}
+		if u, err := url.Parse(upstream); err == nil && u.Scheme != "" && u.Host != "" {
+			return pool(upstream, &UpstreamConfig{Targets: []*TargetConfig{{URL: upstream}}})
+		}
+		return nil, fmt.Errorf("route %s: upstream %q is neither a configured upstream nor a url", name, upstream)
+	}
+
+	previous := map[string]*route{}
+	oldRoutes, _ := t.routes.Load().([]*route)
+	for _, r := range oldRoutes {
+		previous[r.cfg.Name] = r
+	}
+
+	routes := make([]*route, 0, len(cfg))
+	names := map[string]bool{}
+	limits := map[string][]*ratelimit.Config{}
+	subjects := map[string]bool{DefaultClientCertHeader: true}
+
+	for _, rc := range cfg {
+		if names[rc.Name] {
+			return fail(fmt.Errorf("route %s is defined more than once", rc.Name))
+		}
+		names[rc.Name] = true
+
+		rules := map[string]bool{}
+		for _, rl := range rc.RateLimits {
+			if err := rl.Check(); err != nil {
+				return fail(fmt.Errorf("route %s: %v", rc.Name, err))
+			}
+			if rules[rl.Name] {
+				return fail(fmt.Errorf("route %s: rate limit %s is defined more than once", rc.Name, rl.Name))
+			}
+			rules[rl.Name] = true
+		}
+		limits[rc.Name] = rc.RateLimits
+		subjects[http.CanonicalHeaderKey(rc.ClientCert.header())] = true
+
+		p, err := resolve(rc.Name, rc.Upstream)
+		if err != nil {
+			return fail(err)
+		}
+
+		r, err := newRoute(rc, p, previous[rc.Name], t)
+		if err != nil {
+			return fail(err)
+		}
+		routes = append(routes, r)
+	}
+
+	aggregates := make([]*aggregate, 0, len(aggregations))
+	for _, ac := range aggregations {
+		if names[ac.Name] {
+			return fail(fmt.Errorf("route %s is defined more than once", ac.Name))
+		}
+		names[ac.Name] = true
+
+		a, err := newAggregate(ac, func(bc *BranchConfig) (*route, error) {
+			rc := &RouteConfig{Name: ac.Name + "/" + bc.Name, Upstream: bc.Upstream, Timeout: bc.Timeout}
+			p, err := resolve(rc.Name, rc.Upstream)
+			if err != nil {
+				return nil, err
+			}
+			return newRoute(rc, p, nil, t)
+		})
+		if err != nil {
+			return fail(err)
+		}
+		aggregates = append(aggregates, a)
+	}
+
+	transcoders := make([]*transcoder, 0, len(transcodings))
+	for _, tc := range transcodings {
+		if names[tc.Name] {
+			return fail(fmt.Errorf("route %s is defined more than once", tc.Name))
+		}
+		names[tc.Name] = true
+
+		p, err := resolve(tc.Name, tc.Upstream)
+		if err != nil {
+			return fail(err)
+		}
+		r, err := newRoute(&RouteConfig{Name: tc.Name, Upstream: tc.Upstream}, p, nil, t)
+		if err != nil {
+			return fail(err)
+		}
+		r.transport = p.grpcTransport
+
+		tr, err := newTranscoder(tc, r)
+		if err != nil {
+			return fail(err)
+		}
+		transcoders = append(transcoders, tr)
+	}
+
+	// 沒有被 route 引用的 upstream 也建立 pool, 方便在 admin 觀察
+	for name, uc := range upstreams {
+		if _, err := pool(name, uc); err != nil {
+			return fail(err)
+		}
+	}
+
+	// 有指定 host 的優先, 其次是較長的 prefix
+	sort.SliceStable(routes, func(i, j int) bool {
+		hi, hj := routes[i].cfg.Host != "", routes[j].cfg.Host != ""
+		if hi != hj {
+			return hi
+		}
+		return len(routes[i].cfg.PathPrefix) > len(routes[j].cfg.PathPrefix)
+	})
+
+	headers := make([]string, 0, len(subjects))
+	for h := range subjects {
+		headers = append(headers, h)
+	}
+	sort.Strings(headers)
+
+	t.subjectHeaders.Store(headers)
+	t.routes.Store(routes)
+	t.aggregates.Store(aggregates)
+	t.transcoders.Store(transcoders)
+	t.limiter.Configure(limits)
+
+	for name, old := range t.pools {
+		if pools[name] != old {
+			old.close()
+		}
+	}
+	unregisterBreakers(breakerNames(oldRoutes, t.pools), breakerNames(routes, pools))
+	t.pools = pools
+
+	log.Info().Int("routes", len(routes)).Int("upstreams", len(pools)).Msg("proxy route table updated")
+	return nil
+}
+
+// breakerNames 目前使用中的 breaker 名稱
+func breakerNames(routes []*route, pools map[string]*Pool) map[string]bool {
+	names := map[string]bool{}
+	for _, r := range routes {
+		if r.breaker != nil {
+			names[r.breaker.name] = true
+		}
+	}
+	for _, p := range pools {
+		if p.breaker != nil {
+			names[p.breaker.name] = true
+		}
+	}
+	return names
+}
+
+// SetRetryBudget 所有 route 共用同一個 budget, 直接套用新的設定
+func (t *Table) SetRetryBudget(cfg *RetryBudgetConfig) {
+	t.budget.update(cfg)
+}
+
+// Pools status of every upstream pool, sorted by name
+func (t *Table) Pools() []PoolStatus {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	status := make([]PoolStatus, 0, len(t.pools))
+	for _, p := range t.pools {
+		status = append(status, p.Status())
+	}
+	sort.Slice(status, func(i, j int) bool { return status[i].Name < status[j].Name })
+	return status
+}
+
+// Close 停止所有 health checker
+func (t *Table) Close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	for _, p := range t.pools {
+		p.close()
+	}
+	t.pools = map[string]*Pool{}
+}
+
+// match return the first route matching req, nil if none
+func (t *Table) match(req *http.Request) *route {
+	routes, _ := t.routes.Load().([]*route)
+	for _, r := range routes {
+		if r.match(req) {
+			return r
+		}
+	}
+	return nil
+}
+
+// matchAggregate aggregations 優先於一般的 routes
+func (t *Table) matchAggregate(req *http.Request) (*aggregate, map[string]string) {
+	aggregates, _ := t.aggregates.Load().([]*aggregate)
+	for _, a := range aggregates {
+		if params, ok := a.match(req); ok {
+			return a, params
+		}
+	}
+	return nil, nil
+}
+
+// matchTranscoder 回傳符合的 transcoding、binding 與 path 參數
+func (t *Table) matchTranscoder(req *http.Request) (*transcoder, *binding, map[string]string) {
+	transcoders, _ := t.transcoders.Load().([]*transcoder)
+	for _, tc := range transcoders {
+		if b, params := tc.match(req); b != nil {
+			return tc, b, params
+		}
+	}
+	return nil, nil, nil
+}
+
+// Handler 本地註冊的 handler 優先, 沒有符合的 gin route 時才查 proxy table
+func (t *Table) Handler() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if c.FullPath() != "" {
+			c.Next()
+			return
+		}
+
+		// route 驗證 client 憑證後才會設定自己的 subject header
+		headers, _ := t.subjectHeaders.Load().([]string)
+		for _, h := range headers {
+			c.Request.Header.Del(h)
+		}
+
+		if a, params := t.matchAggregate(c.Request); a != nil {
+			c.Set("proxy_route", a.cfg.Name)
+			if !t.limiter.Allow(c, a.cfg.Name) {
+				return
+			}
+			a.serve(c.Writer, c.Request, params)
+			c.Abort()
+			return
+		}
+
+		if tc, b, params := t.matchTranscoder(c.Request); tc != nil {
+			c.Set("proxy_route", tc.cfg.Name)
+			if !t.limiter.Allow(c, tc.cfg.Name) {
+				return
+			}
+			tc.serve(c.Writer, c.Request, b, params)
+			c.Abort()
+			return
+		}
+
+		r := t.match(c.Request)
+		if r == nil {
+			c.Next()
+			return
+		}
+
+		c.Set("proxy_route", r.cfg.Name)
+		if !t.limiter.Allow(c, r.cfg.Name) {
+			return
+		}
+		r.ServeHTTP(c.Writer, c.Request)
+		c.Abort()
+	}
+}
@@ -0,0 +1,233 @@
+package proxy
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	"apigateway/pkg/metrics"
+	"apigateway/pkg/watch"
+
+	"github.com/rs/zerolog/log"
+)
+
+// tlsReloadDelay 檔案變更後等待的時間, 避免 cert 與 key 只更新了其中一個
+const tlsReloadDelay = 500 * time.Millisecond
+
+// UpstreamTLSConfig 連線到 https target 時使用, 檔案變更時自動重新載入, 不影響已建立的連線
+type UpstreamTLSConfig struct {
+	// CertFile 與 KeyFile 為 mTLS 的 client 憑證, 需同時設定
+	CertFile string `yaml:"cert_file" mapstructure:"cert_file" validate:"required_with=KeyFile"`
+	KeyFile  string `yaml:"key_file" mapstructure:"key_file" validate:"required_with=CertFile"`
+	// CAFile 驗證 upstream 憑證的 CA bundle, 未設定時使用系統的 CA
+	CAFile string `yaml:"ca_file" mapstructure:"ca_file"`
+	// ServerName 覆寫 SNI 與驗證憑證時使用的名稱, 預設為 target 的 host
+	ServerName string `yaml:"server_name" mapstructure:"server_name"`
+	// Pins 憑證鏈中任一憑證 SubjectPublicKeyInfo 的 sha256, 格式為 sha256/<base64>, 設定後必須符合其中之一
+	Pins []string `validate:"dive,startswith=sha256/"`
+}
+
+// tlsHandshakeError 與 upstream 的 TLS handshake 或憑證驗證失敗
+type tlsHandshakeError struct {
+	addr string
+	err  error
+}
+
+func (e *tlsHandshakeError) Error() string {
+	return fmt.Sprintf("tls handshake with %s: %v", e.addr, e.err)
+}
+
+func (e *tlsHandshakeError) Unwrap() error {
+	return e.err
+}
+
+var errPinMismatch = errors.New("certificate chain does not match any pin")
+
+// upstreamTLS 保存目前的 client 憑證與 CA, handshake 時才讀取
+type upstreamTLS struct {
+	name string
+	cfg  *UpstreamTLSConfig
+	pins map[string]bool
+	// cert *tls.Certificate
+	cert atomic.Value
+	// roots *x509.CertPool, nil 為系統的 CA
+	roots   atomic.Value
+	watcher io.Closer
+	dialer  *net.Dialer
+}
+
+func newUpstreamTLS(name string, cfg *UpstreamTLSConfig) (*upstreamTLS, error) {
+	u := &upstreamTLS{
+		name:   name,
+		cfg:    cfg,
+		pins:   map[string]bool{},
+		dialer: &net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second},
+	}
+	for _, pin := range cfg.Pins {
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimPrefix(pin, "sha256/"))
+		if err != nil || len(raw) != sha256.Size {
+			return nil, fmt.Errorf("upstream %s: invalid pin %q", name, pin)
+		}
+		u.pins[string(raw)] = true
+	}
+	if err := u.load(); err != nil {
+		return nil, err
+	}
+
+	paths := []string{}
+	if cfg.CertFile != "" {
+		paths = append(paths, cfg.CertFile, cfg.KeyFile)
+	}
+	if cfg.CAFile != "" {
+		paths = append(paths, cfg.CAFile)
+	}
+	if len(paths) == 0 {
+		return u, nil
+	}
+
+	w, err := watch.Files(paths, tlsReloadDelay, func() {
+		if err := u.load(); err != nil {
+			log.Error().Str("upstream", name).Msgf("%v, keep using the previous certificates", err)
+			return
+		}
+		log.Info().Str("upstream", name).Msg("upstream tls certificates reloaded")
+	})
+	if err != nil {
+		return nil, fmt.Errorf("upstream %s: %v", name, err)
+	}
+	u.watcher = w
+	return u, nil
+}
+
+// load 全部檔案都讀取成功才替換
+func (u *upstreamTLS) load() error {
+	var cert *tls.Certificate
+	if u.cfg.CertFile != "" {
+		c, err := tls.LoadX509KeyPair(u.cfg.CertFile, u.cfg.KeyFile)
+		if err != nil {
+			return fmt.Errorf("upstream %s: tls: %s: %v", u.name, u.cfg.CertFile, err)
+		}
+		cert = &c
+	}
+
+	var roots *x509.CertPool
+	if u.cfg.CAFile != "" {
+		data, err := ioutil.ReadFile(u.cfg.CAFile)
+		if err != nil {
+			return fmt.Errorf("upstream %s: tls: %v", u.name, err)
+		}
+		roots = x509.NewCertPool()
+		if !roots.AppendCertsFromPEM(data) {
+			return fmt.Errorf("upstream %s: tls: %s: no certificates found", u.name, u.cfg.CAFile)
+		}
+	}
+
+	u.cert.Store(cert)
+	u.roots.Store(roots)
+	return nil
+}
+
+// dial 給 http.Transport 使用, 只協商 HTTP/1.1
+func (u *upstreamTLS) dial(ctx context.Context, network, addr string) (net.Conn, error) {
+	return u.handshake(ctx, network, addr, "http/1.1")
+}
+
+// dialTLS 給 grpcTransport 的 http2.Transport 使用
+func (u *upstreamTLS) dialTLS(network, addr string, _ *tls.Config) (net.Conn, error) {
+	return u.handshake(context.Background(), network, addr, "h2")
+}
+
+// handshake 連線並驗證 upstream 憑證, 失敗時回傳 *tlsHandshakeError
+func (u *upstreamTLS) handshake(ctx context.Context, network, addr, proto string) (net.Conn, error) {
+	conn, err := u.dialer.DialContext(ctx, network, addr)
+	if err != nil {
+		return nil, err
+	}
+
+	serverName := u.cfg.ServerName
+	if serverName == "" {
+		if serverName, _, err = net.SplitHostPort(addr); err != nil {
+			serverName = addr
+		}
+	}
+
+	tlsConn := tls.Client(conn, &tls.Config{
+		ServerName: serverName,
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{proto},
+		// 憑證在 handshake 後以目前的 CA 與 pins 驗證
+		InsecureSkipVerify: true,
+		GetClientCertificate: func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			if cert := u.cert.Load().(*tls.Certificate); cert != nil {
+				return cert, nil
+			}
+			return &tls.Certificate{}, nil
+		},
+	})
+
+	// go 1.14 沒有 HandshakeContext, 以 deadline 限制 handshake 的時間
+	deadline := time.Now().Add(10 * time.Second)
+	if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+		deadline = d
+	}
+	_ = conn.SetDeadline(deadline)
+	if err = tlsConn.Handshake(); err == nil {
+		err = u.verify(tlsConn.ConnectionState(), serverName)
+	}
+	if err != nil {
+		conn.Close()
+		metrics.UpstreamTLSErrors.Add(u.name, 1)
+		return nil, &tlsHandshakeError{addr: addr, err: err}
+	}
+	_ = conn.SetDeadline(time.Time{})
+	return tlsConn, nil
+}
+
+// verify 以 serverName 驗證, target 為 IP 時 SNI 不會送出, cs.ServerName 是空的
+func (u *upstreamTLS) verify(cs tls.ConnectionState, serverName string) error {
+	if len(cs.PeerCertificates) == 0 {
+		return errors.New("upstream presented no certificate")
+	}
+
+	opts := x509.VerifyOptions{
+		Roots:         u.roots.Load().(*x509.CertPool),
+		DNSName:       serverName,
+		Intermediates: x509.NewCertPool(),
+	}
+	for _, cert := range cs.PeerCertificates[1:] {
+		opts.Intermediates.AddCert(cert)
+	}
+	chains, err := cs.PeerCertificates[0].Verify(opts)
+	if err != nil {
+		return err
+	}
+	if len(u.pins) == 0 {
+		return nil
+	}
+
+	for _, chain := range chains {
+		for _, cert := range chain {
+			sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+			if u.pins[string(sum[:])] {
+				return nil
+			}
+		}
+	}
+	return errPinMismatch
+}
+
+func (u *upstreamTLS) close() {
+	if u.watcher != nil {
+		u.watcher.Close()
+	}
+}
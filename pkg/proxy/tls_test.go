@@ -0,0 +1,302 @@
+package proxy
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"encoding/pem"
+	"errors"
+	"io/ioutil"
+	"math/big"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testCert 測試用的憑證, files 寫入 dir 後回傳 cert 與 key 的路徑
+type testCert struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	der  []byte
+}
+
+func newTestCert(t *testing.T, name string, parent *testCert, dnsNames ...string) *testCert {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+	}
+	signer, signerKey := tmpl, key
+	if parent == nil {
+		tmpl.IsCA, tmpl.BasicConstraintsValid = true, true
+		tmpl.KeyUsage |= x509.KeyUsageCertSign
+	} else {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCert{cert: cert, key: key, der: der}
+}
+
+func (c *testCert) files(t *testing.T, dir string) (string, string) {
+	t.Helper()
+	keyDER, err := x509.MarshalECPrivateKey(c.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	name := c.cert.Subject.CommonName
+	certFile, keyFile := filepath.Join(dir, name+".crt"), filepath.Join(dir, name+".key")
+	if err := ioutil.WriteFile(certFile, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: c.der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := ioutil.WriteFile(keyFile, pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return certFile, keyFile
+}
+
+func (c *testCert) pin() string {
+	sum := sha256.Sum256(c.cert.RawSubjectPublicKeyInfo)
+	return "sha256/" + base64.StdEncoding.EncodeToString(sum[:])
+}
+
+// handshakeInfo upstream 在 handshake 時看到的 SNI 與 client 憑證
+type handshakeInfo struct {
+	serverName string
+	client     string
+}
+
+// tlsUpstream 以 ca 簽發的 upstream.internal 憑證接受連線, 要求 client 憑證但不驗證
+func tlsUpstream(t *testing.T, ca *testCert) (string, <-chan handshakeInfo) {
+	t.Helper()
+	server := newTestCert(t, "upstream", ca, "upstream.internal")
+	lis, err := tls.Listen("tcp", "127.0.0.1:0", &tls.Config{
+		Certificates: []tls.Certificate{{Certificate: [][]byte{server.der}, PrivateKey: server.key}},
+		ClientAuth:   tls.RequestClientCert,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { lis.Close() })
+
+	info := make(chan handshakeInfo, 16)
+	go func() {
+		for {
+			conn, err := lis.Accept()
+			if err != nil {
+				return
+			}
+			tc := conn.(*tls.Conn)
+			if tc.Handshake() == nil {
+				cs := tc.ConnectionState()
+				hi := handshakeInfo{serverName: cs.ServerName}
+				if len(cs.PeerCertificates) > 0 {
+					hi.client = cs.PeerCertificates[0].Subject.CommonName
+				}
+				info <- hi
+			}
+			conn.Close()
+		}
+	}()
+	return lis.Addr().String(), info
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "upstream-tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+func TestUpstreamTLSHandshake(t *testing.T) {
+	dir := tempDir(t)
+	ca := newTestCert(t, "ca", nil)
+	other := newTestCert(t, "other-ca", nil)
+	client := newTestCert(t, "gateway", ca)
+	caFile, _ := ca.files(t, dir)
+	otherFile, _ := other.files(t, dir)
+	certFile, keyFile := client.files(t, dir)
+
+	tests := []struct {
+		name       string
+		cfg        *UpstreamTLSConfig
+		wantErr    error
+		wantClient string
+	}{
+		{
+			name:    "system roots",
+			cfg:     &UpstreamTLSConfig{ServerName: "upstream.internal"},
+			wantErr: x509.UnknownAuthorityError{},
+		},
+		{
+			name:    "other ca",
+			cfg:     &UpstreamTLSConfig{CAFile: otherFile, ServerName: "upstream.internal"},
+			wantErr: x509.UnknownAuthorityError{},
+		},
+		{
+			// 未覆寫時以 target 的 host 驗證, IP 也需符合憑證
+			name:    "ip target",
+			cfg:     &UpstreamTLSConfig{CAFile: caFile},
+			wantErr: x509.HostnameError{},
+		},
+		{
+			name: "server name",
+			cfg:  &UpstreamTLSConfig{CAFile: caFile, ServerName: "upstream.internal"},
+		},
+		{
+			name:       "client certificate",
+			cfg:        &UpstreamTLSConfig{CAFile: caFile, ServerName: "upstream.internal", CertFile: certFile, KeyFile: keyFile},
+			wantClient: "gateway",
+		},
+		{
+			name: "ca pin",
+			cfg:  &UpstreamTLSConfig{CAFile: caFile, ServerName: "upstream.internal", Pins: []string{other.pin(), ca.pin()}},
+		},
+		{
+			name:    "pin mismatch",
+			cfg:     &UpstreamTLSConfig{CAFile: caFile, ServerName: "upstream.internal", Pins: []string{other.pin()}},
+			wantErr: errPinMismatch,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			addr, info := tlsUpstream(t, ca)
+			u, err := newUpstreamTLS("books", tt.cfg)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer u.close()
+
+			conn, err := u.dial(context.Background(), "tcp", addr)
+			if tt.wantErr != nil {
+				var he *tlsHandshakeError
+				if !errors.As(err, &he) {
+					t.Fatalf("dial() error = %v, want *tlsHandshakeError", err)
+				}
+				if !matchError(he.err, tt.wantErr) {
+					t.Errorf("dial() error = %v, want %T", he.err, tt.wantErr)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("dial() error = %v", err)
+			}
+			defer conn.Close()
+
+			if got := conn.(*tls.Conn).ConnectionState().NegotiatedProtocol; got != "" && got != "http/1.1" {
+				t.Errorf("protocol = %q, want http/1.1", got)
+			}
+			select {
+			case hi := <-info:
+				if hi.serverName != tt.cfg.ServerName || hi.client != tt.wantClient {
+					t.Errorf("upstream saw %+v, want sni %q client %q", hi, tt.cfg.ServerName, tt.wantClient)
+				}
+			case <-time.After(2 * time.Second):
+				t.Fatal("upstream did not complete the handshake")
+			}
+		})
+	}
+}
+
+// matchError errPinMismatch 比較值, x509 的錯誤比較型別
+func matchError(err, target error) bool {
+	switch target.(type) {
+	case x509.UnknownAuthorityError:
+		var e x509.UnknownAuthorityError
+		return errors.As(err, &e)
+	case x509.HostnameError:
+		var e x509.HostnameError
+		return errors.As(err, &e)
+	}
+	return errors.Is(err, target)
+}
+
+func TestUpstreamTLSConfig(t *testing.T) {
+	dir := tempDir(t)
+	caFile, _ := newTestCert(t, "ca", nil).files(t, dir)
+	empty := filepath.Join(dir, "empty.pem")
+	if err := ioutil.WriteFile(empty, []byte("no certificates"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		cfg     *UpstreamTLSConfig
+		wantErr bool
+	}{
+		{name: "ca file", cfg: &UpstreamTLSConfig{CAFile: caFile}},
+		{name: "invalid pin", cfg: &UpstreamTLSConfig{Pins: []string{"sha256/abc"}}, wantErr: true},
+		{name: "missing ca file", cfg: &UpstreamTLSConfig{CAFile: filepath.Join(dir, "missing.pem")}, wantErr: true},
+		{name: "ca file without certificates", cfg: &UpstreamTLSConfig{CAFile: empty}, wantErr: true},
+		{name: "missing client certificate", cfg: &UpstreamTLSConfig{CertFile: filepath.Join(dir, "a.crt"), KeyFile: filepath.Join(dir, "a.key")}, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			u, err := newUpstreamTLS("books", tt.cfg)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("newUpstreamTLS() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if u != nil {
+				u.close()
+			}
+		})
+	}
+}
+
+func TestUpstreamTLSReload(t *testing.T) {
+	dir := tempDir(t)
+	ca := newTestCert(t, "ca", nil)
+	caFile, _ := ca.files(t, dir)
+	addr, _ := tlsUpstream(t, ca)
+
+	u, err := newUpstreamTLS("books", &UpstreamTLSConfig{CAFile: caFile, ServerName: "upstream.internal"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer u.close()
+
+	// 讀取失敗時沿用原本的 CA
+	if err := ioutil.WriteFile(caFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := u.load(); err == nil {
+		t.Fatal("load() with a broken ca file succeeded")
+	}
+	conn, err := u.dial(context.Background(), "tcp", addr)
+	if err != nil {
+		t.Fatalf("dial() after a failed load = %v", err)
+	}
+	conn.Close()
+
+	// 換成其他 CA 後不再信任原本的 upstream
+	other := newTestCert(t, "ca", nil)
+	other.files(t, dir)
+	if err := u.load(); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := u.dial(context.Background(), "tcp", addr); err == nil {
+		t.Error("dial() succeeded after the ca was replaced")
+	}
+}
@@ -0,0 +1,561 @@
+package proxy
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"apigateway/pkg/apperror"
+
+	"github.com/rs/zerolog/log"
+	"golang.org/x/net/http2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protodesc"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/reflect/protoregistry"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+// maxGRPCMessage 與 grpc-go 預設的上限相同
+const maxGRPCMessage = 4 << 20
+
+// grpcMetadataPrefix 以此開頭的 header 去掉 prefix 後作為 gRPC metadata, response metadata 亦同
+const grpcMetadataPrefix = "Grpc-Metadata-"
+
+var errGRPCResponse = errors.New("invalid grpc response")
+
+type transcoder struct {
+	cfg      *TranscodeConfig
+	route    *route
+	bindings []*binding
+}
+
+type binding struct {
+	cfg      *BindingConfig
+	segments []string
+	method   protoreflect.MethodDescriptor
+	// path /<service>/<method>
+	path   string
+	body   []protoreflect.FieldDescriptor
+	params map[string][]protoreflect.FieldDescriptor
+}
+
+func newTranscoder(cfg *TranscodeConfig, r *route) (*transcoder, error) {
+	files, err := loadDescriptorSet(cfg.DescriptorSet)
+	if err != nil {
+		return nil, fmt.Errorf("transcoding %s: %v", cfg.Name, err)
+	}
+
+	tc := &transcoder{cfg: cfg, route: r}
+	for _, bc := range cfg.Bindings {
+		b, err := newBinding(bc, files)
+		if err != nil {
+			return nil, fmt.Errorf("transcoding %s: %s %s: %v", cfg.Name, bc.Method, bc.Path, err)
+		}
+		tc.bindings = append(tc.bindings, b)
+	}
+	return tc, nil
+}
+
+// loadDescriptorSet 讀取 protoc 產生的 FileDescriptorSet, 需包含所有 import 的檔案
+func loadDescriptorSet(path string) (*protoregistry.Files, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var set descriptorpb.FileDescriptorSet
+	if err := proto.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	files, err := protodesc.NewFiles(&set)
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return files, nil
+}
+
+func newBinding(cfg *BindingConfig, files *protoregistry.Files) (*binding, error) {
+	name := protoreflect.FullName(strings.Replace(strings.TrimPrefix(cfg.RPC, "/"), "/", ".", 1))
+	d, err := files.FindDescriptorByName(name)
+	if err != nil {
+		return nil, fmt.Errorf("rpc %s: %v", cfg.RPC, err)
+	}
+	md, ok := d.(protoreflect.MethodDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("rpc %s is not a method", cfg.RPC)
+	}
+	if md.IsStreamingClient() || md.IsStreamingServer() {
+		return nil, fmt.Errorf("rpc %s: streaming methods are not supported", cfg.RPC)
+	}
+
+	b := &binding{
+		cfg:      cfg,
+		segments: strings.Split(strings.Trim(cfg.Path, "/"), "/"),
+		method:   md,
+		path:     "/" + string(md.Parent().FullName()) + "/" + string(md.Name()),
+		params:   map[string][]protoreflect.FieldDescriptor{},
+	}
+
+	switch cfg.Body {
+	case "", "*":
+	default:
+		if b.body, err = fieldPath(md.Input(), cfg.Body); err != nil {
+			return nil, fmt.Errorf("body: %v", err)
+		}
+		if last := b.body[len(b.body)-1]; last.Kind() != protoreflect.MessageKind || last.IsList() || last.IsMap() {
+			return nil, fmt.Errorf("body: field %s is not a message", cfg.Body)
+		}
+	}
+
+	for _, s := range b.segments {
+		if !strings.HasPrefix(s, "{") || !strings.HasSuffix(s, "}") {
+			continue
+		}
+		param := s[1 : len(s)-1]
+		fds, err := fieldPath(md.Input(), param)
+		if err != nil {
+			return nil, fmt.Errorf("path parameter %s: %v", param, err)
+		}
+		if last := fds[len(fds)-1]; last.IsList() || last.IsMap() || last.Kind() == protoreflect.MessageKind {
+			return nil, fmt.Errorf("path parameter %s: field is not a scalar", param)
+		}
+		b.params[param] = fds
+	}
+	return b, nil
+}
+
+// fieldPath 以 . 分隔的欄位名稱, 可使用 proto 或 JSON 名稱
+func fieldPath(md protoreflect.MessageDescriptor, path string) ([]protoreflect.FieldDescriptor, error) {
+	var fds []protoreflect.FieldDescriptor
+	for i, name := range strings.Split(path, ".") {
+		if i > 0 {
+			prev := fds[i-1]
+			if prev.Kind() != protoreflect.MessageKind || prev.IsList() || prev.IsMap() {
+				return nil, fmt.Errorf("field %s is not a message", prev.Name())
+			}
+			md = prev.Message()
+		}
+		fd := md.Fields().ByName(protoreflect.Name(name))
+		if fd == nil {
+			fd = md.Fields().ByJSONName(name)
+		}
+		if fd == nil {
+			return nil, fmt.Errorf("no field %q in %s", name, md.FullName())
+		}
+		fds = append(fds, fd)
+	}
+	return fds, nil
+}
+
+// match method 與 path 都符合時回傳 binding 與 path 參數
+func (tc *transcoder) match(req *http.Request) (*binding, map[string]string) {
+	if tc.cfg.Host != "" && !matchHost(tc.cfg.Host, req.Host) {
+		return nil, nil
+	}
+	for _, b := range tc.bindings {
+		if !strings.EqualFold(b.cfg.Method, req.Method) {
+			continue
+		}
+		if params, ok := matchTemplate(b.segments, req.URL.Path); ok {
+			return b, params
+		}
+	}
+	return nil, nil
+}
+
+func (tc *transcoder) serve(w http.ResponseWriter, req *http.Request, b *binding, params map[string]string) {
+	msg, err := b.request(req, params)
+	if err != nil {
+		apperror.Write(w, req, statusError(codes.InvalidArgument, err.Error()))
+		return
+	}
+
+	ctx := req.Context()
+	if tc.cfg.Timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, tc.cfg.Timeout)
+		defer cancel()
+	}
+
+	start := time.Now()
+	out, md, st, err := tc.call(ctx, req, b, msg)
+	if err != nil {
+		tc.route.errorHandler(w, req, err)
+		return
+	}
+
+	for k, vs := range md {
+		for _, v := range vs {
+			w.Header().Add(grpcMetadataPrefix+k, v)
+		}
+	}
+
+	if st.code != codes.OK {
+		log.Debug().
+			Str("transcoding", tc.cfg.Name).
+			Str("rpc", b.path).
+			Str("code", st.code.String()).
+			Dur("latency", time.Since(start)).
+			Msg("transcode: rpc failed")
+		apperror.Write(w, req, statusError(st.code, st.message))
+		return
+	}
+
+	body, err := protojson.MarshalOptions{EmitUnpopulated: true}.Marshal(out)
+	if err != nil {
+		log.Error().Str("transcoding", tc.cfg.Name).Str("rpc", b.path).Msgf("transcode: %v", err)
+		writeError(w, http.StatusBadGateway)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json; charset=utf-8")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// request 依序將 body、query 參數與 path 參數寫入 request message
+func (b *binding) request(req *http.Request, params map[string]string) (*dynamicpb.Message, error) {
+	msg := dynamicpb.NewMessage(b.method.Input())
+
+	if b.cfg.Body != "" && req.Body != nil {
+		data, err := ioutil.ReadAll(io.LimitReader(req.Body, maxGRPCMessage+1))
+		if err != nil {
+			return nil, err
+		}
+		if len(data) > maxGRPCMessage {
+			return nil, errors.New("request body too large")
+		}
+		if len(bytes.TrimSpace(data)) > 0 {
+			target := msg.ProtoReflect()
+			for _, fd := range b.body {
+				target = target.Mutable(fd).Message()
+			}
+			if err := protojson.Unmarshal(data, target.Interface()); err != nil {
+				return nil, fmt.Errorf("body: %v", err)
+			}
+		}
+	}
+
+	for key, values := range req.URL.Query() {
+		fds, err := fieldPath(b.method.Input(), key)
+		if err != nil {
+			return nil, fmt.Errorf("query parameter %s: %v", key, err)
+		}
+		for _, v := range values {
+			if err := setField(msg, fds, v); err != nil {
+				return nil, fmt.Errorf("query parameter %s: %v", key, err)
+			}
+		}
+	}
+
+	for name, fds := range b.params {
+		v, err := url.PathUnescape(params[name])
+		if err != nil {
+			return nil, fmt.Errorf("path parameter %s: %v", name, err)
+		}
+		if err := setField(msg, fds, v); err != nil {
+			return nil, fmt.Errorf("path parameter %s: %v", name, err)
+		}
+	}
+	return msg, nil
+}
+
+// setField repeated 欄位會附加在後面
+func setField(msg protoreflect.ProtoMessage, fds []protoreflect.FieldDescriptor, s string) error {
+	m := msg.ProtoReflect()
+	for _, fd := range fds[:len(fds)-1] {
+		m = m.Mutable(fd).Message()
+	}
+
+	fd := fds[len(fds)-1]
+	if fd.IsMap() || (fd.Kind() == protoreflect.MessageKind || fd.Kind() == protoreflect.GroupKind) {
+		return fmt.Errorf("field %s is not a scalar", fd.Name())
+	}
+	v, err := parseScalar(fd, s)
+	if err != nil {
+		return err
+	}
+	if fd.IsList() {
+		m.Mutable(fd).List().Append(v)
+		return nil
+	}
+	m.Set(fd, v)
+	return nil
+}
+
+func parseScalar(fd protoreflect.FieldDescriptor, s string) (protoreflect.Value, error) {
+	switch fd.Kind() {
+	case protoreflect.StringKind:
+		return protoreflect.ValueOfString(s), nil
+	case protoreflect.BoolKind:
+		v, err := strconv.ParseBool(s)
+		return protoreflect.ValueOfBool(v), err
+	case protoreflect.Int32Kind, protoreflect.Sint32Kind, protoreflect.Sfixed32Kind:
+		v, err := strconv.ParseInt(s, 10, 32)
+		return protoreflect.ValueOfInt32(int32(v)), err
+	case protoreflect.Int64Kind, protoreflect.Sint64Kind, protoreflect.Sfixed64Kind:
+		v, err := strconv.ParseInt(s, 10, 64)
+		return protoreflect.ValueOfInt64(v), err
+	case protoreflect.Uint32Kind, protoreflect.Fixed32Kind:
+		v, err := strconv.ParseUint(s, 10, 32)
+		return protoreflect.ValueOfUint32(uint32(v)), err
+	case protoreflect.Uint64Kind, protoreflect.Fixed64Kind:
+		v, err := strconv.ParseUint(s, 10, 64)
+		return protoreflect.ValueOfUint64(v), err
+	case protoreflect.FloatKind:
+		v, err := strconv.ParseFloat(s, 32)
+		return protoreflect.ValueOfFloat32(float32(v)), err
+	case protoreflect.DoubleKind:
+		v, err := strconv.ParseFloat(s, 64)
+		return protoreflect.ValueOfFloat64(v), err
+	case protoreflect.BytesKind:
+		v, err := base64.StdEncoding.DecodeString(s)
+		if err != nil {
+			v, err = base64.URLEncoding.DecodeString(s)
+		}
+		return protoreflect.ValueOfBytes(v), err
+	case protoreflect.EnumKind:
+		if ev := fd.Enum().Values().ByName(protoreflect.Name(s)); ev != nil {
+			return protoreflect.ValueOfEnum(ev.Number()), nil
+		}
+		v, err := strconv.ParseInt(s, 10, 32)
+		if err != nil {
+			return protoreflect.Value{}, fmt.Errorf("invalid value %q for enum %s", s, fd.Enum().FullName())
+		}
+		return protoreflect.ValueOfEnum(protoreflect.EnumNumber(v)), nil
+	}
+	return protoreflect.Value{}, fmt.Errorf("unsupported field type %s", fd.Kind())
+}
+
+type grpcStatus struct {
+	code    codes.Code
+	message string
+}
+
+// call 透過 route 挑選 target 並送出一個 unary rpc, 回傳 response、response metadata 與 gRPC status
+func (tc *transcoder) call(ctx context.Context, req *http.Request, b *binding, msg proto.Message) (proto.Message, http.Header, grpcStatus, error) {
+	payload, err := proto.Marshal(msg)
+	if err != nil {
+		return nil, nil, grpcStatus{}, err
+	}
+	frame := make([]byte, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:5], uint32(len(payload)))
+	copy(frame[5:], payload)
+
+	out, err := http.NewRequestWithContext(ctx, http.MethodPost, b.path, bytes.NewReader(frame))
+	if err != nil {
+		return nil, nil, grpcStatus{}, err
+	}
+	out.Host = req.Host
+	out.RemoteAddr = req.RemoteAddr
+	out.Header = grpcMetadata(req)
+	out.Header.Set("Content-Type", "application/grpc")
+	out.Header.Set("Te", "trailers")
+	if deadline, ok := ctx.Deadline(); ok {
+		ms := time.Until(deadline).Milliseconds()
+		if ms < 1 {
+			ms = 1
+		}
+		out.Header.Set("Grpc-Timeout", strconv.FormatInt(ms, 10)+"m")
+	}
+
+	resp, err := tc.route.RoundTrip(out)
+	if err != nil {
+		return nil, nil, grpcStatus{}, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK || !strings.HasPrefix(resp.Header.Get("Content-Type"), "application/grpc") {
+		return nil, nil, grpcStatus{}, fmt.Errorf("%w: status %d, content-type %q", errGRPCResponse, resp.StatusCode, resp.Header.Get("Content-Type"))
+	}
+
+	data, err := ioutil.ReadAll(io.LimitReader(resp.Body, maxGRPCMessage+6))
+	if err != nil {
+		return nil, nil, grpcStatus{}, err
+	}
+
+	md := responseMetadata(resp.Header)
+	// trailers-only response 的 status 在 header 中
+	trailer := resp.Trailer
+	if resp.Header.Get("Grpc-Status") != "" {
+		trailer = resp.Header
+	}
+	st, err := parseGRPCStatus(trailer)
+	if err != nil {
+		return nil, nil, grpcStatus{}, err
+	}
+	for k, vs := range responseMetadata(resp.Trailer) {
+		md[k] = append(md[k], vs...)
+	}
+	if st.code != codes.OK {
+		return nil, md, st, nil
+	}
+
+	if len(data) < 5 {
+		return nil, nil, grpcStatus{}, fmt.Errorf("%w: missing message", errGRPCResponse)
+	}
+	if data[0] != 0 {
+		return nil, nil, grpcStatus{}, fmt.Errorf("%w: compressed message", errGRPCResponse)
+	}
+	size := binary.BigEndian.Uint32(data[1:5])
+	if int(size) != len(data)-5 {
+		return nil, nil, grpcStatus{}, fmt.Errorf("%w: expected one message of %d bytes, got %d bytes", errGRPCResponse, size, len(data)-5)
+	}
+
+	result := dynamicpb.NewMessage(b.method.Output())
+	if err := proto.Unmarshal(data[5:], result); err != nil {
+		return nil, nil, grpcStatus{}, fmt.Errorf("%w: %v", errGRPCResponse, err)
+	}
+	return result, md, st, nil
+}
+
+// grpcMetadata 轉送 Authorization、X-Request-Id 與 Grpc-Metadata-* header
+func grpcMetadata(req *http.Request) http.Header {
+	h := http.Header{}
+	for _, name := range []string{"Authorization", "X-Request-Id"} {
+		if v := req.Header.Get(name); v != "" {
+			h.Set(name, v)
+		}
+	}
+	for name, values := range req.Header {
+		if strings.HasPrefix(name, grpcMetadataPrefix) && len(name) > len(grpcMetadataPrefix) {
+			h[http.CanonicalHeaderKey(name[len(grpcMetadataPrefix):])] = values
+		}
+	}
+
+	if ip, _, err := net.SplitHostPort(req.RemoteAddr); err == nil {
+		if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
+			ip = prior + ", " + ip
+		}
+		h.Set("X-Forwarded-For", ip)
+	}
+	return h
+}
+
+// responseMetadata 去掉 gRPC 保留的 header
+func responseMetadata(h http.Header) http.Header {
+	md := http.Header{}
+	for name, values := range h {
+		lower := strings.ToLower(name)
+		if strings.HasPrefix(lower, "grpc-") || lower == "content-type" || lower == "trailer" || lower == "date" {
+			continue
+		}
+		md[name] = values
+	}
+	return md
+}
+
+func parseGRPCStatus(h http.Header) (grpcStatus, error) {
+	raw := h.Get("Grpc-Status")
+	if raw == "" {
+		return grpcStatus{}, fmt.Errorf("%w: missing grpc-status", errGRPCResponse)
+	}
+	code, err := strconv.ParseUint(raw, 10, 32)
+	if err != nil {
+		return grpcStatus{}, fmt.Errorf("%w: invalid grpc-status %q", errGRPCResponse, raw)
+	}
+	// grpc-message 以 percent-encoding 編碼
+	message, err := url.PathUnescape(h.Get("Grpc-Message"))
+	if err != nil {
+		message = h.Get("Grpc-Message")
+	}
+	return grpcStatus{code: codes.Code(code), message: message}, nil
+}
+
+// httpStatusFromCode 與 grpc-gateway 相同的對應
+func httpStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		// client closed request
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	}
+	return http.StatusInternalServerError
+}
+
+// statusError gRPC status 轉成 problem+json, code 為 snake_case 的 gRPC code, 例如 not_found.
+// Internal、Unknown 與 DataLoss 的 message 可能包含 upstream 的內部資訊, 改用通用訊息並放在 Err, release mode 不會輸出
+func statusError(code codes.Code, message string) *apperror.Error {
+	status := httpStatusFromCode(code)
+
+	var b strings.Builder
+	for i, r := range code.String() {
+		if r >= 'A' && r <= 'Z' {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r += 'a' - 'A'
+		}
+		b.WriteRune(r)
+	}
+
+	e := apperror.New(status, b.String(), message)
+	switch {
+	case code == codes.Internal || code == codes.Unknown || code == codes.DataLoss:
+		e.Message = apperror.FromStatus(status).Message
+		if message != "" {
+			e.Err = errors.New(message)
+		}
+	case message == "":
+		e.Message = apperror.FromStatus(status).Message
+	}
+	return e
+}
+
+// grpcTransport gRPC 需要 HTTP/2, http 的 target 以 h2c 連線, https 則以 ALPN 協商
+type grpcTransport struct {
+	h2c *http2.Transport
+	h2  *http2.Transport
+}
+
+func newGRPCTransport() *grpcTransport {
+	return &grpcTransport{
+		h2c: &http2.Transport{
+			AllowHTTP: true,
+			DialTLS: func(network, addr string, _ *tls.Config) (net.Conn, error) {
+				return (&net.Dialer{Timeout: 30 * time.Second, KeepAlive: 30 * time.Second}).Dial(network, addr)
+			},
+		},
+		h2: &http2.Transport{},
+	}
+}
+
+func (t *grpcTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	if req.URL.Scheme == "http" {
+		return t.h2c.RoundTrip(req)
+	}
+	return t.h2.RoundTrip(req)
+}
@@ -0,0 +1,194 @@
+package proxy
+
+import (
+	"context"
+	"encoding/json"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"apigateway/pkg/apperror"
+	"apigateway/pkg/pb/bookpb"
+	"apigateway/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// bookUpstream id 1 存在, 2 回 NotFound, 3 回帶有內部資訊的 Internal
+type bookUpstream struct {
+	bookpb.UnimplementedBookServiceServer
+}
+
+func (*bookUpstream) GetBook(ctx context.Context, req *bookpb.GetBookRequest) (*bookpb.Book, error) {
+	switch req.GetId() {
+	case 1:
+		return &bookpb.Book{Id: 1, Name: "Go"}, nil
+	case 3:
+		return nil, status.Error(codes.Internal, "dial tcp 10.0.0.1:3306: connection refused")
+	}
+	return nil, status.Errorf(codes.NotFound, "book %d not found", req.GetId())
+}
+
+func (*bookUpstream) CreateBook(ctx context.Context, req *bookpb.CreateBookRequest) (*bookpb.Book, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	_ = grpc.SetHeader(ctx, metadata.Pairs("x-caller", strings.Join(md.Get("x-caller"), ",")))
+	book := req.GetBook()
+	book.Id = 10
+	return book, nil
+}
+
+func transcodeRouter(t *testing.T) *gin.Engine {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := grpc.NewServer()
+	bookpb.RegisterBookServiceServer(srv, &bookUpstream{})
+	go func() { _ = srv.Serve(lis) }()
+	t.Cleanup(srv.Stop)
+
+	table, err := NewTable(&testLifecycle{}, nil, nil, nil, Transcodings{{
+		Name:          "books",
+		Upstream:      "http://" + lis.Addr().String(),
+		DescriptorSet: "../pb/bookpb/book.protoset",
+		Bindings: []*BindingConfig{
+			{Method: http.MethodGet, Path: "/v1/books/{id}", RPC: "apigateway.book.v1.BookService/GetBook"},
+			{Method: http.MethodPost, Path: "/v1/books", RPC: "apigateway.book.v1.BookService/CreateBook", Body: "book"},
+		},
+	}}, nil, ratelimit.NewLimiter(ratelimit.NewMemoryStore()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(table.Close)
+
+	router := gin.New()
+	router.Use(table.Handler())
+	return router
+}
+
+func TestTranscode(t *testing.T) {
+	router := transcodeRouter(t)
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		body       string
+		header     map[string]string
+		wantStatus int
+		// want response 需包含的欄位
+		want map[string]interface{}
+	}{
+		{
+			name: "get", method: http.MethodGet, path: "/v1/books/1",
+			wantStatus: http.StatusOK,
+			want:       map[string]interface{}{"id": float64(1), "name": "Go"},
+		},
+		{
+			name: "invalid path parameter", method: http.MethodGet, path: "/v1/books/abc",
+			wantStatus: http.StatusBadRequest,
+			want:       map[string]interface{}{"code": "invalid_argument"},
+		},
+		{
+			name: "grpc status", method: http.MethodGet, path: "/v1/books/2",
+			wantStatus: http.StatusNotFound,
+			want:       map[string]interface{}{"code": "not_found", "detail": "book 2 not found"},
+		},
+		{
+			name: "body field", method: http.MethodPost, path: "/v1/books", body: `{"name":"Go","isbn":"978-0134190440"}`,
+			header:     map[string]string{"Grpc-Metadata-X-Caller": "test"},
+			wantStatus: http.StatusOK,
+			want:       map[string]interface{}{"id": float64(10), "name": "Go", "isbn": "978-0134190440"},
+		},
+		{
+			name: "invalid body", method: http.MethodPost, path: "/v1/books", body: `{"name":`,
+			wantStatus: http.StatusBadRequest,
+			want:       map[string]interface{}{"code": "invalid_argument"},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			req := httptest.NewRequest(tt.method, tt.path, strings.NewReader(tt.body))
+			for k, v := range tt.header {
+				req.Header.Set(k, v)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d: %s", rec.Code, tt.wantStatus, rec.Body.String())
+			}
+			var got map[string]interface{}
+			if err := json.Unmarshal(rec.Body.Bytes(), &got); err != nil {
+				t.Fatal(err)
+			}
+			for k, v := range tt.want {
+				if got[k] != v {
+					t.Errorf("%s = %v, want %v", k, got[k], v)
+				}
+			}
+			if tt.header != nil && rec.Header().Get("Grpc-Metadata-X-Caller") != "test" {
+				t.Errorf("response metadata = %v, want Grpc-Metadata-X-Caller", rec.Header())
+			}
+		})
+	}
+}
+
+func TestTranscodeHidesInternalMessage(t *testing.T) {
+	router := transcodeRouter(t)
+	defer gin.SetMode(gin.Mode())
+
+	for _, mode := range []string{gin.ReleaseMode, gin.DebugMode} {
+		gin.SetMode(mode)
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/v1/books/3", nil))
+
+		if rec.Code != http.StatusInternalServerError {
+			t.Fatalf("%s: status = %d, want 500", mode, rec.Code)
+		}
+		var p apperror.Problem
+		if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+			t.Fatal(err)
+		}
+		if p.Code != "internal" || p.Detail != apperror.ErrInternal.Message {
+			t.Errorf("%s: problem = %+v, want the generic internal message", mode, p)
+		}
+		if hasCause := strings.Contains(p.Cause, "10.0.0.1"); hasCause != (mode != gin.ReleaseMode) {
+			t.Errorf("%s: cause = %q", mode, p.Cause)
+		}
+	}
+}
+
+func TestStatusError(t *testing.T) {
+	tests := []struct {
+		code        codes.Code
+		message     string
+		wantStatus  int
+		wantCode    string
+		wantMessage string
+	}{
+		{code: codes.NotFound, message: "book 2 not found", wantStatus: http.StatusNotFound, wantCode: "not_found", wantMessage: "book 2 not found"},
+		{code: codes.NotFound, wantStatus: http.StatusNotFound, wantCode: "not_found", wantMessage: apperror.ErrNotFound.Message},
+		{code: codes.ResourceExhausted, message: "quota", wantStatus: http.StatusTooManyRequests, wantCode: "resource_exhausted", wantMessage: "quota"},
+		{code: codes.Unknown, message: "panic: nil map", wantStatus: http.StatusInternalServerError, wantCode: "unknown", wantMessage: apperror.ErrInternal.Message},
+		{code: codes.DataLoss, message: "disk", wantStatus: http.StatusInternalServerError, wantCode: "data_loss", wantMessage: apperror.ErrInternal.Message},
+	}
+	for _, tt := range tests {
+		t.Run(tt.code.String(), func(t *testing.T) {
+			e := statusError(tt.code, tt.message)
+			if e.Status != tt.wantStatus || e.Code != tt.wantCode || e.Message != tt.wantMessage {
+				t.Errorf("statusError() = %d %s %q, want %d %s %q", e.Status, e.Code, e.Message, tt.wantStatus, tt.wantCode, tt.wantMessage)
+			}
+			if hidden := e.Message != tt.message && tt.message != ""; hidden != (e.Err != nil) {
+				t.Errorf("Err = %v, want the hidden message", e.Err)
+			}
+		})
+	}
+}
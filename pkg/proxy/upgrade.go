@@ -0,0 +1,487 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"apigateway/pkg/clientip"
+
+	"github.com/rs/zerolog/log"
+)
+
+// closeGoingAway WebSocket close code, server 關閉或 idle timeout 時使用
+const closeGoingAway = 1001
+
+// closeGrace 送出 close frame 後等待雙方關閉的時間
+const closeGrace = time.Second
+
+var (
+	errShuttingDown       = errors.New("server is shutting down")
+	errTooManyConnections = errors.New("too many connections")
+)
+
+// hopHeaders 不轉送的 hop-by-hop headers, upgrade 所需的 Connection 與 Upgrade 另外設定
+var hopHeaders = []string{
+	"Connection",
+	"Proxy-Connection",
+	"Keep-Alive",
+	"Proxy-Authenticate",
+	"Proxy-Authorization",
+	"Te",
+	"Trailer",
+	"Transfer-Encoding",
+	"Upgrade",
+}
+
+// upgrades 所有 route 的 upgraded connections, shutdown 時一起關閉
+type upgrades struct {
+	mu      sync.Mutex
+	closing bool
+	tunnels map[*tunnel]struct{}
+	clients map[string]int
+	wg      sync.WaitGroup
+}
+
+func newUpgrades() *upgrades {
+	return &upgrades{
+		tunnels: map[*tunnel]struct{}{},
+		clients: map[string]int{},
+	}
+}
+
+// acquire 佔用 key 的一個 connection 名額, max 為 0 表示不限制
+func (u *upgrades) acquire(key string, max int) (func(), error) {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closing {
+		return nil, errShuttingDown
+	}
+	if max > 0 && u.clients[key] >= max {
+		return nil, errTooManyConnections
+	}
+	u.clients[key]++
+	u.wg.Add(1)
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			u.mu.Lock()
+			if u.clients[key]--; u.clients[key] <= 0 {
+				delete(u.clients, key)
+			}
+			u.mu.Unlock()
+			u.wg.Done()
+		})
+	}, nil
+}
+
+func (u *upgrades) track(t *tunnel) bool {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+
+	if u.closing {
+		return false
+	}
+	u.tunnels[t] = struct{}{}
+	return true
+}
+
+func (u *upgrades) untrack(t *tunnel) {
+	u.mu.Lock()
+	delete(u.tunnels, t)
+	u.mu.Unlock()
+}
+
+// closeAll 拒絕新的 upgrade, 並對現有的 connection 送出 close frame
+func (u *upgrades) closeAll() {
+	u.mu.Lock()
+	u.closing = true
+	tunnels := make([]*tunnel, 0, len(u.tunnels))
+	for t := range u.tunnels {
+		tunnels = append(tunnels, t)
+	}
+	u.mu.Unlock()
+
+	if len(tunnels) > 0 {
+		log.Info().Int("connections", len(tunnels)).Msg("closing upgraded connections")
+	}
+	for _, t := range tunnels {
+		go t.stop(closeGoingAway, "server shutting down")
+	}
+}
+
+func (u *upgrades) wait(ctx context.Context) error {
+	done := make(chan struct{})
+	go func() {
+		u.wg.Wait()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func isUpgrade(req *http.Request) bool {
+	if req.Header.Get("Upgrade") == "" {
+		return false
+	}
+	for _, v := range req.Header.Values("Connection") {
+		for _, token := range strings.Split(v, ",") {
+			if strings.EqualFold(strings.TrimSpace(token), "upgrade") {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// serveUpgrade 轉送 Upgrade request, 成功後在兩端之間複製資料直到任一端關閉
+func (r *route) serveUpgrade(w http.ResponseWriter, req *http.Request) {
+	ws := r.cfg.WebSocket
+	if ws == nil {
+		writeError(w, http.StatusBadRequest)
+		return
+	}
+
+	ip := clientip.FromRequest(req)
+	release, err := r.upgrades.acquire(r.cfg.Name+"|"+ip, ws.MaxConnectionsPerClient)
+	switch err {
+	case nil:
+		defer release()
+	case errTooManyConnections:
+		log.Warn().Str("route", r.cfg.Name).Str("client_ip", ip).Msg("proxy: too many upgraded connections")
+		writeError(w, http.StatusTooManyRequests)
+		return
+	default:
+		writeError(w, http.StatusServiceUnavailable)
+		return
+	}
+
+	hj, ok := w.(http.Hijacker)
+	if !ok {
+		log.Error().Str("route", r.cfg.Name).Msg("proxy: response writer does not support hijacking")
+		writeError(w, http.StatusInternalServerError)
+		return
+	}
+
+	protocol := req.Header.Get("Upgrade")
+	outreq := req.Clone(req.Context())
+	outreq.RequestURI = ""
+	r.director(outreq)
+	removeHopHeaders(outreq.Header)
+	outreq.Header.Set("Connection", "Upgrade")
+	outreq.Header.Set("Upgrade", protocol)
+	if prior := req.Header.Get("X-Forwarded-For"); prior != "" {
+		outreq.Header.Set("X-Forwarded-For", prior+", "+clientip.RemoteIP(req))
+	} else {
+		outreq.Header.Set("X-Forwarded-For", clientip.RemoteIP(req))
+	}
+
+	resp, err := r.RoundTrip(outreq)
+	if err != nil {
+		r.errorHandler(w, req, err)
+		return
+	}
+	_ = r.modifyResponse(resp)
+
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		// upstream 拒絕 upgrade, 照原樣回傳
+		defer resp.Body.Close()
+		for k, v := range resp.Header {
+			w.Header()[k] = v
+		}
+		w.WriteHeader(resp.StatusCode)
+		_, _ = io.Copy(w, resp.Body)
+		return
+	}
+
+	backend, ok := resp.Body.(io.ReadWriteCloser)
+	if !ok {
+		resp.Body.Close()
+		writeError(w, http.StatusBadGateway)
+		return
+	}
+
+	conn, brw, err := hj.Hijack()
+	if err != nil {
+		backend.Close()
+		log.Error().Str("route", r.cfg.Name).Msgf("proxy: hijack: %v", err)
+		return
+	}
+	// 清除 http.read_timeout 與 write_timeout 設定的 deadline, idle 由 tunnel.watch 處理
+	if err := conn.SetDeadline(time.Time{}); err != nil {
+		conn.Close()
+		backend.Close()
+		return
+	}
+
+	_, _ = brw.WriteString("HTTP/1.1 101 Switching Protocols\r\n")
+	_ = resp.Header.Write(brw)
+	_, _ = brw.WriteString("\r\n")
+	if err := brw.Flush(); err != nil {
+		conn.Close()
+		backend.Close()
+		return
+	}
+
+	t := newTunnel(conn, brw.Reader, backend, strings.EqualFold(protocol, "websocket"), ws.IdleTimeout)
+	if !r.upgrades.track(t) {
+		t.stop(closeGoingAway, "server shutting down")
+		return
+	}
+	defer r.upgrades.untrack(t)
+
+	log.Debug().Str("route", r.cfg.Name).Str("client_ip", ip).Str("protocol", protocol).Msg("proxy: connection upgraded")
+	t.run()
+	log.Debug().Str("route", r.cfg.Name).Str("client_ip", ip).Str("protocol", protocol).Msg("proxy: upgraded connection closed")
+}
+
+// tunnel 在 client 與 upstream 之間複製資料, websocket 以 frame 為單位以便插入 close frame
+type tunnel struct {
+	client    net.Conn
+	clientR   *bufio.Reader
+	backend   io.ReadWriteCloser
+	websocket bool
+	idle      time.Duration
+
+	// 寫入時持有, 確保 close frame 不會插在其他 frame 中間
+	clientMu  sync.Mutex
+	backendMu sync.Mutex
+
+	closing  int32 // atomic
+	last     int64 // atomic, unix nano
+	done     chan struct{}
+	stopOnce sync.Once
+}
+
+func newTunnel(client net.Conn, clientR *bufio.Reader, backend io.ReadWriteCloser, websocket bool, idle time.Duration) *tunnel {
+	return &tunnel{
+		client:    client,
+		clientR:   clientR,
+		backend:   backend,
+		websocket: websocket,
+		idle:      idle,
+		last:      time.Now().UnixNano(),
+		done:      make(chan struct{}),
+	}
+}
+
+// run 任一方向結束後關閉兩端
+func (t *tunnel) run() {
+	errc := make(chan error, 2)
+	go func() { errc <- t.copy(t.backend, &t.backendMu, t.clientR) }()
+	go func() { errc <- t.copy(t.client, &t.clientMu, t.backend) }()
+	if t.idle > 0 {
+		go t.watch()
+	}
+
+	<-errc
+	if atomic.LoadInt32(&t.closing) == 1 {
+		// 正在關閉時給另一端一點時間回應 close frame
+		select {
+		case <-errc:
+			errc <- nil
+		case <-time.After(closeGrace):
+		}
+	}
+	t.client.Close()
+	t.backend.Close()
+	<-errc
+	close(t.done)
+}
+
+// stop 送出 close frame, 之後收到的資料不再轉送, closeGrace 後強制關閉
+func (t *tunnel) stop(code int, reason string) {
+	t.stopOnce.Do(func() {
+		atomic.StoreInt32(&t.closing, 1)
+		if !t.websocket {
+			t.client.Close()
+			t.backend.Close()
+			return
+		}
+
+		_ = t.client.SetWriteDeadline(time.Now().Add(closeGrace))
+		go func() {
+			t.clientMu.Lock()
+			_, _ = t.client.Write(closeFrame(code, reason, false))
+			t.clientMu.Unlock()
+		}()
+		go func() {
+			t.backendMu.Lock()
+			_, _ = t.backend.Write(closeFrame(code, reason, true))
+			t.backendMu.Unlock()
+		}()
+
+		select {
+		case <-t.done:
+		case <-time.After(closeGrace):
+			t.client.Close()
+			t.backend.Close()
+		}
+	})
+}
+
+// watch 兩個方向都沒有資料超過 idle 時關閉
+func (t *tunnel) watch() {
+	interval := t.idle / 2
+	if interval < 100*time.Millisecond {
+		interval = 100 * time.Millisecond
+	}
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-t.done:
+			return
+		case now := <-ticker.C:
+			if now.Sub(time.Unix(0, atomic.LoadInt64(&t.last))) >= t.idle {
+				t.stop(closeGoingAway, "idle timeout")
+				return
+			}
+		}
+	}
+}
+
+func (t *tunnel) touch() {
+	atomic.StoreInt64(&t.last, time.Now().UnixNano())
+}
+
+func (t *tunnel) copy(dst io.Writer, mu *sync.Mutex, src io.Reader) error {
+	if t.websocket {
+		return t.copyFrames(dst, mu, src)
+	}
+
+	buf := make([]byte, 32*1024)
+	for {
+		n, err := src.Read(buf)
+		if n > 0 {
+			t.touch()
+			mu.Lock()
+			_, werr := dst.Write(buf[:n])
+			mu.Unlock()
+			if werr != nil {
+				return werr
+			}
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// copyFrames 原樣轉送 WebSocket frame (RFC 6455 5.2), 關閉中時丟棄
+func (t *tunnel) copyFrames(dst io.Writer, mu *sync.Mutex, src io.Reader) error {
+	header := make([]byte, 14)
+	for {
+		if _, err := io.ReadFull(src, header[:2]); err != nil {
+			return err
+		}
+		t.touch()
+
+		n := 2
+		length := uint64(header[1] & 0x7f)
+		switch length {
+		case 126:
+			n += 2
+		case 127:
+			n += 8
+		}
+		masked := header[1]&0x80 != 0
+		if masked {
+			n += 4
+		}
+		if _, err := io.ReadFull(src, header[2:n]); err != nil {
+			return err
+		}
+		switch length {
+		case 126:
+			length = uint64(binary.BigEndian.Uint16(header[2:4]))
+		case 127:
+			length = binary.BigEndian.Uint64(header[2:10])
+		}
+
+		if atomic.LoadInt32(&t.closing) == 1 {
+			if _, err := io.CopyN(ioutil.Discard, src, int64(length)); err != nil {
+				return err
+			}
+			continue
+		}
+
+		mu.Lock()
+		_, err := dst.Write(header[:n])
+		if err == nil {
+			_, err = io.CopyN(dst, src, int64(length))
+		}
+		mu.Unlock()
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// closeFrame client 送往 server 的 frame 必須 mask
+func closeFrame(code int, reason string, mask bool) []byte {
+	if len(reason) > 123 {
+		reason = reason[:123]
+	}
+	payload := make([]byte, 2+len(reason))
+	binary.BigEndian.PutUint16(payload, uint16(code))
+	copy(payload[2:], reason)
+
+	frame := []byte{0x88, byte(len(payload))}
+	if mask {
+		key := make([]byte, 4)
+		_, _ = rand.Read(key)
+		frame[1] |= 0x80
+		frame = append(frame, key...)
+		for i := range payload {
+			payload[i] ^= key[i%4]
+		}
+	}
+	return append(frame, payload...)
+}
+
+func removeHopHeaders(h http.Header) {
+	for _, v := range h.Values("Connection") {
+		for _, name := range strings.Split(v, ",") {
+			if name = strings.TrimSpace(name); name != "" {
+				h.Del(name)
+			}
+		}
+	}
+	for _, name := range hopHeaders {
+		h.Del(name)
+	}
+}
+
+// CloseUpgraded 對所有 upgraded connections 送出 close frame, 之後的 upgrade request 回 503.
+// 給 http.Server.RegisterOnShutdown 使用
+func (t *Table) CloseUpgraded() {
+	t.upgrades.closeAll()
+}
+
+// WaitUpgraded 等待所有 upgraded connections 結束
+func (t *Table) WaitUpgraded(ctx context.Context) error {
+	if err := t.upgrades.wait(ctx); err != nil {
+		return fmt.Errorf("upgraded connections still open: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,229 @@
+package proxy
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"apigateway/pkg/ratelimit"
+)
+
+// echoUpstream 接受 Upgrade: echo 與 websocket, 之後原樣回傳收到的資料, 其他 protocol 回 403
+func echoUpstream(t *testing.T) *httptest.Server {
+	t.Helper()
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		protocol := req.Header.Get("Upgrade")
+		if protocol != "echo" && protocol != "websocket" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		conn, brw, err := w.(http.Hijacker).Hijack()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		fmt.Fprintf(brw, "HTTP/1.1 101 Switching Protocols\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", protocol)
+		_ = brw.Flush()
+		_, _ = io.Copy(conn, brw)
+	}))
+	t.Cleanup(srv.Close)
+	return srv
+}
+
+// gateway 只有 "ws" 允許 upgrade, "plain" 沒有 websocket 設定
+func gateway(t *testing.T, ws *WebSocketConfig) (*Table, string) {
+	t.Helper()
+	up := echoUpstream(t)
+	table, err := NewTable(&testLifecycle{}, Routes{
+		{Name: "ws", PathPrefix: "/ws", Upstream: up.URL, WebSocket: ws},
+		{Name: "plain", PathPrefix: "/plain", Upstream: up.URL},
+	}, nil, nil, nil, nil, ratelimit.NewLimiter(ratelimit.NewMemoryStore()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(table.Close)
+
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		table.match(req).ServeHTTP(w, req)
+	}))
+	t.Cleanup(srv.Close)
+	return table, srv.Listener.Addr().String()
+}
+
+// dialUpgrade 送出 Upgrade request, 回傳的 reader 接續讀取 upgrade 後的資料
+func dialUpgrade(t *testing.T, addr, path, protocol string) (net.Conn, *bufio.Reader, int) {
+	t.Helper()
+	conn, err := net.Dial("tcp", addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+	_ = conn.SetDeadline(time.Now().Add(5 * time.Second))
+
+	fmt.Fprintf(conn, "GET %s HTTP/1.1\r\nHost: gateway\r\nConnection: Upgrade\r\nUpgrade: %s\r\n\r\n", path, protocol)
+	r := bufio.NewReader(conn)
+	resp, err := http.ReadResponse(r, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.StatusCode != http.StatusSwitchingProtocols {
+		resp.Body.Close()
+	}
+	return conn, r, resp.StatusCode
+}
+
+func TestIsUpgrade(t *testing.T) {
+	tests := []struct {
+		connection string
+		upgrade    string
+		want       bool
+	}{
+		{connection: "Upgrade", upgrade: "websocket", want: true},
+		{connection: "keep-alive, upgrade", upgrade: "h2c", want: true},
+		{connection: "keep-alive", upgrade: "websocket", want: false},
+		{connection: "Upgrade", want: false},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/", nil)
+		req.Header.Set("Connection", tt.connection)
+		if tt.upgrade != "" {
+			req.Header.Set("Upgrade", tt.upgrade)
+		}
+		if got := isUpgrade(req); got != tt.want {
+			t.Errorf("isUpgrade(Connection: %q, Upgrade: %q) = %v, want %v", tt.connection, tt.upgrade, got, tt.want)
+		}
+	}
+}
+
+func TestUpgradeProxy(t *testing.T) {
+	_, addr := gateway(t, &WebSocketConfig{})
+
+	tests := []struct {
+		name       string
+		path       string
+		protocol   string
+		wantStatus int
+	}{
+		{name: "upgraded", path: "/ws", protocol: "echo", wantStatus: http.StatusSwitchingProtocols},
+		{name: "route without websocket", path: "/plain", protocol: "echo", wantStatus: http.StatusBadRequest},
+		{name: "upstream rejects", path: "/ws", protocol: "nope", wantStatus: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conn, r, status := dialUpgrade(t, addr, tt.path, tt.protocol)
+			if status != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", status, tt.wantStatus)
+			}
+			if status != http.StatusSwitchingProtocols {
+				return
+			}
+
+			if _, err := io.WriteString(conn, "ping"); err != nil {
+				t.Fatal(err)
+			}
+			buf := make([]byte, 4)
+			if _, err := io.ReadFull(r, buf); err != nil || string(buf) != "ping" {
+				t.Errorf("echo = %q, %v", buf, err)
+			}
+		})
+	}
+}
+
+func TestUpgradeMaxConnectionsPerClient(t *testing.T) {
+	_, addr := gateway(t, &WebSocketConfig{MaxConnectionsPerClient: 1})
+
+	first, _, status := dialUpgrade(t, addr, "/ws", "echo")
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("first status = %d", status)
+	}
+	if _, _, status := dialUpgrade(t, addr, "/ws", "echo"); status != http.StatusTooManyRequests {
+		t.Fatalf("second status = %d, want 429", status)
+	}
+
+	// 第一個 connection 結束後釋放名額
+	first.Close()
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		_, _, status := dialUpgrade(t, addr, "/ws", "echo")
+		if status == http.StatusSwitchingProtocols {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("status after the first connection closed = %d, want 101", status)
+		}
+		time.Sleep(20 * time.Millisecond)
+	}
+}
+
+func TestUpgradeShutdown(t *testing.T) {
+	table, addr := gateway(t, &WebSocketConfig{})
+
+	_, r, status := dialUpgrade(t, addr, "/ws", "echo")
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d", status)
+	}
+
+	table.CloseUpgraded()
+	if _, err := r.ReadByte(); err != io.EOF {
+		t.Errorf("read after CloseUpgraded = %v, want EOF", err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := table.WaitUpgraded(ctx); err != nil {
+		t.Error(err)
+	}
+
+	if _, _, status := dialUpgrade(t, addr, "/ws", "echo"); status != http.StatusServiceUnavailable {
+		t.Errorf("status after CloseUpgraded = %d, want 503", status)
+	}
+}
+
+func TestWebSocketIdleTimeout(t *testing.T) {
+	_, addr := gateway(t, &WebSocketConfig{IdleTimeout: 200 * time.Millisecond})
+
+	_, r, status := dialUpgrade(t, addr, "/ws", "websocket")
+	if status != http.StatusSwitchingProtocols {
+		t.Fatalf("status = %d", status)
+	}
+
+	// 送給 client 的 close frame 不 mask
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(r, header); err != nil {
+		t.Fatal(err)
+	}
+	if header[0] != 0x88 || header[1]&0x80 != 0 {
+		t.Fatalf("frame header = %x, want an unmasked close frame", header)
+	}
+	payload := make([]byte, header[1])
+	if _, err := io.ReadFull(r, payload); err != nil {
+		t.Fatal(err)
+	}
+	if code := binary.BigEndian.Uint16(payload); code != closeGoingAway {
+		t.Errorf("close code = %d, want %d", code, closeGoingAway)
+	}
+	if reason := string(payload[2:]); !strings.Contains(reason, "idle") {
+		t.Errorf("close reason = %q", reason)
+	}
+}
+
+func TestCloseFrame(t *testing.T) {
+	frame := closeFrame(closeGoingAway, "bye", true)
+	if frame[0] != 0x88 || frame[1] != 0x80|5 {
+		t.Fatalf("frame header = %x", frame[:2])
+	}
+	key, payload := frame[2:6], frame[6:]
+	for i := range payload {
+		payload[i] ^= key[i%4]
+	}
+	if code := binary.BigEndian.Uint16(payload); code != closeGoingAway || string(payload[2:]) != "bye" {
+		t.Errorf("unmasked payload = %d %q", code, payload[2:])
+	}
+}
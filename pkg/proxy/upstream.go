@@ -0,0 +1,195 @@
+package proxy
+
+import (
+	"fmt"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+
+	"golang.org/x/net/http2"
+)
+
+// Target 一個 upstream 位址
+type Target struct {
+	URL    *url.URL
+	Weight int
+
+	healthy int32 // atomic, 1 為 healthy
+	active  int64 // atomic, 進行中的 request 數
+
+	// 以下只由 health checker 使用
+	mu        sync.Mutex
+	successes int
+	failures  int
+	lastError string
+}
+
+// Healthy ...
+func (t *Target) Healthy() bool {
+	return atomic.LoadInt32(&t.healthy) == 1
+}
+
+// Active number of in-flight requests
+func (t *Target) Active() int64 {
+	return atomic.LoadInt64(&t.active)
+}
+
+func (t *Target) acquire() {
+	atomic.AddInt64(&t.active, 1)
+}
+
+func (t *Target) release() {
+	atomic.AddInt64(&t.active, -1)
+}
+
+// Pool a named set of targets with a balancer and an optional health checker
+type Pool struct {
+	Name string
+
+	cfg      *UpstreamConfig
+	targets  []*Target
+	balancer balancer
+	breaker  *Breaker
+	// transport 與 grpcTransport 沒有設定 tls 時為 table 共用的
+	transport     *http.Transport
+	grpcTransport *grpcTransport
+	tls           *upstreamTLS
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+// TargetStatus ...
+type TargetStatus struct {
+	URL               string `json:"url"`
+	Weight            int    `json:"weight"`
+	Healthy           bool   `json:"healthy"`
+	ActiveConnections int64  `json:"active_connections"`
+	LastCheckError    string `json:"last_check_error,omitempty"`
+}
+
+// PoolStatus ...
+type PoolStatus struct {
+	Name        string         `json:"name"`
+	Policy      string         `json:"policy"`
+	HealthCheck bool           `json:"health_check"`
+	Circuit     string         `json:"circuit,omitempty"`
+	Targets     []TargetStatus `json:"targets"`
+}
+
+// newPool 有設定 tls 時建立自己的 transport, 否則沿用傳入的
+func newPool(name string, cfg *UpstreamConfig, transport *http.Transport, grpc *grpcTransport) (*Pool, error) {
+	p := &Pool{
+		Name:          name,
+		cfg:           cfg,
+		breaker:       newBreaker("upstream:"+name, cfg.CircuitBreaker),
+		transport:     transport,
+		grpcTransport: grpc,
+		stop:          make(chan struct{}),
+	}
+
+	for _, tc := range cfg.Targets {
+		u, err := url.Parse(tc.URL)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			return nil, fmt.Errorf("upstream %s: invalid target %q", name, tc.URL)
+		}
+		weight := tc.Weight
+		if weight <= 0 {
+			weight = 1
+		}
+		p.targets = append(p.targets, &Target{URL: u, Weight: weight, healthy: 1})
+	}
+
+	var err error
+	if p.balancer, err = newBalancer(cfg.Balancer, p.targets); err != nil {
+		return nil, fmt.Errorf("upstream %s: %v", name, err)
+	}
+
+	if cfg.TLS != nil {
+		if p.tls, err = newUpstreamTLS(name, cfg.TLS); err != nil {
+			return nil, err
+		}
+		p.transport = transport.Clone()
+		p.transport.DialTLSContext = p.tls.dial
+		// 經過 HTTPS proxy 時不會呼叫 DialTLSContext, 因此設定 tls 的 upstream 一律直接連線
+		p.transport.Proxy = nil
+		p.grpcTransport = &grpcTransport{h2c: grpc.h2c, h2: &http2.Transport{DialTLS: p.tls.dialTLS}}
+	}
+
+	return p, nil
+}
+
+// Pick 以 balancer 挑選 healthy target, 全部不健康時回傳 nil.
+// 優先避開 exclude 中的 target, 沒有其他選擇時才會選到
+func (p *Pool) Pick(req *http.Request, exclude ...*Target) *Target {
+	healthy := make([]*Target, 0, len(p.targets))
+	others := make([]*Target, 0, len(p.targets))
+	for _, t := range p.targets {
+		if !t.Healthy() {
+			continue
+		}
+		healthy = append(healthy, t)
+		if !containsTarget(exclude, t) {
+			others = append(others, t)
+		}
+	}
+	if len(others) > 0 {
+		return p.balancer.next(req, others)
+	}
+	if len(healthy) == 0 {
+		return nil
+	}
+	return p.balancer.next(req, healthy)
+}
+
+func containsTarget(targets []*Target, t *Target) bool {
+	for _, x := range targets {
+		if x == t {
+			return true
+		}
+	}
+	return false
+}
+
+// Status snapshot for the admin endpoint
+func (p *Pool) Status() PoolStatus {
+	status := PoolStatus{
+		Name:        p.Name,
+		Policy:      RoundRobin,
+		HealthCheck: p.cfg.HealthCheck != nil,
+	}
+	if p.cfg.Balancer != nil && p.cfg.Balancer.Policy != "" {
+		status.Policy = p.cfg.Balancer.Policy
+	}
+	if p.breaker != nil {
+		status.Circuit = p.breaker.State()
+	}
+
+	for _, t := range p.targets {
+		t.mu.Lock()
+		lastError := t.lastError
+		t.mu.Unlock()
+
+		status.Targets = append(status.Targets, TargetStatus{
+			URL:               t.URL.String(),
+			Weight:            t.Weight,
+			Healthy:           t.Healthy(),
+			ActiveConnections: t.Active(),
+			LastCheckError:    lastError,
+		})
+	}
+	return status
+}
+
+// close 停止 health checker, 自己建立的 transport 一併關閉
+func (p *Pool) close() {
+	close(p.stop)
+	p.wg.Wait()
+
+	if p.tls != nil {
+		p.tls.close()
+		p.transport.CloseIdleConnections()
+		p.grpcTransport.h2.CloseIdleConnections()
+	}
+}
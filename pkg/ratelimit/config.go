@@ -0,0 +1,122 @@
+package ratelimit
+
+import (
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// Algorithms
+const (
+	TokenBucket   = "token_bucket"
+	SlidingWindow = "sliding_window"
+)
+
+// Key sources
+const (
+	KeyByClientIP  = "client_ip"
+	KeyByPrincipal = "principal"
+	KeyByAPIKey    = "api_key"
+	KeyByHeader    = "header"
+)
+
+// defaultAPIKeyHeader key_by 為 api_key 且沒有指定 header 時使用
+const defaultAPIKeyHeader = "X-API-Key"
+
+// Config 每 Period 允許 Limit 個 request, 一個 route 可以有多條規則
+type Config struct {
+	Name string `json:"name" validate:"required"`
+	// Algorithm 預設為 token_bucket
+	Algorithm string `json:"algorithm" validate:"omitempty,oneof=token_bucket sliding_window"`
+	// KeyBy 預設為 client_ip. principal 使用 http.auth 驗證通過的 principal,
+	// 匿名或沒有帶 header 的 request 改以 client ip 計算
+	KeyBy string `json:"key_by" yaml:"key_by" mapstructure:"key_by" validate:"omitempty,oneof=client_ip principal api_key header"`
+	// Header key_by 為 header 或 api_key 時使用的 header 名稱
+	Header string `json:"header,omitempty"`
+	Limit  int    `json:"limit" validate:"required,min=1"`
+	// Period 預設為 1s
+	Period time.Duration `json:"period" validate:"min=0"`
+	// Burst token bucket 的容量, 預設等於 Limit
+	Burst int `json:"burst,omitempty" validate:"min=0"`
+}
+
+// SetDefaults ...
+func (c *Config) SetDefaults() {
+	if c.Algorithm == "" {
+		c.Algorithm = TokenBucket
+	}
+	if c.KeyBy == "" {
+		c.KeyBy = KeyByClientIP
+	}
+	if c.KeyBy == KeyByAPIKey && c.Header == "" {
+		c.Header = defaultAPIKeyHeader
+	}
+	if c.Period <= 0 {
+		c.Period = time.Second
+	}
+	if c.Burst <= 0 {
+		c.Burst = c.Limit
+	}
+}
+
+// Check 給 admin api 使用, 設定檔由 config.Validate 檢查
+func (c *Config) Check() error {
+	switch {
+	case c.Name == "":
+		return fmt.Errorf("name is required")
+	case c.Limit < 1:
+		return fmt.Errorf("rule %s: limit must be at least 1", c.Name)
+	case c.Period < 0 || c.Burst < 0:
+		return fmt.Errorf("rule %s: period and burst must not be negative", c.Name)
+	}
+
+	switch c.Algorithm {
+	case "", TokenBucket, SlidingWindow:
+	default:
+		return fmt.Errorf("rule %s: unknown algorithm %q", c.Name, c.Algorithm)
+	}
+
+	switch c.KeyBy {
+	case "", KeyByClientIP, KeyByPrincipal, KeyByAPIKey:
+	case KeyByHeader:
+		if c.Header == "" {
+			return fmt.Errorf("rule %s: header is required when key_by is header", c.Name)
+		}
+	default:
+		return fmt.Errorf("rule %s: unknown key_by %q", c.Name, c.KeyBy)
+	}
+	return nil
+}
+
+type configJSON Config
+
+// MarshalJSON period 以 "1m0s" 的字串表示
+func (c Config) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		configJSON
+		Period string `json:"period"`
+	}{configJSON(c), c.Period.String()})
+}
+
+// UnmarshalJSON period 接受 "1m" 之類的字串
+func (c *Config) UnmarshalJSON(data []byte) error {
+	var v struct {
+		*configJSON
+		Period string `json:"period"`
+	}
+	v.configJSON = (*configJSON)(c)
+	if err := json.Unmarshal(data, &v); err != nil {
+		return err
+	}
+	if v.Period == "" {
+		c.Period = 0
+		return nil
+	}
+
+	d, err := time.ParseDuration(v.Period)
+	if err != nil {
+		return fmt.Errorf("invalid period %q", v.Period)
+	}
+	c.Period = d
+	return nil
+}
@@ -0,0 +1,214 @@
+package ratelimit
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"apigateway/pkg/apperror"
+	"apigateway/pkg/clientip"
+	"apigateway/pkg/metrics"
+	"apigateway/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+)
+
+// Module Export rate limit module, 換掉 NewMemoryStore 即可使用其他 Store
+var Module = fx.Options(
+	fx.Provide(
+		NewMemoryStore,
+		NewLimiter,
+	),
+)
+
+// ErrUnknownRoute ...
+var ErrUnknownRoute = errors.New("unknown route")
+
+// Limiter 每個 route 的規則, 可由 admin api 在執行中覆寫
+type Limiter struct {
+	store Store
+
+	mu         sync.RWMutex
+	configured map[string][]*Config
+	overrides  map[string][]*Config
+}
+
+// RouteRules admin api 的回應
+type RouteRules struct {
+	Rules    []*Config `json:"rules"`
+	Override bool      `json:"override"`
+}
+
+// NewLimiter ...
+func NewLimiter(store Store) *Limiter {
+	return &Limiter{
+		store:      store,
+		configured: map[string][]*Config{},
+		overrides:  map[string][]*Config{},
+	}
+}
+
+// Configure 套用設定檔中的規則, 規則沒有變更的 route 保留 admin api 的覆寫
+func (l *Limiter) Configure(rules map[string][]*Config) {
+	configured := map[string][]*Config{}
+	for route, cfgs := range rules {
+		configured[route] = withDefaults(cfgs)
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	for route := range l.overrides {
+		if !reflect.DeepEqual(l.configured[route], configured[route]) {
+			delete(l.overrides, route)
+			log.Info().Str("route", route).Msg("rate limit override dropped by config reload")
+		}
+	}
+	l.configured = configured
+}
+
+// Override 以 rules 取代 route 目前的規則, 空的 rules 代表不限制
+func (l *Limiter) Override(route string, rules []*Config) error {
+	names := map[string]bool{}
+	for _, r := range rules {
+		if err := r.Check(); err != nil {
+			return err
+		}
+		if names[r.Name] {
+			return fmt.Errorf("rule %s is defined more than once", r.Name)
+		}
+		names[r.Name] = true
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.configured[route]; !ok {
+		return ErrUnknownRoute
+	}
+	l.overrides[route] = withDefaults(rules)
+	log.Info().Str("route", route).Int("rules", len(rules)).Msg("rate limit overridden")
+	return nil
+}
+
+// Reset 移除覆寫, 回到設定檔的規則
+func (l *Limiter) Reset(route string) error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if _, ok := l.configured[route]; !ok {
+		return ErrUnknownRoute
+	}
+	delete(l.overrides, route)
+	return nil
+}
+
+// Rules 目前生效的規則
+func (l *Limiter) Rules() map[string]RouteRules {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	all := make(map[string]RouteRules, len(l.configured))
+	for route, rules := range l.configured {
+		if override, ok := l.overrides[route]; ok {
+			all[route] = RouteRules{Rules: override, Override: true}
+			continue
+		}
+		all[route] = RouteRules{Rules: rules}
+	}
+	return all
+}
+
+func (l *Limiter) rules(route string) []*Config {
+	l.mu.RLock()
+	defer l.mu.RUnlock()
+
+	if override, ok := l.overrides[route]; ok {
+		return override
+	}
+	return l.configured[route]
+}
+
+// Allow 依序檢查 route 的規則並寫入 RateLimit-* header, 超過時回 429 並中斷.
+// store 發生錯誤時放行
+func (l *Limiter) Allow(c *gin.Context, route string) bool {
+	rules := l.rules(route)
+	if len(rules) == 0 {
+		return true
+	}
+
+	var strictest *Result
+	for _, rule := range rules {
+		key := requestKey(c, rule)
+		res, err := l.store.Take(c.Request.Context(), route+"|"+rule.Name+"|"+key, rule)
+		if err != nil {
+			log.Error().Str("route", route).Str("rule", rule.Name).Msgf("rate limit store: %v", err)
+			continue
+		}
+
+		if !res.Allowed {
+			setHeaders(c, &res)
+			c.Header("Retry-After", strconv.Itoa(ceilSeconds(res.RetryAfter)))
+			metrics.RateLimited.Add(route, 1)
+			log.Debug().Str("route", route).Str("rule", rule.Name).Str("key", key).Msg("rate limited")
+			apperror.Abort(c, apperror.ErrTooManyRequests)
+			return false
+		}
+		if strictest == nil || res.Remaining < strictest.Remaining {
+			r := res
+			strictest = &r
+		}
+	}
+
+	if strictest != nil {
+		setHeaders(c, strictest)
+	}
+	return true
+}
+
+// requestKey 加上來源的前綴, 避免 principal 或 header 的值與 client ip 共用同一個 bucket
+func requestKey(c *gin.Context, rule *Config) string {
+	switch rule.KeyBy {
+	case KeyByPrincipal:
+		if p := middleware.PrincipalFromContext(c.Request.Context()); p != "" {
+			return "principal:" + p
+		}
+	case KeyByAPIKey, KeyByHeader:
+		if v := c.GetHeader(rule.Header); v != "" {
+			return "header:" + v
+		}
+	}
+	return "ip:" + clientip.FromRequest(c.Request)
+}
+
+func setHeaders(c *gin.Context, res *Result) {
+	c.Header("RateLimit-Limit", strconv.Itoa(res.Limit))
+	c.Header("RateLimit-Remaining", strconv.Itoa(res.Remaining))
+	c.Header("RateLimit-Reset", strconv.Itoa(ceilSeconds(res.Reset)))
+}
+
+func ceilSeconds(d time.Duration) int {
+	return int(math.Ceil(d.Seconds()))
+}
+
+// withDefaults 複製規則並補上預設值, 依名稱排序方便比較
+func withDefaults(cfgs []*Config) []*Config {
+	rules := make([]*Config, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		r := *cfg
+		r.Header = http.CanonicalHeaderKey(strings.TrimSpace(r.Header))
+		r.SetDefaults()
+		rules = append(rules, &r)
+	}
+	sort.SliceStable(rules, func(i, j int) bool { return rules[i].Name < rules[j].Name })
+	return rules
+}
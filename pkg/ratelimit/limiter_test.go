@@ -0,0 +1,178 @@
+package ratelimit
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"apigateway/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// limitedRouter 每個 request 都經過 route "books" 的規則
+func limitedRouter(l *Limiter) *gin.Engine {
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(middleware.Principal([]*middleware.TokenConfig{
+		{Principal: "alice", Token: "token-a"},
+		{Principal: "bob", Token: "token-b"},
+	}))
+	router.GET("/books", func(c *gin.Context) {
+		if l.Allow(c, "books") {
+			c.Status(http.StatusOK)
+		}
+	})
+	return router
+}
+
+func TestLimiterKeys(t *testing.T) {
+	type request struct {
+		remoteAddr string
+		header     map[string]string
+		wantStatus int
+	}
+	alice := map[string]string{"Authorization": "Bearer token-a"}
+	bob := map[string]string{"Authorization": "Bearer token-b"}
+
+	tests := []struct {
+		name     string
+		rule     *Config
+		requests []request
+	}{
+		{
+			name: "client ip ignores untrusted forwarded for",
+			rule: &Config{Name: "ip", Limit: 1, Period: time.Minute},
+			requests: []request{
+				{remoteAddr: "192.0.2.1:1000", header: map[string]string{"X-Forwarded-For": "10.0.0.1"}, wantStatus: http.StatusOK},
+				{remoteAddr: "192.0.2.1:1001", header: map[string]string{"X-Forwarded-For": "10.0.0.2"}, wantStatus: http.StatusTooManyRequests},
+				{remoteAddr: "192.0.2.2:1000", wantStatus: http.StatusOK},
+			},
+		},
+		{
+			name: "principal",
+			rule: &Config{Name: "user", KeyBy: KeyByPrincipal, Limit: 1, Period: time.Minute},
+			requests: []request{
+				{remoteAddr: "192.0.2.1:1000", header: alice, wantStatus: http.StatusOK},
+				{remoteAddr: "192.0.2.2:1000", header: alice, wantStatus: http.StatusTooManyRequests},
+				{remoteAddr: "192.0.2.1:1000", header: bob, wantStatus: http.StatusOK},
+				// 匿名的 request 以 client ip 計算, 與 principal 的 bucket 分開
+				{remoteAddr: "192.0.2.1:1000", wantStatus: http.StatusOK},
+				{remoteAddr: "192.0.2.1:1000", header: map[string]string{"Authorization": "Bearer wrong"}, wantStatus: http.StatusTooManyRequests},
+			},
+		},
+		{
+			name: "api key",
+			rule: &Config{Name: "key", KeyBy: KeyByAPIKey, Limit: 1, Period: time.Minute},
+			requests: []request{
+				{remoteAddr: "192.0.2.1:1000", header: map[string]string{"X-Api-Key": "k1"}, wantStatus: http.StatusOK},
+				{remoteAddr: "192.0.2.2:1000", header: map[string]string{"X-Api-Key": "k1"}, wantStatus: http.StatusTooManyRequests},
+				{remoteAddr: "192.0.2.1:1000", header: map[string]string{"X-Api-Key": "k2"}, wantStatus: http.StatusOK},
+				{remoteAddr: "192.0.2.1:1000", wantStatus: http.StatusOK},
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			l := NewLimiter(NewMemoryStore())
+			l.Configure(map[string][]*Config{"books": {tt.rule}})
+			router := limitedRouter(l)
+
+			for i, r := range tt.requests {
+				req := httptest.NewRequest(http.MethodGet, "/books", nil)
+				req.RemoteAddr = r.remoteAddr
+				for k, v := range r.header {
+					req.Header.Set(k, v)
+				}
+				rec := httptest.NewRecorder()
+				router.ServeHTTP(rec, req)
+				if rec.Code != r.wantStatus {
+					t.Fatalf("request %d: status = %d, want %d", i, rec.Code, r.wantStatus)
+				}
+			}
+		})
+	}
+}
+
+func TestLimiterHeaders(t *testing.T) {
+	l := NewLimiter(NewMemoryStore())
+	l.Configure(map[string][]*Config{"books": {
+		{Name: "burst", Limit: 2, Period: time.Minute},
+		{Name: "hourly", Limit: 10, Period: time.Hour},
+	}})
+	router := limitedRouter(l)
+
+	serve := func() *httptest.ResponseRecorder {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/books", nil))
+		return rec
+	}
+
+	// 回報剩餘額度最少的規則
+	rec := serve()
+	if got := rec.Header().Get("RateLimit-Limit"); got != "2" {
+		t.Errorf("RateLimit-Limit = %s, want 2", got)
+	}
+	if got := rec.Header().Get("RateLimit-Remaining"); got != "1" {
+		t.Errorf("RateLimit-Remaining = %s, want 1", got)
+	}
+
+	serve()
+	rec = serve()
+	if rec.Code != http.StatusTooManyRequests {
+		t.Fatalf("status = %d, want 429", rec.Code)
+	}
+	if got := rec.Header().Get("Retry-After"); got != "30" {
+		t.Errorf("Retry-After = %s, want 30", got)
+	}
+	if got := rec.Header().Get("Content-Type"); got != "application/problem+json" {
+		t.Errorf("Content-Type = %s, want application/problem+json", got)
+	}
+}
+
+func TestLimiterOverride(t *testing.T) {
+	rule := &Config{Name: "ip", Limit: 1, Period: time.Minute}
+	l := NewLimiter(NewMemoryStore())
+	l.Configure(map[string][]*Config{"books": {rule}})
+
+	if err := l.Override("unknown", nil); err != ErrUnknownRoute {
+		t.Errorf("Override(unknown) = %v, want ErrUnknownRoute", err)
+	}
+	if err := l.Override("books", []*Config{{Name: "a", Limit: 1}, {Name: "a", Limit: 2}}); err == nil {
+		t.Error("Override with duplicate rule names should fail")
+	}
+	if err := l.Override("books", []*Config{{Name: "h", KeyBy: KeyByHeader, Limit: 1}}); err == nil {
+		t.Error("Override with key_by header and no header should fail")
+	}
+
+	// 空的 rules 代表不限制
+	if err := l.Override("books", []*Config{}); err != nil {
+		t.Fatal(err)
+	}
+	router := limitedRouter(l)
+	for i := 0; i < 3; i++ {
+		rec := httptest.NewRecorder()
+		router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/books", nil))
+		if rec.Code != http.StatusOK {
+			t.Fatalf("request %d with override: status = %d, want 200", i, rec.Code)
+		}
+	}
+
+	// 設定沒有變更的 reload 保留覆寫, 變更後移除
+	l.Configure(map[string][]*Config{"books": {rule}})
+	if !l.Rules()["books"].Override {
+		t.Error("override dropped by a reload that did not change the route")
+	}
+	l.Configure(map[string][]*Config{"books": {{Name: "ip", Limit: 5, Period: time.Minute}}})
+	if l.Rules()["books"].Override {
+		t.Error("override kept after the route rules changed")
+	}
+
+	if err := l.Override("books", nil); err != nil {
+		t.Fatal(err)
+	}
+	if err := l.Reset("books"); err != nil || l.Rules()["books"].Override {
+		t.Errorf("Reset() = %v, override %v", err, l.Rules()["books"].Override)
+	}
+}
@@ -0,0 +1,171 @@
+package ratelimit
+
+import (
+	"context"
+	"math"
+	"sync"
+	"time"
+)
+
+// Result 一次判斷的結果, 用來產生 RateLimit-* header
+type Result struct {
+	Allowed   bool
+	Limit     int
+	Remaining int
+	// Reset 額度完全恢復所需的時間
+	Reset time.Duration
+	// RetryAfter 被拒絕時, 下一個 request 可以通過的時間
+	RetryAfter time.Duration
+}
+
+// Store 保存計數, 實作需自行處理 concurrency. 預設為 MemoryStore, 多個 instance 共用時可換成外部 store
+type Store interface {
+	Take(ctx context.Context, key string, cfg *Config) (Result, error)
+}
+
+// sweepInterval 多久清一次過期的 key
+const sweepInterval = time.Minute
+
+// MemoryStore 單一 process 內的 Store
+type MemoryStore struct {
+	mu        sync.Mutex
+	buckets   map[string]*bucketState
+	windows   map[string]*windowState
+	lastSweep time.Time
+}
+
+type bucketState struct {
+	tokens  float64
+	last    time.Time
+	expires time.Time
+}
+
+type windowState struct {
+	period   time.Duration
+	start    time.Time
+	previous int
+	current  int
+}
+
+// NewMemoryStore ...
+func NewMemoryStore() Store {
+	return &MemoryStore{
+		buckets:   map[string]*bucketState{},
+		windows:   map[string]*windowState{},
+		lastSweep: time.Now(),
+	}
+}
+
+// Take 依 cfg.Algorithm 消耗一個額度
+func (s *MemoryStore) Take(_ context.Context, key string, cfg *Config) (Result, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(s.lastSweep) > sweepInterval {
+		s.sweep(now)
+	}
+
+	if cfg.Algorithm == SlidingWindow {
+		return s.slidingWindow(key, cfg, now), nil
+	}
+	return s.tokenBucket(key, cfg, now), nil
+}
+
+func (s *MemoryStore) tokenBucket(key string, cfg *Config, now time.Time) Result {
+	rate := float64(cfg.Limit) / cfg.Period.Seconds() // tokens per second
+	capacity := float64(cfg.Burst)
+
+	b, ok := s.buckets[key]
+	if !ok {
+		b = &bucketState{tokens: capacity, last: now}
+		s.buckets[key] = b
+	}
+	b.tokens = math.Min(capacity, b.tokens+now.Sub(b.last).Seconds()*rate)
+	b.last = now
+
+	res := Result{Limit: cfg.Burst}
+	if b.tokens >= 1 {
+		b.tokens--
+		res.Allowed = true
+	} else {
+		res.RetryAfter = seconds((1 - b.tokens) / rate)
+	}
+	res.Remaining = int(b.tokens)
+	res.Reset = seconds((capacity - b.tokens) / rate)
+	b.expires = now.Add(res.Reset)
+	return res
+}
+
+// slidingWindow 以前一個 window 的計數依經過比例加權估計目前的 request 數
+func (s *MemoryStore) slidingWindow(key string, cfg *Config, now time.Time) Result {
+	start := now.Truncate(cfg.Period)
+	w, ok := s.windows[key]
+	switch {
+	case !ok:
+		w = &windowState{period: cfg.Period, start: start}
+		s.windows[key] = w
+	case w.period != cfg.Period:
+		// 規則在執行中被修改
+		w.period, w.start, w.previous, w.current = cfg.Period, start, 0, 0
+	case w.start.Equal(start):
+	case w.start.Add(cfg.Period).Equal(start):
+		w.start, w.previous, w.current = start, w.current, 0
+	default:
+		w.start, w.previous, w.current = start, 0, 0
+	}
+
+	elapsed := now.Sub(start)
+	weight := 1 - float64(elapsed)/float64(cfg.Period)
+	estimated := float64(w.previous)*weight + float64(w.current)
+
+	res := Result{Limit: cfg.Limit, Reset: cfg.Period - elapsed}
+	if estimated+1 <= float64(cfg.Limit) {
+		w.current++
+		res.Allowed = true
+		estimated++
+	} else {
+		res.RetryAfter = w.retryAfter(cfg.Limit, elapsed)
+	}
+	res.Remaining = int(math.Max(0, float64(cfg.Limit)-estimated))
+	if w.previous > 0 {
+		res.Reset += cfg.Period
+	}
+	return res
+}
+
+// retryAfter 加權後的計數加上下一個 request 不超過 limit 所需的時間
+func (w *windowState) retryAfter(limit int, elapsed time.Duration) time.Duration {
+	period := float64(w.period)
+	if w.current < limit {
+		// 前一個 window 的權重降到 (limit-current-1)/previous 即可通過, 被拒絕時 previous 一定大於 0
+		need := 1 - float64(limit-w.current-1)/float64(w.previous)
+		return time.Duration(math.Ceil(need*period)) - elapsed
+	}
+
+	// 目前的 window 已滿, 到下一個 window 後還要等目前的計數權重降到 (limit-1)/current
+	wait := w.period - elapsed
+	if need := 1 - float64(limit-1)/float64(w.current); need > 0 {
+		wait += time.Duration(math.Ceil(need * period))
+	}
+	return wait
+}
+
+func (s *MemoryStore) sweep(now time.Time) {
+	for key, b := range s.buckets {
+		if now.After(b.expires) {
+			delete(s.buckets, key)
+		}
+	}
+	for key, w := range s.windows {
+		// 兩個 window 之前的計數已經沒有影響
+		if now.Sub(w.start) > 2*w.period {
+			delete(s.windows, key)
+		}
+	}
+	s.lastSweep = now
+}
+
+func seconds(s float64) time.Duration {
+	return time.Duration(s * float64(time.Second))
+}
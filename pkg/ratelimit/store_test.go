@@ -0,0 +1,74 @@
+package ratelimit
+
+import (
+	"testing"
+	"time"
+)
+
+func TestTokenBucket(t *testing.T) {
+	s := NewMemoryStore().(*MemoryStore)
+	cfg := &Config{Name: "r", Limit: 2, Period: time.Second}
+	cfg.SetDefaults()
+	now := time.Unix(1000, 0)
+
+	for i := 0; i < 2; i++ {
+		if res := s.tokenBucket("k", cfg, now); !res.Allowed || res.Remaining != 1-i {
+			t.Fatalf("request %d = %+v, want allowed with %d remaining", i, res, 1-i)
+		}
+	}
+	res := s.tokenBucket("k", cfg, now)
+	if res.Allowed {
+		t.Fatal("third request within the burst was allowed")
+	}
+	if res.RetryAfter != 500*time.Millisecond {
+		t.Errorf("RetryAfter = %s, want 500ms", res.RetryAfter)
+	}
+	if res := s.tokenBucket("k", cfg, now.Add(res.RetryAfter)); !res.Allowed {
+		t.Errorf("request after RetryAfter = %+v, want allowed", res)
+	}
+}
+
+// TestSlidingWindowRetryAfter 在 RetryAfter 之前仍被拒絕, 到了 RetryAfter 就能通過
+func TestSlidingWindowRetryAfter(t *testing.T) {
+	start := time.Unix(1000, 0)
+
+	tests := []struct {
+		name string
+		// previous 與 current 兩個 window 的 request 數
+		previous, current int
+		elapsed           time.Duration
+		want              time.Duration
+	}{
+		// 10*0.8 + 5 = 13, 權重需降到 0.4 => 600ms
+		{name: "previous window weight", previous: 10, current: 5, elapsed: 200 * time.Millisecond, want: 400 * time.Millisecond},
+		// 10*0.5 + 5 = 10, 同樣需要權重降到 0.4
+		{name: "exactly at limit", previous: 10, current: 5, elapsed: 500 * time.Millisecond, want: 100 * time.Millisecond},
+		// 目前的 window 已滿, 下一個 window 還要等權重降到 0.9
+		{name: "current window full", current: 10, elapsed: 200 * time.Millisecond, want: 900 * time.Millisecond},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			s := NewMemoryStore().(*MemoryStore)
+			cfg := &Config{Name: "r", Algorithm: SlidingWindow, Limit: 10, Period: time.Second}
+			cfg.SetDefaults()
+
+			s.windows["k"] = &windowState{period: cfg.Period, start: start, previous: tt.previous, current: tt.current}
+			now := start.Add(tt.elapsed)
+
+			res := s.slidingWindow("k", cfg, now)
+			if res.Allowed {
+				t.Fatalf("request over the limit was allowed")
+			}
+			if res.RetryAfter != tt.want {
+				t.Errorf("RetryAfter = %s, want %s", res.RetryAfter, tt.want)
+			}
+
+			if early := s.slidingWindow("k", cfg, now.Add(res.RetryAfter-time.Millisecond)); early.Allowed {
+				t.Errorf("request 1ms before RetryAfter was allowed")
+			}
+			if retry := s.slidingWindow("k", cfg, now.Add(res.RetryAfter)); !retry.Allowed {
+				t.Errorf("request at RetryAfter = %+v, want allowed", retry)
+			}
+		})
+	}
+}
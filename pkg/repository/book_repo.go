@@ -1,22 +1,74 @@
 package repository
 
-import "apigateway/pkg/model"
+import (
+	"context"
+	"errors"
+
+	"apigateway/pkg/model"
+
+	"github.com/jinzhu/gorm"
+)
+
+// ErrNotFound 查詢的資料不存在
+var ErrNotFound = errors.New("record not found")
 
 // BookRepository ...
 type BookRepository interface {
-	// Get book by option
-	GetBook() (model.Book, error)
-	// // List books by option
-	// ListBooks() ([]model.Book, error)
-	// // Create a Book
-	// CreateBook(book model.Book) (model.Book, error)
-	// // Delete the Book
-	// DeleteBook() error
-	// // Update the Book
-	// UpdateBook() (model.Book, error)
+	// Get book by id
+	GetBook(ctx context.Context, id int) (model.Book, error)
+	// List books order by id
+	ListBooks(ctx context.Context) ([]model.Book, error)
+	// Create a Book
+	CreateBook(ctx context.Context, book model.Book) (model.Book, error)
+	// Update the Book
+	UpdateBook(ctx context.Context, book model.Book) (model.Book, error)
+	// Delete the Book
+	DeleteBook(ctx context.Context, id int) error
 }
 
-func (repo *repository) GetBook() (model.Book, error) {
+func (repo *repository) GetBook(ctx context.Context, id int) (model.Book, error) {
 	var book model.Book
+	err := repo.readDB(ctx).Where("id = ?", id).First(&book).Error
+	if gorm.IsRecordNotFoundError(err) {
+		return book, ErrNotFound
+	}
+	return book, err
+}
+
+func (repo *repository) ListBooks(ctx context.Context) ([]model.Book, error) {
+	books := []model.Book{}
+	err := repo.readDB(ctx).Order("id").Find(&books).Error
+	return books, err
+}
+
+func (repo *repository) CreateBook(ctx context.Context, book model.Book) (model.Book, error) {
+	err := repo.writeDB(ctx).Create(&book).Error
+	return book, err
+}
+
+func (repo *repository) UpdateBook(ctx context.Context, book model.Book) (model.Book, error) {
+	db := repo.writeDB(ctx).Model(&model.Book{}).Where("id = ?", book.ID).Updates(map[string]interface{}{
+		"name": book.Name,
+		"isbn": book.ISBN,
+		"slug": book.Slug,
+	})
+	if db.Error != nil {
+		return book, db.Error
+	}
+	if db.RowsAffected == 0 {
+		// 內容相同時部分 driver 也回傳 0, 以查詢確認是否存在
+		return repo.GetBook(ctx, book.ID)
+	}
 	return book, nil
 }
+
+func (repo *repository) DeleteBook(ctx context.Context, id int) error {
+	db := repo.writeDB(ctx).Where("id = ?", id).Delete(&model.Book{})
+	if db.Error != nil {
+		return db.Error
+	}
+	if db.RowsAffected == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
@@ -1,6 +1,8 @@
 package repository
 
 import (
+	"context"
+
 	"apigateway/pkg/database"
 
 	"github.com/jinzhu/gorm"
@@ -8,19 +10,37 @@ import (
 )
 
 type repository struct {
-	readDB  *gorm.DB
-	writeDB *gorm.DB
+	conn *database.RdbmsConn
 	// microservice
 }
 
+// CatalogDB 書籍資料所在的連線名稱, 對應設定檔 databases.catalog
+const CatalogDB = "catalog"
+
+// Params 依名稱取得需要的連線
+type Params struct {
+	fx.In
+
+	Catalog *database.RdbmsConn `name:"catalog"`
+}
+
 // NewRepository ...
-func NewRepository(conn *database.RdbmsConn) IRepository {
+func NewRepository(p Params) IRepository {
 	return &repository{
-		readDB:  conn.ReadDB,
-		writeDB: conn.WriteDB,
+		conn: p.Catalog,
 	}
 }
 
+// readDB 取得綁定 request 查詢統計的 read DB
+func (repo *repository) readDB(ctx context.Context) *gorm.DB {
+	return repo.conn.Read(ctx)
+}
+
+// writeDB 取得綁定 request 查詢統計的 write DB
+func (repo *repository) writeDB(ctx context.Context) *gorm.DB {
+	return repo.conn.Write(ctx)
+}
+
 // IRepository ...
 type IRepository interface {
 	BookRepository
@@ -28,5 +48,6 @@ type IRepository interface {
 
 // Module Export repository module
 var Module = fx.Options(
+	database.Named(CatalogDB),
 	fx.Provide(NewRepository),
 )
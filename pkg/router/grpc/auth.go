@@ -0,0 +1,80 @@
+package grpc
+
+import (
+	"context"
+	"crypto/subtle"
+	"strings"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// AuthConfig client 以 `authorization: Bearer <token>` metadata 驗證
+type AuthConfig struct {
+	Tokens []*TokenConfig `validate:"required,dive,required"`
+	// PublicMethods 不需驗證的 method, 例如 /apigateway.book.v1.BookService/ListBooks
+	PublicMethods []string `yaml:"public_methods" mapstructure:"public_methods"`
+}
+
+// TokenConfig token 可使用 ENC[...] 加密
+type TokenConfig struct {
+	Principal string `validate:"required"`
+	Token     string `validate:"required"`
+}
+
+type principalKey struct{}
+
+// PrincipalFromContext 驗證通過的 client, 沒有設定 auth 時為空字串
+func PrincipalFromContext(ctx context.Context) string {
+	principal, _ := ctx.Value(principalKey{}).(string)
+	return principal
+}
+
+func authInterceptor(cfg *AuthConfig) grpc.UnaryServerInterceptor {
+	public := map[string]bool{}
+	if cfg != nil {
+		for _, m := range cfg.PublicMethods {
+			public[m] = true
+		}
+	}
+
+	return func(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+		if cfg == nil || public[info.FullMethod] || isPublicMethod(info.FullMethod) {
+			return handler(ctx, req)
+		}
+
+		principal, ok := cfg.authenticate(ctx)
+		if !ok {
+			log.Ctx(ctx).Warn().Str("method", info.FullMethod).Msg("grpc unauthenticated")
+			return nil, status.Error(codes.Unauthenticated, "missing or invalid token")
+		}
+		return handler(context.WithValue(ctx, principalKey{}, principal), req)
+	}
+}
+
+func (cfg *AuthConfig) authenticate(ctx context.Context) (string, bool) {
+	md, ok := metadata.FromIncomingContext(ctx)
+	if !ok {
+		return "", false
+	}
+	values := md.Get("authorization")
+	if len(values) == 0 {
+		return "", false
+	}
+
+	const prefix = "bearer "
+	if len(values[0]) <= len(prefix) || !strings.EqualFold(values[0][:len(prefix)], prefix) {
+		return "", false
+	}
+	token := []byte(values[0][len(prefix):])
+
+	for _, t := range cfg.Tokens {
+		if subtle.ConstantTimeCompare(token, []byte(t.Token)) == 1 {
+			return t.Principal, true
+		}
+	}
+	return "", false
+}
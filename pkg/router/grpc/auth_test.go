@@ -0,0 +1,59 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+func TestAuthInterceptor(t *testing.T) {
+	cfg := &AuthConfig{
+		Tokens:        []*TokenConfig{{Principal: "mobile", Token: "secret"}},
+		PublicMethods: []string{"/apigateway.book.v1.BookService/ListBooks"},
+	}
+	const getBook = "/apigateway.book.v1.BookService/GetBook"
+
+	tests := []struct {
+		name          string
+		cfg           *AuthConfig
+		method        string
+		authorization string
+		wantCode      codes.Code
+		wantPrincipal string
+	}{
+		{name: "auth not configured", cfg: nil, method: getBook, wantCode: codes.OK},
+		{name: "public method", cfg: cfg, method: "/apigateway.book.v1.BookService/ListBooks", wantCode: codes.OK},
+		{name: "health", cfg: cfg, method: "/grpc.health.v1.Health/Check", wantCode: codes.OK},
+		{name: "reflection", cfg: cfg, method: "/grpc.reflection.v1alpha.ServerReflection/ServerReflectionInfo", wantCode: codes.OK},
+		{name: "missing token", cfg: cfg, method: getBook, wantCode: codes.Unauthenticated},
+		{name: "wrong token", cfg: cfg, method: getBook, authorization: "Bearer nope", wantCode: codes.Unauthenticated},
+		{name: "basic scheme", cfg: cfg, method: getBook, authorization: "Basic secret", wantCode: codes.Unauthenticated},
+		{name: "valid token", cfg: cfg, method: getBook, authorization: "bearer secret", wantCode: codes.OK, wantPrincipal: "mobile"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.authorization != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs("authorization", tt.authorization))
+			}
+
+			var principal string
+			handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+				principal = PrincipalFromContext(ctx)
+				return "ok", nil
+			}
+			_, err := authInterceptor(tt.cfg)(ctx, nil, &grpc.UnaryServerInfo{FullMethod: tt.method}, handler)
+
+			if code := status.Code(err); code != tt.wantCode {
+				t.Fatalf("code = %s, want %s", code, tt.wantCode)
+			}
+			if principal != tt.wantPrincipal {
+				t.Errorf("principal = %q, want %q", principal, tt.wantPrincipal)
+			}
+		})
+	}
+}
@@ -0,0 +1,139 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"strings"
+
+	"apigateway/pkg/model"
+	"apigateway/pkg/pb/bookpb"
+	"apigateway/pkg/service"
+	"apigateway/pkg/validation"
+
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+// bookServiceName health check 回報的 service 名稱
+const bookServiceName = "apigateway.book.v1.BookService"
+
+// bookServer 與 HTTP handler 共用 service.IService, 寫入一樣會發布 book event
+type bookServer struct {
+	svc service.IService
+}
+
+func (s *bookServer) GetBook(ctx context.Context, req *bookpb.GetBookRequest) (*bookpb.Book, error) {
+	if req.GetId() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid book id")
+	}
+	book, err := s.svc.GetBook(ctx, int(req.GetId()))
+	if err != nil {
+		return nil, bookError(ctx, err)
+	}
+	return toPB(book), nil
+}
+
+func (s *bookServer) ListBooks(ctx context.Context, req *bookpb.ListBooksRequest) (*bookpb.ListBooksResponse, error) {
+	books, err := s.svc.ListBooks(ctx)
+	if err != nil {
+		return nil, bookError(ctx, err)
+	}
+
+	resp := &bookpb.ListBooksResponse{Books: make([]*bookpb.Book, 0, len(books))}
+	for _, b := range books {
+		resp.Books = append(resp.Books, toPB(b))
+	}
+	return resp, nil
+}
+
+func (s *bookServer) CreateBook(ctx context.Context, req *bookpb.CreateBookRequest) (*bookpb.Book, error) {
+	if req.GetBook() == nil {
+		return nil, status.Error(codes.InvalidArgument, "book is required")
+	}
+	if err := validateBook(ctx, req.GetBook()); err != nil {
+		return nil, err
+	}
+	book := fromPB(req.GetBook())
+	book.ID = 0
+
+	book, err := s.svc.CreateBook(ctx, book)
+	if err != nil {
+		return nil, bookError(ctx, err)
+	}
+	return toPB(book), nil
+}
+
+func (s *bookServer) UpdateBook(ctx context.Context, req *bookpb.UpdateBookRequest) (*bookpb.Book, error) {
+	if req.GetBook().GetId() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid book id")
+	}
+	if err := validateBook(ctx, req.GetBook()); err != nil {
+		return nil, err
+	}
+
+	book, err := s.svc.UpdateBook(ctx, fromPB(req.GetBook()))
+	if err != nil {
+		return nil, bookError(ctx, err)
+	}
+	return toPB(book), nil
+}
+
+func (s *bookServer) DeleteBook(ctx context.Context, req *bookpb.DeleteBookRequest) (*bookpb.DeleteBookResponse, error) {
+	if req.GetId() <= 0 {
+		return nil, status.Error(codes.InvalidArgument, "invalid book id")
+	}
+	if err := s.svc.DeleteBook(ctx, int(req.GetId())); err != nil {
+		return nil, bookError(ctx, err)
+	}
+	return &bookpb.DeleteBookResponse{}, nil
+}
+
+// bookMessage 與 HTTP 的 bookRequest 使用相同的驗證規則
+type bookMessage struct {
+	Name string `json:"name" validate:"required,max=255"`
+	ISBN string `json:"isbn" validate:"omitempty,max=17,isbn"`
+	Slug string `json:"slug" validate:"omitempty,max=128,slug"`
+}
+
+// validateBook 驗證失敗時回傳 InvalidArgument, 訊息依 accept-language metadata 選擇語系
+func validateBook(ctx context.Context, b *bookpb.Book) error {
+	err := validation.Struct(&bookMessage{Name: b.GetName(), ISBN: b.GetIsbn(), Slug: b.GetSlug()})
+	if err == nil {
+		return nil
+	}
+
+	var lang string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		lang = strings.Join(md.Get("accept-language"), ",")
+	}
+	trans := validation.TranslatorFor(lang)
+	fields := validation.Translate(err, trans)
+	if fields == nil {
+		log.Ctx(ctx).Error().Err(err).Msg("book validation failed")
+		return status.Error(codes.Internal, "internal error")
+	}
+
+	msgs := make([]string, 0, len(fields))
+	for _, f := range fields {
+		msgs = append(msgs, f.Field+": "+f.Message)
+	}
+	return status.Errorf(codes.InvalidArgument, "%s %s", validation.Message(trans), strings.Join(msgs, "; "))
+}
+
+func bookError(ctx context.Context, err error) error {
+	if errors.Is(err, service.ErrNotFound) {
+		return status.Error(codes.NotFound, "book not found")
+	}
+	log.Ctx(ctx).Error().Err(err).Msg("book rpc failed")
+	return status.Error(codes.Internal, "internal error")
+}
+
+func toPB(b model.Book) *bookpb.Book {
+	return &bookpb.Book{Id: int32(b.ID), Name: b.Name, Isbn: b.ISBN, Slug: b.Slug}
+}
+
+func fromPB(b *bookpb.Book) model.Book {
+	return model.Book{ID: int(b.GetId()), Name: b.GetName(), ISBN: b.GetIsbn(), Slug: b.GetSlug()}
+}
@@ -0,0 +1,101 @@
+package grpc
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"apigateway/pkg/model"
+	"apigateway/pkg/pb/bookpb"
+	"apigateway/pkg/service"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// fakeService id 1 存在, 其他回傳 ErrNotFound, err 不為 nil 時一律回傳 err
+type fakeService struct {
+	service.IService
+	err error
+}
+
+func (s *fakeService) GetBook(ctx context.Context, id int) (model.Book, error) {
+	if s.err != nil {
+		return model.Book{}, s.err
+	}
+	if id != 1 {
+		return model.Book{}, service.ErrNotFound
+	}
+	return model.Book{ID: 1, Name: "Go"}, nil
+}
+
+func (s *fakeService) CreateBook(ctx context.Context, book model.Book) (model.Book, error) {
+	book.ID = 2
+	return book, s.err
+}
+
+func getBook(id int32) func(s *bookServer) error {
+	return func(s *bookServer) error {
+		_, err := s.GetBook(context.Background(), &bookpb.GetBookRequest{Id: id})
+		return err
+	}
+}
+
+func TestBookServer(t *testing.T) {
+	tests := []struct {
+		name     string
+		err      error
+		call     func(s *bookServer) error
+		wantCode codes.Code
+	}{
+		{
+			name:     "get",
+			call:     getBook(1),
+			wantCode: codes.OK,
+		},
+		{
+			name:     "invalid id",
+			call:     getBook(0),
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name:     "not found",
+			call:     getBook(9),
+			wantCode: codes.NotFound,
+		},
+		{
+			name:     "service error is not exposed",
+			err:      errors.New("dial tcp 10.0.0.1:3306: connection refused"),
+			call:     getBook(1),
+			wantCode: codes.Internal,
+		},
+		{
+			name: "create validates",
+			call: func(s *bookServer) error {
+				_, err := s.CreateBook(context.Background(), &bookpb.CreateBookRequest{Book: &bookpb.Book{Isbn: "x"}})
+				return err
+			},
+			wantCode: codes.InvalidArgument,
+		},
+		{
+			name: "create",
+			call: func(s *bookServer) error {
+				_, err := s.CreateBook(context.Background(), &bookpb.CreateBookRequest{Book: &bookpb.Book{Id: 7, Name: "Go"}})
+				return err
+			},
+			wantCode: codes.OK,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.call(&bookServer{svc: &fakeService{err: tt.err}})
+			st := status.Convert(err)
+			if st.Code() != tt.wantCode {
+				t.Fatalf("code = %s (%s), want %s", st.Code(), st.Message(), tt.wantCode)
+			}
+			if tt.err != nil && st.Message() != "internal error" {
+				t.Errorf("message = %q, want the service error hidden", st.Message())
+			}
+		})
+	}
+}
@@ -1 +1,117 @@
 package grpc
+
+import (
+	"context"
+	"errors"
+	"net"
+
+	"apigateway/pkg/lifecycle"
+	"apigateway/pkg/pb/bookpb"
+	"apigateway/pkg/service"
+
+	"github.com/rs/zerolog/log"
+	"go.uber.org/fx"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/health"
+	healthpb "google.golang.org/grpc/health/grpc_health_v1"
+	"google.golang.org/grpc/reflection"
+)
+
+// Module Export grpc server module
+var Module = fx.Options(
+	fx.Provide(NewServer),
+	fx.Invoke(RunServer),
+)
+
+// Config the structure for gRPC, 沒有設定時不啟動 gRPC server
+type Config struct {
+	Address string `validate:"required"`
+	// Reflection 提供 grpcurl 等工具查詢 service 定義
+	Reflection bool
+	// Auth 沒有設定時不驗證
+	Auth *AuthConfig `validate:"omitempty"`
+}
+
+// Server 包含 health server, 關閉時先將狀態改為 NOT_SERVING
+type Server struct {
+	*grpc.Server
+	cfg    *Config
+	health *health.Server
+}
+
+// NewServer ...
+func NewServer(cfg *Config, svc service.IService) *Server {
+	if cfg == nil {
+		return &Server{}
+	}
+
+	srv := grpc.NewServer(
+		grpc.ChainUnaryInterceptor(
+			requestIDInterceptor,
+			loggingInterceptor,
+			recoveryInterceptor,
+			authInterceptor(cfg.Auth),
+		),
+	)
+
+	bookpb.RegisterBookServiceServer(srv, &bookServer{svc: svc})
+
+	hs := health.NewServer()
+	hs.SetServingStatus(bookServiceName, healthpb.HealthCheckResponse_SERVING)
+	healthpb.RegisterHealthServer(srv, hs)
+
+	if cfg.Reflection {
+		reflection.Register(srv)
+	}
+
+	return &Server{Server: srv, cfg: cfg, health: hs}
+}
+
+// RunServer listen 失敗時直接讓 app 啟動失敗, 開始 drain 時 health 改為 NOT_SERVING
+func RunServer(lc fx.Lifecycle, srv *Server, d *lifecycle.Drainer, shutdowner fx.Shutdowner) {
+	if srv.Server == nil {
+		return
+	}
+	d.OnDrain(srv.health.Shutdown)
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			lis, err := net.Listen("tcp", srv.cfg.Address)
+			if err != nil {
+				return err
+			}
+
+			go func() {
+				if err := srv.Serve(lis); err != nil && !errors.Is(err, grpc.ErrServerStopped) {
+					log.Error().Msgf("grpc serve: %s", err)
+					_ = shutdowner.Shutdown()
+				}
+			}()
+			log.Info().Str("address", srv.cfg.Address).Msg("grpc server started")
+			return nil
+		},
+		OnStop: srv.Shutdown,
+	})
+}
+
+// Shutdown 等待進行中的 rpc 結束, ctx 逾時後強制關閉
+func (srv *Server) Shutdown(ctx context.Context) error {
+	if srv.Server == nil {
+		return nil
+	}
+	srv.health.Shutdown()
+
+	done := make(chan struct{})
+	go func() {
+		srv.GracefulStop()
+		close(done)
+	}()
+
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		srv.Stop()
+		return ctx.Err()
+	}
+}
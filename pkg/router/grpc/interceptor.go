@@ -0,0 +1,80 @@
+package grpc
+
+import (
+	"context"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"apigateway/pkg/middleware"
+
+	"github.com/google/uuid"
+	"github.com/rs/zerolog/log"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+// metadataRequestID 與 HTTP 的 X-Request-Id 相同, metadata key 一律小寫
+const metadataRequestID = "x-request-id"
+
+// requestIDInterceptor 沿用 client 帶來的 request id, 沒有時產生一個並回傳在 header
+func requestIDInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	var requestID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(metadataRequestID); len(ids) > 0 {
+			requestID = ids[0]
+		}
+	}
+	if requestID == "" {
+		requestID = uuid.New().String()
+	}
+	_ = grpc.SetHeader(ctx, metadata.Pairs(metadataRequestID, requestID))
+
+	zlog := log.With().Str("request_id", requestID).Logger()
+	ctx = middleware.ContextWithRequestID(zlog.WithContext(ctx), requestID)
+	return handler(ctx, req)
+}
+
+// loggingInterceptor 每個 rpc 結束時記錄 status code 與耗時
+func loggingInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (interface{}, error) {
+	start := time.Now()
+	resp, err := handler(ctx, req)
+
+	code := status.Code(err)
+	event := log.Ctx(ctx).Info()
+	if code == codes.Internal || code == codes.Unknown || code == codes.DataLoss {
+		event = log.Ctx(ctx).Error()
+	}
+	if p, ok := peer.FromContext(ctx); ok {
+		event = event.Str("peer", p.Addr.String())
+	}
+	event.
+		Str("method", info.FullMethod).
+		Str("code", code.String()).
+		Dur("latency", time.Since(start)).
+		Msg("grpc request")
+	return resp, err
+}
+
+// recoveryInterceptor panic 時回 Internal, 不把 panic 內容回給 client
+func recoveryInterceptor(ctx context.Context, req interface{}, info *grpc.UnaryServerInfo, handler grpc.UnaryHandler) (resp interface{}, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			log.Ctx(ctx).Error().
+				Str("method", info.FullMethod).
+				Str("stack", string(debug.Stack())).
+				Msgf("grpc panic recovered: %v", r)
+			err = status.Error(codes.Internal, "internal error")
+		}
+	}()
+	return handler(ctx, req)
+}
+
+// isPublicMethod health 與 reflection 不需驗證
+func isPublicMethod(method string) bool {
+	return strings.HasPrefix(method, "/grpc.health.v1.Health/") ||
+		strings.HasPrefix(method, "/grpc.reflection.v1alpha.ServerReflection/")
+}
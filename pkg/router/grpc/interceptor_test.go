@@ -0,0 +1,76 @@
+package grpc
+
+import (
+	"context"
+	"testing"
+
+	"apigateway/pkg/middleware"
+
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+var testInfo = &grpc.UnaryServerInfo{FullMethod: "/apigateway.book.v1.BookService/GetBook"}
+
+func TestRequestIDInterceptor(t *testing.T) {
+	tests := []struct {
+		name     string
+		incoming string
+	}{
+		{name: "from client", incoming: "abc-123"},
+		{name: "generated"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx := context.Background()
+			if tt.incoming != "" {
+				ctx = metadata.NewIncomingContext(ctx, metadata.Pairs(metadataRequestID, tt.incoming))
+			}
+
+			var got string
+			_, _ = requestIDInterceptor(ctx, nil, testInfo, func(ctx context.Context, req interface{}) (interface{}, error) {
+				got = middleware.RequestIDFromContext(ctx)
+				return nil, nil
+			})
+
+			switch {
+			case tt.incoming != "" && got != tt.incoming:
+				t.Errorf("request id = %q, want %q", got, tt.incoming)
+			case got == "":
+				t.Error("request id not generated")
+			}
+		})
+	}
+}
+
+func TestRecoveryInterceptor(t *testing.T) {
+	resp, err := recoveryInterceptor(context.Background(), nil, testInfo, func(context.Context, interface{}) (interface{}, error) {
+		panic("secret detail")
+	})
+	if resp != nil {
+		t.Errorf("resp = %v, want nil", resp)
+	}
+	st := status.Convert(err)
+	if st.Code() != codes.Internal || st.Message() != "internal error" {
+		t.Errorf("status = %s %q, want Internal without the panic value", st.Code(), st.Message())
+	}
+
+	want := status.Error(codes.NotFound, "book not found")
+	if _, err := recoveryInterceptor(context.Background(), nil, testInfo, func(context.Context, interface{}) (interface{}, error) {
+		return nil, want
+	}); err != want {
+		t.Errorf("err = %v, want the handler error unchanged", err)
+	}
+}
+
+func TestLoggingInterceptorPassesThrough(t *testing.T) {
+	want := status.Error(codes.Internal, "internal error")
+	resp, err := loggingInterceptor(context.Background(), nil, testInfo, func(context.Context, interface{}) (interface{}, error) {
+		return "resp", want
+	})
+	if resp != "resp" || err != want {
+		t.Errorf("loggingInterceptor() = %v, %v", resp, err)
+	}
+}
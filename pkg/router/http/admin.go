@@ -0,0 +1,136 @@
+package http
+
+import (
+	"crypto/subtle"
+	"net/http"
+	"strings"
+
+	"apigateway/pkg/apperror"
+	"apigateway/pkg/cache"
+	"apigateway/pkg/metrics"
+	"apigateway/pkg/proxy"
+	"apigateway/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// AdminConfig /admin api 以 `Authorization: Bearer <token>` 驗證
+type AdminConfig struct {
+	// Tokens 可使用 ENC[...] 加密. 空白的 token 會被忽略, 例如沒有設定的 ${APIGW_ADMIN_TOKEN}
+	Tokens []string
+}
+
+// tokens 非空白的 token
+func (cfg *AdminConfig) tokens() []string {
+	if cfg == nil {
+		return nil
+	}
+	tokens := make([]string, 0, len(cfg.Tokens))
+	for _, t := range cfg.Tokens {
+		if strings.TrimSpace(t) != "" {
+			tokens = append(tokens, t)
+		}
+	}
+	return tokens
+}
+
+// RegisteAdmin 管理用的 api, 沒有任何 admin token 時不註冊
+func RegisteAdmin(cfg *AdminConfig, table *proxy.Table, limiter *ratelimit.Limiter, c *cache.Cache) func(*gin.Engine) *gin.Engine {
+	return func(g *gin.Engine) *gin.Engine {
+		tokens := cfg.tokens()
+		if len(tokens) == 0 {
+			log.Warn().Msg("http.admin.tokens is not configured, admin api disabled")
+			return g
+		}
+		admin := g.Group("/admin", adminAuth(tokens))
+
+		admin.GET("/upstreams", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{
+				"upstreams": table.Pools(),
+			})
+		})
+
+		admin.GET("/metrics", metrics.Handler())
+
+		admin.GET("/ratelimits", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{
+				"routes": limiter.Rules(),
+			})
+		})
+
+		// 覆寫到下次 reload 改變該 route 的設定為止
+		admin.PUT("/ratelimits/:route", func(c *gin.Context) {
+			var body struct {
+				Rules []*ratelimit.Config `json:"rules"`
+			}
+			if err := c.ShouldBindJSON(&body); err != nil {
+				apperror.Abort(c, apperror.ErrBadRequest.WithMessage("The request body is not valid JSON.").Wrap(err))
+				return
+			}
+
+			if err := limiter.Override(c.Param("route"), body.Rules); err != nil {
+				adminError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, limiter.Rules()[c.Param("route")])
+		})
+
+		admin.DELETE("/ratelimits/:route", func(c *gin.Context) {
+			if err := limiter.Reset(c.Param("route")); err != nil {
+				adminError(c, err)
+				return
+			}
+			c.JSON(http.StatusOK, limiter.Rules()[c.Param("route")])
+		})
+
+		admin.GET("/cache", func(ctx *gin.Context) {
+			ctx.JSON(http.StatusOK, c.Stats())
+		})
+
+		// DELETE /admin/cache?key=... 或 ?tag=...
+		admin.DELETE("/cache", func(ctx *gin.Context) {
+			key, tag := ctx.Query("key"), ctx.Query("tag")
+			switch {
+			case key != "" && tag == "":
+				ctx.JSON(http.StatusOK, gin.H{"purged": c.PurgeKey(key)})
+			case tag != "" && key == "":
+				ctx.JSON(http.StatusOK, gin.H{"purged": c.PurgeTag(tag)})
+			default:
+				apperror.Abort(ctx, apperror.ErrBadRequest.WithMessage("Exactly one of key or tag is required."))
+			}
+		})
+
+		return g
+	}
+}
+
+// adminAuth token 以 constant time 比對
+func adminAuth(tokens []string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		const prefix = "bearer "
+		value := c.GetHeader("Authorization")
+		if len(value) > len(prefix) && strings.EqualFold(value[:len(prefix)], prefix) {
+			token := []byte(value[len(prefix):])
+			for _, t := range tokens {
+				if subtle.ConstantTimeCompare(token, []byte(t)) == 1 {
+					c.Next()
+					return
+				}
+			}
+		}
+
+		log.Warn().Str("remote_addr", c.Request.RemoteAddr).Str("path", c.Request.URL.Path).Msg("admin api unauthorized")
+		c.Header("WWW-Authenticate", `Bearer realm="admin"`)
+		apperror.Abort(c, apperror.ErrUnauthorized)
+	}
+}
+
+// adminError limiter 的錯誤訊息是給管理者看的, 直接放在 message
+func adminError(c *gin.Context, err error) {
+	if err == ratelimit.ErrUnknownRoute {
+		apperror.Abort(c, apperror.ErrNotFound.WithMessage(err.Error()))
+		return
+	}
+	apperror.Abort(c, apperror.ErrBadRequest.WithMessage(err.Error()))
+}
@@ -0,0 +1,49 @@
+package http
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"apigateway/pkg/ratelimit"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestAdminAuth(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	tests := []struct {
+		name          string
+		cfg           *AdminConfig
+		authorization string
+		wantStatus    int
+	}{
+		{name: "not configured", cfg: nil, authorization: "Bearer secret", wantStatus: http.StatusNotFound},
+		{name: "unset env token", cfg: &AdminConfig{Tokens: []string{""}}, authorization: "Bearer ", wantStatus: http.StatusNotFound},
+		{name: "missing token", cfg: &AdminConfig{Tokens: []string{"secret"}}, wantStatus: http.StatusUnauthorized},
+		{name: "wrong token", cfg: &AdminConfig{Tokens: []string{"secret"}}, authorization: "Bearer nope", wantStatus: http.StatusUnauthorized},
+		{name: "basic scheme", cfg: &AdminConfig{Tokens: []string{"secret"}}, authorization: "Basic secret", wantStatus: http.StatusUnauthorized},
+		{name: "valid token", cfg: &AdminConfig{Tokens: []string{"", "secret"}}, authorization: "bearer secret", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			router := gin.New()
+			RegisteAdmin(tt.cfg, nil, ratelimit.NewLimiter(ratelimit.NewMemoryStore()), nil)(router)
+
+			req := httptest.NewRequest(http.MethodGet, "/admin/ratelimits", nil)
+			if tt.authorization != "" {
+				req.Header.Set("Authorization", tt.authorization)
+			}
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, req)
+
+			if rec.Code != tt.wantStatus {
+				t.Fatalf("status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if rec.Code == http.StatusUnauthorized && rec.Header().Get("WWW-Authenticate") == "" {
+				t.Error("401 without WWW-Authenticate")
+			}
+		})
+	}
+}
@@ -1,6 +1,15 @@
 package http
 
-import "github.com/gin-gonic/gin"
+import (
+	"apigateway/pkg/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+// AuthConfig 以 bearer token 識別 principal, 例如 rate limit 的 key_by principal
+type AuthConfig struct {
+	Tokens []*middleware.TokenConfig `validate:"required,min=1,dive,required"`
+}
 
 // RegisteAuth ...
 func RegisteAuth(g *gin.Engine) *gin.Engine {
@@ -2,8 +2,12 @@ package http
 
 import (
 	"net/http"
+	"sort"
+	"strings"
 	"time"
 
+	"apigateway/pkg/apperror"
+
 	"github.com/gin-gonic/gin"
 )
 
@@ -20,10 +24,48 @@ func RegisteDefault(g *gin.Engine) *gin.Engine {
 		})
 	})
 	g.NoRoute(func(c *gin.Context) {
-		c.JSON(http.StatusNotFound, gin.H{
-			"message": "unmatched router",
-		})
+		apperror.Abort(c, apperror.ErrNotFound.WithMessage("No route matches the request path."))
+	})
+
+	// path 存在但 method 不符合時回 405, Allow 列出該 path 可用的 method
+	g.HandleMethodNotAllowed = true
+	g.NoMethod(func(c *gin.Context) {
+		c.Header("Allow", strings.Join(allowedMethods(g.Routes(), c.Request.URL.Path), ", "))
+		apperror.Abort(c, apperror.ErrMethodNotAllowed)
 	})
 
 	return g
 }
+
+// allowedMethods gin 不會提供符合 path 的 method, 依註冊的 route pattern 比對
+func allowedMethods(routes gin.RoutesInfo, path string) []string {
+	seen := map[string]bool{}
+	var methods []string
+	for _, r := range routes {
+		if seen[r.Method] || !matchRoute(r.Path, path) {
+			continue
+		}
+		seen[r.Method] = true
+		methods = append(methods, r.Method)
+	}
+	sort.Strings(methods)
+	return methods
+}
+
+// matchRoute 支援 gin 的 :param 與 *catchAll
+func matchRoute(pattern, path string) bool {
+	ps := strings.Split(strings.Trim(pattern, "/"), "/")
+	segs := strings.Split(strings.Trim(path, "/"), "/")
+	for i, p := range ps {
+		if strings.HasPrefix(p, "*") {
+			return true
+		}
+		if i >= len(segs) {
+			return false
+		}
+		if !strings.HasPrefix(p, ":") && p != segs[i] {
+			return false
+		}
+	}
+	return len(ps) == len(segs)
+}
@@ -0,0 +1,54 @@
+package http
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"apigateway/pkg/apperror"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRegisteDefaultErrors(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+	router := RegisteDefault(gin.New())
+	router.GET("/books/:id", func(c *gin.Context) {})
+	router.DELETE("/books/:id", func(c *gin.Context) {})
+	router.GET("/files/*path", func(c *gin.Context) {})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		wantStatus int
+		wantCode   string
+		wantAllow  string
+	}{
+		{name: "no route", method: http.MethodGet, path: "/authors", wantStatus: http.StatusNotFound, wantCode: "not_found"},
+		{name: "param", method: http.MethodPost, path: "/books/1", wantStatus: http.StatusMethodNotAllowed, wantCode: "method_not_allowed", wantAllow: "DELETE, GET"},
+		{name: "catch all", method: http.MethodPut, path: "/files/a/b", wantStatus: http.StatusMethodNotAllowed, wantCode: "method_not_allowed", wantAllow: "GET"},
+		{name: "static", method: http.MethodPost, path: "/ping", wantStatus: http.StatusMethodNotAllowed, wantCode: "method_not_allowed", wantAllow: "GET"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(tt.method, tt.path, nil))
+
+			if rec.Code != tt.wantStatus || rec.Header().Get("Content-Type") != apperror.ContentType {
+				t.Fatalf("response = %d %s, want %d problem", rec.Code, rec.Header().Get("Content-Type"), tt.wantStatus)
+			}
+			if allow := rec.Header().Get("Allow"); allow != tt.wantAllow {
+				t.Errorf("Allow = %q, want %q", allow, tt.wantAllow)
+			}
+			var p apperror.Problem
+			if err := json.Unmarshal(rec.Body.Bytes(), &p); err != nil {
+				t.Fatal(err)
+			}
+			if p.Code != tt.wantCode || p.Instance != tt.path {
+				t.Errorf("problem = %+v", p)
+			}
+		})
+	}
+}
@@ -1,6 +1,11 @@
 package http
 
 import (
+	"apigateway/pkg/cache"
+	"apigateway/pkg/event"
+	"apigateway/pkg/health"
+	"apigateway/pkg/proxy"
+	"apigateway/pkg/ratelimit"
 	v1 "apigateway/pkg/router/http/v1"
 	"apigateway/pkg/service"
 
@@ -9,23 +14,31 @@ import (
 
 // Handler Restful api handler
 type Handler struct {
-	Svc service.IService
+	Svc         service.IService
+	Events      *event.Broker
+	EventConfig *event.Config
+	Health      *health.Checker
 }
 
 // NewHandler Create restful api handler
-func NewHandler(svc service.IService) *Handler {
+func NewHandler(svc service.IService, events *event.Broker, eventCfg *event.Config, checker *health.Checker) *Handler {
 	return &Handler{
-		Svc: svc,
+		Svc:         svc,
+		Events:      events,
+		EventConfig: eventCfg,
+		Health:      checker,
 	}
 }
 
 // RegisteRouter ...
-func RegisteRouter(router *gin.Engine) {
+func RegisteRouter(router *gin.Engine, cfg *Config, h *Handler, table *proxy.Table, limiter *ratelimit.Limiter, c *cache.Cache) {
 	Scopes(
 		router,
 		RegisteDefault,
+		RegisteProbe(h.Health),
 		RegisteAuth,
-		v1.RegisteBook,
+		RegisteAdmin(cfg.Admin, table, limiter, c),
+		v1.RegisteBook(h.Svc, h.Events, h.EventConfig),
 		// add new http router at here
 	)
 }
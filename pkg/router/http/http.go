@@ -1,10 +1,23 @@
 package http
 
 import (
+	"context"
+	"fmt"
+	"net"
 	"net/http"
 	"time"
 
+	"apigateway/pkg/cache"
+	"apigateway/pkg/clientip"
+	"apigateway/pkg/database"
+	"apigateway/pkg/lifecycle"
+	"apigateway/pkg/middleware"
+	"apigateway/pkg/proxy"
+	"apigateway/pkg/ratelimit"
+	"apigateway/pkg/validation"
+
 	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
 	"github.com/rs/zerolog/log"
 	"go.uber.org/fx"
 )
@@ -13,6 +26,7 @@ import (
 var Module = fx.Options(
 	fx.Provide(
 		NewHandler,
+		NewTimeouts,
 		NewServer,
 	),
 	fx.Invoke(RunServer),
@@ -20,50 +34,177 @@ var Module = fx.Options(
 
 // Config the structure for HTTP
 type Config struct {
-	Mode           string        `json:"mode"`
-	Address        string        `json:"address"`
-	AppID          string        `yaml:"app_id" mapstructure:"app_id"`
-	ReadTimeout    time.Duration `json:"read_timeout"`
-	WriteTimeout   time.Duration `json:"write_timeout"`
-	MaxHeaderBytes int           `json:"max_header_bytes"`
+	Mode    string `json:"mode" validate:"required,oneof=debug release test"`
+	Address string `json:"address" validate:"required"`
+	AppID   string `yaml:"app_id" mapstructure:"app_id"`
+	// ReadTimeout 與 WriteTimeout 可以 reload, 從下一個 request 開始生效. 讀取 header 的 timeout 固定為啟動時的 ReadTimeout
+	ReadTimeout    time.Duration `json:"read_timeout" mapstructure:"read_timeout" validate:"min=0"`
+	WriteTimeout   time.Duration `json:"write_timeout" mapstructure:"write_timeout" validate:"min=0"`
+	MaxHeaderBytes int           `json:"max_header_bytes" mapstructure:"max_header_bytes" validate:"min=0"`
+	// Middlewares 依序套用的 global middleware, 預設為 request_id, access_log, recovery
+	Middlewares []string `validate:"dive,oneof=request_id access_log recovery"`
+	// TrustedProxies 可信任的 proxy (CIDR 或 IP), 只有來自這些位址的 X-Forwarded-For 才會被採用
+	TrustedProxies []string `yaml:"trusted_proxies" mapstructure:"trusted_proxies" validate:"dive,cidr|ip"`
+	// Auth 沒有設定時所有 request 都是匿名的
+	Auth *AuthConfig `validate:"omitempty"`
+	// Admin 沒有設定時不提供 /admin api
+	Admin *AdminConfig `validate:"omitempty"`
+	// TLS 沒有設定時使用 plain HTTP
+	TLS *TLSConfig `yaml:"tls" mapstructure:"tls" validate:"omitempty"`
+	// QueryProfile 只在 debug mode 生效
+	QueryProfile *database.ProfileConfig `yaml:"query_profile" mapstructure:"query_profile"`
+}
+
+// NewTimeouts read_timeout 與 write_timeout, reload 時由 config 更新
+func NewTimeouts(cfg *Config) *middleware.Timeouts {
+	return middleware.NewTimeouts(cfg.ReadTimeout, cfg.WriteTimeout)
 }
 
 // NewServer ...
-func NewServer(cfg *Config) *http.Server {
-	router := gin.Default()
+func NewServer(lc fx.Lifecycle, cfg *Config, h *Handler, timeouts *middleware.Timeouts, table *proxy.Table, limiter *ratelimit.Limiter, c *cache.Cache) (*http.Server, error) {
+	// release mode 的錯誤回應不包含內部原因
+	gin.SetMode(cfg.Mode)
+	binding.Validator = validation.Binding
+	if err := clientip.SetTrustedProxies(cfg.TrustedProxies); err != nil {
+		return nil, err
+	}
+	router := gin.New()
+	// c.ClientIP() 不直接信任 X-Forwarded-For, 需要 client ip 時使用 clientip.FromRequest
+	router.ForwardedByClientIP = false
 
-	// use middleware
+	// timeout 在最外層, 整個 request 都受 deadline 限制
+	router.Use(timeouts.Handler())
 
 	// Global middleware
-	// Logger middleware will write the logs to gin.DefaultWriter even if you set with GIN_MODE=release.
-	// By default gin.DefaultWriter = os.Stdout
-	// g.Use(gin.Logger())
+	handlers, err := globalMiddlewares(cfg)
+	if err != nil {
+		return nil, err
+	}
+	router.Use(handlers...)
 
-	// Recovery middleware recovers from any panics and writes a 500 if there was one.
-	// g.Use(gin.Recovery())
+	if cfg.Auth != nil {
+		router.Use(middleware.Principal(cfg.Auth.Tokens))
+	}
 
-	RegisteRouter(router)
+	if cfg.Mode == gin.DebugMode && cfg.QueryProfile != nil && cfg.QueryProfile.Enabled {
+		router.Use(middleware.QueryProfiler(cfg.QueryProfile))
+	}
+
+	// 沒有符合本地 handler 的 request 交給 proxy route table
+	router.Use(table.Handler())
+
+	RegisteRouter(router, cfg, h, table, limiter, c)
 
 	// create server to run
-	return &http.Server{
-		Addr:           cfg.Address,
-		Handler:        router,
-		ReadTimeout:    cfg.ReadTimeout,
-		WriteTimeout:   cfg.WriteTimeout,
-		MaxHeaderBytes: cfg.MaxHeaderBytes,
+	// ReadTimeout 與 WriteTimeout 由 timeouts 在每個 request 設定, server 上維持 0
+	srv := &http.Server{
+		Addr:              cfg.Address,
+		Handler:           router,
+		ReadHeaderTimeout: cfg.ReadTimeout,
+		MaxHeaderBytes:    cfg.MaxHeaderBytes,
+		ConnContext:       timeouts.ConnContext,
+	}
+
+	// Shutdown 不會等待 hijacked connections, 由 proxy 送出 close frame 後自行關閉.
+	// SSE 的 request 不會自行結束, 一併中斷
+	srv.RegisterOnShutdown(table.CloseUpgraded)
+	srv.RegisterOnShutdown(h.Events.Close)
+
+	if cfg.TLS != nil {
+		store, err := newCertStore(cfg.TLS)
+		if err != nil {
+			return nil, err
+		}
+		if err := store.watch(); err != nil {
+			return nil, err
+		}
+		srv.TLSConfig = store.tlsConfig()
+
+		lc.Append(fx.Hook{
+			OnStop: func(context.Context) error {
+				return store.close()
+			},
+		})
 	}
+
+	return srv, nil
 }
 
-// RunServer ...
-func RunServer(cfg *Config, srv *http.Server) error {
+// global middleware names
+const (
+	MiddlewareRequestID = "request_id"
+	MiddlewareAccessLog = "access_log"
+	MiddlewareRecovery  = "recovery"
+)
+
+// defaultMiddlewares recovery 在最內層, access log 才能記錄 panic 的 500
+var defaultMiddlewares = []string{MiddlewareRequestID, MiddlewareAccessLog, MiddlewareRecovery}
+
+func globalMiddlewares(cfg *Config) ([]gin.HandlerFunc, error) {
+	names := cfg.Middlewares
+	if len(names) == 0 {
+		names = defaultMiddlewares
+	}
 
-	// Initializing the server in a goroutine so that
-	// it won't block the graceful shutdown handling below
-	go func() {
-		if err := srv.ListenAndServe(); err != nil && err != http.ErrServerClosed {
-			log.Fatal().Msgf("listen: %s\n", err)
+	handlers := make([]gin.HandlerFunc, 0, len(names))
+	used := map[string]bool{}
+	for _, name := range names {
+		if used[name] {
+			return nil, fmt.Errorf("http.middlewares: %s is listed more than once", name)
 		}
-	}()
+		used[name] = true
+
+		switch name {
+		case MiddlewareRequestID:
+			handlers = append(handlers, middleware.RequestID())
+		case MiddlewareAccessLog:
+			handlers = append(handlers, middleware.AccessLogger(cfg.AppID))
+		case MiddlewareRecovery:
+			handlers = append(handlers, middleware.Recovery())
+		default:
+			return nil, fmt.Errorf("http.middlewares: unknown middleware %s", name)
+		}
+	}
+	return handlers, nil
+}
+
+// RunServer listen 失敗時直接讓 app 啟動失敗, 執行中發生錯誤時關閉 app
+func RunServer(lc fx.Lifecycle, srv *http.Server, table *proxy.Table, d *lifecycle.Drainer, shutdowner fx.Shutdowner) {
+	// drain 期間回應的 connection 不再 keep-alive, 讓 client 改連其他 instance
+	d.OnDrain(func() {
+		srv.SetKeepAlivesEnabled(false)
+	})
+
+	lc.Append(fx.Hook{
+		OnStart: func(context.Context) error {
+			lis, err := net.Listen("tcp", srv.Addr)
+			if err != nil {
+				return err
+			}
 
-	return nil
+			go func() {
+				var err error
+				if srv.TLSConfig != nil {
+					// 憑證由 TLSConfig 提供
+					err = srv.ServeTLS(lis, "", "")
+				} else {
+					err = srv.Serve(lis)
+				}
+				if err != nil && err != http.ErrServerClosed {
+					log.Error().Msgf("listen: %s", err)
+					_ = shutdowner.Shutdown()
+				}
+			}()
+			log.Info().Str("address", srv.Addr).Msg("http server started")
+			return nil
+		},
+		OnStop: func(ctx context.Context) error {
+			// 停止 accept 並等待進行中的 request, hijacked connections 由 RegisterOnShutdown 關閉
+			if err := srv.Shutdown(ctx); err != nil {
+				srv.Close()
+				return fmt.Errorf("http server forced to shutdown: %v", err)
+			}
+			return table.WaitUpgraded(ctx)
+		},
+	})
 }
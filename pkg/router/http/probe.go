@@ -0,0 +1,29 @@
+package http
+
+import (
+	"net/http"
+
+	"apigateway/pkg/health"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RegisteProbe /healthz 只表示程序仍在執行, /readyz 彙整所有 readiness check,
+// 只有 critical 的 check 失敗才回 503
+func RegisteProbe(checker *health.Checker) func(*gin.Engine) *gin.Engine {
+	return func(g *gin.Engine) *gin.Engine {
+		g.GET("/healthz", func(c *gin.Context) {
+			c.JSON(http.StatusOK, gin.H{"status": health.StatusOK})
+		})
+		g.GET("/readyz", func(c *gin.Context) {
+			report := checker.Ready()
+			status := http.StatusOK
+			if report.Status == health.StatusFail {
+				status = http.StatusServiceUnavailable
+			}
+			c.Header("Cache-Control", "no-store")
+			c.JSON(status, report)
+		})
+		return g
+	}
+}
@@ -0,0 +1,59 @@
+package http
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"apigateway/pkg/health"
+
+	"github.com/gin-gonic/gin"
+)
+
+func TestRegisteProbe(t *testing.T) {
+	tests := []struct {
+		name       string
+		critical   bool
+		err        error
+		wantStatus int
+		wantReport string
+	}{
+		{name: "ok", critical: true, wantStatus: http.StatusOK, wantReport: health.StatusOK},
+		{name: "degraded", err: errors.New("down"), wantStatus: http.StatusOK, wantReport: health.StatusDegraded},
+		{name: "fail", critical: true, err: errors.New("down"), wantStatus: http.StatusServiceUnavailable, wantReport: health.StatusFail},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			checker := health.NewChecker(nil)
+			err := tt.err
+			checker.Register(health.Check{Name: "dep", Critical: tt.critical, Run: func(context.Context) error { return err }})
+			router := RegisteProbe(checker)(gin.New())
+
+			rec := httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/readyz", nil))
+			if rec.Code != tt.wantStatus {
+				t.Errorf("/readyz status = %d, want %d", rec.Code, tt.wantStatus)
+			}
+			if cc := rec.Header().Get("Cache-Control"); cc != "no-store" {
+				t.Errorf("Cache-Control = %q, want no-store", cc)
+			}
+			var report health.Report
+			if err := json.Unmarshal(rec.Body.Bytes(), &report); err != nil {
+				t.Fatal(err)
+			}
+			if report.Status != tt.wantReport {
+				t.Errorf("report status = %s, want %s", report.Status, tt.wantReport)
+			}
+
+			// liveness 不受 readiness check 影響
+			rec = httptest.NewRecorder()
+			router.ServeHTTP(rec, httptest.NewRequest(http.MethodGet, "/healthz", nil))
+			if rec.Code != http.StatusOK {
+				t.Errorf("/healthz status = %d, want 200", rec.Code)
+			}
+		})
+	}
+}
@@ -0,0 +1,122 @@
+package http
+
+import (
+	"context"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+
+	"apigateway/pkg/lifecycle"
+	"apigateway/pkg/proxy"
+	"apigateway/pkg/ratelimit"
+
+	"go.uber.org/fx"
+)
+
+// hookLifecycle 保存 hook 由測試自行執行
+type hookLifecycle struct {
+	hooks []fx.Hook
+}
+
+func (lc *hookLifecycle) Append(h fx.Hook) {
+	lc.hooks = append(lc.hooks, h)
+}
+
+type testShutdowner struct{}
+
+func (testShutdowner) Shutdown(...fx.ShutdownOption) error { return nil }
+
+func freeAddr(t *testing.T) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer lis.Close()
+	return lis.Addr().String()
+}
+
+// TestRunServerDrain drain 之後不再 keep-alive, 停止時等待進行中的 request 完成
+func TestRunServerDrain(t *testing.T) {
+	started, release := make(chan struct{}), make(chan struct{})
+	mux := http.NewServeMux()
+	mux.HandleFunc("/slow", func(w http.ResponseWriter, req *http.Request) {
+		close(started)
+		<-release
+		_, _ = w.Write([]byte("done"))
+	})
+	mux.HandleFunc("/fast", func(w http.ResponseWriter, req *http.Request) {})
+
+	addr := freeAddr(t)
+	srv := &http.Server{Addr: addr, Handler: mux}
+	table, err := proxy.NewTable(&hookLifecycle{}, nil, nil, nil, nil, nil, ratelimit.NewLimiter(ratelimit.NewMemoryStore()), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer table.Close()
+	d := lifecycle.NewDrainer(nil)
+	lc := &hookLifecycle{}
+	RunServer(lc, srv, table, d, testShutdowner{})
+
+	ctx := context.Background()
+	if err := lc.hooks[0].OnStart(ctx); err != nil {
+		t.Fatal(err)
+	}
+	client := &http.Client{Transport: &http.Transport{}}
+	defer client.CloseIdleConnections()
+
+	resp, err := client.Get("http://" + addr + "/fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.Close {
+		t.Error("connection closed before drain")
+	}
+
+	slow := make(chan string)
+	go func() {
+		resp, err := http.Get("http://" + addr + "/slow")
+		if err != nil {
+			slow <- err.Error()
+			return
+		}
+		defer resp.Body.Close()
+		buf := make([]byte, 4)
+		n, _ := resp.Body.Read(buf)
+		slow <- string(buf[:n])
+	}()
+	<-started
+
+	if err := d.Drain(ctx); err != nil {
+		t.Fatal(err)
+	}
+	resp, err = client.Get("http://" + addr + "/fast")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if !resp.Close {
+		t.Error("connection kept alive while draining")
+	}
+
+	stopped := make(chan error)
+	go func() { stopped <- lc.hooks[0].OnStop(ctx) }()
+	select {
+	case err := <-stopped:
+		t.Fatalf("OnStop returned %v before the in-flight request finished", err)
+	case <-time.After(100 * time.Millisecond):
+	}
+	if _, err := net.DialTimeout("tcp", addr, time.Second); err == nil {
+		t.Error("server still accepts connections after OnStop")
+	}
+
+	close(release)
+	if body := <-slow; body != "done" {
+		t.Errorf("in-flight request = %q, want done", body)
+	}
+	if err := <-stopped; err != nil {
+		t.Error(err)
+	}
+}
@@ -0,0 +1,183 @@
+package http
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync/atomic"
+	"time"
+
+	"apigateway/pkg/watch"
+
+	"github.com/rs/zerolog/log"
+)
+
+// TLS cipher policies
+const (
+	// CipherModern TLS 1.2 只允許 ECDHE 與 AEAD 的 cipher suites
+	CipherModern = "modern"
+	// CipherCompatible 使用 Go 的預設值
+	CipherCompatible = "compatible"
+)
+
+// reloadDelay 檔案變更後等待的時間, 避免 cert 與 key 只更新了其中一個
+const reloadDelay = 500 * time.Millisecond
+
+var tlsVersions = map[string]uint16{
+	"1.0": tls.VersionTLS10,
+	"1.1": tls.VersionTLS11,
+	"1.2": tls.VersionTLS12,
+	"1.3": tls.VersionTLS13,
+}
+
+var modernCipherSuites = []uint16{
+	tls.TLS_ECDHE_ECDSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_RSA_WITH_AES_128_GCM_SHA256,
+	tls.TLS_ECDHE_ECDSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_RSA_WITH_AES_256_GCM_SHA384,
+	tls.TLS_ECDHE_ECDSA_WITH_CHACHA20_POLY1305,
+	tls.TLS_ECDHE_RSA_WITH_CHACHA20_POLY1305,
+}
+
+// TLSConfig 設定後 server 只接受 TLS, 檔案變更時自動重新載入, 不影響已建立的連線
+type TLSConfig struct {
+	// Certificates 依 SNI 選擇, 沒有符合的時候使用第一個
+	Certificates []*CertificateConfig `validate:"required,min=1,dive,required"`
+	// MinVersion 預設 1.2
+	MinVersion string `yaml:"min_version" mapstructure:"min_version" validate:"omitempty,oneof=1.0 1.1 1.2 1.3"`
+	// CipherPolicy 預設 modern, TLS 1.3 的 cipher suites 無法設定
+	CipherPolicy string `yaml:"cipher_policy" mapstructure:"cipher_policy" validate:"omitempty,oneof=modern compatible"`
+	// ClientCA 驗證 client 憑證的 CA bundle, 只有設定 client_cert 的 route 會要求 client 提供憑證
+	ClientCA string `yaml:"client_ca" mapstructure:"client_ca"`
+}
+
+// CertificateConfig PEM 格式的憑證與私鑰
+type CertificateConfig struct {
+	CertFile string `yaml:"cert_file" mapstructure:"cert_file" validate:"required"`
+	KeyFile  string `yaml:"key_file" mapstructure:"key_file" validate:"required"`
+}
+
+// certStore 保存目前的憑證, handshake 時才讀取, 替換後只影響新的連線
+type certStore struct {
+	cfg *TLSConfig
+	// certs []*tls.Certificate
+	certs atomic.Value
+	// clientCAs *x509.CertPool
+	clientCAs atomic.Value
+	watcher   io.Closer
+}
+
+func newCertStore(cfg *TLSConfig) (*certStore, error) {
+	s := &certStore{cfg: cfg}
+	if err := s.load(); err != nil {
+		return nil, err
+	}
+	return s, nil
+}
+
+// load 全部檔案都讀取成功才替換
+func (s *certStore) load() error {
+	certs := make([]*tls.Certificate, 0, len(s.cfg.Certificates))
+	for _, cc := range s.cfg.Certificates {
+		cert, err := tls.LoadX509KeyPair(cc.CertFile, cc.KeyFile)
+		if err != nil {
+			return fmt.Errorf("tls: %s: %v", cc.CertFile, err)
+		}
+		if cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0]); err != nil {
+			return fmt.Errorf("tls: %s: %v", cc.CertFile, err)
+		}
+		certs = append(certs, &cert)
+	}
+
+	var pool *x509.CertPool
+	if s.cfg.ClientCA != "" {
+		data, err := ioutil.ReadFile(s.cfg.ClientCA)
+		if err != nil {
+			return fmt.Errorf("tls: %v", err)
+		}
+		pool = x509.NewCertPool()
+		if !pool.AppendCertsFromPEM(data) {
+			return fmt.Errorf("tls: %s: no certificates found", s.cfg.ClientCA)
+		}
+	}
+
+	s.certs.Store(certs)
+	if pool != nil {
+		s.clientCAs.Store(pool)
+	}
+	return nil
+}
+
+// getCertificate 依 SNI 與 client 支援的演算法選擇憑證
+func (s *certStore) getCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
+	certs := s.certs.Load().([]*tls.Certificate)
+	if hello.ServerName != "" {
+		for _, cert := range certs {
+			if hello.SupportsCertificate(cert) == nil {
+				return cert, nil
+			}
+		}
+	}
+	return certs[0], nil
+}
+
+// tlsConfig 有 client CA 時每次 handshake 取用最新的 CA pool
+func (s *certStore) tlsConfig() *tls.Config {
+	base := &tls.Config{
+		GetCertificate: s.getCertificate,
+		MinVersion:     tls.VersionTLS12,
+		NextProtos:     []string{"h2", "http/1.1"},
+	}
+	if s.cfg.MinVersion != "" {
+		base.MinVersion = tlsVersions[s.cfg.MinVersion]
+	}
+	if s.cfg.CipherPolicy != CipherCompatible {
+		base.CipherSuites = modernCipherSuites
+	}
+	if s.cfg.ClientCA == "" {
+		return base
+	}
+
+	cfg := base.Clone()
+	cfg.GetConfigForClient = func(*tls.ClientHelloInfo) (*tls.Config, error) {
+		c := base.Clone()
+		// 是否必須提供憑證由 route 決定
+		c.ClientAuth = tls.VerifyClientCertIfGiven
+		c.ClientCAs = s.clientCAs.Load().(*x509.CertPool)
+		return c, nil
+	}
+	return cfg
+}
+
+// watch 檔案變更時重新載入, 失敗時沿用原本的憑證
+func (s *certStore) watch() error {
+	paths := []string{}
+	for _, cc := range s.cfg.Certificates {
+		paths = append(paths, cc.CertFile, cc.KeyFile)
+	}
+	if s.cfg.ClientCA != "" {
+		paths = append(paths, s.cfg.ClientCA)
+	}
+
+	w, err := watch.Files(paths, reloadDelay, func() {
+		if err := s.load(); err != nil {
+			log.Error().Msgf("%v, keep using the previous certificates", err)
+			return
+		}
+		log.Info().Int("certificates", len(s.cfg.Certificates)).Msg("tls certificates reloaded")
+	})
+	if err != nil {
+		return err
+	}
+	s.watcher = w
+	return nil
+}
+
+func (s *certStore) close() error {
+	if s == nil || s.watcher == nil {
+		return nil
+	}
+	return s.watcher.Close()
+}
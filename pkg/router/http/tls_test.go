@@ -0,0 +1,267 @@
+package http
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io/ioutil"
+	"log"
+	"math/big"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+// testCA 測試用的 CA, issue 簽發 server 或 client 憑證並寫入 dir
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+	dir  string
+}
+
+func newTestCA(t *testing.T, dir, name string) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	ca := &testCA{cert: cert, key: key, dir: dir}
+	writePEM(t, filepath.Join(dir, name+".crt"), "CERTIFICATE", der)
+	return ca
+}
+
+func (ca *testCA) file() string {
+	return filepath.Join(ca.dir, ca.cert.Subject.CommonName+".crt")
+}
+
+func (ca *testCA) pool() *x509.CertPool {
+	pool := x509.NewCertPool()
+	pool.AddCert(ca.cert)
+	return pool
+}
+
+// issue 回傳憑證與私鑰的檔案路徑
+func (ca *testCA) issue(t *testing.T, name string, client bool, dnsNames ...string) (string, string) {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	usage := x509.ExtKeyUsageServerAuth
+	if client {
+		usage = x509.ExtKeyUsageClientAuth
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(time.Now().UnixNano()),
+		Subject:      pkix.Name{CommonName: name},
+		DNSNames:     dnsNames,
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{usage},
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, ca.cert, &key.PublicKey, ca.key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	certFile, keyFile := filepath.Join(ca.dir, name+".crt"), filepath.Join(ca.dir, name+".key")
+	writePEM(t, certFile, "CERTIFICATE", der)
+	writePEM(t, keyFile, "EC PRIVATE KEY", keyDER)
+	return certFile, keyFile
+}
+
+func writePEM(t *testing.T, file, typ string, der []byte) {
+	t.Helper()
+	if err := ioutil.WriteFile(file, pem.EncodeToMemory(&pem.Block{Type: typ, Bytes: der}), 0600); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func tempDir(t *testing.T) string {
+	t.Helper()
+	dir, err := ioutil.TempDir("", "tls")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { os.RemoveAll(dir) })
+	return dir
+}
+
+// serveTLS httptest 會加入自己的憑證, 改為直接使用 store 的設定, 回傳 https url
+func serveTLS(t *testing.T, store *certStore, h http.Handler) string {
+	t.Helper()
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	srv := &http.Server{Handler: h, ErrorLog: log.New(ioutil.Discard, "", 0)}
+	go func() { _ = srv.Serve(tls.NewListener(lis, store.tlsConfig())) }()
+	t.Cleanup(func() { srv.Close() })
+	return "https://" + lis.Addr().String()
+}
+
+func TestCertStoreSNI(t *testing.T) {
+	ca := newTestCA(t, tempDir(t), "ca")
+	aCert, aKey := ca.issue(t, "a", false, "a.test")
+	bCert, bKey := ca.issue(t, "b", false, "b.test", "*.b.test")
+
+	store, err := newCertStore(&TLSConfig{Certificates: []*CertificateConfig{
+		{CertFile: aCert, KeyFile: aKey},
+		{CertFile: bCert, KeyFile: bKey},
+	}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := strings.TrimPrefix(serveTLS(t, store, http.NotFoundHandler()), "https://")
+
+	tests := []struct {
+		serverName string
+		want       string
+	}{
+		{serverName: "a.test", want: "a"},
+		{serverName: "b.test", want: "b"},
+		{serverName: "api.b.test", want: "b"},
+		// 沒有符合的 SNI 使用第一個憑證
+		{serverName: "", want: "a"},
+		{serverName: "c.test", want: "a"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.serverName, func(t *testing.T) {
+			conn, err := tls.Dial("tcp", addr, &tls.Config{ServerName: tt.serverName, InsecureSkipVerify: true})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+			if got := conn.ConnectionState().PeerCertificates[0].Subject.CommonName; got != tt.want {
+				t.Errorf("certificate = %s, want %s", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestCertStoreReload(t *testing.T) {
+	dir := tempDir(t)
+	ca := newTestCA(t, dir, "ca")
+	certFile, keyFile := ca.issue(t, "server", false, "a.test")
+
+	store, err := newCertStore(&TLSConfig{Certificates: []*CertificateConfig{{CertFile: certFile, KeyFile: keyFile}}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	serial := func() *big.Int {
+		return store.certs.Load().([]*tls.Certificate)[0].Leaf.SerialNumber
+	}
+	before := serial()
+
+	// 讀取失敗時沿用原本的憑證
+	if err := ioutil.WriteFile(certFile, []byte("broken"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.load(); err == nil {
+		t.Fatal("load() with a broken certificate succeeded")
+	}
+	if serial().Cmp(before) != 0 {
+		t.Fatal("certificate replaced after a failed load")
+	}
+
+	ca.issue(t, "server", false, "a.test")
+	if err := store.load(); err != nil {
+		t.Fatal(err)
+	}
+	if serial().Cmp(before) == 0 {
+		t.Error("certificate not replaced after load")
+	}
+}
+
+func TestClientCertVerification(t *testing.T) {
+	dir := tempDir(t)
+	ca := newTestCA(t, dir, "ca")
+	other := newTestCA(t, dir, "other-ca")
+	serverCert, serverKey := ca.issue(t, "server", false, "a.test")
+	clientCert, clientKey := ca.issue(t, "partner", true)
+	untrustedCert, untrustedKey := other.issue(t, "intruder", true)
+
+	store, err := newCertStore(&TLSConfig{
+		Certificates: []*CertificateConfig{{CertFile: serverCert, KeyFile: serverKey}},
+		ClientCA:     ca.file(),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := serveTLS(t, store, http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		if len(req.TLS.VerifiedChains) == 0 {
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		_, _ = w.Write([]byte(req.TLS.VerifiedChains[0][0].Subject.CommonName))
+	}))
+
+	tests := []struct {
+		name        string
+		cert, key   string
+		wantErr     bool
+		wantSubject string
+	}{
+		// 憑證是否必須由 route 決定, handshake 本身允許沒有憑證
+		{name: "no certificate"},
+		{name: "trusted", cert: clientCert, key: clientKey, wantSubject: "partner"},
+		{name: "untrusted", cert: untrustedCert, key: untrustedKey, wantErr: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cfg := &tls.Config{ServerName: "a.test", RootCAs: ca.pool()}
+			if tt.cert != "" {
+				cert, err := tls.LoadX509KeyPair(tt.cert, tt.key)
+				if err != nil {
+					t.Fatal(err)
+				}
+				// client 只送出符合 server 接受的 CA 的憑證, 固定送出以驗證 server 拒絕不信任的憑證
+				cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+					return &cert, nil
+				}
+			}
+			client := &http.Client{Transport: &http.Transport{TLSClientConfig: cfg}}
+			defer client.CloseIdleConnections()
+
+			resp, err := client.Get(url)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("Get() error = %v, wantErr %v", err, tt.wantErr)
+			}
+			if err != nil {
+				return
+			}
+			defer resp.Body.Close()
+			body, _ := ioutil.ReadAll(resp.Body)
+			if string(body) != tt.wantSubject {
+				t.Errorf("verified subject = %q, want %q", body, tt.wantSubject)
+			}
+		})
+	}
+}
@@ -1,33 +1,171 @@
 package v1
 
-import "github.com/gin-gonic/gin"
+import (
+	"errors"
+	"net/http"
+	"strconv"
+
+	"apigateway/pkg/apperror"
+	"apigateway/pkg/event"
+	"apigateway/pkg/model"
+	"apigateway/pkg/service"
+	"apigateway/pkg/validation"
+
+	"github.com/gin-gonic/gin"
+)
+
+var (
+	errInvalidBookID = apperror.ErrBadRequest.WithMessage("Invalid book id.")
+	errBookNotFound  = apperror.New(http.StatusNotFound, "book_not_found", "Book not found.")
+)
+
+// bookRequest create 與 update 的 request body, id 由 path 決定
+type bookRequest struct {
+	Name string `json:"name" validate:"required,max=255"`
+	ISBN string `json:"isbn" validate:"omitempty,max=17,isbn"`
+	Slug string `json:"slug" validate:"omitempty,max=128,slug"`
+}
+
+func (r *bookRequest) book(id int) model.Book {
+	return model.Book{ID: id, Name: r.Name, ISBN: r.ISBN, Slug: r.Slug}
+}
+
+type bookHandler struct {
+	svc    service.IService
+	events *event.Broker
+	cfg    *event.Config
+}
 
 // RegisteBook ...
-func RegisteBook(g *gin.Engine) *gin.Engine {
+func RegisteBook(svc service.IService, events *event.Broker, cfg *event.Config) func(*gin.Engine) *gin.Engine {
+	h := &bookHandler{svc: svc, events: events, cfg: cfg}
+
+	return func(g *gin.Engine) *gin.Engine {
+		bookV1 := g.Group("/api/v1")
+
+		bookV1.GET("/books", h.ListBooks)
+		// GET /books/events 與 /books/:id 衝突, 由 GetBook 轉給 Events
+		bookV1.GET("/books/:id", h.GetBook)
+		bookV1.POST("/books", h.CreateBook)
+		bookV1.PUT("/books/:id", h.UpdateBook)
+		bookV1.DELETE("/books/:id", h.DeleteBook)
+
+		return g
+	}
+}
 
-	bookV1 := g.Group("/api/v1")
+// GetBook Get a single book
+// @Produce  json
+// @Param id path int true "ID"
+// @Success 200 {object} model.Book
+// @Failure 404 {object} apperror.Problem
+// @Router /api/v1/books/{id} [get]
+func (h *bookHandler) GetBook(c *gin.Context) {
+	if c.Param("id") == "events" {
+		h.Events(c)
+		return
+	}
 
-	bookV1.GET("/books", ListBokks)
-	bookV1.GET("/books/:id", GetBook)
-	bookV1.POST("/books", CreateBook)
-	bookV1.PUT("/books/:id", UpdateBook)
-	bookV1.DELETE("/books/:id", DeleteBook)
+	id, ok := bookID(c)
+	if !ok {
+		return
+	}
+	book, err := h.svc.GetBook(c.Request.Context(), id)
+	if err != nil {
+		bookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, book)
+}
 
-	return g
+// ListBooks List all books
+// @Produce  json
+// @Success 200 {array} model.Book
+// @Router /api/v1/books [get]
+func (h *bookHandler) ListBooks(c *gin.Context) {
+	books, err := h.svc.ListBooks(c.Request.Context())
+	if err != nil {
+		bookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, books)
 }
 
-// GetBook Get a single article
+// CreateBook Create a book
+// @Accept  json
+// @Produce  json
+// @Success 201 {object} model.Book
+// @Failure 422 {object} apperror.Problem
+// @Router /api/v1/books [post]
+func (h *bookHandler) CreateBook(c *gin.Context) {
+	var req bookRequest
+	if !validation.BindJSON(c, &req) {
+		return
+	}
+
+	book, err := h.svc.CreateBook(c.Request.Context(), req.book(0))
+	if err != nil {
+		bookError(c, err)
+		return
+	}
+	c.JSON(http.StatusCreated, book)
+}
+
+// UpdateBook Update a book
+// @Accept  json
 // @Produce  json
 // @Param id path int true "ID"
-// @Success 200 {object} app.Response
-// @Failure 500 {object} app.Response
-// @Router /api/v1/articles/{id} [get]
-func GetBook(c *gin.Context) {}
+// @Success 200 {object} model.Book
+// @Failure 422 {object} apperror.Problem
+// @Router /api/v1/books/{id} [put]
+func (h *bookHandler) UpdateBook(c *gin.Context) {
+	id, ok := bookID(c)
+	if !ok {
+		return
+	}
+	var req bookRequest
+	if !validation.BindJSON(c, &req) {
+		return
+	}
 
-func ListBokks(c *gin.Context) {}
+	book, err := h.svc.UpdateBook(c.Request.Context(), req.book(id))
+	if err != nil {
+		bookError(c, err)
+		return
+	}
+	c.JSON(http.StatusOK, book)
+}
 
-func CreateBook(c *gin.Context) {}
+// DeleteBook Delete a book
+// @Param id path int true "ID"
+// @Success 204
+// @Router /api/v1/books/{id} [delete]
+func (h *bookHandler) DeleteBook(c *gin.Context) {
+	id, ok := bookID(c)
+	if !ok {
+		return
+	}
+	if err := h.svc.DeleteBook(c.Request.Context(), id); err != nil {
+		bookError(c, err)
+		return
+	}
+	c.Status(http.StatusNoContent)
+}
 
-func DeleteBook(c *gin.Context) {}
+func bookID(c *gin.Context) (int, bool) {
+	id, err := strconv.Atoi(c.Param("id"))
+	if err != nil || id <= 0 {
+		apperror.Abort(c, errInvalidBookID)
+		return 0, false
+	}
+	return id, true
+}
 
-func UpdateBook(c *gin.Context) {}
+// bookError 其他錯誤由 access log 記錄原因
+func bookError(c *gin.Context, err error) {
+	if errors.Is(err, service.ErrNotFound) {
+		apperror.Abort(c, errBookNotFound.Wrap(err))
+		return
+	}
+	apperror.Abort(c, apperror.ErrInternal.Wrap(err))
+}
@@ -0,0 +1,68 @@
+package v1
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+
+	"apigateway/pkg/event"
+	"apigateway/pkg/middleware"
+
+	"github.com/gin-contrib/sse"
+	"github.com/gin-gonic/gin"
+	"github.com/rs/zerolog/log"
+)
+
+// Events Stream book changes as Server-Sent Events
+// 帶 Last-Event-ID 重新連線時會先補送 replay buffer 中之後的 event.
+// http.write_timeout 限制每次寫入, 而不是整個連線.
+// @Produce  text/event-stream
+// @Router /api/v1/books/events [get]
+func (h *bookHandler) Events(c *gin.Context) {
+	replay, sub := h.events.Subscribe(c.GetHeader("Last-Event-ID"))
+	defer sub.Close()
+
+	c.Header("Content-Type", "text/event-stream")
+	c.Header("Cache-Control", "no-cache")
+	c.Header("Connection", "keep-alive")
+	// 避免 nginx 等 reverse proxy 緩衝
+	c.Header("X-Accel-Buffering", "no")
+	c.Status(http.StatusOK)
+
+	written := middleware.ExtendWriteDeadline(c.Request)
+	for _, e := range replay {
+		renderEvent(c, e)
+	}
+	c.Writer.Flush()
+	written()
+
+	heartbeat := time.NewTicker(h.cfg.HeartbeatInterval())
+	defer heartbeat.Stop()
+
+	for {
+		select {
+		case <-c.Request.Context().Done():
+			return
+		case e, ok := <-sub.Events():
+			if !ok {
+				log.Info().Str("client_ip", c.ClientIP()).Msgf("book events closed: %v", sub.Err())
+				return
+			}
+			written = middleware.ExtendWriteDeadline(c.Request)
+			renderEvent(c, e)
+		case <-heartbeat.C:
+			written = middleware.ExtendWriteDeadline(c.Request)
+			_, _ = c.Writer.WriteString(": heartbeat\n\n")
+		}
+		c.Writer.Flush()
+		written()
+	}
+}
+
+func renderEvent(c *gin.Context, e event.Event) {
+	c.Render(-1, sse.Event{
+		Id:    strconv.FormatUint(e.ID, 10),
+		Event: e.Type,
+		Data:  e.Data,
+	})
+}
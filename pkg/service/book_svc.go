@@ -1 +1,51 @@
 package service
+
+import (
+	"context"
+
+	"apigateway/pkg/event"
+	"apigateway/pkg/model"
+)
+
+// BookService 寫入成功後發布對應的 book event
+type BookService interface {
+	GetBook(ctx context.Context, id int) (model.Book, error)
+	ListBooks(ctx context.Context) ([]model.Book, error)
+	CreateBook(ctx context.Context, book model.Book) (model.Book, error)
+	UpdateBook(ctx context.Context, book model.Book) (model.Book, error)
+	DeleteBook(ctx context.Context, id int) error
+}
+
+func (svc *service) GetBook(ctx context.Context, id int) (model.Book, error) {
+	return svc.repo.GetBook(ctx, id)
+}
+
+func (svc *service) ListBooks(ctx context.Context) ([]model.Book, error) {
+	return svc.repo.ListBooks(ctx)
+}
+
+func (svc *service) CreateBook(ctx context.Context, book model.Book) (model.Book, error) {
+	book, err := svc.repo.CreateBook(ctx, book)
+	if err != nil {
+		return book, err
+	}
+	svc.events.Publish(event.Created, book)
+	return book, nil
+}
+
+func (svc *service) UpdateBook(ctx context.Context, book model.Book) (model.Book, error) {
+	book, err := svc.repo.UpdateBook(ctx, book)
+	if err != nil {
+		return book, err
+	}
+	svc.events.Publish(event.Updated, book)
+	return book, nil
+}
+
+func (svc *service) DeleteBook(ctx context.Context, id int) error {
+	if err := svc.repo.DeleteBook(ctx, id); err != nil {
+		return err
+	}
+	svc.events.Publish(event.Deleted, model.Book{ID: id})
+	return nil
+}
@@ -1,24 +1,31 @@
 package service
 
 import (
+	"apigateway/pkg/event"
 	"apigateway/pkg/repository"
 
 	"go.uber.org/fx"
 )
 
+// ErrNotFound 查詢的資料不存在
+var ErrNotFound = repository.ErrNotFound
+
 type service struct {
-	repo repository.IRepository
+	repo   repository.IRepository
+	events *event.Broker
 }
 
 // NewService ...
-func NewService(repo repository.IRepository) IService {
+func NewService(repo repository.IRepository, events *event.Broker) IService {
 	return &service{
-		repo: repo,
+		repo:   repo,
+		events: events,
 	}
 }
 
 // IService ...
 type IService interface {
+	BookService
 }
 
 // Module Export service module
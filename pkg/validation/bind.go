@@ -0,0 +1,48 @@
+package validation
+
+import (
+	"reflect"
+	"strings"
+
+	"apigateway/pkg/apperror"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gin-gonic/gin/binding"
+)
+
+// Binding 讓 gin 的 ShouldBind 使用 validate tag 與這裡註冊的規則, 在建立 router 前設定給 binding.Validator
+var Binding binding.StructValidator = ginValidator{}
+
+type ginValidator struct{}
+
+func (ginValidator) ValidateStruct(obj interface{}) error {
+	v := reflect.ValueOf(obj)
+	for v.Kind() == reflect.Ptr {
+		v = v.Elem()
+	}
+	if v.Kind() != reflect.Struct {
+		return nil
+	}
+	return validate.Struct(obj)
+}
+
+func (ginValidator) Engine() interface{} {
+	return validate
+}
+
+// BindJSON 解析並驗證 request body. 格式錯誤回 400, 驗證失敗回 422 並依 Accept-Language 列出每個欄位的錯誤
+func BindJSON(c *gin.Context, obj interface{}) bool {
+	err := c.ShouldBindJSON(obj)
+	if err == nil {
+		return true
+	}
+
+	trans := TranslatorFor(c.GetHeader("Accept-Language"))
+	if fields := Translate(err, trans); fields != nil {
+		c.Header("Content-Language", strings.Replace(trans.Locale(), "_", "-", -1))
+		apperror.Abort(c, apperror.ErrValidation.WithMessage(Message(trans)).WithDetails(fields).Wrap(err))
+		return false
+	}
+	apperror.Abort(c, apperror.ErrBadRequest.WithMessage("The request body is not valid JSON.").Wrap(err))
+	return false
+}
@@ -0,0 +1,87 @@
+package validation
+
+import (
+	"sort"
+	"strconv"
+	"strings"
+
+	ut "github.com/go-playground/universal-translator"
+)
+
+// messageKey 整個 request 驗證失敗的訊息
+const messageKey = "validation_failed"
+
+var messages = map[string]string{
+	"en":      "The request has invalid fields.",
+	"zh_Hant": "請求中有欄位未通過驗證。",
+}
+
+// Message 驗證失敗時給使用者的訊息
+func Message(trans ut.Translator) string {
+	msg, err := trans.T(messageKey)
+	if err != nil {
+		return messages["en"]
+	}
+	return msg
+}
+
+// TranslatorFor 依 Accept-Language 的 q 值選擇語系, 中文一律使用繁體, 沒有支援的語系時使用英文
+func TranslatorFor(acceptLanguage string) ut.Translator {
+	for _, tag := range parseAcceptLanguage(acceptLanguage) {
+		if trans, ok := uni.GetTranslator(locale(tag)); ok {
+			return trans
+		}
+	}
+	return uni.GetFallback()
+}
+
+// locale zh-TW、zh-Hant-HK、zh => zh_Hant, en-US => en
+func locale(tag string) string {
+	tag = strings.ToLower(strings.Replace(tag, "_", "-", -1))
+	primary := strings.SplitN(tag, "-", 2)[0]
+	switch primary {
+	case "zh":
+		if strings.Contains(tag, "hans") || strings.HasSuffix(tag, "-cn") || strings.HasSuffix(tag, "-sg") {
+			return tag
+		}
+		return "zh_Hant"
+	default:
+		return primary
+	}
+}
+
+type weightedTag struct {
+	tag string
+	q   float64
+}
+
+// parseAcceptLanguage 依 q 值由高到低排序, q=0 的語系排除
+func parseAcceptLanguage(header string) []string {
+	var tags []weightedTag
+	for _, part := range strings.Split(header, ",") {
+		fields := strings.Split(strings.TrimSpace(part), ";")
+		tag := strings.TrimSpace(fields[0])
+		if tag == "" || tag == "*" {
+			continue
+		}
+		q := 1.0
+		for _, f := range fields[1:] {
+			f = strings.TrimSpace(f)
+			if strings.HasPrefix(f, "q=") {
+				if v, err := strconv.ParseFloat(f[2:], 64); err == nil {
+					q = v
+				}
+			}
+		}
+		if q > 0 {
+			tags = append(tags, weightedTag{tag: tag, q: q})
+		}
+	}
+
+	sort.SliceStable(tags, func(i, j int) bool { return tags[i].q > tags[j].q })
+	result := make([]string, len(tags))
+	for i, t := range tags {
+		result[i] = t.tag
+	}
+	return result
+}
@@ -0,0 +1,50 @@
+package validation
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseAcceptLanguage(t *testing.T) {
+	tests := []struct {
+		header string
+		want   []string
+	}{
+		{header: "", want: []string{}},
+		{header: "en", want: []string{"en"}},
+		{header: "zh-TW,zh;q=0.9,en-US;q=0.8,en;q=0.7", want: []string{"zh-TW", "zh", "en-US", "en"}},
+		{header: "en;q=0.5, zh-TW", want: []string{"zh-TW", "en"}},
+		{header: "fr;q=0.8, de;q=0.8", want: []string{"fr", "de"}},
+		{header: "en;q=0, zh", want: []string{"zh"}},
+		{header: "*, en;q=0.1", want: []string{"en"}},
+		{header: "en;q=abc", want: []string{"en"}},
+		{header: " , en ;q=0.3", want: []string{"en"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.header, func(t *testing.T) {
+			if got := parseAcceptLanguage(tt.header); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseAcceptLanguage(%q) = %q, want %q", tt.header, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestLocale(t *testing.T) {
+	tests := []struct {
+		tag, want string
+	}{
+		{tag: "zh", want: "zh_Hant"},
+		{tag: "zh-TW", want: "zh_Hant"},
+		{tag: "zh_tw", want: "zh_Hant"},
+		{tag: "zh-Hant-HK", want: "zh_Hant"},
+		{tag: "en", want: "en"},
+		{tag: "en-US", want: "en"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.tag, func(t *testing.T) {
+			if got := locale(tt.tag); got != tt.want {
+				t.Errorf("locale(%q) = %q, want %q", tt.tag, got, tt.want)
+			}
+		})
+	}
+}
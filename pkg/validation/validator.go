@@ -0,0 +1,201 @@
+package validation
+
+import (
+	"errors"
+	"reflect"
+	"regexp"
+	"strings"
+
+	"github.com/go-playground/locales/en"
+	"github.com/go-playground/locales/zh_Hant"
+	ut "github.com/go-playground/universal-translator"
+	"github.com/go-playground/validator/v10"
+	enTranslations "github.com/go-playground/validator/v10/translations/en"
+	zhTranslations "github.com/go-playground/validator/v10/translations/zh_tw"
+	"github.com/rs/zerolog/log"
+)
+
+var (
+	validate = validator.New()
+	uni      *ut.UniversalTranslator
+
+	slugRegexp = regexp.MustCompile(`^[a-z0-9]+(?:-[a-z0-9]+)*$`)
+)
+
+// customValidation 自訂的 tag 與各語系的錯誤訊息, {0} 為欄位名稱
+type customValidation struct {
+	tag          string
+	fn           validator.Func
+	translations map[string]string
+}
+
+var customValidations = []customValidation{
+	{
+		// isbn 取代內建的版本, 允許以 - 或空白分隔並檢查 checksum
+		tag: "isbn",
+		fn:  isISBN,
+		translations: map[string]string{
+			"en":      "{0} must be a valid ISBN-10 or ISBN-13",
+			"zh_Hant": "{0}必須是有效的 ISBN-10 或 ISBN-13",
+		},
+	},
+	{
+		tag: "slug",
+		fn:  isSlug,
+		translations: map[string]string{
+			"en":      "{0} must contain only lowercase letters, digits and single hyphens",
+			"zh_Hant": "{0}只能包含小寫英文字母、數字與單一連字號",
+		},
+	},
+}
+
+func init() {
+	// 錯誤中的欄位名稱使用 json 名稱
+	validate.RegisterTagNameFunc(func(f reflect.StructField) string {
+		name := strings.SplitN(f.Tag.Get("json"), ",", 2)[0]
+		if name == "-" {
+			return ""
+		}
+		if name == "" {
+			return f.Name
+		}
+		return name
+	})
+
+	for _, cv := range customValidations {
+		if err := validate.RegisterValidation(cv.tag, cv.fn); err != nil {
+			panic(err)
+		}
+	}
+
+	english := en.New()
+	uni = ut.New(english, english, zh_Hant.New())
+	if err := registerTranslations("en", enTranslations.RegisterDefaultTranslations); err != nil {
+		panic(err)
+	}
+	if err := registerTranslations("zh_Hant", zhTranslations.RegisterDefaultTranslations); err != nil {
+		panic(err)
+	}
+}
+
+func registerTranslations(locale string, defaults func(*validator.Validate, ut.Translator) error) error {
+	trans, _ := uni.GetTranslator(locale)
+	if err := defaults(validate, trans); err != nil {
+		return err
+	}
+	if err := trans.Add(messageKey, messages[locale], true); err != nil {
+		return err
+	}
+
+	for _, cv := range customValidations {
+		text := cv.translations[locale]
+		tag := cv.tag
+		err := validate.RegisterTranslation(tag, trans,
+			func(t ut.Translator) error {
+				return t.Add(tag, text, true)
+			},
+			func(t ut.Translator, fe validator.FieldError) string {
+				msg, err := t.T(tag, fe.Field())
+				if err != nil {
+					log.Warn().Str("tag", tag).Msgf("validation: %v", err)
+					return fe.(error).Error()
+				}
+				return msg
+			},
+		)
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Struct 以 validate tag 檢查 s
+func Struct(s interface{}) error {
+	return validate.Struct(s)
+}
+
+// FieldError 一個欄位的錯誤
+type FieldError struct {
+	Field   string `json:"field"`
+	Rule    string `json:"rule"`
+	Param   string `json:"param,omitempty"`
+	Message string `json:"message"`
+}
+
+// Translate 將 validator 的錯誤轉成 trans 語系的欄位錯誤, 不是驗證錯誤時回傳 nil
+func Translate(err error, trans ut.Translator) []FieldError {
+	var errs validator.ValidationErrors
+	if !errors.As(err, &errs) {
+		return nil
+	}
+
+	fields := make([]FieldError, 0, len(errs))
+	for _, fe := range errs {
+		fields = append(fields, FieldError{
+			Field:   fieldPath(fe.Namespace()),
+			Rule:    fe.Tag(),
+			Param:   fe.Param(),
+			Message: fe.Translate(trans),
+		})
+	}
+	return fields
+}
+
+// fieldPath Book.authors[0].name => authors[0].name
+func fieldPath(namespace string) string {
+	if i := strings.Index(namespace, "."); i >= 0 {
+		return namespace[i+1:]
+	}
+	return namespace
+}
+
+func isSlug(fl validator.FieldLevel) bool {
+	return slugRegexp.MatchString(fl.Field().String())
+}
+
+func isISBN(fl validator.FieldLevel) bool {
+	s := strings.NewReplacer("-", "", " ", "").Replace(fl.Field().String())
+	switch len(s) {
+	case 10:
+		return isbn10(s)
+	case 13:
+		return isbn13(s)
+	default:
+		return false
+	}
+}
+
+func isbn10(s string) bool {
+	sum := 0
+	for i := 0; i < 10; i++ {
+		c := s[i]
+		var d int
+		switch {
+		case c >= '0' && c <= '9':
+			d = int(c - '0')
+		case i == 9 && (c == 'X' || c == 'x'):
+			d = 10
+		default:
+			return false
+		}
+		sum += (10 - i) * d
+	}
+	return sum%11 == 0
+}
+
+func isbn13(s string) bool {
+	sum := 0
+	for i := 0; i < 13; i++ {
+		c := s[i]
+		if c < '0' || c > '9' {
+			return false
+		}
+		d := int(c - '0')
+		if i%2 == 1 {
+			d *= 3
+		}
+		sum += d
+	}
+	return sum%10 == 0
+}
@@ -0,0 +1,84 @@
+package validation
+
+import (
+	"testing"
+)
+
+func TestISBN(t *testing.T) {
+	tests := []struct {
+		isbn string
+		want bool
+	}{
+		{isbn: "9783161484100", want: true},
+		{isbn: "978-3-16-148410-0", want: true},
+		{isbn: "978 3 16 148410 0", want: true},
+		{isbn: "9783161484101", want: false},
+		{isbn: "0306406152", want: true},
+		{isbn: "0-306-40615-2", want: true},
+		{isbn: "0306406153", want: false},
+		{isbn: "080442957X", want: true},
+		{isbn: "080442957x", want: true},
+		{isbn: "08044295X7", want: false},
+		{isbn: "978316148410", want: false},
+		{isbn: "97831614841000", want: false},
+		{isbn: "978316148410A", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.isbn, func(t *testing.T) {
+			s := struct {
+				ISBN string `validate:"isbn"`
+			}{tt.isbn}
+			if got := Struct(&s) == nil; got != tt.want {
+				t.Errorf("isbn %q valid = %v, want %v", tt.isbn, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestSlug(t *testing.T) {
+	tests := []struct {
+		slug string
+		want bool
+	}{
+		{slug: "go", want: true},
+		{slug: "the-go-programming-language", want: true},
+		{slug: "go-2", want: true},
+		{slug: "Go", want: false},
+		{slug: "go--lang", want: false},
+		{slug: "-go", want: false},
+		{slug: "go-", want: false},
+		{slug: "go lang", want: false},
+		{slug: "go_lang", want: false},
+	}
+	for _, tt := range tests {
+		t.Run(tt.slug, func(t *testing.T) {
+			s := struct {
+				Slug string `validate:"slug"`
+			}{tt.slug}
+			if got := Struct(&s) == nil; got != tt.want {
+				t.Errorf("slug %q valid = %v, want %v", tt.slug, got, tt.want)
+			}
+		})
+	}
+}
+
+func TestTranslate(t *testing.T) {
+	s := struct {
+		Name string `json:"name" validate:"required"`
+		ISBN string `json:"isbn" validate:"omitempty,isbn"`
+	}{ISBN: "123"}
+
+	fields := Translate(Struct(&s), TranslatorFor("zh-TW"))
+	if len(fields) != 2 {
+		t.Fatalf("Translate() = %+v, want 2 fields", fields)
+	}
+	for i, want := range []struct{ field, rule string }{{"name", "required"}, {"isbn", "isbn"}} {
+		if fields[i].Field != want.field || fields[i].Rule != want.rule || fields[i].Message == "" {
+			t.Errorf("fields[%d] = %+v, want field %s rule %s with a message", i, fields[i], want.field, want.rule)
+		}
+	}
+
+	if Translate(nil, TranslatorFor("")) != nil {
+		t.Error("Translate(nil) should be nil")
+	}
+}
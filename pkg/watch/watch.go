@@ -0,0 +1,60 @@
+package watch
+
+import (
+	"io"
+	"path/filepath"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/rs/zerolog/log"
+)
+
+// Files 檔案變更後等待 delay 才呼叫 onChange, 期間的變更合併成一次.
+// 監看檔案所在的目錄, 可處理以 rename 或 symlink (例如 kubernetes secret 的 ..data) 替換檔案的情況.
+func Files(paths []string, delay time.Duration, onChange func()) (io.Closer, error) {
+	w, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+
+	files := map[string]bool{}
+	dirs := map[string]bool{}
+	for _, path := range paths {
+		abs, err := filepath.Abs(path)
+		if err != nil {
+			abs = path
+		}
+		files[abs] = true
+		dirs[filepath.Dir(abs)] = true
+	}
+	for dir := range dirs {
+		if err := w.Add(dir); err != nil {
+			w.Close()
+			return nil, err
+		}
+	}
+
+	go func() {
+		var timer <-chan time.Time
+		for {
+			select {
+			case e, ok := <-w.Events:
+				if !ok {
+					return
+				}
+				if files[e.Name] || filepath.Base(e.Name) == "..data" {
+					timer = time.After(delay)
+				}
+			case err, ok := <-w.Errors:
+				if !ok {
+					return
+				}
+				log.Error().Msgf("watch: %v", err)
+			case <-timer:
+				timer = nil
+				onChange()
+			}
+		}
+	}()
+	return w, nil
+}